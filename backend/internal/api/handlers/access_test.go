@@ -0,0 +1,353 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/amityadav9314/goinkgrid/internal/db/models"
+	db "github.com/amityadav9314/goinkgrid/internal/db/postgres"
+	"github.com/amityadav9314/goinkgrid/internal/services"
+	"github.com/amityadav9314/goinkgrid/pkg/signedurl"
+	"github.com/gin-gonic/gin"
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// tenant is a user with a project, a main image, a tile and a completed
+// mosaic of their own
+type tenant struct {
+	user    models.User
+	project models.Project
+	main    models.Image
+	tile    models.Image
+	mosaic  models.GeneratedMosaic
+}
+
+// accessFixture is a router backed by a fresh database holding two tenants
+type accessFixture struct {
+	router    *gin.Engine
+	signer    *signedurl.Signer
+	uploadDir string
+	alice     tenant
+	bob       tenant
+}
+
+// newTestDB opens an empty SQLite database with every table migrated and
+// makes it the global database the services use
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	gdb, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	if err := db.Migrate(gdb); err != nil {
+		t.Fatalf("migrate database: %v", err)
+	}
+	previous := db.DB
+	db.DB = gdb
+	t.Cleanup(func() { db.DB = previous })
+	return gdb
+}
+
+func newAccessFixture(t *testing.T) *accessFixture {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	gdb := newTestDB(t)
+	f := &accessFixture{
+		signer:    signedurl.NewSigner("test-secret", time.Hour),
+		uploadDir: t.TempDir(),
+	}
+	f.alice = createTenant(t, gdb, f.uploadDir, "alice@example.com")
+	f.bob = createTenant(t, gdb, f.uploadDir, "bob@example.com")
+
+	memberService := services.NewProjectMemberService(gdb)
+	policy := services.NewAccessPolicy(gdb, memberService)
+	mosaicService := services.NewMosaicService(f.uploadDir, services.RenderConfig{}, services.PreviewConfig{})
+	imageHandler := NewImageHandler(f.uploadDir, services.NewImageService(gdb), policy, f.signer)
+	mosaicHandler := NewMosaicHandler(mosaicService, policy, f.signer)
+	shareHandler := NewShareHandler(f.uploadDir, services.NewShareLinkService(gdb), mosaicService, policy, nil, f.signer)
+	fileHandler := NewFileHandler(f.uploadDir, f.signer)
+
+	// The user is taken from a header instead of a token
+	asUser := func(c *gin.Context) {
+		if id, err := strconv.ParseUint(c.GetHeader("X-Test-User"), 10, 32); err == nil {
+			c.Set("userID", uint(id))
+		}
+	}
+
+	r := gin.New()
+	r.GET("/files/*filepath", fileHandler.ServeFile)
+	api := r.Group("/api", asUser)
+	api.GET("/projects/:id/images", imageHandler.GetProjectImages)
+	api.GET("/projects/:id/mosaics", mosaicHandler.GetProjectMosaics)
+	api.POST("/generate/", mosaicHandler.GenerateMosaic)
+	api.POST("/generate/settings", mosaicHandler.SaveMosaicSettings)
+	api.GET("/generate/settings", mosaicHandler.GetMosaicSettings)
+	api.GET("/generate/:id/status", mosaicHandler.GetGenerationStatus)
+	api.GET("/generate/:id/diff/:otherId", mosaicHandler.DiffMosaics)
+	api.POST("/generate/:id/rerender", mosaicHandler.RerenderMosaic)
+	api.POST("/generate/:id/exports", mosaicHandler.ExportMosaic)
+	api.GET("/generate/:id/deepzoom.dzi", mosaicHandler.GetDeepZoom)
+	api.GET("/generate/:id/deepzoom_files/*tile", mosaicHandler.GetDeepZoom)
+	api.GET("/generate/:id/placement", mosaicHandler.GetPlacementMap)
+	api.GET("/generate/:id/placement/at", mosaicHandler.GetPlacementAt)
+	api.GET("/generate/:id/placement/tiles/:imageId", mosaicHandler.GetTilePlacements)
+	api.GET("/generate/:id/shares", shareHandler.ListShareLinks)
+	api.POST("/generate/:id/shares", shareHandler.CreateShareLink)
+	f.router = r
+	return f
+}
+
+// createTenant creates a user with a project, images, a completed mosaic
+// and a completed Deep Zoom export of it
+func createTenant(t *testing.T, gdb *gorm.DB, uploadDir, email string) tenant {
+	t.Helper()
+	var tn tenant
+	tn.user = models.User{Email: email, PasswordHash: "x", Role: services.RoleUser}
+	mustCreate(t, gdb, &tn.user)
+	tn.project = models.Project{UserID: tn.user.ID, Name: email}
+	mustCreate(t, gdb, &tn.project)
+
+	dir := fmt.Sprintf("user_%d", tn.user.ID)
+	writeUpload(t, uploadDir, dir+"/main.jpg")
+	tn.main = models.Image{UserID: tn.user.ID, ProjectID: &tn.project.ID, Type: "main", Path: "/uploads/" + dir + "/main.jpg", Width: 64, Height: 64}
+	mustCreate(t, gdb, &tn.main)
+	tn.tile = models.Image{UserID: tn.user.ID, ProjectID: &tn.project.ID, Type: "tile", Path: "/uploads/" + dir + "/tile.jpg", Width: 64, Height: 64}
+	mustCreate(t, gdb, &tn.tile)
+
+	tn.mosaic = models.GeneratedMosaic{
+		UserID: tn.user.ID, ProjectID: tn.project.ID, MainImageID: tn.main.ID, Status: "completed",
+		SDPath: "/" + dir + "/sd.jpg", HDPath: "/" + dir + "/hd.jpg",
+		TileSize: 20, TileDensity: 50, ColorAdjustment: 30, Style: "classic",
+	}
+	mustCreate(t, gdb, &tn.mosaic)
+
+	writeUpload(t, uploadDir, dir+"/mosaic.dzi")
+	mustCreate(t, gdb, &models.MosaicArtifact{MosaicID: tn.mosaic.ID, Format: "dzi", Status: "completed", Path: "/" + dir + "/mosaic.dzi"})
+	return tn
+}
+
+func mustCreate(t *testing.T, gdb *gorm.DB, value interface{}) {
+	t.Helper()
+	if err := gdb.Create(value).Error; err != nil {
+		t.Fatalf("create %T: %v", value, err)
+	}
+}
+
+func writeUpload(t *testing.T, uploadDir, name string) {
+	t.Helper()
+	path := filepath.Join(uploadDir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// do sends a request as the given user and returns the response
+func (f *accessFixture) do(userID uint, method, target string, body interface{}) *httptest.ResponseRecorder {
+	var reader *bytes.Reader
+	if body != nil {
+		encoded, _ := json.Marshal(body)
+		reader = bytes.NewReader(encoded)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+	req := httptest.NewRequest(method, target, reader)
+	req.Header.Set("Content-Type", "application/json")
+	if userID != 0 {
+		req.Header.Set("X-Test-User", strconv.FormatUint(uint64(userID), 10))
+	}
+	w := httptest.NewRecorder()
+	f.router.ServeHTTP(w, req)
+	return w
+}
+
+func TestProjectImagesAreHiddenFromOtherUsers(t *testing.T) {
+	f := newAccessFixture(t)
+	target := fmt.Sprintf("/api/projects/%d/images", f.alice.project.ID)
+
+	if w := f.do(f.alice.user.ID, http.MethodGet, target, nil); w.Code != http.StatusOK {
+		t.Fatalf("owner: got %d, want 200: %s", w.Code, w.Body)
+	}
+	if w := f.do(f.bob.user.ID, http.MethodGet, target, nil); w.Code != http.StatusNotFound {
+		t.Fatalf("other user: got %d, want 404: %s", w.Code, w.Body)
+	}
+}
+
+func TestGenerateRejectsOtherUsersImages(t *testing.T) {
+	f := newAccessFixture(t)
+	id := func(img models.Image) string { return strconv.FormatUint(uint64(img.ID), 10) }
+	request := func(main models.Image, tiles []models.Image, mask *models.Image) gin.H {
+		tileIDs := []string{}
+		for _, tile := range tiles {
+			tileIDs = append(tileIDs, id(tile))
+		}
+		req := gin.H{
+			"project_id":     f.bob.project.ID,
+			"main_image_id":  id(main),
+			"tile_image_ids": tileIDs,
+			"tile_size":      20,
+			"tile_density":   50,
+			"overlay_ratio":  0.3,
+			"style":          "classic",
+			// Rejected after the images are authorized, so no job starts
+			"exports": []gin.H{{"format": "bogus"}},
+		}
+		if mask != nil {
+			req["mask"] = gin.H{"image_id": mask.ID}
+		}
+		return req
+	}
+
+	// Bob's own images pass authorization and fail on the export format
+	w := f.do(f.bob.user.ID, http.MethodPost, "/api/generate/", request(f.bob.main, []models.Image{f.bob.tile}, &f.bob.tile))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("own images: got %d, want 400: %s", w.Code, w.Body)
+	}
+
+	tests := []struct {
+		name string
+		body gin.H
+	}{
+		{"main image", request(f.alice.main, []models.Image{f.bob.tile}, nil)},
+		{"tile image", request(f.bob.main, []models.Image{f.bob.tile, f.alice.tile}, nil)},
+		{"mask image", request(f.bob.main, []models.Image{f.bob.tile}, &f.alice.main)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := f.do(f.bob.user.ID, http.MethodPost, "/api/generate/", tt.body); w.Code != http.StatusNotFound {
+				t.Fatalf("got %d, want 404: %s", w.Code, w.Body)
+			}
+		})
+	}
+
+	// Nor can Bob generate into Alice's project with his own images
+	body := request(f.bob.main, []models.Image{f.bob.tile}, nil)
+	body["project_id"] = f.alice.project.ID
+	if w := f.do(f.bob.user.ID, http.MethodPost, "/api/generate/", body); w.Code != http.StatusNotFound {
+		t.Fatalf("other project: got %d, want 404: %s", w.Code, w.Body)
+	}
+}
+
+func TestMosaicEndpointsAreHiddenFromOtherUsers(t *testing.T) {
+	f := newAccessFixture(t)
+	alice, bob := f.alice.mosaic.ID, f.bob.mosaic.ID
+
+	if w := f.do(f.alice.user.ID, http.MethodGet, fmt.Sprintf("/api/generate/%d/status", alice), nil); w.Code != http.StatusOK {
+		t.Fatalf("owner status: got %d, want 200: %s", w.Code, w.Body)
+	}
+	if w := f.do(f.alice.user.ID, http.MethodGet, fmt.Sprintf("/api/generate/%d/deepzoom.dzi", alice), nil); w.Code != http.StatusOK {
+		t.Fatalf("owner deep zoom: got %d, want 200: %s", w.Code, w.Body)
+	}
+
+	tests := []struct {
+		method string
+		target string
+		body   interface{}
+	}{
+		{http.MethodGet, fmt.Sprintf("/api/projects/%d/mosaics", f.alice.project.ID), nil},
+		{http.MethodGet, fmt.Sprintf("/api/generate/%d/status", alice), nil},
+		{http.MethodGet, fmt.Sprintf("/api/generate/%d/diff/%d", alice, bob), nil},
+		{http.MethodGet, fmt.Sprintf("/api/generate/%d/diff/%d", bob, alice), nil},
+		{http.MethodPost, fmt.Sprintf("/api/generate/%d/rerender", alice), nil},
+		{http.MethodPost, fmt.Sprintf("/api/generate/%d/exports", alice), gin.H{"exports": []gin.H{{"format": "png"}}}},
+		{http.MethodGet, fmt.Sprintf("/api/generate/%d/deepzoom.dzi", alice), nil},
+		{http.MethodGet, fmt.Sprintf("/api/generate/%d/deepzoom_files/0/0_0.jpg", alice), nil},
+		{http.MethodGet, fmt.Sprintf("/api/generate/%d/placement", alice), nil},
+		{http.MethodGet, fmt.Sprintf("/api/generate/%d/shares", alice), nil},
+		{http.MethodPost, fmt.Sprintf("/api/generate/%d/shares", alice), gin.H{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.target, func(t *testing.T) {
+			if w := f.do(f.bob.user.ID, tt.method, tt.target, tt.body); w.Code != http.StatusNotFound {
+				t.Fatalf("got %d, want 404: %s", w.Code, w.Body)
+			}
+		})
+	}
+}
+
+func TestProjectSettingsNeedAccessToTheProject(t *testing.T) {
+	f := newAccessFixture(t)
+	project := f.alice.project.ID
+	settings := gin.H{"tile_size": 30, "tile_density": 60, "color_adjustment": 20, "style": "classic", "project_id": project}
+	target := fmt.Sprintf("/api/generate/settings?project_id=%d", project)
+
+	if w := f.do(f.bob.user.ID, http.MethodPost, "/api/generate/settings", settings); w.Code != http.StatusNotFound {
+		t.Fatalf("save on another user's project: got %d, want 404: %s", w.Code, w.Body)
+	}
+	if w := f.do(f.bob.user.ID, http.MethodGet, target, nil); w.Code != http.StatusNotFound {
+		t.Fatalf("get on another user's project: got %d, want 404: %s", w.Code, w.Body)
+	}
+	var count int64
+	db.DB.Model(&models.MosaicSettings{}).Where("project_id = ?", project).Count(&count)
+	if count != 0 {
+		t.Fatal("settings were saved on a project the user cannot see")
+	}
+
+	// Any member may keep settings of their own on the project
+	now := time.Now()
+	mustCreate(t, db.DB, &models.ProjectMember{
+		ProjectID: project, UserID: &f.bob.user.ID, Email: f.bob.user.Email,
+		Role: services.ProjectRoleViewer, InvitedByID: f.alice.user.ID, AcceptedAt: &now,
+	})
+	for _, user := range []uint{f.alice.user.ID, f.bob.user.ID} {
+		if w := f.do(user, http.MethodPost, "/api/generate/settings", settings); w.Code != http.StatusOK {
+			t.Fatalf("user %d save: got %d: %s", user, w.Code, w.Body)
+		}
+		if w := f.do(user, http.MethodGet, target, nil); w.Code != http.StatusOK {
+			t.Fatalf("user %d get: got %d: %s", user, w.Code, w.Body)
+		}
+	}
+}
+
+func TestFilesNeedAValidSignature(t *testing.T) {
+	f := newAccessFixture(t)
+	path := fmt.Sprintf("/user_%d/main.jpg", f.alice.user.ID)
+	other := fmt.Sprintf("/user_%d/main.jpg", f.bob.user.ID)
+
+	if w := f.do(0, http.MethodGet, "/files"+path+"?"+f.signer.Sign(path), nil); w.Code != http.StatusOK {
+		t.Fatalf("signed: got %d, want 200: %s", w.Code, w.Body)
+	}
+
+	tests := []struct {
+		name   string
+		target string
+		want   int
+	}{
+		{"unsigned", "/files" + path, http.StatusForbidden},
+		{"tampered signature", "/files" + path + "?expires=" + strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10) + "&sig=00", http.StatusForbidden},
+		{"signature of another file", "/files" + path + "?" + f.signer.Sign(other), http.StatusForbidden},
+		{"extended expiry", "/files" + path + "?" + extendExpiry(f.signer.Sign(path)), http.StatusForbidden},
+		{"expired", "/files" + path + "?" + f.signer.SignUntil(path, time.Now().Add(-time.Minute)), http.StatusGone},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := f.do(0, http.MethodGet, tt.target, nil); w.Code != tt.want {
+				t.Fatalf("got %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+		})
+	}
+}
+
+// extendExpiry moves the expiry of a signed query a day later while
+// keeping its signature
+func extendExpiry(query string) string {
+	values, _ := url.ParseQuery(query)
+	expires, _ := strconv.ParseInt(values.Get("expires"), 10, 64)
+	values.Set("expires", strconv.FormatInt(expires+24*60*60, 10))
+	return values.Encode()
+}
@@ -0,0 +1,182 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/amityadav9314/goinkgrid/internal/services"
+	"github.com/gin-gonic/gin"
+)
+
+// AdminHandler handles administrative requests
+type AdminHandler struct {
+	adminService  services.AdminService
+	mosaicService services.MosaicService
+}
+
+// NewAdminHandler creates a new admin handler
+func NewAdminHandler(adminService services.AdminService, mosaicService services.MosaicService) *AdminHandler {
+	return &AdminHandler{
+		adminService:  adminService,
+		mosaicService: mosaicService,
+	}
+}
+
+// AdminUserResponse represents a user in admin listings
+type AdminUserResponse struct {
+	ID         uint       `json:"id"`
+	Email      string     `json:"email"`
+	Name       string     `json:"name"`
+	Role       string     `json:"role"`
+	DisabledAt *time.Time `json:"disabled_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// UpdateUserRequest represents an admin update user request
+type UpdateUserRequest struct {
+	Disabled *bool   `json:"disabled"`
+	Role     *string `json:"role" binding:"omitempty,oneof=user admin"`
+}
+
+// ListUsers returns a page of users
+func (h *AdminHandler) ListUsers(c *gin.Context) {
+	offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if offset < 0 {
+		offset = 0
+	}
+	if limit <= 0 || limit > 200 {
+		limit = 50
+	}
+
+	users, total, err := h.adminService.ListUsers(offset, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch users"})
+		return
+	}
+
+	responses := make([]AdminUserResponse, 0, len(users))
+	for _, user := range users {
+		responses = append(responses, AdminUserResponse{
+			ID:         user.ID,
+			Email:      user.Email,
+			Name:       user.Name,
+			Role:       user.Role,
+			DisabledAt: user.DisabledAt,
+			CreatedAt:  user.CreatedAt,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"users":  responses,
+		"total":  total,
+		"offset": offset,
+		"limit":  limit,
+	})
+}
+
+// UpdateUser disables, re-enables or changes the role of a user
+func (h *AdminHandler) UpdateUser(c *gin.Context) {
+	// Get admin user ID from context (set by auth middleware)
+	adminID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	userID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var req UpdateUserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Admins cannot lock themselves out
+	if uint(userID) == adminID.(uint) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "You cannot change your own account"})
+		return
+	}
+
+	if req.Disabled != nil {
+		if err := h.adminService.SetUserDisabled(uint(userID), *req.Disabled); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if req.Role != nil {
+		if err := h.adminService.SetUserRole(uint(userID), *req.Role); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "User updated successfully"})
+}
+
+// GetJobs returns running mosaic generation jobs and the number of stale
+// jobs, which are marked processing but have no running task
+func (h *AdminHandler) GetJobs(c *gin.Context) {
+	stats, err := h.mosaicService.GetJobStats()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch jobs"})
+		return
+	}
+
+	running := make([]gin.H, 0, len(stats.Running))
+	for _, mosaic := range stats.Running {
+		running = append(running, gin.H{
+			"id":         mosaic.ID,
+			"user_id":    mosaic.UserID,
+			"project_id": mosaic.ProjectID,
+			"progress":   mosaic.Progress,
+			"created_at": mosaic.CreatedAt,
+			"updated_at": mosaic.UpdatedAt,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"running":    running,
+		"stale_jobs": stats.StaleJobs,
+	})
+}
+
+// CancelJob force-cancels a mosaic generation job
+func (h *AdminHandler) CancelJob(c *gin.Context) {
+	mosaicID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid job ID"})
+		return
+	}
+
+	if err := h.mosaicService.CancelMosaic(uint(mosaicID)); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Job cancelled"})
+}
+
+// GetStorageUsage returns disk usage per user
+func (h *AdminHandler) GetStorageUsage(c *gin.Context) {
+	usage, err := h.adminService.StorageUsage()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to compute storage usage"})
+		return
+	}
+
+	var totalBytes int64
+	for _, u := range usage {
+		totalBytes += u.Bytes
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"users":       usage,
+		"total_bytes": totalBytes,
+	})
+}
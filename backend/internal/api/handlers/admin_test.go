@@ -0,0 +1,242 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/amityadav9314/goinkgrid/internal/api/middleware"
+	"github.com/amityadav9314/goinkgrid/internal/db/models"
+	"github.com/amityadav9314/goinkgrid/internal/services"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v4"
+	"gorm.io/gorm"
+)
+
+const adminTestSecret = "admin-test-secret"
+
+// adminFixture serves the admin routes behind the real auth middleware,
+// with an admin and a regular user
+type adminFixture struct {
+	router    *gin.Engine
+	db        *gorm.DB
+	uploadDir string
+	apiKeys   services.APIKeyService
+	admin     models.User
+	user      models.User
+}
+
+func newAdminFixture(t *testing.T) *adminFixture {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	gdb := newTestDB(t)
+	f := &adminFixture{
+		db:        gdb,
+		uploadDir: t.TempDir(),
+		apiKeys:   services.NewAPIKeyService(gdb),
+		admin:     models.User{Email: "admin@example.com", PasswordHash: "x", Role: services.RoleAdmin},
+		user:      models.User{Email: "user@example.com", PasswordHash: "x", Role: services.RoleUser},
+	}
+	mustCreate(t, gdb, &f.admin)
+	mustCreate(t, gdb, &f.user)
+
+	auth := middleware.NewAuthMiddleware(adminTestSecret, services.NewUserService(gdb), f.apiKeys)
+	handler := NewAdminHandler(services.NewAdminService(gdb, f.uploadDir),
+		services.NewMosaicService(f.uploadDir, services.RenderConfig{}, services.PreviewConfig{}))
+
+	r := gin.New()
+	admin := r.Group("/admin")
+	admin.Use(auth.RequireAuth(), auth.RequireSession(), auth.RequireRole(services.RoleAdmin))
+	admin.GET("/users", handler.ListUsers)
+	admin.PATCH("/users/:id", handler.UpdateUser)
+	admin.GET("/jobs", handler.GetJobs)
+	admin.POST("/jobs/:id/cancel", handler.CancelJob)
+	admin.GET("/storage", handler.GetStorageUsage)
+	f.router = r
+	return f
+}
+
+// token signs a session token for a user, as the login handler does
+func (f *adminFixture) token(t *testing.T, user models.User) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"id":    user.ID,
+		"email": user.Email,
+		"role":  user.Role,
+	}).SignedString([]byte(adminTestSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// do sends a request with a bearer token, or none if token is empty
+func (f *adminFixture) do(token, method, target string, body interface{}) *httptest.ResponseRecorder {
+	var encoded []byte
+	if body != nil {
+		encoded, _ = json.Marshal(body)
+	}
+	req := httptest.NewRequest(method, target, bytes.NewReader(encoded))
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	f.router.ServeHTTP(w, req)
+	return w
+}
+
+func TestAdminRoutesRequireTheAdminRole(t *testing.T) {
+	f := newAdminFixture(t)
+	userToken := f.token(t, f.user)
+
+	if w := f.do("", http.MethodGet, "/admin/users", nil); w.Code != http.StatusUnauthorized {
+		t.Fatalf("anonymous: got %d, want 401", w.Code)
+	}
+	if w := f.do(userToken, http.MethodGet, "/admin/users", nil); w.Code != http.StatusForbidden {
+		t.Fatalf("user: got %d, want 403", w.Code)
+	}
+	if w := f.do(f.token(t, f.admin), http.MethodGet, "/admin/users", nil); w.Code != http.StatusOK {
+		t.Fatalf("admin: got %d: %s", w.Code, w.Body)
+	}
+
+	// The role is read from the account, so a promotion applies to the
+	// token the user already has, and a token claiming admin is not enough
+	forged := f.user
+	forged.Role = services.RoleAdmin
+	if w := f.do(f.token(t, forged), http.MethodGet, "/admin/users", nil); w.Code != http.StatusForbidden {
+		t.Fatalf("forged role claim: got %d, want 403", w.Code)
+	}
+	if err := f.db.Model(&f.user).Update("role", services.RoleAdmin).Error; err != nil {
+		t.Fatal(err)
+	}
+	if w := f.do(userToken, http.MethodGet, "/admin/users", nil); w.Code != http.StatusOK {
+		t.Fatalf("promoted user: got %d: %s", w.Code, w.Body)
+	}
+
+	// Admin routes are for interactive sessions, not API keys
+	key, _, err := f.apiKeys.Create(f.admin.ID, "ci", services.ValidScopes, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := f.do(key, http.MethodGet, "/admin/users", nil); w.Code != http.StatusForbidden {
+		t.Fatalf("admin API key: got %d, want 403", w.Code)
+	}
+}
+
+func TestAdminUpdatesUsers(t *testing.T) {
+	f := newAdminFixture(t)
+	adminToken, userToken := f.token(t, f.admin), f.token(t, f.user)
+
+	w := f.do(adminToken, http.MethodGet, "/admin/users?limit=1", nil)
+	var page struct {
+		Users []AdminUserResponse `json:"users"`
+		Total int64               `json:"total"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+		t.Fatal(err)
+	}
+	if page.Total != 2 || len(page.Users) != 1 || page.Users[0].ID != f.admin.ID {
+		t.Fatalf("got page %+v, want the admin of 2 users", page)
+	}
+
+	tests := []struct {
+		name   string
+		target string
+		body   gin.H
+		want   int
+	}{
+		{"own account", fmt.Sprintf("/admin/users/%d", f.admin.ID), gin.H{"disabled": true}, http.StatusBadRequest},
+		{"unknown user", "/admin/users/999", gin.H{"disabled": true}, http.StatusNotFound},
+		{"unknown role", fmt.Sprintf("/admin/users/%d", f.user.ID), gin.H{"role": "owner"}, http.StatusBadRequest},
+		{"disable", fmt.Sprintf("/admin/users/%d", f.user.ID), gin.H{"disabled": true}, http.StatusOK},
+	}
+	for _, tt := range tests {
+		if w := f.do(adminToken, http.MethodPatch, tt.target, tt.body); w.Code != tt.want {
+			t.Fatalf("%s: got %d, want %d: %s", tt.name, w.Code, tt.want, w.Body)
+		}
+	}
+
+	// A disabled account is locked out at once, even with a valid token
+	if w := f.do(userToken, http.MethodGet, "/admin/users", nil); w.Code != http.StatusForbidden {
+		t.Fatalf("disabled user: got %d, want 403", w.Code)
+	}
+
+	if w := f.do(adminToken, http.MethodPatch, fmt.Sprintf("/admin/users/%d", f.user.ID), gin.H{"disabled": false, "role": "admin"}); w.Code != http.StatusOK {
+		t.Fatalf("re-enable and promote: got %d: %s", w.Code, w.Body)
+	}
+	if w := f.do(userToken, http.MethodGet, "/admin/users", nil); w.Code != http.StatusOK {
+		t.Fatalf("re-enabled admin: got %d: %s", w.Code, w.Body)
+	}
+}
+
+func TestAdminJobsReportStaleJobs(t *testing.T) {
+	f := newAdminFixture(t)
+	adminToken := f.token(t, f.admin)
+
+	// A processing record left without a running task, as after a restart
+	mustCreate(t, f.db, &models.GeneratedMosaic{UserID: f.user.ID, ProjectID: 1, Status: "processing"})
+	mustCreate(t, f.db, &models.GeneratedMosaic{UserID: f.user.ID, ProjectID: 1, Status: "completed"})
+
+	w := f.do(adminToken, http.MethodGet, "/admin/jobs", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d: %s", w.Code, w.Body)
+	}
+	var jobs struct {
+		Running   []gin.H `json:"running"`
+		StaleJobs int64   `json:"stale_jobs"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &jobs); err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs.Running) != 0 || jobs.StaleJobs != 1 {
+		t.Fatalf("got %+v, want no running jobs and one stale job", jobs)
+	}
+
+	// Cancelling clears a stale job, while finished jobs cannot be cancelled
+	if w := f.do(adminToken, http.MethodPost, "/admin/jobs/1/cancel", nil); w.Code != http.StatusOK {
+		t.Fatalf("cancelling the stale job: got %d: %s", w.Code, w.Body)
+	}
+	if w := f.do(adminToken, http.MethodPost, "/admin/jobs/2/cancel", nil); w.Code != http.StatusNotFound {
+		t.Fatalf("cancelling a completed job: got %d, want 404", w.Code)
+	}
+	w = f.do(adminToken, http.MethodGet, "/admin/jobs", nil)
+	if err := json.Unmarshal(w.Body.Bytes(), &jobs); err != nil {
+		t.Fatal(err)
+	}
+	if jobs.StaleJobs != 0 {
+		t.Fatalf("got %d stale jobs after cancelling, want 0", jobs.StaleJobs)
+	}
+}
+
+func TestAdminStorageUsage(t *testing.T) {
+	f := newAdminFixture(t)
+	for name, size := range map[string]int{"user_2/a.jpg": 100, "user_2/project_1/b.jpg": 50, "user_x/c.jpg": 10} {
+		path := filepath.Join(f.uploadDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, make([]byte, size), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mustCreate(t, f.db, &models.Image{UserID: 2, Type: "main", Path: "/uploads/user_2/a.jpg"})
+
+	w := f.do(f.token(t, f.admin), http.MethodGet, "/admin/storage", nil)
+	var usage struct {
+		Users      []services.UserStorageUsage `json:"users"`
+		TotalBytes int64                       `json:"total_bytes"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &usage); err != nil {
+		t.Fatal(err)
+	}
+	want := services.UserStorageUsage{UserID: 2, Bytes: 150, FileCount: 2, ImageCount: 1}
+	if len(usage.Users) != 1 || usage.Users[0] != want || usage.TotalBytes != 150 {
+		t.Fatalf("got %+v, want only %+v", usage, want)
+	}
+}
@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/amityadav9314/goinkgrid/internal/db/models"
+	"github.com/amityadav9314/goinkgrid/internal/services"
+	"github.com/gin-gonic/gin"
+)
+
+// APIKeyHandler handles personal API key management requests
+type APIKeyHandler struct {
+	apiKeyService services.APIKeyService
+}
+
+// NewAPIKeyHandler creates a new API key handler
+func NewAPIKeyHandler(apiKeyService services.APIKeyService) *APIKeyHandler {
+	return &APIKeyHandler{
+		apiKeyService: apiKeyService,
+	}
+}
+
+// CreateAPIKeyRequest represents a create API key request
+type CreateAPIKeyRequest struct {
+	Name      string     `json:"name" binding:"required,max=100"`
+	Scopes    []string   `json:"scopes" binding:"required,min=1"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// APIKeyResponse represents an API key response. Key is only set when the
+// key has just been created.
+type APIKeyResponse struct {
+	ID         uint       `json:"id"`
+	Name       string     `json:"name"`
+	Key        string     `json:"key,omitempty"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// ListAPIKeys returns the user's API keys
+func (h *APIKeyHandler) ListAPIKeys(c *gin.Context) {
+	// Get user ID from context (set by auth middleware)
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	keys, err := h.apiKeyService.FindByUserID(userID.(uint))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch API keys"})
+		return
+	}
+
+	responses := make([]APIKeyResponse, 0, len(keys))
+	for i := range keys {
+		responses = append(responses, toAPIKeyResponse(&keys[i]))
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"api_keys": responses,
+		"count":    len(responses),
+	})
+}
+
+// CreateAPIKey creates a new API key and returns the plaintext key once
+func (h *APIKeyHandler) CreateAPIKey(c *gin.Context) {
+	// Get user ID from context (set by auth middleware)
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var req CreateAPIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.ExpiresAt != nil && req.ExpiresAt.Before(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Expiry must be in the future"})
+		return
+	}
+
+	rawKey, apiKey, err := h.apiKeyService.Create(userID.(uint), req.Name, req.Scopes, req.ExpiresAt)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	response := toAPIKeyResponse(apiKey)
+	response.Key = rawKey
+
+	c.JSON(http.StatusCreated, response)
+}
+
+// RevokeAPIKey revokes one of the user's API keys
+func (h *APIKeyHandler) RevokeAPIKey(c *gin.Context) {
+	// Get user ID from context (set by auth middleware)
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	keyID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid API key ID"})
+		return
+	}
+
+	if err := h.apiKeyService.Revoke(uint(keyID), userID.(uint)); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "API key revoked successfully"})
+}
+
+// toAPIKeyResponse converts an API key model to its response format
+func toAPIKeyResponse(apiKey *models.APIKey) APIKeyResponse {
+	return APIKeyResponse{
+		ID:         apiKey.ID,
+		Name:       apiKey.Name,
+		Prefix:     services.APIKeyPrefix + apiKey.Prefix,
+		Scopes:     strings.Fields(apiKey.Scopes),
+		ExpiresAt:  apiKey.ExpiresAt,
+		LastUsedAt: apiKey.LastUsedAt,
+		RevokedAt:  apiKey.RevokedAt,
+		CreatedAt:  apiKey.CreatedAt,
+	}
+}
@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/amityadav9314/goinkgrid/internal/services"
+	"github.com/gin-gonic/gin"
+)
+
+// ArchiveHandler handles project export and import requests
+type ArchiveHandler struct {
+	archiveService services.ProjectArchiveService
+	policy         services.AccessPolicy
+}
+
+// NewArchiveHandler creates a new archive handler
+func NewArchiveHandler(archiveService services.ProjectArchiveService, policy services.AccessPolicy) *ArchiveHandler {
+	return &ArchiveHandler{
+		archiveService: archiveService,
+		policy:         policy,
+	}
+}
+
+// ExportProject sends a ZIP archive of a project with its manifest and
+// every referenced file
+func (h *ArchiveHandler) ExportProject(c *gin.Context) {
+	projectID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		return
+	}
+
+	// Exports carry everything in the project, so only owners can take one
+	project, _, ok := authorizeProject(c, h.policy, uint(projectID), services.ProjectRoleOwner)
+	if !ok {
+		return
+	}
+
+	// The archive is built in a temporary file before anything is sent, so
+	// a failure is reported as an error instead of a truncated download
+	tmp, err := os.CreateTemp("", "inkgrid-export-*.zip")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export project"})
+		return
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	if err := h.archiveService.Export(project.ID, tmp); err != nil {
+		fmt.Printf("Error exporting project %d: %v\n", project.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export project"})
+		return
+	}
+	size, err := tmp.Seek(0, io.SeekCurrent)
+	if err == nil {
+		_, err = tmp.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export project"})
+		return
+	}
+
+	c.DataFromReader(http.StatusOK, size, "application/zip", tmp, map[string]string{
+		"Content-Disposition": fmt.Sprintf(`attachment; filename="project-%d.zip"`, project.ID),
+	})
+}
+
+// ImportProject recreates a project from an uploaded archive under the
+// authenticated user
+func (h *ArchiveHandler) ImportProject(c *gin.Context) {
+	// Get user ID from context (set by auth middleware)
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	fileHeader, err := c.FormFile("archive")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No archive provided"})
+		return
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read archive"})
+		return
+	}
+	defer file.Close()
+
+	project, err := h.archiveService.Import(userID.(uint), file, fileHeader.Size)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidArchive) || errors.Is(err, services.ErrUnsupportedArchive) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to import project"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, toProjectResponse(project, services.ProjectRoleOwner))
+}
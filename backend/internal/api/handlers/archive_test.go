@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/amityadav9314/goinkgrid/internal/db/models"
+	"github.com/amityadav9314/goinkgrid/internal/services"
+	"github.com/gin-gonic/gin"
+)
+
+// failingArchiveService writes part of an archive and then fails
+type failingArchiveService struct {
+	services.ProjectArchiveService
+}
+
+func (failingArchiveService) Export(projectID uint, w io.Writer) error {
+	zw := zip.NewWriter(w)
+	entry, err := zw.Create("manifest.json")
+	if err != nil {
+		return err
+	}
+	entry.Write(bytes.Repeat([]byte("x"), 64<<10))
+	zw.Flush()
+	return errors.New("disk went away")
+}
+
+func TestExportProject(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	gdb := newTestDB(t)
+	user := models.User{Email: "ada@example.com", PasswordHash: "x", Role: services.RoleUser}
+	mustCreate(t, gdb, &user)
+	project := models.Project{UserID: user.ID, Name: "Garden"}
+	mustCreate(t, gdb, &project)
+	policy := services.NewAccessPolicy(gdb, services.NewProjectMemberService(gdb))
+
+	export := func(archiveService services.ProjectArchiveService) *httptest.ResponseRecorder {
+		r := gin.New()
+		r.Use(func(c *gin.Context) { c.Set("userID", user.ID) })
+		r.GET("/projects/:id/export", NewArchiveHandler(archiveService, policy).ExportProject)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/projects/"+strconv.Itoa(int(project.ID))+"/export", nil))
+		return w
+	}
+
+	w := export(services.NewProjectArchiveService(gdb, t.TempDir()))
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/zip" {
+		t.Fatalf("export: got %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+	if w.Header().Get("Content-Length") != strconv.Itoa(w.Body.Len()) {
+		t.Fatalf("Content-Length %s, body %d bytes", w.Header().Get("Content-Length"), w.Body.Len())
+	}
+	if _, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len())); err != nil {
+		t.Fatalf("export is not a ZIP file: %v", err)
+	}
+
+	// A failure part way through is an error, not a truncated download
+	w = export(failingArchiveService{})
+	if w.Code != http.StatusInternalServerError || w.Header().Get("Content-Type") == "application/zip" {
+		t.Fatalf("failed export: got %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+}
+
+func (failingArchiveService) Import(userID uint, r io.ReaderAt, size int64) (*models.Project, error) {
+	return nil, errors.New("disk went away")
+}
+
+func TestImportProject(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	gdb := newTestDB(t)
+	policy := services.NewAccessPolicy(gdb, services.NewProjectMemberService(gdb))
+
+	upload := func(archiveService services.ProjectArchiveService, data []byte) *httptest.ResponseRecorder {
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		part, err := mw.CreateFormFile("archive", "project.zip")
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write(data)
+		mw.Close()
+
+		r := gin.New()
+		r.Use(func(c *gin.Context) { c.Set("userID", uint(1)) })
+		r.POST("/projects/import", NewArchiveHandler(archiveService, policy).ImportProject)
+		req := httptest.NewRequest(http.MethodPost, "/projects/import", &body)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	// A bad upload is the client's to fix, a failure on the server is not
+	if w := upload(services.NewProjectArchiveService(gdb, t.TempDir()), []byte("not a zip")); w.Code != http.StatusBadRequest {
+		t.Fatalf("invalid archive: got %d: %s", w.Code, w.Body)
+	}
+	if w := upload(failingArchiveService{}, []byte("not a zip")); w.Code != http.StatusInternalServerError {
+		t.Fatalf("failed import: got %d: %s", w.Code, w.Body)
+	}
+}
@@ -1,8 +1,13 @@
 package handlers
 
 import (
+	"fmt"
+	"math"
+	"strconv"
+
 	models "github.com/amityadav9314/goinkgrid/internal/db/models"
 	"github.com/amityadav9314/goinkgrid/internal/services"
+	"github.com/amityadav9314/goinkgrid/pkg/oidc"
 	"net/http"
 	"time"
 
@@ -14,14 +19,23 @@ import (
 // AuthHandler handles authentication related requests
 type AuthHandler struct {
 	userService services.UserService
+	loginGuard  services.LoginGuardService
 	jwtSecret   []byte
+
+	// OIDC single sign-on, nil when disabled
+	oidcClient           *oidc.Client
+	oidcFrontendRedirect string
 }
 
-// NewAuthHandler creates a new auth handler
-func NewAuthHandler(userService services.UserService, jwtSecret string) *AuthHandler {
+// NewAuthHandler creates a new auth handler. oidcClient may be nil to
+// disable single sign-on.
+func NewAuthHandler(userService services.UserService, loginGuard services.LoginGuardService, jwtSecret string, oidcClient *oidc.Client, oidcFrontendRedirect string) *AuthHandler {
 	return &AuthHandler{
-		userService: userService,
-		jwtSecret:   []byte(jwtSecret),
+		userService:          userService,
+		loginGuard:           loginGuard,
+		jwtSecret:            []byte(jwtSecret),
+		oidcClient:           oidcClient,
+		oidcFrontendRedirect: oidcFrontendRedirect,
 	}
 }
 
@@ -60,6 +74,7 @@ func (h *AuthHandler) Register(c *gin.Context) {
 		return
 	}
 	if existingUser != nil {
+		recordAuthAttempt(c, h.loginGuard, services.AuthEventRegister, req.Email, nil, false, "email_taken")
 		c.JSON(http.StatusConflict, gin.H{"error": "Email already registered"})
 		return
 	}
@@ -75,14 +90,18 @@ func (h *AuthHandler) Register(c *gin.Context) {
 	newUser := &models.User{
 		Email:        req.Email,
 		PasswordHash: hashedPassword,
+		Role:         services.RoleUser,
+		Name:         req.Name,
 		CreatedAt:    time.Now(),
 		UpdatedAt:    time.Now(),
 	}
 
 	if err := h.userService.Create(newUser); err != nil {
+		recordAuthAttempt(c, h.loginGuard, services.AuthEventRegister, req.Email, nil, false, "create_failed")
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
 		return
 	}
+	recordAuthAttempt(c, h.loginGuard, services.AuthEventRegister, req.Email, &newUser.ID, true, "")
 
 	c.JSON(http.StatusCreated, gin.H{"message": "User registered successfully"})
 }
@@ -95,21 +114,43 @@ func (h *AuthHandler) Login(c *gin.Context) {
 		return
 	}
 
+	// Reject the attempt while the account or IP is backing off
+	wait, err := h.loginGuard.Check(services.AuthEventLogin, req.Email, c.ClientIP())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check login attempts"})
+		return
+	}
+	if wait > 0 {
+		recordAuthAttempt(c, h.loginGuard, services.AuthEventLogin, req.Email, nil, false, services.AuthReasonThrottled)
+		respondTooManyAttempts(c, wait)
+		return
+	}
+
 	// Get user from database
 	user, err := h.userService.FindByEmail(req.Email)
 	if err != nil {
+		recordAuthAttempt(c, h.loginGuard, services.AuthEventLogin, req.Email, nil, false, "unknown_email")
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid email or password"})
 		return
 	}
 
 	// Compare passwords
 	if !CheckPasswordHash(req.Password, user.PasswordHash) {
+		recordAuthAttempt(c, h.loginGuard, services.AuthEventLogin, req.Email, &user.ID, false, "invalid_password")
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid email or password"})
 		return
 	}
 
+	// Disabled accounts cannot sign in even with the right password
+	if user.DisabledAt != nil {
+		recordAuthAttempt(c, h.loginGuard, services.AuthEventLogin, req.Email, &user.ID, false, "account_disabled")
+		c.JSON(http.StatusForbidden, gin.H{"error": "Account is disabled"})
+		return
+	}
+	recordAuthAttempt(c, h.loginGuard, services.AuthEventLogin, req.Email, &user.ID, true, "")
+
 	// Generate JWT token
-	token, refreshToken, expiresAt, err := h.generateTokens(user.ID, req.Email)
+	token, refreshToken, expiresAt, err := h.generateTokens(user.ID, req.Email, user.Role)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
 		return
@@ -131,7 +172,7 @@ func (h *AuthHandler) RefreshToken(c *gin.Context) {
 }
 
 // generateTokens generates JWT tokens
-func (h *AuthHandler) generateTokens(userID uint, email string) (token string, refreshToken string, expiresAt time.Time, err error) {
+func (h *AuthHandler) generateTokens(userID uint, email string, role string) (token string, refreshToken string, expiresAt time.Time, err error) {
 	// Set token expiration
 	expiresAt = time.Now().Add(24 * time.Hour)
 
@@ -139,6 +180,7 @@ func (h *AuthHandler) generateTokens(userID uint, email string) (token string, r
 	claims := jwt.MapClaims{
 		"id":    userID,
 		"email": email,
+		"role":  role,
 		"exp":   expiresAt.Unix(),
 	}
 
@@ -155,6 +197,33 @@ func (h *AuthHandler) generateTokens(userID uint, email string) (token string, r
 	return token, refreshToken, expiresAt, nil
 }
 
+// recordAuthAttempt writes an attempt to the auth audit log. Failures to
+// write the log are reported but never fail the request.
+func recordAuthAttempt(c *gin.Context, loginGuard services.LoginGuardService, event, email string, userID *uint, success bool, reason string) {
+	entry := &models.AuthAuditLog{
+		UserID:    userID,
+		Email:     email,
+		IP:        c.ClientIP(),
+		Event:     event,
+		Success:   success,
+		Reason:    reason,
+		UserAgent: c.Request.UserAgent(),
+	}
+	if err := loginGuard.Record(entry); err != nil {
+		fmt.Printf("Error writing auth audit log: %v\n", err)
+	}
+}
+
+// respondTooManyAttempts rejects a throttled attempt with 429 and Retry-After
+func respondTooManyAttempts(c *gin.Context, wait time.Duration) {
+	retryAfter := int(math.Ceil(wait.Seconds()))
+	c.Header("Retry-After", strconv.Itoa(retryAfter))
+	c.JSON(http.StatusTooManyRequests, gin.H{
+		"error":       "Too many failed attempts, try again later",
+		"retry_after": retryAfter,
+	})
+}
+
 // HashPassword hashes a password
 func HashPassword(password string) (string, error) {
 	bytes, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/amityadav9314/goinkgrid/internal/db/models"
+	"github.com/amityadav9314/goinkgrid/internal/services"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// loginFixture is the login endpoint behind a router that trusts no
+// proxies, as the server is configured by default
+type loginFixture struct {
+	db     *gorm.DB
+	router *gin.Engine
+}
+
+func newLoginFixture(t *testing.T, cfg services.LoginGuardConfig) *loginFixture {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	gdb := newTestDB(t)
+	hash, err := HashPassword("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustCreate(t, gdb, &models.User{Email: "ada@example.com", PasswordHash: hash, Role: services.RoleUser})
+
+	handler := NewAuthHandler(services.NewUserService(gdb), services.NewLoginGuardService(gdb, cfg), "test-secret", nil, "")
+	r := gin.New()
+	if err := r.SetTrustedProxies(nil); err != nil {
+		t.Fatal(err)
+	}
+	r.POST("/login", handler.Login)
+	return &loginFixture{db: gdb, router: r}
+}
+
+// login attempts a login, claiming to be forwarded for ip if it is set
+func (f *loginFixture) login(email, password, forwardedFor string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/login",
+		strings.NewReader(fmt.Sprintf(`{"email": %q, "password": %q}`, email, password)))
+	req.Header.Set("Content-Type", "application/json")
+	if forwardedFor != "" {
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+	}
+	w := httptest.NewRecorder()
+	f.router.ServeHTTP(w, req)
+	return w
+}
+
+// retryAfter returns the Retry-After seconds of a throttled response
+func retryAfter(t *testing.T, w *httptest.ResponseRecorder) int {
+	t.Helper()
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("got %d, want 429: %s", w.Code, w.Body)
+	}
+	seconds, err := strconv.Atoi(w.Header().Get("Retry-After"))
+	if err != nil {
+		t.Fatalf("Retry-After %q: %v", w.Header().Get("Retry-After"), err)
+	}
+	return seconds
+}
+
+func TestLoginBacksOffAfterAFailure(t *testing.T) {
+	f := newLoginFixture(t, services.LoginGuardConfig{BaseBackoff: 30 * time.Second})
+
+	if w := f.login("ada@example.com", "wrong", ""); w.Code != http.StatusUnauthorized {
+		t.Fatalf("wrong password: got %d, want 401", w.Code)
+	}
+	// Even the right password waits out the backoff
+	w := f.login("ada@example.com", "correct horse", "")
+	if seconds := retryAfter(t, w); seconds < 29 || seconds > 30 {
+		t.Fatalf("Retry-After %d, want 30", seconds)
+	}
+}
+
+func TestLoginLocksTheAccount(t *testing.T) {
+	f := newLoginFixture(t, services.LoginGuardConfig{
+		BaseBackoff: time.Nanosecond, MaxBackoff: time.Nanosecond,
+		MaxAccountFailures: 3, LockoutDuration: 10 * time.Minute,
+	})
+
+	for i := 0; i < 3; i++ {
+		if w := f.login("ada@example.com", "wrong", ""); w.Code != http.StatusUnauthorized {
+			t.Fatalf("failure %d: got %d, want 401", i+1, w.Code)
+		}
+	}
+	w := f.login("ada@example.com", "correct horse", "")
+	if seconds := retryAfter(t, w); seconds < 599 || seconds > 600 {
+		t.Fatalf("Retry-After %d, want 600", seconds)
+	}
+
+	// Every attempt is audited, and the throttled one is marked as such
+	var logs []models.AuthAuditLog
+	if err := f.db.Order("id").Find(&logs).Error; err != nil {
+		t.Fatal(err)
+	}
+	reasons := make([]string, len(logs))
+	for i, entry := range logs {
+		if entry.Event != services.AuthEventLogin || entry.Email != "ada@example.com" || entry.Success || entry.IP != "192.0.2.1" {
+			t.Errorf("audit row %+v", entry)
+		}
+		reasons[i] = entry.Reason
+	}
+	want := "invalid_password,invalid_password,invalid_password," + services.AuthReasonThrottled
+	if strings.Join(reasons, ",") != want {
+		t.Fatalf("audit reasons %v, want %s", reasons, want)
+	}
+}
+
+func TestLoginLocksTheIPWhateverItClaimsToForward(t *testing.T) {
+	f := newLoginFixture(t, services.LoginGuardConfig{
+		BaseBackoff: time.Nanosecond, MaxBackoff: time.Nanosecond,
+		MaxIPFailures: 3, LockoutDuration: 10 * time.Minute,
+	})
+
+	// Each attempt tries another account and a new forwarded address
+	for i := 0; i < 3; i++ {
+		w := f.login(fmt.Sprintf("user%d@example.com", i), "wrong", fmt.Sprintf("203.0.113.%d", i+1))
+		if w.Code != http.StatusUnauthorized {
+			t.Fatalf("failure %d: got %d, want 401", i+1, w.Code)
+		}
+	}
+	retryAfter(t, f.login("ada@example.com", "correct horse", "203.0.113.99"))
+}
@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/amityadav9314/goinkgrid/pkg/signedurl"
+	"github.com/gin-gonic/gin"
+)
+
+// filesRoute is where signed file URLs are served from
+const filesRoute = "/goinkgrid/files"
+
+// FileHandler serves uploaded and generated files through signed URLs
+type FileHandler struct {
+	uploadDir string
+	signer    *signedurl.Signer
+}
+
+// NewFileHandler creates a new file handler
+func NewFileHandler(uploadPath string, signer *signedurl.Signer) *FileHandler {
+	return &FileHandler{
+		uploadDir: uploadPath,
+		signer:    signer,
+	}
+}
+
+// ServeFile serves a file from the uploads directory once its signature and
+// expiry have been verified
+func (h *FileHandler) ServeFile(c *gin.Context) {
+	filePath := cleanFilePath(c.Param("filepath"))
+	if filePath == "/" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return
+	}
+
+	if err := h.signer.Verify(filePath, c.Query("expires"), c.Query("sig")); err != nil {
+		if errors.Is(err, signedurl.ErrExpired) {
+			c.JSON(http.StatusGone, gin.H{"error": "Link has expired"})
+			return
+		}
+		c.JSON(http.StatusForbidden, gin.H{"error": "Invalid file signature"})
+		return
+	}
+
+	fullPath := uploadFilePath(h.uploadDir, filePath)
+	info, err := os.Stat(fullPath)
+	if err != nil || info.IsDir() {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return
+	}
+
+	// Signed URLs must not outlive their signature in shared caches
+	c.Header("Cache-Control", "private, max-age=300")
+	c.File(fullPath)
+}
+
+// signedFileURL builds a full, signed URL for a path relative to the
+// uploads directory
+func signedFileURL(c *gin.Context, signer *signedurl.Signer, filePath string) string {
+	if filePath == "" || strings.HasPrefix(filePath, "http") {
+		return filePath
+	}
+
+	filePath = cleanFilePath(filePath)
+	return fmt.Sprintf("%s%s%s?%s", requestBaseURL(c), filesRoute, filePath, signer.Sign(filePath))
+}
+
+// requestBaseURL returns the scheme and host the request was made to
+func requestBaseURL(c *gin.Context) string {
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s", scheme, c.Request.Host)
+}
+
+// uploadFilePath resolves a stored file path to its location on disk
+func uploadFilePath(uploadDir, filePath string) string {
+	return filepath.Join(uploadDir, filepath.FromSlash(cleanFilePath(filePath)))
+}
+
+// cleanFilePath normalizes a stored or requested file path so it always
+// starts with a slash and cannot escape the uploads directory
+func cleanFilePath(filePath string) string {
+	filePath = filepath.ToSlash(filePath)
+	filePath = strings.TrimPrefix(filePath, "/uploads/")
+	return path.Clean("/" + filePath)
+}
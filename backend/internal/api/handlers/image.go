@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"github.com/amityadav9314/goinkgrid/internal/db/models"
 	"github.com/amityadav9314/goinkgrid/internal/services"
+	"github.com/amityadav9314/goinkgrid/pkg/signedurl"
+	"mime/multipart"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -19,13 +21,17 @@ import (
 type ImageHandler struct {
 	uploadDir    string
 	imageService services.ImageService
+	policy       services.AccessPolicy
+	signer       *signedurl.Signer
 }
 
 // NewImageHandler creates a new image handler
-func NewImageHandler(uploadPath string, imageService services.ImageService) *ImageHandler {
+func NewImageHandler(uploadPath string, imageService services.ImageService, policy services.AccessPolicy, signer *signedurl.Signer) *ImageHandler {
 	return &ImageHandler{
 		uploadDir:    uploadPath,
 		imageService: imageService,
+		policy:       policy,
+		signer:       signer,
 	}
 }
 
@@ -75,6 +81,13 @@ func (h *ImageHandler) UploadMainImage(c *gin.Context) {
 		fmt.Println("No project ID provided")
 	}
 
+	// Uploading into a project needs edit access to it
+	if projectID != nil {
+		if _, _, ok := authorizeProject(c, h.policy, *projectID, services.ProjectRoleEditor); !ok {
+			return
+		}
+	}
+
 	// Get file from form
 	file, err := c.FormFile("image")
 	if err != nil {
@@ -109,37 +122,20 @@ func (h *ImageHandler) UploadMainImage(c *gin.Context) {
 
 	// Generate unique filename for main image
 	filename := "main_" + uuid.New().String() + ext
-	dst := filepath.Join(projectDir, filename)
 
 	// Save file
-	if err := c.SaveUploadedFile(file, dst); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save image"})
-		return
-	}
-
-	// Get image dimensions using image package
-	imgFile, err := os.Open(dst)
+	imagePath, width, height, err := saveImageUpload(c, h.uploadDir, file, projectDir, filename)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process image"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save image"})
 		return
 	}
-	defer imgFile.Close()
-
-	imgConfig, _, err := image.DecodeConfig(imgFile)
-	var width, height int
-	if err != nil {
+	if width == 0 || height == 0 {
 		// If we can't decode the image, use placeholders
 		width, height = 800, 600
-	} else {
-		width, height = imgConfig.Width, imgConfig.Height
 	}
 
 	// Create image metadata in database
 	imageID := uuid.New().String()
-	imagePath := strings.TrimPrefix(dst, h.uploadDir) // Store relative path
-	if !strings.HasPrefix(imagePath, "/") {
-		imagePath = "/" + imagePath
-	}
 
 	imageModel := &models.Image{
 		UserID:    userID.(uint),
@@ -165,7 +161,7 @@ func (h *ImageHandler) UploadMainImage(c *gin.Context) {
 		UserID:    userID.(uint),
 		ProjectID: projectID,
 		Type:      "main",
-		Path:      imagePath,
+		Path:      signedFileURL(c, h.signer, imagePath),
 		Filename:  file.Filename,
 		Width:     width,
 		Height:    height,
@@ -206,6 +202,13 @@ func (h *ImageHandler) UploadTileImages(c *gin.Context) {
 		fmt.Println("Tile upload - No project ID provided")
 	}
 
+	// Uploading into a project needs edit access to it
+	if projectID != nil {
+		if _, _, ok := authorizeProject(c, h.policy, *projectID, services.ProjectRoleEditor); !ok {
+			return
+		}
+	}
+
 	// Get collection ID from query if present
 	// Commented out until implemented
 	/*
@@ -266,36 +269,19 @@ func (h *ImageHandler) UploadTileImages(c *gin.Context) {
 
 		// Generate unique filename for tile image
 		filename := fmt.Sprintf("tile_%d_%s%s", i+1, uuid.New().String(), ext)
-		dst := filepath.Join(tilesDir, filename)
 
 		// Save file
-		if err := c.SaveUploadedFile(file, dst); err != nil {
-			continue // Skip failed files
-		}
-
-		// Get image dimensions using image package
-		imgFile, err := os.Open(dst)
+		imagePath, width, height, err := saveImageUpload(c, h.uploadDir, file, tilesDir, filename)
 		if err != nil {
 			continue // Skip failed files
 		}
-
-		imgConfig, _, err := image.DecodeConfig(imgFile)
-		imgFile.Close()
-
-		var width, height int
-		if err != nil {
+		if width == 0 || height == 0 {
 			// If we can't decode the image, use placeholders
 			width, height = 400, 300
-		} else {
-			width, height = imgConfig.Width, imgConfig.Height
 		}
 
 		// Create image metadata in database
 		imageID := uuid.New().String()
-		imagePath := strings.TrimPrefix(dst, h.uploadDir) // Store relative path
-		if !strings.HasPrefix(imagePath, "/") {
-			imagePath = "/" + imagePath
-		}
 
 		imageModel := &models.Image{
 			UserID:    userID.(uint),
@@ -322,7 +308,7 @@ func (h *ImageHandler) UploadTileImages(c *gin.Context) {
 			UserID:    userID.(uint),
 			ProjectID: projectID,
 			Type:      "tile",
-			Path:      imagePath,
+			Path:      signedFileURL(c, h.signer, imagePath),
 			Filename:  file.Filename,
 			Width:     width,
 			Height:    height,
@@ -372,11 +358,11 @@ func (h *ImageHandler) GetTileCollections(c *gin.Context) {
 // GetProjectImages returns all images for a specific project
 func (h *ImageHandler) GetProjectImages(c *gin.Context) {
 	// Get user ID from context (set by auth middleware)
-	//userID, exists := c.Get("userID")
-	//if !exists {
-	//	c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
-	//	return
-	//}
+	_, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
 
 	// Get project ID from path parameter
 	projectIDStr := c.Param("id")
@@ -394,6 +380,10 @@ func (h *ImageHandler) GetProjectImages(c *gin.Context) {
 	// Convert to uint
 	projectIDUint := uint(projectID)
 
+	if _, _, ok := authorizeProject(c, h.policy, projectIDUint, services.ProjectRoleViewer); !ok {
+		return
+	}
+
 	// Get images from database
 	images, err := h.imageService.FindByProjectID(projectIDUint)
 	if err != nil {
@@ -404,17 +394,8 @@ func (h *ImageHandler) GetProjectImages(c *gin.Context) {
 	// Map database models to response format
 	responses := make([]ImageResponse, 0, len(images))
 	for _, img := range images {
-		// Build full URL for image path
-		imagePath := img.Path
-		if !strings.HasPrefix(imagePath, "http") {
-			// If it's a relative path, prepend the base URL
-			baseURL := c.Request.Host
-			scheme := "http"
-			if c.Request.TLS != nil {
-				scheme = "https"
-			}
-			imagePath = fmt.Sprintf("%s://%s%s", scheme, baseURL, imagePath)
-		}
+		// Build signed URL for image path
+		imagePath := signedFileURL(c, h.signer, img.Path)
 
 		responses = append(responses, ImageResponse{
 			ID:        fmt.Sprintf("%d", img.ID), // Convert uint to string
@@ -448,6 +429,63 @@ func (h *ImageHandler) GetProjectImages(c *gin.Context) {
 	})
 }
 
+// DeleteImage moves one of the user's uploaded images to the trash
+func (h *ImageHandler) DeleteImage(c *gin.Context) {
+	// Get user ID from context (set by auth middleware)
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	imageID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid image ID"})
+		return
+	}
+
+	// Only the uploader can delete an image
+	if err := h.imageService.Delete(uint(imageID), userID.(uint)); err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete image"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Image moved to trash"})
+}
+
+// saveImageUpload stores an uploaded image file as dir/filename and returns its
+// path relative to uploadDir together with its dimensions. Width and height are
+// zero when the stored file cannot be decoded as an image.
+func saveImageUpload(c *gin.Context, uploadDir string, file *multipart.FileHeader, dir, filename string) (string, int, int, error) {
+	dst := filepath.Join(dir, filename)
+	if err := c.SaveUploadedFile(file, dst); err != nil {
+		return "", 0, 0, err
+	}
+
+	// Get image dimensions using image package
+	imgFile, err := os.Open(dst)
+	if err != nil {
+		return "", 0, 0, err
+	}
+	defer imgFile.Close()
+
+	var width, height int
+	if imgConfig, _, err := image.DecodeConfig(imgFile); err == nil {
+		width, height = imgConfig.Width, imgConfig.Height
+	}
+
+	imagePath := strings.TrimPrefix(dst, uploadDir) // Store relative path
+	if !strings.HasPrefix(imagePath, "/") {
+		imagePath = "/" + imagePath
+	}
+
+	return imagePath, width, height, nil
+}
+
 // Helper function to validate image extensions
 func isValidImageExt(ext string) bool {
 	validExts := map[string]bool{
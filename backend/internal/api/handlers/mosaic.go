@@ -1,25 +1,34 @@
 package handlers
 
 import (
+	"bytes"
+	"errors"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"fmt"
 	"github.com/amityadav9314/goinkgrid/internal/db/models"
 	"github.com/amityadav9314/goinkgrid/internal/services"
+	"github.com/amityadav9314/goinkgrid/pkg/render"
+	"github.com/amityadav9314/goinkgrid/pkg/signedurl"
 	"github.com/gin-gonic/gin"
 )
 
 // MosaicHandler handles mosaic generation requests
 type MosaicHandler struct {
 	mosaicService services.MosaicService
+	policy        services.AccessPolicy
+	signer        *signedurl.Signer
 }
 
 // NewMosaicHandler creates a new mosaic handler
-func NewMosaicHandler(mosaicService services.MosaicService) *MosaicHandler {
+func NewMosaicHandler(mosaicService services.MosaicService, policy services.AccessPolicy, signer *signedurl.Signer) *MosaicHandler {
 	return &MosaicHandler{
 		mosaicService: mosaicService,
+		policy:        policy,
+		signer:        signer,
 	}
 }
 
@@ -33,12 +42,27 @@ type MosaicGenerationRequest struct {
 	OverlayRatio    float64  `json:"overlay_ratio" binding:"required,min=0,max=1"`
 	Style           string   `json:"style" binding:"required,oneof=classic random flowing"`
 	ColorCorrection bool     `json:"color_correction"`
+	Seed            *int64   `json:"seed"` // the saved seed, or a random one, if omitted
+	// Output size in pixels or as a print size; the main image size if omitted
+	Output *render.OutputSpec `json:"output"`
+	// Tile shape and grout; square tiles edge to edge if omitted
+	Shape *render.ShapeSpec `json:"shape"`
+	// Tile mask and background; tiles everywhere if omitted
+	Mask *render.MaskSpec `json:"mask"`
+	// Extra formats to export alongside the HD JPEG
+	Exports []render.ExportSpec `json:"exports"`
+}
+
+// MosaicExportRequest represents a request to export a completed mosaic
+type MosaicExportRequest struct {
+	Exports []render.ExportSpec `json:"exports" binding:"required,min=1"`
 }
 
 // MosaicGenerationResponse represents the mosaic generation response
 type MosaicGenerationResponse struct {
 	ID        string    `json:"id"`
 	Status    string    `json:"status"`
+	Seed      int64     `json:"seed"`
 	CreatedAt time.Time `json:"created_at"`
 }
 
@@ -48,34 +72,95 @@ type MosaicSettings struct {
 	TileDensity     int    `json:"tile_density" binding:"required,min=1,max=100"`
 	ColorAdjustment int    `json:"color_adjustment" binding:"required,min=0,max=100"`
 	Style           string `json:"style" binding:"required,oneof=classic random flowing"`
+	Seed            *int64 `json:"seed"`
 }
 
 // GenerateMosaic handles mosaic generation requests
 func (h *MosaicHandler) GenerateMosaic(c *gin.Context) {
+	userID, projectID, snapshot, _, exports, ok := h.bindGenerationRequest(c)
+	if !ok {
+		return
+	}
+
+	// Start mosaic generation
+	mosaic, err := h.mosaicService.GenerateMosaic(userID, projectID, snapshot, nil, exports)
+	if err != nil {
+		respondGenerationError(c, err)
+		return
+	}
+
+	// Return the mosaic generation ID
+	c.JSON(http.StatusAccepted, MosaicGenerationResponse{
+		ID:        fmt.Sprintf("%d", mosaic.ID),
+		Status:    mosaic.Status,
+		Seed:      snapshot.Seed,
+		CreatedAt: mosaic.CreatedAt,
+	})
+}
+
+// PreviewMosaic renders a small preview of a generation request and returns
+// it as a JPEG, without creating a generation. The seed used is returned in
+// the X-Mosaic-Seed header, so the full generation can match the preview.
+func (h *MosaicHandler) PreviewMosaic(c *gin.Context) {
+	_, _, snapshot, images, _, ok := h.bindGenerationRequest(c)
+	if !ok {
+		return
+	}
+
+	img, err := h.mosaicService.RenderPreview(c.Request.Context(), snapshot, images)
+	if err != nil {
+		if errors.Is(err, services.ErrPreviewTimeout) {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Preview took too long to render, please try again"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := render.EncodeJPEG(&buf, img, 80, render.DefaultDPI); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to encode preview"})
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.Header("X-Mosaic-Seed", strconv.FormatInt(snapshot.Seed, 10))
+	c.Data(http.StatusOK, "image/jpeg", buf.Bytes())
+}
+
+// bindGenerationRequest parses a generation request and checks that the
+// user can generate in the project with its images. It writes the error
+// response and returns false if the request cannot go ahead.
+func (h *MosaicHandler) bindGenerationRequest(c *gin.Context) (uint, uint, *services.MosaicSnapshot, []models.Image, []render.ExportSpec, bool) {
 	// Get user ID from context (set by auth middleware)
 	userID, exists := c.Get("userID")
 	if !exists {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
-		return
+		return 0, 0, nil, nil, nil, false
 	}
 
 	var req MosaicGenerationRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
+		return 0, 0, nil, nil, nil, false
 	}
 
 	// Validate that project ID is provided
 	if req.ProjectID == nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Project ID is required"})
-		return
+		return 0, 0, nil, nil, nil, false
+	}
+
+	// Generating a mosaic needs edit access to the project
+	if _, _, ok := authorizeProject(c, h.policy, *req.ProjectID, services.ProjectRoleEditor); !ok {
+		return 0, 0, nil, nil, nil, false
 	}
 
 	// Parse main image ID
 	mainImageID, err := strconv.ParseUint(req.MainImageID, 10, 32)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid main image ID"})
-		return
+		return 0, 0, nil, nil, nil, false
 	}
 
 	// Parse tile image IDs
@@ -90,45 +175,85 @@ func (h *MosaicHandler) GenerateMosaic(c *gin.Context) {
 
 	if len(tileImageIDs) == 0 {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "No valid tile image IDs provided"})
-		return
+		return 0, 0, nil, nil, nil, false
 	}
 
-	// Create settings for mosaic generation
-	settings := &models.MosaicSettings{
-		UserID:          userID.(uint),
-		ProjectID:       req.ProjectID,
+	// The main, tile and mask images must belong to the caller or to the
+	// project
+	imageIDs := append([]uint{uint(mainImageID)}, tileImageIDs...)
+	if req.Mask != nil && req.Mask.ImageID != 0 {
+		imageIDs = append(imageIDs, req.Mask.ImageID)
+	}
+	images, err := h.policy.AuthorizeImages(userID.(uint), *req.ProjectID, imageIDs)
+	if err != nil {
+		respondAccessError(c, err, "One or more images were not found", "images")
+		return 0, 0, nil, nil, nil, false
+	}
+
+	// Reject output sizes, exports, tile shapes and masks that cannot be
+	// rendered before starting the job
+	var output render.OutputSpec
+	if req.Output != nil {
+		output = *req.Output
+	}
+	size, err := validateOutputSpec(output, images, uint(mainImageID))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return 0, 0, nil, nil, nil, false
+	}
+	for _, spec := range req.Exports {
+		if err := spec.Validate(size); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return 0, 0, nil, nil, nil, false
+		}
+	}
+	if req.Shape != nil {
+		if err := req.Shape.Validate(req.TileSize); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return 0, 0, nil, nil, nil, false
+		}
+	}
+	if req.Mask != nil {
+		if err := req.Mask.Validate(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return 0, 0, nil, nil, nil, false
+		}
+	}
+
+	// Snapshot every input so the generation can be compared and re-rendered
+	snapshot := &services.MosaicSnapshot{
+		MainImageID:     uint(mainImageID),
+		TileImageIDs:    tileImageIDs,
 		TileSize:        req.TileSize,
 		TileDensity:     req.TileDensity,
-		ColorAdjustment: int(req.OverlayRatio * 100),
+		OverlayRatio:    req.OverlayRatio,
 		Style:           req.Style,
+		ColorCorrection: req.ColorCorrection,
+		Seed:            h.generationSeed(userID.(uint), *req.ProjectID, req.Seed),
+		Output:          req.Output,
+		Shape:           req.Shape,
+		Mask:            req.Mask,
 	}
+	return userID.(uint), *req.ProjectID, snapshot, images, req.Exports, true
+}
 
-	// Start mosaic generation
-	mosaic, err := h.mosaicService.GenerateMosaic(
-		userID.(uint),
-		*req.ProjectID,
-		uint(mainImageID),
-		tileImageIDs,
-		settings,
-	)
-
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
+// generationSeed returns the seed a generation is rendered with: the one in
+// the request, which may be 0, else the one saved in the caller's settings
+// for the project, else a random one
+func (h *MosaicHandler) generationSeed(userID, projectID uint, requested *int64) int64 {
+	if requested != nil {
+		return *requested
 	}
-
-	// Return the mosaic generation ID
-	c.JSON(http.StatusAccepted, MosaicGenerationResponse{
-		ID:        fmt.Sprintf("%d", mosaic.ID),
-		Status:    mosaic.Status,
-		CreatedAt: mosaic.CreatedAt,
-	})
+	if settings, err := h.mosaicService.GetSettings(userID, &projectID); err == nil && settings.Seed != nil {
+		return *settings.Seed
+	}
+	return time.Now().UnixNano()
 }
 
 // GetGenerationStatus returns the status of a mosaic generation task
 func (h *MosaicHandler) GetGenerationStatus(c *gin.Context) {
 	// Get user ID from context (set by auth middleware)
-	userID, exists := c.Get("userID")
+	_, exists := c.Get("userID")
 	if !exists {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
 		return
@@ -148,10 +273,9 @@ func (h *MosaicHandler) GetGenerationStatus(c *gin.Context) {
 		return
 	}
 
-	// Get mosaic status
-	mosaic, err := h.mosaicService.GetMosaicStatus(userID.(uint), uint(generationID))
-	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	// Get mosaic status. Any collaborator on the project can follow the generation.
+	mosaic, ok := authorizeMosaic(c, h.policy, uint(generationID), services.ProjectRoleViewer)
+	if !ok {
 		return
 	}
 
@@ -166,18 +290,9 @@ func (h *MosaicHandler) GetGenerationStatus(c *gin.Context) {
 
 	// Add result URLs if completed
 	if mosaic.Status == "completed" {
-		// Build full URLs for the mosaic images
-		baseURL := c.Request.Host
-		scheme := "http"
-		if c.Request.TLS != nil {
-			scheme = "https"
-		}
-
-		sdURL := fmt.Sprintf("%s://%s/uploads%s", scheme, baseURL, mosaic.SDPath)
-		hdURL := fmt.Sprintf("%s://%s/uploads%s", scheme, baseURL, mosaic.HDPath)
-
-		response["sd_url"] = sdURL
-		response["hd_url"] = hdURL
+		// Build signed URLs for the mosaic images
+		response["sd_url"] = signedFileURL(c, h.signer, mosaic.SDPath)
+		response["hd_url"] = signedFileURL(c, h.signer, mosaic.HDPath)
 	}
 
 	// Add error message if failed
@@ -185,13 +300,22 @@ func (h *MosaicHandler) GetGenerationStatus(c *gin.Context) {
 		response["error"] = mosaic.ErrorMessage
 	}
 
+	addSnapshotFields(response, mosaic)
+
+	artifacts, err := h.mosaicService.GetArtifacts([]uint{mosaic.ID})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	response["artifacts"] = h.artifactResponses(c, artifacts)
+
 	c.JSON(http.StatusOK, response)
 }
 
 // GetProjectMosaics returns all mosaics for a project
 func (h *MosaicHandler) GetProjectMosaics(c *gin.Context) {
 	// Get user ID from context (set by auth middleware)
-	userID, exists := c.Get("userID")
+	_, exists := c.Get("userID")
 	if !exists {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
 		return
@@ -211,23 +335,35 @@ func (h *MosaicHandler) GetProjectMosaics(c *gin.Context) {
 		return
 	}
 
+	if _, _, ok := authorizeProject(c, h.policy, uint(projectID), services.ProjectRoleViewer); !ok {
+		return
+	}
+
 	// Get mosaics for the project
-	mosaics, err := h.mosaicService.GetProjectMosaics(userID.(uint), uint(projectID))
+	mosaics, err := h.mosaicService.GetProjectMosaics(uint(projectID))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
+	// Get the exported artifacts of all the mosaics at once
+	mosaicIDs := make([]uint, 0, len(mosaics))
+	for _, mosaic := range mosaics {
+		mosaicIDs = append(mosaicIDs, mosaic.ID)
+	}
+	artifacts, err := h.mosaicService.GetArtifacts(mosaicIDs)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	artifactsByMosaic := make(map[uint][]models.MosaicArtifact)
+	for _, artifact := range artifacts {
+		artifactsByMosaic[artifact.MosaicID] = append(artifactsByMosaic[artifact.MosaicID], artifact)
+	}
+
 	// Build response
 	response := make([]gin.H, 0, len(mosaics))
 	for _, mosaic := range mosaics {
-		// Build full URLs for the mosaic images
-		baseURL := c.Request.Host
-		scheme := "http"
-		if c.Request.TLS != nil {
-			scheme = "https"
-		}
-
 		mosaicResponse := gin.H{
 			"id":               fmt.Sprintf("%d", mosaic.ID),
 			"status":           mosaic.Status,
@@ -243,10 +379,10 @@ func (h *MosaicHandler) GetProjectMosaics(c *gin.Context) {
 		// Add URLs if completed
 		if mosaic.Status == "completed" {
 			if mosaic.SDPath != "" {
-				mosaicResponse["sd_url"] = fmt.Sprintf("%s://%s%s", scheme, baseURL, mosaic.SDPath)
+				mosaicResponse["sd_url"] = signedFileURL(c, h.signer, mosaic.SDPath)
 			}
 			if mosaic.HDPath != "" {
-				mosaicResponse["hd_url"] = fmt.Sprintf("%s://%s%s", scheme, baseURL, mosaic.HDPath)
+				mosaicResponse["hd_url"] = signedFileURL(c, h.signer, mosaic.HDPath)
 			}
 		}
 
@@ -255,6 +391,9 @@ func (h *MosaicHandler) GetProjectMosaics(c *gin.Context) {
 			mosaicResponse["error"] = mosaic.ErrorMessage
 		}
 
+		addSnapshotFields(mosaicResponse, &mosaic)
+		mosaicResponse["artifacts"] = h.artifactResponses(c, artifactsByMosaic[mosaic.ID])
+
 		response = append(response, mosaicResponse)
 	}
 
@@ -264,6 +403,250 @@ func (h *MosaicHandler) GetProjectMosaics(c *gin.Context) {
 	})
 }
 
+// DiffMosaics compares the inputs of two generations the user can view
+func (h *MosaicHandler) DiffMosaics(c *gin.Context) {
+	// Get user ID from context (set by auth middleware)
+	_, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	fromID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid generation ID"})
+		return
+	}
+	toID, err := strconv.ParseUint(c.Param("otherId"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid generation ID"})
+		return
+	}
+
+	from, ok := authorizeMosaic(c, h.policy, uint(fromID), services.ProjectRoleViewer)
+	if !ok {
+		return
+	}
+	to, ok := authorizeMosaic(c, h.policy, uint(toID), services.ProjectRoleViewer)
+	if !ok {
+		return
+	}
+
+	fromSnapshot, err := services.DecodeMosaicSnapshot(from)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read generation inputs"})
+		return
+	}
+	toSnapshot, err := services.DecodeMosaicSnapshot(to)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read generation inputs"})
+		return
+	}
+
+	c.JSON(http.StatusOK, services.DiffMosaicSnapshots(from.ID, fromSnapshot, to.ID, toSnapshot))
+}
+
+// RerenderMosaic starts a new generation with the exact inputs of an
+// earlier one, including its seed
+func (h *MosaicHandler) RerenderMosaic(c *gin.Context) {
+	// Get user ID from context (set by auth middleware)
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	generationID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid generation ID"})
+		return
+	}
+
+	// Re-rendering creates a new generation, so it needs edit access
+	source, ok := authorizeMosaic(c, h.policy, uint(generationID), services.ProjectRoleEditor)
+	if !ok {
+		return
+	}
+
+	snapshot, err := services.DecodeMosaicSnapshot(source)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read generation inputs"})
+		return
+	}
+	if !snapshot.Reproducible() {
+		c.JSON(http.StatusConflict, gin.H{"error": "This generation predates version history and cannot be re-rendered"})
+		return
+	}
+
+	// The images may have been deleted or unshared since the original render
+	imageIDs := append([]uint{snapshot.MainImageID}, snapshot.TileImageIDs...)
+	if snapshot.Mask != nil && snapshot.Mask.ImageID != 0 {
+		imageIDs = append(imageIDs, snapshot.Mask.ImageID)
+	}
+	if _, err := h.policy.AuthorizeImages(userID.(uint), source.ProjectID, imageIDs); err != nil {
+		respondAccessError(c, err, "One or more images of this generation are no longer available", "images")
+		return
+	}
+
+	mosaic, err := h.mosaicService.GenerateMosaic(userID.(uint), source.ProjectID, snapshot, &source.ID, nil)
+	if err != nil {
+		respondGenerationError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, MosaicGenerationResponse{
+		ID:        fmt.Sprintf("%d", mosaic.ID),
+		Status:    mosaic.Status,
+		Seed:      snapshot.Seed,
+		CreatedAt: mosaic.CreatedAt,
+	})
+}
+
+// ExportMosaic exports a completed generation in more formats. The exports
+// are rendered in the background and listed with the generation's status.
+func (h *MosaicHandler) ExportMosaic(c *gin.Context) {
+	generationID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid generation ID"})
+		return
+	}
+
+	var req MosaicExportRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Exporting writes new files to the project, so it needs edit access
+	mosaic, ok := authorizeMosaic(c, h.policy, uint(generationID), services.ProjectRoleEditor)
+	if !ok {
+		return
+	}
+	if mosaic.Status != "completed" {
+		c.JSON(http.StatusConflict, gin.H{"error": "Only completed generations can be exported"})
+		return
+	}
+
+	size := render.Size{Width: mosaic.Width, Height: mosaic.Height, DPI: mosaic.DPI}
+	for _, spec := range req.Exports {
+		if err := spec.Validate(size); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	artifacts, err := h.mosaicService.ExportMosaic(mosaic, req.Exports)
+	if err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"artifacts": h.artifactResponses(c, artifacts)})
+}
+
+// GetDeepZoom serves the descriptor or a tile of the latest Deep Zoom export
+// of a generation. Tiles are requested relative to the descriptor, so a
+// viewer given the descriptor URL and the auth header finds them.
+func (h *MosaicHandler) GetDeepZoom(c *gin.Context) {
+	generationID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid generation ID"})
+		return
+	}
+
+	mosaic, ok := authorizeMosaic(c, h.policy, uint(generationID), services.ProjectRoleViewer)
+	if !ok {
+		return
+	}
+
+	c.Header("Cache-Control", "private, max-age=300")
+	serveDeepZoom(c, h.mosaicService, mosaic.ID)
+}
+
+// serveDeepZoom writes the Deep Zoom file named by the request's tile
+// parameter, or the descriptor if there is none
+func serveDeepZoom(c *gin.Context, mosaicService services.MosaicService, mosaicID uint) {
+	tile := strings.TrimPrefix(c.Param("tile"), "/")
+	path, err := mosaicService.DeepZoomFile(mosaicID, tile)
+	if err != nil {
+		if errors.Is(err, services.ErrResourceNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Deep zoom export not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if tile == "" {
+		c.Header("Content-Type", "application/xml")
+	}
+	c.File(path)
+}
+
+// artifactResponses builds the response entries of exported artifacts,
+// with download URLs for the completed ones
+func (h *MosaicHandler) artifactResponses(c *gin.Context, artifacts []models.MosaicArtifact) []gin.H {
+	response := make([]gin.H, 0, len(artifacts))
+	for _, artifact := range artifacts {
+		artifactResponse := gin.H{
+			"id":      fmt.Sprintf("%d", artifact.ID),
+			"format":  artifact.Format,
+			"options": artifact.Options,
+			"status":  artifact.Status,
+		}
+		if artifact.Layer != "" {
+			artifactResponse["layer"] = artifact.Layer
+		}
+		if artifact.Status == "completed" {
+			artifactResponse["url"] = signedFileURL(c, h.signer, artifact.Path)
+			artifactResponse["size"] = artifact.Size
+		}
+		if artifact.Status == "failed" && artifact.ErrorMessage != "" {
+			artifactResponse["error"] = artifact.ErrorMessage
+		}
+		response = append(response, artifactResponse)
+	}
+	return response
+}
+
+// respondGenerationError reports a generation that could not be started.
+// Outputs too large for the tiles to fit in memory are the caller's to fix.
+func respondGenerationError(c *gin.Context, err error) {
+	if errors.Is(err, services.ErrTileMemory) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+}
+
+// validateOutputSpec checks that an output size can be rendered from the
+// main image among images and returns the size. The size is zero if the
+// main image's dimensions are not known.
+func validateOutputSpec(spec render.OutputSpec, images []models.Image, mainImageID uint) (render.Size, error) {
+	for _, img := range images {
+		if img.ID == mainImageID && img.Width > 0 && img.Height > 0 {
+			return spec.Resolve(img.Width, img.Height)
+		}
+	}
+	return render.Size{}, spec.Validate()
+}
+
+// addSnapshotFields adds the recorded inputs of a generation to a response
+func addSnapshotFields(response gin.H, mosaic *models.GeneratedMosaic) {
+	if mosaic.Width > 0 {
+		response["width"] = mosaic.Width
+		response["height"] = mosaic.Height
+		response["dpi"] = mosaic.DPI
+	}
+	if mosaic.SourceMosaicID != nil {
+		response["source_mosaic_id"] = fmt.Sprintf("%d", *mosaic.SourceMosaicID)
+	}
+	if snapshot, err := services.DecodeMosaicSnapshot(mosaic); err == nil {
+		response["snapshot"] = snapshot
+		response["reproducible"] = snapshot.Reproducible()
+	}
+}
+
 // SaveMosaicSettings handles saving mosaic settings
 func (h *MosaicHandler) SaveMosaicSettings(c *gin.Context) {
 	// Get user ID from context (set by auth middleware)
@@ -278,6 +661,7 @@ func (h *MosaicHandler) SaveMosaicSettings(c *gin.Context) {
 		TileDensity     int    `json:"tile_density" binding:"required,min=1,max=100"`
 		ColorAdjustment int    `json:"color_adjustment" binding:"required,min=0,max=100"`
 		Style           string `json:"style" binding:"required,oneof=classic random flowing"`
+		Seed            *int64 `json:"seed"` // nil picks a new seed for every generation
 		ProjectID       *uint  `json:"project_id"`
 	}
 
@@ -286,6 +670,13 @@ func (h *MosaicHandler) SaveMosaicSettings(c *gin.Context) {
 		return
 	}
 
+	// Settings are kept per member, so any role on the project may save them
+	if requestBody.ProjectID != nil {
+		if _, _, ok := authorizeProject(c, h.policy, *requestBody.ProjectID, services.ProjectRoleViewer); !ok {
+			return
+		}
+	}
+
 	// Create settings model
 	settings := &models.MosaicSettings{
 		UserID:          userID.(uint),
@@ -294,6 +685,7 @@ func (h *MosaicHandler) SaveMosaicSettings(c *gin.Context) {
 		TileDensity:     requestBody.TileDensity,
 		ColorAdjustment: requestBody.ColorAdjustment,
 		Style:           requestBody.Style,
+		Seed:            requestBody.Seed,
 	}
 
 	// Save settings to database associated with the user
@@ -330,6 +722,11 @@ func (h *MosaicHandler) GetMosaicSettings(c *gin.Context) {
 			projectID = &pidUint
 		}
 	}
+	if projectID != nil {
+		if _, _, ok := authorizeProject(c, h.policy, *projectID, services.ProjectRoleViewer); !ok {
+			return
+		}
+	}
 
 	// Get settings from database for the user and project
 	settings, err := h.mosaicService.GetSettings(userID.(uint), projectID)
@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/amityadav9314/goinkgrid/internal/services"
+	"github.com/gin-gonic/gin"
+)
+
+func TestGenerationSeed(t *testing.T) {
+	f := newAccessFixture(t)
+	h := &MosaicHandler{mosaicService: services.NewMosaicService(f.uploadDir, services.RenderConfig{}, services.PreviewConfig{})}
+	user, project := f.alice.user.ID, f.alice.project.ID
+	seed := func(v int64) *int64 { return &v }
+
+	// Without saved settings every generation gets a new seed
+	if a, b := h.generationSeed(user, project, nil), h.generationSeed(user, project, nil); a == b {
+		t.Fatalf("two generations without a seed both got %d", a)
+	}
+
+	// A saved seed is the default, but 0 is a seed like any other
+	settings := gin.H{"tile_size": 30, "tile_density": 60, "color_adjustment": 20, "style": "classic", "project_id": project, "seed": 0}
+	if w := f.do(user, http.MethodPost, "/api/generate/settings", settings); w.Code != http.StatusOK {
+		t.Fatalf("save settings: got %d: %s", w.Code, w.Body)
+	}
+	if got := h.generationSeed(user, project, nil); got != 0 {
+		t.Fatalf("got seed %d, want the saved 0", got)
+	}
+	if got := h.generationSeed(user, project, seed(42)); got != 42 {
+		t.Fatalf("got seed %d, want the requested 42", got)
+	}
+	settings["seed"] = 7
+	f.do(user, http.MethodPost, "/api/generate/settings", settings)
+	if got := h.generationSeed(user, project, seed(0)); got != 0 {
+		t.Fatalf("got seed %d, want the requested 0", got)
+	}
+	if got := h.generationSeed(user, project, nil); got != 7 {
+		t.Fatalf("got seed %d, want the saved 7", got)
+	}
+
+	// Saving without a seed goes back to a new seed for every generation
+	delete(settings, "seed")
+	f.do(user, http.MethodPost, "/api/generate/settings", settings)
+	w := f.do(user, http.MethodGet, fmt.Sprintf("/api/generate/settings?project_id=%d", project), nil)
+	var saved struct {
+		Settings struct {
+			Seed *int64
+		} `json:"settings"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &saved); err != nil {
+		t.Fatal(err)
+	}
+	if saved.Settings.Seed != nil {
+		t.Fatalf("got saved seed %d, want none", *saved.Settings.Seed)
+	}
+}
@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/amityadav9314/goinkgrid/internal/db/models"
+	"github.com/amityadav9314/goinkgrid/internal/services"
+	"github.com/amityadav9314/goinkgrid/pkg/oidc"
+	"github.com/gin-gonic/gin"
+)
+
+// oidcStateCookie holds the state of the login the browser started
+const oidcStateCookie = "inkgrid_oidc_state"
+
+// OIDCLogin starts an OIDC authorization code + PKCE login by redirecting
+// to the configured identity provider
+func (h *AuthHandler) OIDCLogin(c *gin.Context) {
+	if h.oidcClient == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Single sign-on is not enabled"})
+		return
+	}
+
+	authURL, state, err := h.oidcClient.AuthCodeURL(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to contact identity provider"})
+		return
+	}
+
+	// The state is also kept in a cookie, so the callback can check that
+	// it completes a login this browser started. Lax lets the cookie
+	// through on the provider's top-level redirect back.
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     "/",
+		MaxAge:   int(oidc.LoginTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   c.Request.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// OIDCCallback completes an OIDC login, provisioning or linking the local
+// user, and issues the regular JWTs
+func (h *AuthHandler) OIDCCallback(c *gin.Context) {
+	if h.oidcClient == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Single sign-on is not enabled"})
+		return
+	}
+
+	if errParam := c.Query("error"); errParam != "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Identity provider returned an error: " + errParam})
+		return
+	}
+
+	state := c.Query("state")
+	code := c.Query("code")
+	if state == "" || code == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing state or code"})
+		return
+	}
+
+	// A login started in another browser is refused, so nobody can sign a
+	// victim in to the attacker's account with a callback URL
+	cookie, err := c.Request.Cookie(oidcStateCookie)
+	if err != nil || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(state)) != 1 {
+		recordAuthAttempt(c, h.loginGuard, services.AuthEventOIDCLogin, "", nil, false, "state_mismatch")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Login was not started from this browser"})
+		return
+	}
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     oidcStateCookie,
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   c.Request.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	identity, err := h.oidcClient.Exchange(c.Request.Context(), state, code)
+	if err != nil {
+		recordAuthAttempt(c, h.loginGuard, services.AuthEventOIDCLogin, "", nil, false, "exchange_failed")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, status, err := h.findOrProvisionOIDCUser(identity)
+	if err != nil {
+		recordAuthAttempt(c, h.loginGuard, services.AuthEventOIDCLogin, identity.Email, nil, false, err.Error())
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	if user.DisabledAt != nil {
+		recordAuthAttempt(c, h.loginGuard, services.AuthEventOIDCLogin, user.Email, &user.ID, false, "account_disabled")
+		c.JSON(http.StatusForbidden, gin.H{"error": "Account is disabled"})
+		return
+	}
+	recordAuthAttempt(c, h.loginGuard, services.AuthEventOIDCLogin, user.Email, &user.ID, true, "")
+
+	// Generate JWT token
+	token, refreshToken, expiresAt, err := h.generateTokens(user.ID, user.Email, user.Role)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+
+	// Hand the tokens to the frontend in the URL fragment so they are
+	// never sent to a server or written to access logs
+	if h.oidcFrontendRedirect != "" {
+		fragment := url.Values{
+			"token":         {token},
+			"refresh_token": {refreshToken},
+			"expires_at":    {expiresAt.Format(time.RFC3339)},
+		}
+		c.Redirect(http.StatusFound, h.oidcFrontendRedirect+"#"+fragment.Encode())
+		return
+	}
+
+	c.JSON(http.StatusOK, TokenResponse{
+		Token:        token,
+		RefreshToken: refreshToken,
+		ExpiresAt:    expiresAt,
+	})
+}
+
+// findOrProvisionOIDCUser returns the local user for an external identity.
+// Existing accounts are linked by verified email; otherwise a new account
+// without a password is created. The returned status is used on error.
+func (h *AuthHandler) findOrProvisionOIDCUser(identity *oidc.Identity) (*models.User, int, error) {
+	user, err := h.userService.FindByOIDCIdentity(identity.Issuer, identity.Subject)
+	if err == nil {
+		return user, http.StatusOK, nil
+	}
+	if err.Error() != "record not found" {
+		return nil, http.StatusInternalServerError, errors.New("failed to look up user")
+	}
+
+	if identity.Email == "" {
+		return nil, http.StatusBadRequest, errors.New("identity provider did not return an email address")
+	}
+
+	existingUser, err := h.userService.FindByEmail(identity.Email)
+	if err != nil && err.Error() != "record not found" {
+		return nil, http.StatusInternalServerError, errors.New("failed to check existing user")
+	}
+
+	if existingUser != nil {
+		// Only link accounts when the provider vouches for the email
+		if !identity.EmailVerified {
+			return nil, http.StatusConflict, errors.New("email already registered and not verified by identity provider")
+		}
+		if existingUser.OIDCSubject != "" {
+			return nil, http.StatusConflict, errors.New("email already linked to another identity")
+		}
+
+		existingUser.OIDCIssuer = identity.Issuer
+		existingUser.OIDCSubject = identity.Subject
+		if existingUser.Name == "" {
+			existingUser.Name = identity.Name
+		}
+		if err := h.userService.Update(existingUser); err != nil {
+			return nil, http.StatusInternalServerError, errors.New("failed to link user")
+		}
+		return existingUser, http.StatusOK, nil
+	}
+
+	// First login, provision a new account. It has no password so it can
+	// only sign in through the identity provider.
+	newUser := &models.User{
+		Role:        services.RoleUser,
+		Email:       identity.Email,
+		Name:        identity.Name,
+		OIDCIssuer:  identity.Issuer,
+		OIDCSubject: identity.Subject,
+		CreatedAt:   time.Now(),
+		UpdatedAt:   time.Now(),
+	}
+	if err := h.userService.Create(newUser); err != nil {
+		return nil, http.StatusInternalServerError, errors.New("failed to create user")
+	}
+
+	return newUser, http.StatusOK, nil
+}
@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/amityadav9314/goinkgrid/internal/db/models"
+	"github.com/amityadav9314/goinkgrid/internal/services"
+	"github.com/amityadav9314/goinkgrid/pkg/oidc"
+	"github.com/amityadav9314/goinkgrid/pkg/oidc/oidctest"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// oidcFixture is an app with single sign-on through a local provider
+type oidcFixture struct {
+	db       *gorm.DB
+	router   *gin.Engine
+	provider *oidctest.Server
+}
+
+func newOIDCFixture(t *testing.T) *oidcFixture {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	gdb := newTestDB(t)
+	provider := oidctest.NewServer("inkgrid")
+	t.Cleanup(provider.Close)
+
+	client := oidc.NewClient(oidc.Config{
+		DiscoveryURL: provider.DiscoveryURL(),
+		ClientID:     "inkgrid",
+		RedirectURL:  "http://app.test/auth/oidc/callback",
+	})
+	handler := NewAuthHandler(services.NewUserService(gdb), services.NewLoginGuardService(gdb, services.LoginGuardConfig{}), "test-secret", client, "")
+
+	r := gin.New()
+	r.GET("/auth/oidc/login", handler.OIDCLogin)
+	r.GET("/auth/oidc/callback", handler.OIDCCallback)
+	return &oidcFixture{db: gdb, router: r, provider: provider}
+}
+
+// startLogin starts a login as a browser would and signs in at the
+// provider. It returns the state cookie the app set and the callback URL
+// the provider sent the browser back to.
+func (f *oidcFixture) startLogin(t *testing.T) (*http.Cookie, string) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	f.router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/auth/oidc/login", nil))
+	if w.Code != http.StatusFound {
+		t.Fatalf("login: got %d: %s", w.Code, w.Body)
+	}
+
+	var stateCookie *http.Cookie
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == oidcStateCookie {
+			stateCookie = cookie
+		}
+	}
+	if stateCookie == nil || !stateCookie.HttpOnly || stateCookie.SameSite != http.SameSiteLaxMode {
+		t.Fatalf("login set no HttpOnly, SameSite state cookie: %+v", stateCookie)
+	}
+
+	noRedirect := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := noRedirect.Get(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("authorize: %v", err)
+	}
+	resp.Body.Close()
+	callback, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return stateCookie, callback.RequestURI()
+}
+
+// callback completes a login with the given state cookie, if any
+func (f *oidcFixture) callback(callbackURI string, cookie *http.Cookie) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, callbackURI, nil)
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	w := httptest.NewRecorder()
+	f.router.ServeHTTP(w, req)
+	return w
+}
+
+func TestOIDCLoginProvisionsUser(t *testing.T) {
+	f := newOIDCFixture(t)
+	f.provider.SetIdentity(oidctest.Identity{Subject: "ada", Email: "ada@example.com", EmailVerified: true, Name: "Ada"})
+
+	cookie, callbackURI := f.startLogin(t)
+	w := f.callback(callbackURI, cookie)
+	if w.Code != http.StatusOK {
+		t.Fatalf("callback: got %d: %s", w.Code, w.Body)
+	}
+	var tokens TokenResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &tokens); err != nil || tokens.Token == "" {
+		t.Fatalf("callback returned no token: %s", w.Body)
+	}
+
+	var user models.User
+	if err := f.db.Where(&models.User{OIDCSubject: "ada"}).First(&user).Error; err != nil {
+		t.Fatalf("user was not provisioned: %v", err)
+	}
+	if user.Email != "ada@example.com" || user.OIDCIssuer != f.provider.URL {
+		t.Fatalf("provisioned user %+v", user)
+	}
+
+	// Signing in again finds the linked account
+	cookie, callbackURI = f.startLogin(t)
+	if w := f.callback(callbackURI, cookie); w.Code != http.StatusOK {
+		t.Fatalf("second callback: got %d: %s", w.Code, w.Body)
+	}
+	var count int64
+	f.db.Model(&models.User{}).Count(&count)
+	if count != 1 {
+		t.Fatalf("got %d users, want 1", count)
+	}
+}
+
+func TestOIDCCallbackRequiresTheBrowserThatStartedTheLogin(t *testing.T) {
+	f := newOIDCFixture(t)
+
+	// The attacker signs in at the provider and sends the callback URL to
+	// a victim, who has no state cookie or one from their own login
+	_, attackerCallback := f.startLogin(t)
+	victimCookie, _ := f.startLogin(t)
+
+	if w := f.callback(attackerCallback, nil); w.Code != http.StatusUnauthorized {
+		t.Fatalf("without cookie: got %d, want 401: %s", w.Code, w.Body)
+	}
+	if w := f.callback(attackerCallback, victimCookie); w.Code != http.StatusUnauthorized {
+		t.Fatalf("with another login's cookie: got %d, want 401: %s", w.Code, w.Body)
+	}
+
+	var count int64
+	f.db.Model(&models.User{}).Count(&count)
+	if count != 0 {
+		t.Fatalf("%d users were signed in", count)
+	}
+}
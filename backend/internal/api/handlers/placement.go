@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/amityadav9314/goinkgrid/internal/db/models"
+	"github.com/amityadav9314/goinkgrid/internal/services"
+	"github.com/amityadav9314/goinkgrid/pkg/render"
+	"github.com/gin-gonic/gin"
+)
+
+// GetPlacementMap returns where every tile photo of a generation was
+// placed, as JSON or, with format=csv, as a CSV download
+func (h *MosaicHandler) GetPlacementMap(c *gin.Context) {
+	mosaic, placements, ok := h.loadPlacementMap(c)
+	if !ok {
+		return
+	}
+
+	switch c.DefaultQuery("format", "json") {
+	case "json":
+		c.Header("Content-Type", "application/json; charset=utf-8")
+		c.Status(http.StatusOK)
+		placements.WriteJSON(c.Writer)
+	case "csv":
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="mosaic_%d_placement.csv"`, mosaic.ID))
+		c.Status(http.StatusOK)
+		placements.WriteCSV(c.Writer)
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Format must be json or csv"})
+	}
+}
+
+// GetTilePlacements returns every position of one tile photo in a generation
+func (h *MosaicHandler) GetTilePlacements(c *gin.Context) {
+	imageID, err := strconv.ParseUint(c.Param("imageId"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid image ID"})
+		return
+	}
+
+	_, placements, ok := h.loadPlacementMap(c)
+	if !ok {
+		return
+	}
+
+	positions := placements.ForTile(uint(imageID))
+	c.JSON(http.StatusOK, gin.H{
+		"tile_image_id": fmt.Sprintf("%d", imageID),
+		"width":         placements.Width,
+		"height":        placements.Height,
+		"count":         len(positions),
+		"placements":    positions,
+	})
+}
+
+// GetPlacementAt returns the tile photo at pixel x, y of a generation's HD
+// image
+func (h *MosaicHandler) GetPlacementAt(c *gin.Context) {
+	x, errX := strconv.Atoi(c.Query("x"))
+	y, errY := strconv.Atoi(c.Query("y"))
+	if errX != nil || errY != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "x and y are required"})
+		return
+	}
+
+	_, placements, ok := h.loadPlacementMap(c)
+	if !ok {
+		return
+	}
+
+	if x < 0 || y < 0 || x >= placements.Width || y >= placements.Height {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("x and y must be within %dx%d", placements.Width, placements.Height)})
+		return
+	}
+	placement, found := placements.At(x, y)
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No tile at this position"})
+		return
+	}
+	c.JSON(http.StatusOK, placement)
+}
+
+// GetPlacementHighlight returns the SD image of a generation as a JPEG with
+// every position of one tile photo highlighted
+func (h *MosaicHandler) GetPlacementHighlight(c *gin.Context) {
+	imageID, err := strconv.ParseUint(c.Param("imageId"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid image ID"})
+		return
+	}
+
+	mosaic, _, ok := h.loadPlacementMap(c)
+	if !ok {
+		return
+	}
+
+	img, err := h.mosaicService.RenderPlacementHighlight(mosaic, uint(imageID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := render.EncodeJPEG(&buf, img, 85, render.DefaultDPI); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to encode image"})
+		return
+	}
+	c.Header("Cache-Control", "private, max-age=300")
+	c.Data(http.StatusOK, "image/jpeg", buf.Bytes())
+}
+
+// loadPlacementMap loads the placement map of the generation in the path,
+// which any collaborator on the project can read. It writes the error
+// response and returns false if there is none.
+func (h *MosaicHandler) loadPlacementMap(c *gin.Context) (*models.GeneratedMosaic, *services.PlacementMap, bool) {
+	generationID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid generation ID"})
+		return nil, nil, false
+	}
+
+	mosaic, ok := authorizeMosaic(c, h.policy, uint(generationID), services.ProjectRoleViewer)
+	if !ok {
+		return nil, nil, false
+	}
+	if mosaic.Status != "completed" {
+		c.JSON(http.StatusConflict, gin.H{"error": "The generation has not completed"})
+		return nil, nil, false
+	}
+
+	placements, err := h.mosaicService.GetPlacementMap(mosaic)
+	if err != nil {
+		if errors.Is(err, services.ErrResourceNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "This generation predates placement maps; re-render it to get one"})
+			return nil, nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, nil, false
+	}
+	return mosaic, placements, true
+}
@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	db "github.com/amityadav9314/goinkgrid/internal/db/postgres"
+	"github.com/amityadav9314/goinkgrid/internal/services"
+	"github.com/amityadav9314/goinkgrid/pkg/render"
+)
+
+// savePlacementMap stores m as the placement map of a tenant's mosaic
+func (f *accessFixture) savePlacementMap(t *testing.T, tn tenant, m services.PlacementMap) {
+	t.Helper()
+	data, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	storedPath := fmt.Sprintf("/user_%d/placement.json", tn.user.ID)
+	if err := os.WriteFile(filepath.Join(f.uploadDir, filepath.FromSlash(storedPath)), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.DB.Model(&tn.mosaic).Update("placement_path", storedPath).Error; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPlacementLookups(t *testing.T) {
+	f := newAccessFixture(t)
+	alice := f.alice
+	// Two 20px cells side by side, the second without a tile
+	f.savePlacementMap(t, alice, services.PlacementMap{
+		Width: 40, Height: 20, CellSize: 20, Cols: 2, Rows: 1,
+		TileImageIDs: []uint{alice.tile.ID}, TileSizes: [][2]int{{64, 64}},
+		Cells: []int{0, render.NoTile}, Errors: []float32{1.5, 0},
+	})
+	at := func(x, y int) (int, services.Placement) {
+		w := f.do(alice.user.ID, http.MethodGet, fmt.Sprintf("/api/generate/%d/placement/at?x=%d&y=%d", alice.mosaic.ID, x, y), nil)
+		var p services.Placement
+		json.Unmarshal(w.Body.Bytes(), &p)
+		return w.Code, p
+	}
+
+	if code, p := at(19, 19); code != http.StatusOK || p.TileImageID != alice.tile.ID || p.MatchError != 1.5 {
+		t.Fatalf("at 19, 19: got %d %+v", code, p)
+	}
+	if code, _ := at(20, 0); code != http.StatusNotFound {
+		t.Fatalf("cell without a tile: got %d, want 404", code)
+	}
+	if code, _ := at(40, 0); code != http.StatusBadRequest {
+		t.Fatalf("outside the output: got %d, want 400", code)
+	}
+
+	w := f.do(alice.user.ID, http.MethodGet, fmt.Sprintf("/api/generate/%d/placement/tiles/%d", alice.mosaic.ID, alice.tile.ID), nil)
+	var tiles struct {
+		Count int `json:"count"`
+	}
+	if json.Unmarshal(w.Body.Bytes(), &tiles); w.Code != http.StatusOK || tiles.Count != 1 {
+		t.Fatalf("tile placements: got %d %s", w.Code, w.Body)
+	}
+}
+
+func TestPlacementLookupsRejectACorruptMap(t *testing.T) {
+	f := newAccessFixture(t)
+	alice := f.alice
+	// An imported map whose tile sizes do not match its tiles
+	f.savePlacementMap(t, alice, services.PlacementMap{
+		Width: 20, Height: 20, CellSize: 20, Cols: 1, Rows: 1,
+		TileImageIDs: []uint{alice.tile.ID, alice.main.ID}, TileSizes: [][2]int{{64, 64}},
+		Cells: []int{1}, Errors: []float32{0},
+	})
+	w := f.do(alice.user.ID, http.MethodGet, fmt.Sprintf("/api/generate/%d/placement/at?x=0&y=0", alice.mosaic.ID), nil)
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("got %d, want 500: %s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,329 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/amityadav9314/goinkgrid/internal/db/models"
+	"github.com/amityadav9314/goinkgrid/internal/services"
+	"github.com/amityadav9314/goinkgrid/pkg/signedurl"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// ProfileHandler handles requests for the authenticated user's profile
+type ProfileHandler struct {
+	uploadDir    string
+	userService  services.UserService
+	imageService services.ImageService
+	trashService services.TrashService
+	loginGuard   services.LoginGuardService
+	signer       *signedurl.Signer
+}
+
+// NewProfileHandler creates a new profile handler
+func NewProfileHandler(uploadPath string, userService services.UserService, imageService services.ImageService, trashService services.TrashService, loginGuard services.LoginGuardService, signer *signedurl.Signer) *ProfileHandler {
+	return &ProfileHandler{
+		uploadDir:    uploadPath,
+		userService:  userService,
+		imageService: imageService,
+		trashService: trashService,
+		loginGuard:   loginGuard,
+		signer:       signer,
+	}
+}
+
+// MosaicPreferences represents the user's default mosaic settings
+type MosaicPreferences struct {
+	TileSize        int    `json:"tile_size" binding:"omitempty,min=10,max=200"`
+	TileDensity     int    `json:"tile_density" binding:"omitempty,min=1,max=100"`
+	ColorAdjustment int    `json:"color_adjustment" binding:"omitempty,min=0,max=100"`
+	Style           string `json:"style" binding:"omitempty,oneof=classic random flowing"`
+}
+
+// MosaicPreferencesRequest updates the user's default mosaic settings.
+// Fields are pointers so a zero value can be told apart from a missing one.
+type MosaicPreferencesRequest struct {
+	TileSize        *int    `json:"tile_size" binding:"omitempty,min=10,max=200"`
+	TileDensity     *int    `json:"tile_density" binding:"omitempty,min=1,max=100"`
+	ColorAdjustment *int    `json:"color_adjustment" binding:"omitempty,min=0,max=100"`
+	Style           *string `json:"style" binding:"omitempty,oneof=classic random flowing"`
+}
+
+// ProfileResponse represents a user profile response
+type ProfileResponse struct {
+	ID          uint              `json:"id"`
+	Email       string            `json:"email"`
+	Name        string            `json:"name"`
+	AvatarURL   string            `json:"avatar_url,omitempty"`
+	Locale      string            `json:"locale"`
+	Preferences MosaicPreferences `json:"preferences"`
+	CreatedAt   time.Time         `json:"created_at"`
+	UpdatedAt   time.Time         `json:"updated_at"`
+}
+
+// UpdateProfileRequest represents an update profile request
+type UpdateProfileRequest struct {
+	Name        *string                   `json:"name" binding:"omitempty,min=1,max=100"`
+	Locale      *string                   `json:"locale" binding:"omitempty,bcp47_language_tag"`
+	Preferences *MosaicPreferencesRequest `json:"preferences"`
+}
+
+// ChangePasswordRequest represents a change password request
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" binding:"required"`
+	NewPassword     string `json:"new_password" binding:"required,min=8"`
+}
+
+// GetProfile returns the authenticated user's profile
+func (h *ProfileHandler) GetProfile(c *gin.Context) {
+	// Get user ID from context (set by auth middleware)
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	user, err := h.userService.FindByID(userID.(uint))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, h.toProfileResponse(c, user))
+}
+
+// UpdateProfile updates the authenticated user's profile
+func (h *ProfileHandler) UpdateProfile(c *gin.Context) {
+	// Get user ID from context (set by auth middleware)
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var req UpdateProfileRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := h.userService.FindByID(userID.(uint))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	// Only fields present in the request are updated
+	if req.Name != nil {
+		user.Name = strings.TrimSpace(*req.Name)
+	}
+	if req.Locale != nil {
+		user.Locale = *req.Locale
+	}
+	if req.Preferences != nil {
+		if req.Preferences.TileSize != nil {
+			user.DefaultTileSize = *req.Preferences.TileSize
+		}
+		if req.Preferences.TileDensity != nil {
+			user.DefaultTileDensity = *req.Preferences.TileDensity
+		}
+		if req.Preferences.ColorAdjustment != nil {
+			user.DefaultColorAdjustment = *req.Preferences.ColorAdjustment
+		}
+		if req.Preferences.Style != nil {
+			user.DefaultStyle = *req.Preferences.Style
+		}
+	}
+
+	if err := h.userService.Update(user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update profile"})
+		return
+	}
+
+	c.JSON(http.StatusOK, h.toProfileResponse(c, user))
+}
+
+// UploadAvatar handles avatar upload for the authenticated user
+func (h *ProfileHandler) UploadAvatar(c *gin.Context) {
+	// Get user ID from context (set by auth middleware)
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	user, err := h.userService.FindByID(userID.(uint))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	// Get file from form
+	file, err := c.FormFile("image")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No image provided"})
+		return
+	}
+
+	// Validate file type
+	ext := filepath.Ext(file.Filename)
+	if !isValidImageExt(ext) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid image format"})
+		return
+	}
+
+	// Create directory structure: uploads/userID/avatar/
+	avatarDir := filepath.Join(h.uploadDir, fmt.Sprintf("user_%d", user.ID), "avatar")
+	if err := os.MkdirAll(avatarDir, 0755); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create avatar directory"})
+		return
+	}
+
+	filename := "avatar_" + uuid.New().String() + ext
+	imagePath, width, height, err := saveImageUpload(c, h.uploadDir, file, avatarDir, filename)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save image"})
+		return
+	}
+	if width == 0 || height == 0 {
+		os.Remove(uploadFilePath(h.uploadDir, imagePath))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to decode image"})
+		return
+	}
+
+	imageModel := &models.Image{
+		UserID:   user.ID,
+		Type:     "avatar",
+		Path:     imagePath,
+		Filename: file.Filename,
+		Width:    width,
+		Height:   height,
+		Format:   ext[1:],
+	}
+	if err := h.imageService.Create(imageModel); err != nil {
+		os.Remove(uploadFilePath(h.uploadDir, imagePath))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save image"})
+		return
+	}
+
+	previousAvatar := user.AvatarPath
+	user.AvatarPath = imagePath
+	if err := h.userService.Update(user); err != nil {
+		h.discardAvatar(user.ID, imageModel)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update profile"})
+		return
+	}
+
+	// The replaced avatar is an image like any other, so it may have been
+	// used in a generation or share its file with a copy
+	if previousAvatar != "" {
+		previous, err := h.imageService.FindByPath(user.ID, previousAvatar)
+		if err != nil {
+			log.Printf("Error finding previous avatar %s: %v", previousAvatar, err)
+		} else {
+			h.discardAvatar(user.ID, previous)
+		}
+	}
+
+	c.JSON(http.StatusOK, h.toProfileResponse(c, user))
+}
+
+// discardAvatar deletes an avatar image through the trash, which removes
+// its file unless something else still references it
+func (h *ProfileHandler) discardAvatar(userID uint, image *models.Image) {
+	if err := h.imageService.Delete(image.ID, userID); err != nil {
+		log.Printf("Error deleting avatar %d: %v", image.ID, err)
+		return
+	}
+	if err := h.trashService.PurgeImage(userID, image.ID); err != nil {
+		log.Printf("Error purging avatar %d: %v", image.ID, err)
+	}
+}
+
+// ChangePassword changes the authenticated user's password after
+// re-authenticating with the current password
+func (h *ProfileHandler) ChangePassword(c *gin.Context) {
+	// Get user ID from context (set by auth middleware)
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var req ChangePasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := h.userService.FindByID(userID.(uint))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	// Re-authentication is guarded the same way as login
+	wait, err := h.loginGuard.Check(services.AuthEventPasswordChange, user.Email, c.ClientIP())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check login attempts"})
+		return
+	}
+	if wait > 0 {
+		recordAuthAttempt(c, h.loginGuard, services.AuthEventPasswordChange, user.Email, &user.ID, false, services.AuthReasonThrottled)
+		respondTooManyAttempts(c, wait)
+		return
+	}
+
+	// Re-authenticate with the current password
+	if !CheckPasswordHash(req.CurrentPassword, user.PasswordHash) {
+		recordAuthAttempt(c, h.loginGuard, services.AuthEventPasswordChange, user.Email, &user.ID, false, "invalid_password")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Current password is incorrect"})
+		return
+	}
+
+	hashedPassword, err := HashPassword(req.NewPassword)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process password"})
+		return
+	}
+
+	user.PasswordHash = hashedPassword
+	if err := h.userService.Update(user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update password"})
+		return
+	}
+	recordAuthAttempt(c, h.loginGuard, services.AuthEventPasswordChange, user.Email, &user.ID, true, "")
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password changed successfully"})
+}
+
+// toProfileResponse converts a user model to a profile response
+func (h *ProfileHandler) toProfileResponse(c *gin.Context, user *models.User) ProfileResponse {
+	response := ProfileResponse{
+		ID:     user.ID,
+		Email:  user.Email,
+		Name:   user.Name,
+		Locale: user.Locale,
+		Preferences: MosaicPreferences{
+			TileSize:        user.DefaultTileSize,
+			TileDensity:     user.DefaultTileDensity,
+			ColorAdjustment: user.DefaultColorAdjustment,
+			Style:           user.DefaultStyle,
+		},
+		CreatedAt: user.CreatedAt,
+		UpdatedAt: user.UpdatedAt,
+	}
+
+	if user.AvatarPath != "" {
+		// Build signed URL for the avatar
+		response.AvatarURL = signedFileURL(c, h.signer, user.AvatarPath)
+	}
+
+	return response
+}
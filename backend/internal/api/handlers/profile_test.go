@@ -0,0 +1,215 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/amityadav9314/goinkgrid/internal/db/models"
+	"github.com/amityadav9314/goinkgrid/internal/services"
+	"github.com/amityadav9314/goinkgrid/pkg/signedurl"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// profileFixture is the profile API for a single user
+type profileFixture struct {
+	db        *gorm.DB
+	router    *gin.Engine
+	uploadDir string
+	user      models.User
+}
+
+func newProfileFixture(t *testing.T) *profileFixture {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	gdb := newTestDB(t)
+	f := &profileFixture{db: gdb, uploadDir: t.TempDir()}
+	f.user = models.User{Email: "ada@example.com", PasswordHash: "x", Role: services.RoleUser,
+		DefaultTileSize: 50, DefaultTileDensity: 80, DefaultColorAdjustment: 50, DefaultStyle: "classic"}
+	mustCreate(t, gdb, &f.user)
+
+	f.router = f.newRouter(services.NewUserService(gdb))
+	return f
+}
+
+// newRouter serves the profile API with the given user service
+func (f *profileFixture) newRouter(userService services.UserService) *gin.Engine {
+	handler := NewProfileHandler(f.uploadDir, userService, services.NewImageService(f.db),
+		services.NewTrashService(f.db, f.uploadDir, services.TrashConfig{}),
+		services.NewLoginGuardService(f.db, services.LoginGuardConfig{}), signedurl.NewSigner("test-secret", time.Hour))
+	r := gin.New()
+	r.Use(func(c *gin.Context) { c.Set("userID", f.user.ID) })
+	r.PUT("/profile", handler.UpdateProfile)
+	r.POST("/profile/avatar", handler.UploadAvatar)
+	return r
+}
+
+func (f *profileFixture) reload(t *testing.T) models.User {
+	t.Helper()
+	var user models.User
+	if err := f.db.First(&user, f.user.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+// uploadAvatar posts data as the avatar file avatar.png
+func (f *profileFixture) uploadAvatar(t *testing.T, data []byte) *httptest.ResponseRecorder {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("image", "avatar.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(data)
+	form.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/profile/avatar", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	w := httptest.NewRecorder()
+	f.router.ServeHTTP(w, req)
+	return w
+}
+
+// avatarFiles lists the files in the user's avatar directory
+func (f *profileFixture) avatarFiles(t *testing.T) []string {
+	t.Helper()
+	matches, err := filepath.Glob(filepath.Join(f.uploadDir, "user_*", "avatar", "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return matches
+}
+
+func pngBytes(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 4, 4))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestUpdateProfileSavesZeroPreferences(t *testing.T) {
+	f := newProfileFixture(t)
+
+	w := httptest.NewRecorder()
+	f.router.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/profile",
+		strings.NewReader(`{"preferences": {"color_adjustment": 0}}`)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d: %s", w.Code, w.Body)
+	}
+
+	// Only the field that was sent changes
+	user := f.reload(t)
+	if user.DefaultColorAdjustment != 0 {
+		t.Fatalf("color adjustment is %d, want 0", user.DefaultColorAdjustment)
+	}
+	if user.DefaultTileSize != 50 || user.DefaultTileDensity != 80 || user.DefaultStyle != "classic" {
+		t.Fatalf("other preferences changed: %+v", user)
+	}
+
+	w = httptest.NewRecorder()
+	f.router.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/profile",
+		strings.NewReader(`{"preferences": {"tile_size": 0}}`)))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("tile size 0: got %d, want 400", w.Code)
+	}
+}
+
+func TestUploadAvatarRemovesUnusedFiles(t *testing.T) {
+	f := newProfileFixture(t)
+
+	if w := f.uploadAvatar(t, []byte("not an image")); w.Code != http.StatusBadRequest {
+		t.Fatalf("undecodable avatar: got %d, want 400", w.Code)
+	}
+	if files := f.avatarFiles(t); len(files) != 0 {
+		t.Fatalf("undecodable avatar was kept: %v", files)
+	}
+
+	if w := f.uploadAvatar(t, pngBytes(t)); w.Code != http.StatusOK {
+		t.Fatalf("first avatar: got %d: %s", w.Code, w.Body)
+	}
+	first := f.reload(t).AvatarPath
+
+	if w := f.uploadAvatar(t, pngBytes(t)); w.Code != http.StatusOK {
+		t.Fatalf("second avatar: got %d: %s", w.Code, w.Body)
+	}
+	second := f.reload(t).AvatarPath
+	if second == first {
+		t.Fatal("avatar path did not change")
+	}
+	if _, err := os.Stat(uploadFilePath(f.uploadDir, first)); !os.IsNotExist(err) {
+		t.Fatalf("previous avatar was kept: %v", err)
+	}
+	if files := f.avatarFiles(t); len(files) != 1 {
+		t.Fatalf("got avatar files %v, want only the current one", files)
+	}
+
+	// Only the current avatar has an image row, in the trash or out of it
+	var paths []string
+	f.db.Unscoped().Model(&models.Image{}).Where("type = ?", "avatar").Pluck("path", &paths)
+	if len(paths) != 1 || paths[0] != second {
+		t.Fatalf("avatar images %v, want [%s]", paths, second)
+	}
+}
+
+func TestUploadAvatarKeepsFilesInUse(t *testing.T) {
+	f := newProfileFixture(t)
+	if w := f.uploadAvatar(t, pngBytes(t)); w.Code != http.StatusOK {
+		t.Fatalf("first avatar: got %d: %s", w.Code, w.Body)
+	}
+	first := f.reload(t).AvatarPath
+
+	// A copy of the avatar, such as one in a duplicated project, shares its file
+	mustCreate(t, f.db, &models.Image{UserID: f.user.ID, Type: "main", Path: first})
+
+	if w := f.uploadAvatar(t, pngBytes(t)); w.Code != http.StatusOK {
+		t.Fatalf("second avatar: got %d: %s", w.Code, w.Body)
+	}
+	if _, err := os.Stat(uploadFilePath(f.uploadDir, first)); err != nil {
+		t.Fatalf("a file still in use was removed: %v", err)
+	}
+	var count int64
+	f.db.Unscoped().Model(&models.Image{}).Where("path = ? AND type = ?", first, "avatar").Count(&count)
+	if count != 0 {
+		t.Fatal("the replaced avatar's image row was kept")
+	}
+}
+
+// failingUserService fails to save users
+type failingUserService struct {
+	services.UserService
+}
+
+func (failingUserService) Update(user *models.User) error {
+	return errors.New("database went away")
+}
+
+func TestUploadAvatarCleansUpWhenTheProfileIsNotSaved(t *testing.T) {
+	f := newProfileFixture(t)
+	f.router = f.newRouter(failingUserService{services.NewUserService(f.db)})
+
+	if w := f.uploadAvatar(t, pngBytes(t)); w.Code != http.StatusInternalServerError {
+		t.Fatalf("got %d, want 500", w.Code)
+	}
+	if files := f.avatarFiles(t); len(files) != 0 {
+		t.Fatalf("avatar files %v were kept", files)
+	}
+	var count int64
+	f.db.Unscoped().Model(&models.Image{}).Count(&count)
+	if count != 0 {
+		t.Fatalf("%d image rows were kept", count)
+	}
+}
@@ -15,12 +15,16 @@ import (
 // ProjectHandler handles project-related requests
 type ProjectHandler struct {
 	projectService services.ProjectService
+	memberService  services.ProjectMemberService
+	policy         services.AccessPolicy
 }
 
 // NewProjectHandler creates a new project handler
-func NewProjectHandler(projectService services.ProjectService) *ProjectHandler {
+func NewProjectHandler(projectService services.ProjectService, memberService services.ProjectMemberService, policy services.AccessPolicy) *ProjectHandler {
 	return &ProjectHandler{
 		projectService: projectService,
+		memberService:  memberService,
+		policy:         policy,
 	}
 }
 
@@ -34,6 +38,8 @@ type ProjectResponse struct {
 	UpdatedAt   time.Time      `json:"updated_at"`
 	Settings    gin.H          `json:"settings"`
 	Status      string         `json:"status"`
+	IsTemplate  bool           `json:"is_template"`
+	Role        string         `json:"role,omitempty"` // the caller's role on the project
 	MainImage   *ImageResponse `json:"main_image,omitempty"`
 }
 
@@ -45,6 +51,17 @@ type CreateProjectRequest struct {
 	Settings    gin.H  `json:"settings"`
 }
 
+// DuplicateProjectRequest represents a duplicate project request
+type DuplicateProjectRequest struct {
+	Name          string `json:"name"`
+	IncludeImages bool   `json:"include_images"`
+}
+
+// SetTemplateRequest represents a mark-as-template request
+type SetTemplateRequest struct {
+	IsTemplate *bool `json:"is_template" binding:"required"`
+}
+
 // UpdateProjectRequest represents an update project request
 type UpdateProjectRequest struct {
 	Name        string `json:"name"`
@@ -68,6 +85,35 @@ func (h *ProjectHandler) ListProjects(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch projects"})
 		return
 	}
+	roles := make(map[uint]string, len(projects))
+	for _, project := range projects {
+		roles[project.ID] = services.ProjectRoleOwner
+	}
+
+	// Add projects shared with the user
+	memberships, err := h.memberService.FindMembershipsForUser(userID.(uint))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch projects"})
+		return
+	}
+	sharedIDs := make([]uint, 0, len(memberships))
+	sharedRoles := make(map[uint]string, len(memberships))
+	for _, member := range memberships {
+		sharedIDs = append(sharedIDs, member.ProjectID)
+		sharedRoles[member.ProjectID] = member.Role
+	}
+	sharedProjects, err := h.projectService.FindByIDs(sharedIDs)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch projects"})
+		return
+	}
+	for _, project := range sharedProjects {
+		if _, owned := roles[project.ID]; owned {
+			continue
+		}
+		roles[project.ID] = sharedRoles[project.ID]
+		projects = append(projects, project)
+	}
 
 	// Convert to response format
 	var projectResponses []ProjectResponse
@@ -82,6 +128,8 @@ func (h *ProjectHandler) ListProjects(c *gin.Context) {
 			UpdatedAt:   project.UpdatedAt,
 			Settings:    gin.H{},
 			Status:      project.Status,
+			IsTemplate:  project.IsTemplate,
+			Role:        roles[project.ID],
 		}
 
 		// Parse settings if available
@@ -159,7 +207,7 @@ func (h *ProjectHandler) CreateProject(c *gin.Context) {
 // GetProject returns a specific project
 func (h *ProjectHandler) GetProject(c *gin.Context) {
 	// Get user ID from context (set by auth middleware)
-	userID, exists := c.Get("userID")
+	_, exists := c.Get("userID")
 	if !exists {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
 		return
@@ -173,16 +221,9 @@ func (h *ProjectHandler) GetProject(c *gin.Context) {
 		return
 	}
 
-	// Get project from database
-	project, err := h.projectService.FindByID(uint(projectID))
-	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
-		return
-	}
-
-	// Verify that the project belongs to the user
-	if project.UserID != userID.(uint) {
-		c.JSON(http.StatusForbidden, gin.H{"error": "You don't have permission to access this project"})
+	// Get project from database, checking the user can view it
+	project, role, ok := authorizeProject(c, h.policy, uint(projectID), services.ProjectRoleViewer)
+	if !ok {
 		return
 	}
 
@@ -195,6 +236,8 @@ func (h *ProjectHandler) GetProject(c *gin.Context) {
 		CreatedAt:   project.CreatedAt,
 		UpdatedAt:   project.UpdatedAt,
 		Status:      project.Status,
+		IsTemplate:  project.IsTemplate,
+		Role:        role,
 	}
 
 	// Parse settings if available
@@ -213,7 +256,7 @@ func (h *ProjectHandler) GetProject(c *gin.Context) {
 // UpdateProject updates a project
 func (h *ProjectHandler) UpdateProject(c *gin.Context) {
 	// Get user ID from context (set by auth middleware)
-	userID, exists := c.Get("userID")
+	_, exists := c.Get("userID")
 	if !exists {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
 		return
@@ -233,16 +276,9 @@ func (h *ProjectHandler) UpdateProject(c *gin.Context) {
 		return
 	}
 
-	// Get project from database
-	project, err := h.projectService.FindByID(uint(projectID))
-	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
-		return
-	}
-
-	// Verify that the project belongs to the user
-	if project.UserID != userID.(uint) {
-		c.JSON(http.StatusForbidden, gin.H{"error": "You don't have permission to update this project"})
+	// Get project from database, checking the user can edit it
+	project, role, ok := authorizeProject(c, h.policy, uint(projectID), services.ProjectRoleEditor)
+	if !ok {
 		return
 	}
 
@@ -277,6 +313,8 @@ func (h *ProjectHandler) UpdateProject(c *gin.Context) {
 		CreatedAt:   project.CreatedAt,
 		UpdatedAt:   project.UpdatedAt,
 		Status:      project.Status,
+		IsTemplate:  project.IsTemplate,
+		Role:        role,
 	}
 
 	// Parse settings
@@ -293,7 +331,7 @@ func (h *ProjectHandler) UpdateProject(c *gin.Context) {
 // DeleteProject deletes a project
 func (h *ProjectHandler) DeleteProject(c *gin.Context) {
 	// Get user ID from context (set by auth middleware)
-	userID, exists := c.Get("userID")
+	_, exists := c.Get("userID")
 	if !exists {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
 		return
@@ -307,11 +345,131 @@ func (h *ProjectHandler) DeleteProject(c *gin.Context) {
 		return
 	}
 
-	// Delete project from database
-	if err := h.projectService.Delete(uint(projectID), userID.(uint)); err != nil {
+	// Only owners can delete a project
+	project, _, ok := authorizeProject(c, h.policy, uint(projectID), services.ProjectRoleOwner)
+	if !ok {
+		return
+	}
+
+	// Move the project to the trash; it is purged after the retention period
+	if err := h.projectService.Delete(project.ID, project.UserID); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete project"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Project deleted successfully"})
+	c.JSON(http.StatusOK, gin.H{"message": "Project moved to trash"})
+}
+
+// DuplicateProject copies a project the user can view, or any template,
+// into a new project owned by the user
+func (h *ProjectHandler) DuplicateProject(c *gin.Context) {
+	// Get user ID from context (set by auth middleware)
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	projectID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		return
+	}
+
+	// The body is optional
+	var req DuplicateProjectRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	source, err := h.policy.AuthorizeDuplicate(userID.(uint), uint(projectID))
+	if err != nil {
+		respondAccessError(c, err, "Project not found", "project")
+		return
+	}
+
+	project, err := h.projectService.Duplicate(source.ID, userID.(uint), req.Name, req.IncludeImages)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to duplicate project"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, toProjectResponse(project, services.ProjectRoleOwner))
+}
+
+// SetProjectTemplate marks or unmarks a project as a template
+func (h *ProjectHandler) SetProjectTemplate(c *gin.Context) {
+	projectID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		return
+	}
+
+	var req SetTemplateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Publishing a template exposes the project to everyone, so only owners can
+	// publish or unpublish it
+	project, role, ok := authorizeProject(c, h.policy, uint(projectID), services.ProjectRoleOwner)
+	if !ok {
+		return
+	}
+
+	project.IsTemplate = *req.IsTemplate
+	if err := h.projectService.Update(project); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update project"})
+		return
+	}
+
+	c.JSON(http.StatusOK, toProjectResponse(project, role))
+}
+
+// ListTemplates returns the projects that can be used as templates
+func (h *ProjectHandler) ListTemplates(c *gin.Context) {
+	projects, err := h.projectService.FindTemplates()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch templates"})
+		return
+	}
+
+	responses := make([]ProjectResponse, 0, len(projects))
+	for i := range projects {
+		responses = append(responses, toProjectResponse(&projects[i], ""))
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"templates": responses,
+		"count":     len(responses),
+	})
+}
+
+// toProjectResponse converts a project model to its response format
+func toProjectResponse(project *models.Project, role string) ProjectResponse {
+	response := ProjectResponse{
+		ID:          project.ID,
+		UserID:      project.UserID,
+		Name:        project.Name,
+		Description: project.Description,
+		CreatedAt:   project.CreatedAt,
+		UpdatedAt:   project.UpdatedAt,
+		Settings:    gin.H{},
+		Status:      project.Status,
+		IsTemplate:  project.IsTemplate,
+		Role:        role,
+	}
+
+	if project.Settings != nil {
+		var settings map[string]interface{}
+		if err := json.Unmarshal(project.Settings, &settings); err == nil {
+			response.Settings = settings
+		}
+	}
+
+	return response
 }
@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/amityadav9314/goinkgrid/internal/db/models"
+	"github.com/amityadav9314/goinkgrid/internal/services"
+	"github.com/gin-gonic/gin"
+)
+
+// authorizeProject checks that the authenticated user holds at least the
+// required role on a project. On failure it writes the error response and
+// returns false; on success it returns the project and the user's role.
+func authorizeProject(c *gin.Context, policy services.AccessPolicy, projectID uint, required string) (*models.Project, string, bool) {
+	// Get user ID from context (set by auth middleware)
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return nil, "", false
+	}
+
+	project, role, err := policy.AuthorizeProject(userID.(uint), projectID, required)
+	if err != nil {
+		respondAccessError(c, err, "Project not found", "project")
+		return nil, "", false
+	}
+
+	return project, role, true
+}
+
+// authorizeMosaic checks that the authenticated user holds at least the
+// required role on the project a mosaic belongs to
+func authorizeMosaic(c *gin.Context, policy services.AccessPolicy, mosaicID uint, required string) (*models.GeneratedMosaic, bool) {
+	// Get user ID from context (set by auth middleware)
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return nil, false
+	}
+
+	mosaic, err := policy.AuthorizeMosaic(userID.(uint), mosaicID, required)
+	if err != nil {
+		respondAccessError(c, err, "Mosaic not found", "mosaic")
+		return nil, false
+	}
+
+	return mosaic, true
+}
+
+// respondAccessError writes the response for an access policy error
+func respondAccessError(c *gin.Context, err error, notFoundMessage, resource string) {
+	switch {
+	case errors.Is(err, services.ErrResourceNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": notFoundMessage})
+	case errors.Is(err, services.ErrAccessDenied):
+		c.JSON(http.StatusForbidden, gin.H{"error": "You don't have permission to perform this action on the " + resource})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check " + resource + " access"})
+	}
+}
@@ -0,0 +1,274 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/amityadav9314/goinkgrid/internal/db/models"
+	"github.com/amityadav9314/goinkgrid/internal/services"
+	"github.com/gin-gonic/gin"
+)
+
+// ProjectMemberHandler handles project sharing requests
+type ProjectMemberHandler struct {
+	memberService services.ProjectMemberService
+	userService   services.UserService
+	policy        services.AccessPolicy
+	mailer        services.Mailer
+}
+
+// NewProjectMemberHandler creates a new project member handler
+func NewProjectMemberHandler(memberService services.ProjectMemberService, userService services.UserService, policy services.AccessPolicy, mailer services.Mailer) *ProjectMemberHandler {
+	return &ProjectMemberHandler{
+		memberService: memberService,
+		userService:   userService,
+		policy:        policy,
+		mailer:        mailer,
+	}
+}
+
+// InviteMemberRequest represents an invite collaborator request
+type InviteMemberRequest struct {
+	Email string `json:"email" binding:"required,email"`
+	Role  string `json:"role" binding:"required,oneof=viewer editor owner"`
+}
+
+// UpdateMemberRequest represents an update collaborator role request
+type UpdateMemberRequest struct {
+	Role string `json:"role" binding:"required,oneof=viewer editor owner"`
+}
+
+// ProjectMemberResponse represents a project member or pending invite
+type ProjectMemberResponse struct {
+	ID          uint       `json:"id"`
+	ProjectID   uint       `json:"project_id"`
+	UserID      *uint      `json:"user_id,omitempty"`
+	Email       string     `json:"email"`
+	Role        string     `json:"role"`
+	Status      string     `json:"status"` // pending, active
+	InviteToken string     `json:"invite_token,omitempty"`
+	AcceptedAt  *time.Time `json:"accepted_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// ListMembers returns the members and pending invites of a project
+func (h *ProjectMemberHandler) ListMembers(c *gin.Context) {
+	projectID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		return
+	}
+
+	project, _, ok := authorizeProject(c, h.policy, uint(projectID), services.ProjectRoleViewer)
+	if !ok {
+		return
+	}
+
+	members, err := h.memberService.FindByProjectID(project.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch members"})
+		return
+	}
+
+	responses := make([]ProjectMemberResponse, 0, len(members))
+	for i := range members {
+		responses = append(responses, toProjectMemberResponse(&members[i]))
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"owner_id": project.UserID,
+		"members":  responses,
+		"count":    len(responses),
+	})
+}
+
+// InviteMember invites a collaborator to a project by email
+func (h *ProjectMemberHandler) InviteMember(c *gin.Context) {
+	projectID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		return
+	}
+
+	var req InviteMemberRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	project, _, ok := authorizeProject(c, h.policy, uint(projectID), services.ProjectRoleOwner)
+	if !ok {
+		return
+	}
+
+	token, member, err := h.memberService.Invite(project.ID, c.GetUint("userID"), req.Email, req.Role)
+	if err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+
+	// The invite is emailed to the collaborator where delivery is set up.
+	// The token is returned to the owner either way, so an invite can still
+	// be shared when the email does not arrive.
+	err = h.mailer.SendInvite(services.InviteEmail{
+		To:          member.Email,
+		ProjectName: project.Name,
+		Role:        member.Role,
+		Token:       token,
+	})
+	if err != nil {
+		log.Printf("Error sending invite email for project %d: %v", project.ID, err)
+	}
+
+	response := toProjectMemberResponse(member)
+	response.InviteToken = token
+
+	c.JSON(http.StatusCreated, response)
+}
+
+// UpdateMember changes a collaborator's role
+func (h *ProjectMemberHandler) UpdateMember(c *gin.Context) {
+	projectID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		return
+	}
+	memberID, err := strconv.ParseUint(c.Param("memberId"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid member ID"})
+		return
+	}
+
+	var req UpdateMemberRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	project, _, ok := authorizeProject(c, h.policy, uint(projectID), services.ProjectRoleOwner)
+	if !ok {
+		return
+	}
+
+	if err := h.memberService.UpdateRole(project.ID, uint(memberID), req.Role); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Member updated successfully"})
+}
+
+// RemoveMember removes a collaborator or pending invite. Owners can remove
+// anyone and members can remove themselves to leave a project.
+func (h *ProjectMemberHandler) RemoveMember(c *gin.Context) {
+	projectID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		return
+	}
+	memberID, err := strconv.ParseUint(c.Param("memberId"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid member ID"})
+		return
+	}
+
+	project, role, ok := authorizeProject(c, h.policy, uint(projectID), services.ProjectRoleViewer)
+	if !ok {
+		return
+	}
+
+	member, err := h.memberService.FindByID(project.ID, uint(memberID))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	isSelf := member.UserID != nil && *member.UserID == c.GetUint("userID")
+	if role != services.ProjectRoleOwner && !isSelf {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You don't have permission to remove this member"})
+		return
+	}
+
+	if err := h.memberService.Remove(project.ID, member.ID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Member removed successfully"})
+}
+
+// ListInvites returns pending invites for the authenticated user's email
+func (h *ProjectMemberHandler) ListInvites(c *gin.Context) {
+	// Get user ID from context (set by auth middleware)
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	user, err := h.userService.FindByID(userID.(uint))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	invites, err := h.memberService.FindPendingByEmail(user.Email)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch invites"})
+		return
+	}
+
+	responses := make([]ProjectMemberResponse, 0, len(invites))
+	for i := range invites {
+		responses = append(responses, toProjectMemberResponse(&invites[i]))
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"invites": responses,
+		"count":   len(responses),
+	})
+}
+
+// AcceptInvite accepts a project invite with its token
+func (h *ProjectMemberHandler) AcceptInvite(c *gin.Context) {
+	// Get user ID from context (set by auth middleware)
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	user, err := h.userService.FindByID(userID.(uint))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	member, err := h.memberService.Accept(c.Param("token"), user)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, toProjectMemberResponse(member))
+}
+
+// toProjectMemberResponse converts a member model to its response format
+func toProjectMemberResponse(member *models.ProjectMember) ProjectMemberResponse {
+	status := "pending"
+	if member.AcceptedAt != nil {
+		status = "active"
+	}
+	return ProjectMemberResponse{
+		ID:         member.ID,
+		ProjectID:  member.ProjectID,
+		UserID:     member.UserID,
+		Email:      member.Email,
+		Role:       member.Role,
+		Status:     status,
+		AcceptedAt: member.AcceptedAt,
+		CreatedAt:  member.CreatedAt,
+	}
+}
@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/amityadav9314/goinkgrid/internal/db/models"
+	"github.com/amityadav9314/goinkgrid/internal/services"
+	"github.com/gin-gonic/gin"
+)
+
+// recordingMailer keeps the invites it was asked to send
+type recordingMailer struct {
+	invites []services.InviteEmail
+}
+
+func (m *recordingMailer) SendInvite(invite services.InviteEmail) error {
+	m.invites = append(m.invites, invite)
+	return nil
+}
+
+func TestInviteMemberSendsEmail(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	gdb := newTestDB(t)
+	owner := models.User{Email: "ada@example.com", PasswordHash: "x", Role: services.RoleUser}
+	mustCreate(t, gdb, &owner)
+	project := models.Project{UserID: owner.ID, Name: "Garden"}
+	mustCreate(t, gdb, &project)
+
+	memberService := services.NewProjectMemberService(gdb)
+	mailer := &recordingMailer{}
+	handler := NewProjectMemberHandler(memberService, services.NewUserService(gdb), services.NewAccessPolicy(gdb, memberService), mailer)
+	r := gin.New()
+	r.Use(func(c *gin.Context) { c.Set("userID", owner.ID) })
+	r.POST("/projects/:id/members", handler.InviteMember)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/projects/"+strconv.Itoa(int(project.ID))+"/members",
+		strings.NewReader(`{"email": "grace@example.com", "role": "editor"}`)))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("got %d: %s", w.Code, w.Body)
+	}
+	var response ProjectMemberResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+
+	want := services.InviteEmail{To: "grace@example.com", ProjectName: "Garden", Role: "editor", Token: response.InviteToken}
+	if len(mailer.invites) != 1 || mailer.invites[0] != want {
+		t.Fatalf("sent %+v, want %+v", mailer.invites, want)
+	}
+}
@@ -0,0 +1,538 @@
+package handlers
+
+import (
+	"fmt"
+	"html/template"
+	"image"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/amityadav9314/goinkgrid/internal/db/models"
+	"github.com/amityadav9314/goinkgrid/internal/services"
+	"github.com/amityadav9314/goinkgrid/pkg/signedurl"
+	"github.com/gin-gonic/gin"
+)
+
+// Public share routes, relative to the API base
+const (
+	sharePageRoute = "/goinkgrid/s/"
+	shareAPIRoute  = "/goinkgrid/share/"
+	oembedRoute    = "/goinkgrid/oembed"
+)
+
+// sharePasswordHeader carries the password for protected links on API requests
+const sharePasswordHeader = "X-Share-Password"
+
+// ShareHandler handles public share links for generated mosaics
+type ShareHandler struct {
+	uploadDir     string
+	shareService  services.ShareLinkService
+	mosaicService services.MosaicService
+	policy        services.AccessPolicy
+	loginGuard    services.LoginGuardService
+	signer        *signedurl.Signer
+}
+
+// NewShareHandler creates a new share handler
+func NewShareHandler(uploadPath string, shareService services.ShareLinkService, mosaicService services.MosaicService, policy services.AccessPolicy, loginGuard services.LoginGuardService, signer *signedurl.Signer) *ShareHandler {
+	return &ShareHandler{
+		uploadDir:     uploadPath,
+		shareService:  shareService,
+		mosaicService: mosaicService,
+		policy:        policy,
+		loginGuard:    loginGuard,
+		signer:        signer,
+	}
+}
+
+// CreateShareLinkRequest represents a create share link request
+type CreateShareLinkRequest struct {
+	Password  string     `json:"password" binding:"omitempty,min=4,max=72"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// ShareLinkResponse represents a share link response
+type ShareLinkResponse struct {
+	ID                uint       `json:"id"`
+	MosaicID          uint       `json:"mosaic_id"`
+	URL               string     `json:"url"`
+	PasswordProtected bool       `json:"password_protected"`
+	ExpiresAt         *time.Time `json:"expires_at,omitempty"`
+	RevokedAt         *time.Time `json:"revoked_at,omitempty"`
+	ViewCount         int        `json:"view_count"`
+	CreatedAt         time.Time  `json:"created_at"`
+}
+
+// SharedMosaicResponse is the public, read-only view of a shared mosaic
+type SharedMosaicResponse struct {
+	Token       string    `json:"token"`
+	SDURL       string    `json:"sd_url"`
+	HDURL       string    `json:"hd_url"`
+	TileSize    int       `json:"tile_size"`
+	TileDensity int       `json:"tile_density"`
+	Style       string    `json:"style"`
+	CreatedAt   time.Time `json:"created_at"`
+	// Deep Zoom descriptor for pan and zoom viewers, on open links that
+	// have a deep zoom export
+	DeepZoomURL string `json:"deep_zoom_url,omitempty"`
+}
+
+// ListShareLinks returns the share links of a mosaic
+func (h *ShareHandler) ListShareLinks(c *gin.Context) {
+	mosaicID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid generation ID"})
+		return
+	}
+
+	mosaic, ok := authorizeMosaic(c, h.policy, uint(mosaicID), services.ProjectRoleViewer)
+	if !ok {
+		return
+	}
+
+	links, err := h.shareService.FindByMosaicID(mosaic.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch share links"})
+		return
+	}
+
+	responses := make([]ShareLinkResponse, 0, len(links))
+	for i := range links {
+		responses = append(responses, h.toShareLinkResponse(c, &links[i]))
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"links": responses,
+		"count": len(responses),
+	})
+}
+
+// CreateShareLink creates a public share link for a completed mosaic
+func (h *ShareHandler) CreateShareLink(c *gin.Context) {
+	mosaicID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid generation ID"})
+		return
+	}
+
+	var req CreateShareLinkRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	mosaic, ok := authorizeMosaic(c, h.policy, uint(mosaicID), services.ProjectRoleEditor)
+	if !ok {
+		return
+	}
+
+	var passwordHash string
+	if req.Password != "" {
+		passwordHash, err = HashPassword(req.Password)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process password"})
+			return
+		}
+	}
+
+	link, err := h.shareService.Create(mosaic.ID, c.GetUint("userID"), passwordHash, req.ExpiresAt)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, h.toShareLinkResponse(c, link))
+}
+
+// RevokeShareLink revokes a share link so it stops working immediately
+func (h *ShareHandler) RevokeShareLink(c *gin.Context) {
+	mosaicID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid generation ID"})
+		return
+	}
+	linkID, err := strconv.ParseUint(c.Param("shareId"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid share link ID"})
+		return
+	}
+
+	mosaic, ok := authorizeMosaic(c, h.policy, uint(mosaicID), services.ProjectRoleEditor)
+	if !ok {
+		return
+	}
+
+	if err := h.shareService.Revoke(mosaic.ID, uint(linkID)); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Share link revoked"})
+}
+
+// GetSharedMosaic returns the public view of a shared mosaic. Protected
+// links need the password in the X-Share-Password header.
+func (h *ShareHandler) GetSharedMosaic(c *gin.Context) {
+	link, mosaic, err := h.shareService.Resolve(c.Param("token"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Share link not found"})
+		return
+	}
+
+	if link.PasswordHash != "" {
+		ok, wait, err := h.verifySharePassword(c, link, c.GetHeader(sharePasswordHeader))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check password"})
+			return
+		}
+		if wait > 0 {
+			respondTooManyAttempts(c, wait)
+			return
+		}
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "A valid password is required", "password_required": true})
+			return
+		}
+	}
+
+	if err := h.shareService.RecordView(link.ID); err != nil {
+		fmt.Printf("Error recording share link view: %v\n", err)
+	}
+
+	c.JSON(http.StatusOK, h.toSharedMosaicResponse(c, link, mosaic))
+}
+
+// GetSharedImage serves the SD or HD image of a share link that is not
+// password protected. Protected links expose signed URLs instead.
+func (h *ShareHandler) GetSharedImage(c *gin.Context) {
+	link, mosaic, err := h.shareService.Resolve(c.Param("token"))
+	if err != nil || link.PasswordHash != "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Share link not found"})
+		return
+	}
+
+	storedPath := mosaic.SDPath
+	switch c.Param("size") {
+	case "sd":
+	case "hd":
+		storedPath = mosaic.HDPath
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Size must be sd or hd"})
+		return
+	}
+
+	fullPath := uploadFilePath(h.uploadDir, storedPath)
+	if _, err := os.Stat(fullPath); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return
+	}
+
+	// Keep caching short so revocation takes effect quickly
+	c.Header("Cache-Control", "public, max-age=300")
+	c.File(fullPath)
+}
+
+// GetSharedDeepZoom serves the Deep Zoom descriptor or a tile of a share
+// link that is not password protected. Viewers fetch hundreds of tiles,
+// so protected links do not offer deep zoom.
+func (h *ShareHandler) GetSharedDeepZoom(c *gin.Context) {
+	link, mosaic, err := h.shareService.Resolve(c.Param("token"))
+	if err != nil || link.PasswordHash != "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Share link not found"})
+		return
+	}
+
+	// Keep caching short so revocation takes effect quickly
+	c.Header("Cache-Control", "public, max-age=300")
+	serveDeepZoom(c, h.mosaicService, mosaic.ID)
+}
+
+// SharePage renders a minimal public page for a share link. Protected links
+// show a password form that posts back to the same URL.
+func (h *ShareHandler) SharePage(c *gin.Context) {
+	link, mosaic, err := h.shareService.Resolve(c.Param("token"))
+	if err != nil {
+		h.renderSharePage(c, http.StatusNotFound, sharePageData{NotFound: true})
+		return
+	}
+
+	pageURL := requestBaseURL(c) + sharePageRoute + link.Token
+	data := sharePageData{
+		PageURL:   pageURL,
+		OEmbedURL: requestBaseURL(c) + oembedRoute + "?format=json&url=" + url.QueryEscape(pageURL),
+	}
+
+	if link.PasswordHash != "" {
+		data.PasswordRequired = true
+		if c.Request.Method != http.MethodPost {
+			h.renderSharePage(c, http.StatusOK, data)
+			return
+		}
+
+		ok, wait, err := h.verifySharePassword(c, link, c.PostForm("password"))
+		if err != nil {
+			c.String(http.StatusInternalServerError, "Failed to check password")
+			return
+		}
+		if wait > 0 {
+			data.Error = "Too many failed attempts, try again later"
+			h.renderSharePage(c, http.StatusTooManyRequests, data)
+			return
+		}
+		if !ok {
+			data.Error = "Incorrect password"
+			h.renderSharePage(c, http.StatusUnauthorized, data)
+			return
+		}
+		data.PasswordRequired = false
+	}
+
+	if err := h.shareService.RecordView(link.ID); err != nil {
+		fmt.Printf("Error recording share link view: %v\n", err)
+	}
+
+	shared := h.toSharedMosaicResponse(c, link, mosaic)
+	data.SDURL = shared.SDURL
+	data.HDURL = shared.HDURL
+	data.Mosaic = shared
+	// Only open links advertise the image to link unfurlers
+	if link.PasswordHash == "" {
+		data.OGImage = shared.SDURL
+	}
+
+	h.renderSharePage(c, http.StatusOK, data)
+}
+
+// OEmbed implements the oEmbed endpoint for share page URLs so links unfurl
+// in chat tools. Only the JSON format is supported.
+func (h *ShareHandler) OEmbed(c *gin.Context) {
+	if format := c.DefaultQuery("format", "json"); format != "json" {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "Only the json format is supported"})
+		return
+	}
+
+	token := shareTokenFromURL(c.Query("url"))
+	if token == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Share link not found"})
+		return
+	}
+
+	link, mosaic, err := h.shareService.Resolve(token)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Share link not found"})
+		return
+	}
+
+	response := gin.H{
+		"version":       "1.0",
+		"title":         "Photo mosaic",
+		"provider_name": "InkGrid",
+		"provider_url":  requestBaseURL(c),
+		"cache_age":     300,
+	}
+
+	// Protected links unfurl as plain links without revealing the image
+	if link.PasswordHash != "" {
+		response["type"] = "link"
+		c.JSON(http.StatusOK, response)
+		return
+	}
+
+	width, height := h.imageSize(mosaic.SDPath)
+	width, height = fitWithin(width, height, queryInt(c, "maxwidth"), queryInt(c, "maxheight"))
+
+	response["type"] = "photo"
+	response["url"] = requestBaseURL(c) + shareAPIRoute + link.Token + "/image/sd"
+	response["width"] = width
+	response["height"] = height
+
+	c.JSON(http.StatusOK, response)
+}
+
+// verifySharePassword checks a share link password. Guesses are throttled
+// per link and per IP with the login guard. A non-zero wait means the
+// attempt was rejected without checking the password.
+func (h *ShareHandler) verifySharePassword(c *gin.Context, link *models.MosaicShareLink, password string) (bool, time.Duration, error) {
+	wait, err := h.loginGuard.Check(services.AuthEventSharePassword, link.Token, c.ClientIP())
+	if err != nil {
+		return false, 0, err
+	}
+	if wait > 0 {
+		recordAuthAttempt(c, h.loginGuard, services.AuthEventSharePassword, link.Token, nil, false, services.AuthReasonThrottled)
+		return false, wait, nil
+	}
+
+	if password == "" {
+		return false, 0, nil
+	}
+	if !CheckPasswordHash(password, link.PasswordHash) {
+		recordAuthAttempt(c, h.loginGuard, services.AuthEventSharePassword, link.Token, nil, false, "invalid_password")
+		return false, 0, nil
+	}
+
+	recordAuthAttempt(c, h.loginGuard, services.AuthEventSharePassword, link.Token, nil, true, "")
+	return true, 0, nil
+}
+
+// toShareLinkResponse converts a share link model to its response format
+func (h *ShareHandler) toShareLinkResponse(c *gin.Context, link *models.MosaicShareLink) ShareLinkResponse {
+	return ShareLinkResponse{
+		ID:                link.ID,
+		MosaicID:          link.MosaicID,
+		URL:               requestBaseURL(c) + sharePageRoute + link.Token,
+		PasswordProtected: link.PasswordHash != "",
+		ExpiresAt:         link.ExpiresAt,
+		RevokedAt:         link.RevokedAt,
+		ViewCount:         link.ViewCount,
+		CreatedAt:         link.CreatedAt,
+	}
+}
+
+// toSharedMosaicResponse builds the public view of a shared mosaic. Open
+// links use stable image URLs; protected links get short-lived signed URLs
+// so the password is not needed on every image request.
+func (h *ShareHandler) toSharedMosaicResponse(c *gin.Context, link *models.MosaicShareLink, mosaic *models.GeneratedMosaic) SharedMosaicResponse {
+	response := SharedMosaicResponse{
+		Token:       link.Token,
+		TileSize:    mosaic.TileSize,
+		TileDensity: mosaic.TileDensity,
+		Style:       mosaic.Style,
+		CreatedAt:   mosaic.CreatedAt,
+	}
+
+	if link.PasswordHash == "" {
+		imageURL := requestBaseURL(c) + shareAPIRoute + link.Token + "/image/"
+		response.SDURL = imageURL + "sd"
+		response.HDURL = imageURL + "hd"
+		if _, err := h.mosaicService.DeepZoomFile(mosaic.ID, ""); err == nil {
+			response.DeepZoomURL = requestBaseURL(c) + shareAPIRoute + link.Token + "/deepzoom.dzi"
+		}
+	} else {
+		response.SDURL = signedFileURL(c, h.signer, mosaic.SDPath)
+		response.HDURL = signedFileURL(c, h.signer, mosaic.HDPath)
+	}
+
+	return response
+}
+
+// imageSize reads the dimensions of a stored image, or zero if unreadable
+func (h *ShareHandler) imageSize(storedPath string) (int, int) {
+	file, err := os.Open(uploadFilePath(h.uploadDir, storedPath))
+	if err != nil {
+		return 0, 0
+	}
+	defer file.Close()
+
+	config, _, err := image.DecodeConfig(file)
+	if err != nil {
+		return 0, 0
+	}
+	return config.Width, config.Height
+}
+
+// shareTokenFromURL extracts the token from a share page or share API URL
+func shareTokenFromURL(rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+
+	for _, prefix := range []string{sharePageRoute, shareAPIRoute} {
+		if strings.HasPrefix(parsed.Path, prefix) {
+			token := strings.TrimPrefix(parsed.Path, prefix)
+			token, _, _ = strings.Cut(token, "/")
+			return token
+		}
+	}
+	return ""
+}
+
+// fitWithin scales width and height down to fit the given bounds, keeping
+// the aspect ratio. Bounds of zero are ignored.
+func fitWithin(width, height, maxWidth, maxHeight int) (int, int) {
+	if width == 0 || height == 0 {
+		return width, height
+	}
+	if maxWidth > 0 && width > maxWidth {
+		height = height * maxWidth / width
+		width = maxWidth
+	}
+	if maxHeight > 0 && height > maxHeight {
+		width = width * maxHeight / height
+		height = maxHeight
+	}
+	return width, height
+}
+
+// queryInt parses an optional integer query parameter, returning zero when
+// it is missing or invalid
+func queryInt(c *gin.Context, name string) int {
+	value, err := strconv.Atoi(c.Query(name))
+	if err != nil || value < 0 {
+		return 0
+	}
+	return value
+}
+
+// sharePageData is the template data for the public share page
+type sharePageData struct {
+	NotFound         bool
+	PasswordRequired bool
+	Error            string
+	PageURL          string
+	OEmbedURL        string
+	OGImage          string
+	SDURL            string
+	HDURL            string
+	Mosaic           SharedMosaicResponse
+}
+
+// renderSharePage writes the public share page
+func (h *ShareHandler) renderSharePage(c *gin.Context, status int, data sharePageData) {
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	c.Header("X-Robots-Tag", "noindex")
+	c.Status(status)
+	if err := sharePageTemplate.Execute(c.Writer, data); err != nil {
+		fmt.Printf("Error rendering share page: %v\n", err)
+	}
+}
+
+var sharePageTemplate = template.Must(template.New("share").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Photo mosaic - InkGrid</title>
+{{if .OEmbedURL}}<link rel="alternate" type="application/json+oembed" href="{{.OEmbedURL}}" title="Photo mosaic">{{end}}
+<meta property="og:type" content="website">
+<meta property="og:title" content="Photo mosaic">
+{{if .PageURL}}<meta property="og:url" content="{{.PageURL}}">{{end}}
+{{if .OGImage}}<meta property="og:image" content="{{.OGImage}}">{{end}}
+<style>
+body { font-family: sans-serif; margin: 0; padding: 24px; background: #111; color: #eee; text-align: center; }
+img { max-width: 100%; height: auto; }
+a { color: #9cf; }
+.error { color: #f88; }
+</style>
+</head>
+<body>
+{{if .NotFound}}
+<p>This link does not exist, has expired or was revoked.</p>
+{{else if .PasswordRequired}}
+<form method="post">
+<p>This mosaic is password protected.</p>
+{{if .Error}}<p class="error">{{.Error}}</p>{{end}}
+<input type="password" name="password" autofocus required>
+<button type="submit">View</button>
+</form>
+{{else}}
+<img src="{{.SDURL}}" alt="Photo mosaic">
+<p>{{.Mosaic.Style}} style, {{.Mosaic.TileSize}}px tiles &middot; <a href="{{.HDURL}}">Download HD</a></p>
+{{end}}
+</body>
+</html>
+`))
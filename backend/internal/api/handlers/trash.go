@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/amityadav9314/goinkgrid/internal/services"
+	"github.com/amityadav9314/goinkgrid/pkg/signedurl"
+	"github.com/gin-gonic/gin"
+)
+
+// TrashHandler handles listing, restoring and purging deleted items
+type TrashHandler struct {
+	trashService services.TrashService
+	signer       *signedurl.Signer
+}
+
+// NewTrashHandler creates a new trash handler
+func NewTrashHandler(trashService services.TrashService, signer *signedurl.Signer) *TrashHandler {
+	return &TrashHandler{
+		trashService: trashService,
+		signer:       signer,
+	}
+}
+
+// TrashProjectResponse represents a project in the trash
+type TrashProjectResponse struct {
+	ID          uint      `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	DeletedAt   time.Time `json:"deleted_at"`
+	PurgeAt     time.Time `json:"purge_at"`
+}
+
+// TrashImageResponse represents an image in the trash
+type TrashImageResponse struct {
+	ID        uint      `json:"id"`
+	ProjectID *uint     `json:"project_id,omitempty"`
+	Type      string    `json:"type"`
+	Path      string    `json:"path"`
+	Filename  string    `json:"filename"`
+	DeletedAt time.Time `json:"deleted_at"`
+	PurgeAt   time.Time `json:"purge_at"`
+}
+
+// ListTrash returns the user's deleted projects and images with the time
+// each one will be purged
+func (h *TrashHandler) ListTrash(c *gin.Context) {
+	// Get user ID from context (set by auth middleware)
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	trash, err := h.trashService.List(userID.(uint))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch trash"})
+		return
+	}
+
+	retention := h.trashService.Retention()
+
+	projects := make([]TrashProjectResponse, 0, len(trash.Projects))
+	for _, project := range trash.Projects {
+		projects = append(projects, TrashProjectResponse{
+			ID:          project.ID,
+			Name:        project.Name,
+			Description: project.Description,
+			DeletedAt:   project.DeletedAt.Time,
+			PurgeAt:     project.DeletedAt.Time.Add(retention),
+		})
+	}
+
+	images := make([]TrashImageResponse, 0, len(trash.Images))
+	for _, img := range trash.Images {
+		images = append(images, TrashImageResponse{
+			ID:        img.ID,
+			ProjectID: img.ProjectID,
+			Type:      img.Type,
+			Path:      signedFileURL(c, h.signer, img.Path),
+			Filename:  img.Filename,
+			DeletedAt: img.DeletedAt.Time,
+			PurgeAt:   img.DeletedAt.Time.Add(retention),
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"projects":       projects,
+		"images":         images,
+		"retention_days": int(retention.Hours() / 24),
+	})
+}
+
+// RestoreProject moves one of the user's projects out of the trash
+func (h *TrashHandler) RestoreProject(c *gin.Context) {
+	h.handleTrashAction(c, "project", h.trashService.RestoreProject, "Project restored")
+}
+
+// RestoreImage moves one of the user's images out of the trash
+func (h *TrashHandler) RestoreImage(c *gin.Context) {
+	h.handleTrashAction(c, "image", h.trashService.RestoreImage, "Image restored")
+}
+
+// PurgeProject permanently deletes a trashed project without waiting for
+// the retention period
+func (h *TrashHandler) PurgeProject(c *gin.Context) {
+	h.handleTrashAction(c, "project", h.trashService.PurgeProject, "Project permanently deleted")
+}
+
+// PurgeImage permanently deletes a trashed image without waiting for the
+// retention period
+func (h *TrashHandler) PurgeImage(c *gin.Context) {
+	h.handleTrashAction(c, "image", h.trashService.PurgeImage, "Image permanently deleted")
+}
+
+// handleTrashAction parses the item ID from the path and applies action to
+// it on behalf of the authenticated user
+func (h *TrashHandler) handleTrashAction(c *gin.Context, resource string, action func(userID uint, id uint) error, message string) {
+	// Get user ID from context (set by auth middleware)
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + resource + " ID"})
+		return
+	}
+
+	if err := action(userID.(uint), uint(id)); err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update trash"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": message})
+}
@@ -5,23 +5,29 @@ import (
 	"net/http"
 	"strings"
 
+	"github.com/amityadav9314/goinkgrid/internal/services"
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v4"
 )
 
 // AuthMiddleware is a middleware for authentication
 type AuthMiddleware struct {
-	jwtSecret []byte
+	jwtSecret     []byte
+	userService   services.UserService
+	apiKeyService services.APIKeyService
 }
 
 // NewAuthMiddleware creates a new auth middleware
-func NewAuthMiddleware(jwtSecret string) *AuthMiddleware {
+func NewAuthMiddleware(jwtSecret string, userService services.UserService, apiKeyService services.APIKeyService) *AuthMiddleware {
 	return &AuthMiddleware{
-		jwtSecret: []byte(jwtSecret),
+		jwtSecret:     []byte(jwtSecret),
+		userService:   userService,
+		apiKeyService: apiKeyService,
 	}
 }
 
-// RequireAuth is a middleware that requires authentication
+// RequireAuth is a middleware that requires authentication.
+// It accepts either a JWT or a personal API key as the Bearer token.
 func (m *AuthMiddleware) RequireAuth() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Get token from Authorization header
@@ -42,32 +48,35 @@ func (m *AuthMiddleware) RequireAuth() gin.HandlerFunc {
 
 		tokenString := parts[1]
 
-		// Parse and validate token
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			// Validate signing method
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, errors.New("unexpected signing method")
+		// Personal API keys are recognised by their prefix
+		if strings.HasPrefix(tokenString, services.APIKeyPrefix) {
+			apiKey, err := m.apiKeyService.Authenticate(tokenString)
+			if err != nil {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid API key"})
+				c.Abort()
+				return
 			}
-			return m.jwtSecret, nil
-		})
 
-		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
-			c.Abort()
-			return
-		}
+			user, err := m.userService.FindByID(apiKey.UserID)
+			if err != nil || user.DisabledAt != nil {
+				c.JSON(http.StatusForbidden, gin.H{"error": "Account is disabled"})
+				c.Abort()
+				return
+			}
 
-		// Check if token is valid
-		if !token.Valid {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
-			c.Abort()
+			c.Set("userID", apiKey.UserID)
+			c.Set("role", user.Role)
+			c.Set("apiKeyID", apiKey.ID)
+			c.Set("scopes", strings.Fields(apiKey.Scopes))
+
+			c.Next()
 			return
 		}
 
-		// Extract claims
-		claims, ok := token.Claims.(jwt.MapClaims)
-		if !ok {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token claims"})
+		// Parse and validate token
+		claims, err := m.parseToken(tokenString)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
 			c.Abort()
 			return
 		}
@@ -80,8 +89,75 @@ func (m *AuthMiddleware) RequireAuth() gin.HandlerFunc {
 			return
 		}
 
+		// Tokens stay valid until expiry, so disabled accounts are checked here
+		user, err := m.userService.FindByID(uint(userID))
+		if err != nil || user.DisabledAt != nil {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Account is disabled"})
+			c.Abort()
+			return
+		}
+
+		// The role is read from the account rather than the token, so a
+		// role change applies to tokens that were already issued
 		c.Set("userID", uint(userID))
 		c.Set("email", claims["email"])
+		c.Set("role", user.Role)
+
+		c.Next()
+	}
+}
+
+// RequireRole is a middleware that requires the authenticated user to have
+// the given role. It must run after RequireAuth.
+func (m *AuthMiddleware) RequireRole(role string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetString("role") != role {
+			c.JSON(http.StatusForbidden, gin.H{"error": "You don't have permission to access this resource"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// RequireScope is a middleware that checks API key scopes. Safe (read-only)
+// requests need readScope and all other requests need writeScope. Requests
+// authenticated with a JWT session are not restricted by scopes.
+func (m *AuthMiddleware) RequireScope(readScope, writeScope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		scopes, isAPIKey := c.Get("scopes")
+		if !isAPIKey {
+			c.Next()
+			return
+		}
+
+		required := writeScope
+		if c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead {
+			required = readScope
+		}
+
+		for _, scope := range scopes.([]string) {
+			if scope == required {
+				c.Next()
+				return
+			}
+		}
+
+		c.JSON(http.StatusForbidden, gin.H{"error": "API key is missing required scope: " + required})
+		c.Abort()
+	}
+}
+
+// RequireSession is a middleware that rejects requests authenticated with an
+// API key, for routes that must only be reachable from an interactive login
+func (m *AuthMiddleware) RequireSession() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, isAPIKey := c.Get("apiKeyID"); isAPIKey {
+			c.JSON(http.StatusForbidden, gin.H{"error": "This endpoint cannot be used with an API key"})
+			c.Abort()
+			return
+		}
 
 		c.Next()
 	}
@@ -109,39 +185,57 @@ func (m *AuthMiddleware) OptionalAuth() gin.HandlerFunc {
 		tokenString := parts[1]
 
 		// Parse and validate token
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			// Validate signing method
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, errors.New("unexpected signing method")
-			}
-			return m.jwtSecret, nil
-		})
-
-		if err != nil || !token.Valid {
+		claims, err := m.parseToken(tokenString)
+		if err != nil {
 			// Invalid token, continue without authentication
 			c.Next()
 			return
 		}
 
-		// Extract claims
-		claims, ok := token.Claims.(jwt.MapClaims)
+		// Set user ID in context
+		userID, ok := claims["id"].(float64)
 		if !ok {
-			// Invalid claims, continue without authentication
+			// Invalid user ID, continue without authentication
 			c.Next()
 			return
 		}
 
-		// Set user ID in context
-		userID, ok := claims["id"].(float64)
-		if !ok {
-			// Invalid user ID, continue without authentication
+		// Disabled accounts continue without authentication
+		user, err := m.userService.FindByID(uint(userID))
+		if err != nil || user.DisabledAt != nil {
 			c.Next()
 			return
 		}
 
+		// The role is read from the account rather than the token, so a
+		// role change applies to tokens that were already issued
 		c.Set("userID", uint(userID))
 		c.Set("email", claims["email"])
+		c.Set("role", user.Role)
 
 		c.Next()
 	}
 }
+
+// parseToken parses and validates a JWT and returns its claims
+func (m *AuthMiddleware) parseToken(tokenString string) (jwt.MapClaims, error) {
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		// Validate signing method
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, errors.New("unexpected signing method")
+		}
+		return m.jwtSecret, nil
+	})
+
+	if err != nil || !token.Valid {
+		return nil, errors.New("Invalid token")
+	}
+
+	// Extract claims
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, errors.New("Invalid token claims")
+	}
+
+	return claims, nil
+}
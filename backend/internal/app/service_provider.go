@@ -1,8 +1,14 @@
 package app
 
 import (
+	"log"
+	"time"
+
+	"github.com/amityadav9314/goinkgrid/config"
 	"github.com/amityadav9314/goinkgrid/internal/api/handlers"
 	"github.com/amityadav9314/goinkgrid/internal/services"
+	"github.com/amityadav9314/goinkgrid/pkg/oidc"
+	"github.com/amityadav9314/goinkgrid/pkg/signedurl"
 	akyWs "github.com/amityadav9314/goinkgrid/pkg/websocket"
 	"gorm.io/gorm"
 )
@@ -18,12 +24,32 @@ type ServiceProvider struct {
 	projectService services.ProjectService
 	imageService   services.ImageService
 	mosaicService  services.MosaicService
+	apiKeyService  services.APIKeyService
+	loginGuard     services.LoginGuardService
+	adminService   services.AdminService
+	memberService  services.ProjectMemberService
+	accessPolicy   services.AccessPolicy
+	shareService   services.ShareLinkService
+	archiveService services.ProjectArchiveService
+	trashService   services.TrashService
+	mailer         services.Mailer
+
+	// Signs file URLs served by the file handler
+	fileSigner *signedurl.Signer
 
 	// Handlers
 	authHandler    *handlers.AuthHandler
 	projectHandler *handlers.ProjectHandler
 	imageHandler   *handlers.ImageHandler
 	mosaicHandler  *handlers.MosaicHandler
+	profileHandler *handlers.ProfileHandler
+	apiKeyHandler  *handlers.APIKeyHandler
+	adminHandler   *handlers.AdminHandler
+	memberHandler  *handlers.ProjectMemberHandler
+	fileHandler    *handlers.FileHandler
+	shareHandler   *handlers.ShareHandler
+	archiveHandler *handlers.ArchiveHandler
+	trashHandler   *handlers.TrashHandler
 }
 
 // NewServiceProvider initializes the service provider with dependencies
@@ -37,6 +63,9 @@ func NewServiceProvider(db *gorm.DB, jwtSecret string, pool *akyWs.Pool) *Servic
 	// Initialize services
 	sp.initServices()
 
+	// Promote the admins listed in config
+	sp.bootstrapAdmins()
+
 	// Initialize handlers
 	sp.initHandlers()
 
@@ -48,15 +77,102 @@ func (sp *ServiceProvider) initServices() {
 	sp.userService = services.NewUserService(sp.db)
 	sp.projectService = services.NewProjectService(sp.db)
 	sp.imageService = services.NewImageService(sp.db)
-	sp.mosaicService = services.NewMosaicService("./uploads")
+	sp.mosaicService = services.NewMosaicService("./uploads", services.RenderConfig{
+		TileCacheBytes: config.Config.GetInt64("render.tile_cache_mb") << 20,
+	}, services.PreviewConfig{
+		MaxEdge: config.Config.GetInt("preview.max_edge"),
+		Timeout: time.Duration(config.Config.GetInt("preview.timeout_ms")) * time.Millisecond,
+	})
+	sp.apiKeyService = services.NewAPIKeyService(sp.db)
+	sp.adminService = services.NewAdminService(sp.db, "./uploads")
+	sp.memberService = services.NewProjectMemberService(sp.db)
+	sp.accessPolicy = services.NewAccessPolicy(sp.db, sp.memberService)
+	sp.fileSigner = sp.newFileSigner()
+	sp.shareService = services.NewShareLinkService(sp.db)
+	sp.archiveService = services.NewProjectArchiveService(sp.db, "./uploads")
+	// Invites are only logged until an SMTP host is configured
+	sp.mailer = services.NewMailer(services.SMTPConfig{
+		Host:      config.Config.GetString("mail.smtp.host"),
+		Port:      config.Config.GetInt("mail.smtp.port"),
+		Username:  config.Config.GetString("mail.smtp.username"),
+		Password:  config.Config.GetString("mail.smtp.password"),
+		From:      config.Config.GetString("mail.from"),
+		InviteURL: config.Config.GetString("mail.invite_url"),
+	})
+	sp.trashService = services.NewTrashService(sp.db, "./uploads", services.TrashConfig{
+		Retention:     time.Duration(config.Config.GetInt("trash.retention_days")) * 24 * time.Hour,
+		PurgeInterval: time.Duration(config.Config.GetInt("trash.purge_interval_minutes")) * time.Minute,
+	})
+	sp.loginGuard = services.NewLoginGuardService(sp.db, services.LoginGuardConfig{
+		Window:             time.Duration(config.Config.GetInt("auth.lockout.window_seconds")) * time.Second,
+		MaxAccountFailures: config.Config.GetInt("auth.lockout.max_account_failures"),
+		MaxIPFailures:      config.Config.GetInt("auth.lockout.max_ip_failures"),
+		BaseBackoff:        time.Duration(config.Config.GetInt("auth.lockout.base_backoff_seconds")) * time.Second,
+		MaxBackoff:         time.Duration(config.Config.GetInt("auth.lockout.max_backoff_seconds")) * time.Second,
+		LockoutDuration:    time.Duration(config.Config.GetInt("auth.lockout.lockout_seconds")) * time.Second,
+	})
+}
+
+// bootstrapAdmins gives the admin role to the accounts listed under
+// admin.emails. Emails without an account yet are promoted on a later
+// start, once the account exists.
+func (sp *ServiceProvider) bootstrapAdmins() {
+	missing, err := sp.adminService.PromoteAdmins(config.Config.GetStringSlice("admin.emails"))
+	if err != nil {
+		log.Printf("Failed to promote configured admins: %v", err)
+		return
+	}
+	for _, email := range missing {
+		log.Printf("Configured admin %s has no account yet", email)
+	}
 }
 
 // initHandlers initializes all handlers
 func (sp *ServiceProvider) initHandlers() {
-	sp.authHandler = handlers.NewAuthHandler(sp.userService, sp.jwtSecret)
-	sp.projectHandler = handlers.NewProjectHandler(sp.projectService)
-	sp.imageHandler = handlers.NewImageHandler("./uploads", sp.imageService)
-	sp.mosaicHandler = handlers.NewMosaicHandler(sp.mosaicService)
+	sp.authHandler = handlers.NewAuthHandler(sp.userService, sp.loginGuard, sp.jwtSecret, sp.newOIDCClient(), config.Config.GetString("oidc.frontend_redirect_url"))
+	sp.projectHandler = handlers.NewProjectHandler(sp.projectService, sp.memberService, sp.accessPolicy)
+	sp.imageHandler = handlers.NewImageHandler("./uploads", sp.imageService, sp.accessPolicy, sp.fileSigner)
+	sp.mosaicHandler = handlers.NewMosaicHandler(sp.mosaicService, sp.accessPolicy, sp.fileSigner)
+	sp.profileHandler = handlers.NewProfileHandler("./uploads", sp.userService, sp.imageService, sp.trashService, sp.loginGuard, sp.fileSigner)
+	sp.apiKeyHandler = handlers.NewAPIKeyHandler(sp.apiKeyService)
+	sp.adminHandler = handlers.NewAdminHandler(sp.adminService, sp.mosaicService)
+	sp.memberHandler = handlers.NewProjectMemberHandler(sp.memberService, sp.userService, sp.accessPolicy, sp.mailer)
+	sp.fileHandler = handlers.NewFileHandler("./uploads", sp.fileSigner)
+	sp.shareHandler = handlers.NewShareHandler("./uploads", sp.shareService, sp.mosaicService, sp.accessPolicy, sp.loginGuard, sp.fileSigner)
+	sp.archiveHandler = handlers.NewArchiveHandler(sp.archiveService, sp.accessPolicy)
+	sp.trashHandler = handlers.NewTrashHandler(sp.trashService, sp.fileSigner)
+}
+
+// newOIDCClient creates the OIDC client from config, or returns nil when
+// single sign-on is disabled
+func (sp *ServiceProvider) newOIDCClient() *oidc.Client {
+	if !config.Config.GetBool("oidc.enabled") {
+		return nil
+	}
+
+	return oidc.NewClient(oidc.Config{
+		DiscoveryURL: config.Config.GetString("oidc.discovery_url"),
+		ClientID:     config.Config.GetString("oidc.client_id"),
+		ClientSecret: config.Config.GetString("oidc.client_secret"),
+		RedirectURL:  config.Config.GetString("oidc.redirect_url"),
+		Scopes:       config.Config.GetStringSlice("oidc.scopes"),
+	})
+}
+
+// newFileSigner creates the signer for file URLs. A dedicated secret can be
+// configured; otherwise one is derived from the JWT secret.
+func (sp *ServiceProvider) newFileSigner() *signedurl.Signer {
+	secret := config.Config.GetString("files.url_signing_secret")
+	if secret == "" {
+		secret = "files:" + sp.jwtSecret
+	}
+
+	ttl := time.Duration(config.Config.GetInt("files.url_ttl_seconds")) * time.Second
+	if ttl <= 0 {
+		ttl = time.Hour
+	}
+
+	return signedurl.NewSigner(secret, ttl)
 }
 
 // UserService returns the user service
@@ -79,6 +195,21 @@ func (sp *ServiceProvider) MosaicService() services.MosaicService {
 	return sp.mosaicService
 }
 
+// APIKeyService returns the API key service
+func (sp *ServiceProvider) APIKeyService() services.APIKeyService {
+	return sp.apiKeyService
+}
+
+// ProjectMemberService returns the project member service
+func (sp *ServiceProvider) ProjectMemberService() services.ProjectMemberService {
+	return sp.memberService
+}
+
+// TrashService returns the trash service
+func (sp *ServiceProvider) TrashService() services.TrashService {
+	return sp.trashService
+}
+
 // AuthHandler returns the auth handler
 func (sp *ServiceProvider) AuthHandler() *handlers.AuthHandler {
 	return sp.authHandler
@@ -99,6 +230,51 @@ func (sp *ServiceProvider) MosaicHandler() *handlers.MosaicHandler {
 	return sp.mosaicHandler
 }
 
+// ProfileHandler returns the profile handler
+func (sp *ServiceProvider) ProfileHandler() *handlers.ProfileHandler {
+	return sp.profileHandler
+}
+
+// APIKeyHandler returns the API key handler
+func (sp *ServiceProvider) APIKeyHandler() *handlers.APIKeyHandler {
+	return sp.apiKeyHandler
+}
+
+// AdminHandler returns the admin handler
+func (sp *ServiceProvider) AdminHandler() *handlers.AdminHandler {
+	return sp.adminHandler
+}
+
+// AccessPolicy returns the access policy
+func (sp *ServiceProvider) AccessPolicy() services.AccessPolicy {
+	return sp.accessPolicy
+}
+
+// FileHandler returns the file handler
+func (sp *ServiceProvider) FileHandler() *handlers.FileHandler {
+	return sp.fileHandler
+}
+
+// ShareHandler returns the share handler
+func (sp *ServiceProvider) ShareHandler() *handlers.ShareHandler {
+	return sp.shareHandler
+}
+
+// ArchiveHandler returns the archive handler
+func (sp *ServiceProvider) ArchiveHandler() *handlers.ArchiveHandler {
+	return sp.archiveHandler
+}
+
+// TrashHandler returns the trash handler
+func (sp *ServiceProvider) TrashHandler() *handlers.TrashHandler {
+	return sp.trashHandler
+}
+
+// ProjectMemberHandler returns the project member handler
+func (sp *ServiceProvider) ProjectMemberHandler() *handlers.ProjectMemberHandler {
+	return sp.memberHandler
+}
+
 // JWTSecret returns the JWT secret
 func (sp *ServiceProvider) JWTSecret() string {
 	return sp.jwtSecret
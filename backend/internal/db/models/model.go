@@ -4,15 +4,29 @@ import (
 	"time"
 
 	"gorm.io/datatypes"
+	"gorm.io/gorm"
 )
 
 type User struct {
 	ID           uint   `gorm:"primaryKey"`
 	Email        string `gorm:"unique;not null"`
 	PasswordHash string `gorm:"not null"`
-	CreatedAt    time.Time
-	UpdatedAt    time.Time
-	Projects     []Project
+	Role         string `gorm:"not null;default:'user'"` // user, admin
+	DisabledAt   *time.Time
+	Name         string
+	AvatarPath   string
+	Locale       string `gorm:"not null;default:'en'"`
+	// External identity for users provisioned or linked through OIDC login
+	OIDCIssuer  string `gorm:"index:idx_user_oidc_identity"`
+	OIDCSubject string `gorm:"index:idx_user_oidc_identity"`
+	// Default mosaic preferences used when no saved settings exist
+	DefaultTileSize        int    `gorm:"not null;default:50"`
+	DefaultTileDensity     int    `gorm:"not null;default:80"`
+	DefaultColorAdjustment int    `gorm:"not null;default:50"`
+	DefaultStyle           string `gorm:"not null;default:'classic'"`
+	CreatedAt              time.Time
+	UpdatedAt              time.Time
+	Projects               []Project
 }
 
 type Project struct {
@@ -22,9 +36,12 @@ type Project struct {
 	Description string
 	CreatedAt   time.Time
 	UpdatedAt   time.Time
+	DeletedAt   gorm.DeletedAt `gorm:"index"` // set while the project is in the trash
 	Settings    datatypes.JSON
 	Status      string
-	Images      []Image
+	// Templates can be duplicated by any user
+	IsTemplate bool `gorm:"not null;default:false;index"`
+	Images     []Image
 }
 
 type Image struct {
@@ -38,6 +55,7 @@ type Image struct {
 	Height      int
 	Format      string
 	CreatedAt   time.Time
+	DeletedAt   gorm.DeletedAt   `gorm:"index"` // set while the image is in the trash
 	ColorData   datatypes.JSON   // for tiles
 	Collections []TileCollection `gorm:"many2many:collection_images;"`
 }
@@ -62,33 +80,114 @@ type CollectionImage struct {
 // MosaicSettings represents user-specific mosaic generation settings
 type MosaicSettings struct {
 	ID              uint   `gorm:"primaryKey"`
-	UserID          uint   `gorm:"not null;index;uniqueIndex"` // One settings per user
-	ProjectID       *uint  `gorm:"index"`
+	UserID          uint   `gorm:"not null;index;uniqueIndex:idx_mosaic_settings_user_project;uniqueIndex:idx_mosaic_settings_user_default,where:project_id IS NULL"` // One settings per user and project, and one without a project
+	ProjectID       *uint  `gorm:"index;uniqueIndex:idx_mosaic_settings_user_project"`
 	TileSize        int    `gorm:"not null;default:50"`
 	TileDensity     int    `gorm:"not null;default:80"`
 	ColorAdjustment int    `gorm:"not null;default:50"`
 	Style           string `gorm:"not null;default:'classic'"`
+	Seed            *int64 // seed generations use when the request has none, a new one for every generation if nil
 	CreatedAt       time.Time
 	UpdatedAt       time.Time
 }
 
 // GeneratedMosaic represents a generated mosaic image
 type GeneratedMosaic struct {
-	ID             uint      `gorm:"primaryKey"`
-	UserID         uint      `gorm:"not null;index"`
-	ProjectID      uint      `gorm:"not null;index"`
-	MainImageID    uint      `gorm:"index"`
-	Status         string    `gorm:"not null;default:'processing'"` // processing, completed, failed
-	SDPath         string    // Standard definition mosaic path
-	HDPath         string    // High definition mosaic path
-	TileSize       int       `gorm:"not null"`
-	TileDensity    int       `gorm:"not null"`
-	ColorAdjustment int       `gorm:"not null"`
-	Style          string    `gorm:"not null"`
-	Progress       int       `gorm:"not null;default:0"` // 0-100 percentage
-	ErrorMessage   string
-	CreatedAt      time.Time
-	UpdatedAt      time.Time
+	ID              uint   `gorm:"primaryKey"`
+	UserID          uint   `gorm:"not null;index"`
+	ProjectID       uint   `gorm:"not null;index"`
+	MainImageID     uint   `gorm:"index"`
+	Status          string `gorm:"not null;default:'processing'"` // processing, completed, failed
+	SDPath          string // Standard definition mosaic path
+	HDPath          string // High definition mosaic path
+	PlacementPath   string // which tile photo is in each cell, see services.PlacementMap
+	Width           int    // HD output width in pixels
+	Height          int    // HD output height in pixels
+	DPI             int    // HD output resolution
+	TileSize        int    `gorm:"not null"`
+	TileDensity     int    `gorm:"not null"`
+	ColorAdjustment int    `gorm:"not null"`
+	Style           string `gorm:"not null"`
+	Progress        int    `gorm:"not null;default:0"` // 0-100 percentage
+	ErrorMessage    string
+	Snapshot        datatypes.JSON // every input of the generation, see services.MosaicSnapshot
+	SourceMosaicID  *uint          `gorm:"index"` // set when this is a re-render of another generation
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+}
+
+// MosaicArtifact is a file exported from a generated mosaic in a format
+// other than the default JPEG
+type MosaicArtifact struct {
+	ID           uint           `gorm:"primaryKey"`
+	MosaicID     uint           `gorm:"not null;index"`
+	Format       string         `gorm:"not null"` // jpeg, png, webp or tiff
+	Layer        string         // tiles or guide for layered exports, empty for the whole mosaic
+	Options      datatypes.JSON // the export spec, see render.ExportSpec
+	Status       string         `gorm:"not null;default:'processing'"` // processing, completed, failed, cancelled
+	Path         string
+	Size         int64 // file size in bytes
+	ErrorMessage string
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+// APIKey represents a personal API key used for scripted access.
+// Only a hash of the key is stored; Prefix identifies the key for lookup.
+type APIKey struct {
+	ID         uint   `gorm:"primaryKey"`
+	UserID     uint   `gorm:"not null;index"`
+	Name       string `gorm:"not null"`
+	Prefix     string `gorm:"not null;uniqueIndex"`
+	KeyHash    string `gorm:"not null"`
+	Scopes     string `gorm:"not null"` // space separated, e.g. "images:write generate"
+	ExpiresAt  *time.Time
+	LastUsedAt *time.Time
+	RevokedAt  *time.Time
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+// AuthAuditLog records a single authentication attempt
+type AuthAuditLog struct {
+	ID        uint   `gorm:"primaryKey"`
+	UserID    *uint  `gorm:"index"`
+	Email     string `gorm:"index:idx_auth_audit_email_created"`
+	IP        string `gorm:"index:idx_auth_audit_ip_created"`
+	Event     string `gorm:"not null"` // login, register, oidc_login, password_change
+	Success   bool   `gorm:"not null"`
+	Reason    string // why the attempt failed or was blocked
+	UserAgent string
+	CreatedAt time.Time `gorm:"index:idx_auth_audit_email_created;index:idx_auth_audit_ip_created"`
+}
+
+// ProjectMember grants a user a role on another user's project. Invites are
+// created for an email address and bound to a user once accepted.
+type ProjectMember struct {
+	ID              uint   `gorm:"primaryKey"`
+	ProjectID       uint   `gorm:"not null;index"`
+	UserID          *uint  `gorm:"index"` // nil until the invite is accepted
+	Email           string `gorm:"not null;index"`
+	Role            string `gorm:"not null"` // viewer, editor, owner
+	InvitedByID     uint   `gorm:"not null"`
+	InviteTokenHash string `gorm:"index"`
+	AcceptedAt      *time.Time
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+}
+
+// MosaicShareLink is a revocable public link to a generated mosaic
+type MosaicShareLink struct {
+	ID           uint   `gorm:"primaryKey"`
+	MosaicID     uint   `gorm:"not null;index"`
+	CreatedByID  uint   `gorm:"not null;index"`
+	Token        string `gorm:"not null;uniqueIndex"`
+	PasswordHash string // empty when the link is not password protected
+	ExpiresAt    *time.Time
+	RevokedAt    *time.Time
+	ViewCount    int `gorm:"not null;default:0"`
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
 }
 
 // Update the existing Image model to add the collections relationship
@@ -33,8 +33,36 @@ func Init() {
 
 	log.Println("Successfully connected to PostgreSQL database")
 
+	// Settings used to be unique per user only, which prevented saving
+	// settings for more than one project. AutoMigrate recreates the plain index.
+	if DB.Migrator().HasIndex(&models.MosaicSettings{}, "idx_mosaic_settings_user_id") {
+		if err := DB.Migrator().DropIndex(&models.MosaicSettings{}, "idx_mosaic_settings_user_id"); err != nil {
+			log.Printf("failed to drop legacy settings index: %v", err)
+		}
+	}
+
+	// Saving settings without a project used to add a row every time. Keep
+	// the latest row of each user so the unique index can be created.
+	if DB.Migrator().HasTable(&models.MosaicSettings{}) && !DB.Migrator().HasIndex(&models.MosaicSettings{}, "idx_mosaic_settings_user_default") {
+		err := DB.Exec(`DELETE FROM mosaic_settings s USING mosaic_settings newer
+			WHERE s.project_id IS NULL AND newer.project_id IS NULL
+			AND s.user_id = newer.user_id AND s.id < newer.id`).Error
+		if err != nil {
+			log.Printf("failed to remove duplicate settings: %v", err)
+		}
+	}
+
 	// Auto migrate models
-	err = DB.AutoMigrate(
+	if err := Migrate(DB); err != nil {
+		log.Fatalf("failed to migrate database: %v", err)
+	}
+
+	log.Println("Successfully migrated database schema")
+}
+
+// Migrate creates or updates the tables of every model
+func Migrate(db *gorm.DB) error {
+	return db.AutoMigrate(
 		&models.User{},
 		&models.Project{},
 		&models.Image{},
@@ -42,10 +70,10 @@ func Init() {
 		&models.CollectionImage{},
 		&models.MosaicSettings{},
 		&models.GeneratedMosaic{},
+		&models.MosaicArtifact{},
+		&models.APIKey{},
+		&models.AuthAuditLog{},
+		&models.ProjectMember{},
+		&models.MosaicShareLink{},
 	)
-	if err != nil {
-		log.Fatalf("failed to migrate database: %v", err)
-	}
-
-	log.Println("Successfully migrated database schema")
 }
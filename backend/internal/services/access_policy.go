@@ -0,0 +1,139 @@
+package services
+
+import (
+	"errors"
+
+	"github.com/amityadav9314/goinkgrid/internal/db/models"
+	"gorm.io/gorm"
+)
+
+// Access policy errors. Resources the user has no role on at all are
+// reported as not found so their existence is not leaked.
+var (
+	ErrResourceNotFound = errors.New("resource not found")
+	ErrAccessDenied     = errors.New("access denied")
+)
+
+// AccessPolicyImpl implements the AccessPolicy interface
+type AccessPolicyImpl struct {
+	db            *gorm.DB
+	memberService ProjectMemberService
+}
+
+// NewAccessPolicy creates a new AccessPolicy implementation
+func NewAccessPolicy(db *gorm.DB, memberService ProjectMemberService) AccessPolicy {
+	return &AccessPolicyImpl{
+		db:            db,
+		memberService: memberService,
+	}
+}
+
+// AuthorizeProject checks that the user holds at least the required role on
+// a project and returns the project and the user's role
+func (p *AccessPolicyImpl) AuthorizeProject(userID uint, projectID uint, required string) (*models.Project, string, error) {
+	project, role, err := p.memberService.RoleFor(projectID, userID)
+	if err != nil {
+		if err.Error() == "project not found" {
+			return nil, "", ErrResourceNotFound
+		}
+		return nil, "", err
+	}
+
+	if role == "" {
+		return nil, "", ErrResourceNotFound
+	}
+	if !ProjectRoleAllows(role, required) {
+		return nil, role, ErrAccessDenied
+	}
+
+	return project, role, nil
+}
+
+// AuthorizeMosaic checks that the user holds at least the required role on
+// the project a generated mosaic belongs to
+func (p *AccessPolicyImpl) AuthorizeMosaic(userID uint, mosaicID uint, required string) (*models.GeneratedMosaic, error) {
+	var mosaic models.GeneratedMosaic
+	result := p.db.First(&mosaic, mosaicID)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, ErrResourceNotFound
+		}
+		return nil, result.Error
+	}
+
+	if _, _, err := p.AuthorizeProject(userID, mosaic.ProjectID, required); err != nil {
+		return nil, err
+	}
+
+	return &mosaic, nil
+}
+
+// AuthorizeImages checks that every image can be used in the project by the
+// user. An image is usable when the user uploaded it, or when it was
+// uploaded to the project and the user has edit access to the project.
+func (p *AccessPolicyImpl) AuthorizeImages(userID uint, projectID uint, imageIDs []uint) ([]models.Image, error) {
+	if len(imageIDs) == 0 {
+		return nil, nil
+	}
+
+	var images []models.Image
+	if err := p.db.Where("id IN ?", imageIDs).Find(&images).Error; err != nil {
+		return nil, err
+	}
+
+	// Every requested ID must exist, duplicates are allowed
+	found := make(map[uint]bool, len(images))
+	for _, img := range images {
+		found[img.ID] = true
+	}
+	for _, id := range imageIDs {
+		if !found[id] {
+			return nil, ErrResourceNotFound
+		}
+	}
+
+	// The project role is only looked up if some image is not the user's own
+	projectChecked := false
+	for _, img := range images {
+		if img.UserID == userID {
+			continue
+		}
+		if img.ProjectID == nil || *img.ProjectID != projectID {
+			return nil, ErrResourceNotFound
+		}
+		if !projectChecked {
+			if _, _, err := p.AuthorizeProject(userID, projectID, ProjectRoleEditor); err != nil {
+				if errors.Is(err, ErrAccessDenied) {
+					return nil, ErrResourceNotFound
+				}
+				return nil, err
+			}
+			projectChecked = true
+		}
+	}
+
+	return images, nil
+}
+
+// AuthorizeDuplicate checks that the user may copy a project. Members with
+// any role can copy a project and anyone can copy a template.
+func (p *AccessPolicyImpl) AuthorizeDuplicate(userID uint, projectID uint) (*models.Project, error) {
+	project, _, err := p.AuthorizeProject(userID, projectID, ProjectRoleViewer)
+	if err == nil {
+		return project, nil
+	}
+	if !errors.Is(err, ErrResourceNotFound) {
+		return nil, err
+	}
+
+	var template models.Project
+	result := p.db.Where("id = ? AND is_template = ?", projectID, true).First(&template)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, ErrResourceNotFound
+		}
+		return nil, result.Error
+	}
+
+	return &template, nil
+}
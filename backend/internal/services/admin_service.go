@@ -0,0 +1,172 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/amityadav9314/goinkgrid/internal/db/models"
+	"gorm.io/gorm"
+)
+
+// User roles
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+// UserStorageUsage is the disk usage of one user's upload directory
+type UserStorageUsage struct {
+	UserID     uint  `json:"user_id"`
+	Bytes      int64 `json:"bytes"`
+	FileCount  int   `json:"file_count"`
+	ImageCount int64 `json:"image_count"`
+}
+
+// AdminServiceImpl implements the AdminService interface
+type AdminServiceImpl struct {
+	db        *gorm.DB
+	uploadDir string
+}
+
+// NewAdminService creates a new AdminService implementation
+func NewAdminService(db *gorm.DB, uploadDir string) AdminService {
+	return &AdminServiceImpl{
+		db:        db,
+		uploadDir: uploadDir,
+	}
+}
+
+// ListUsers returns a page of users and the total user count
+func (s *AdminServiceImpl) ListUsers(offset, limit int) ([]models.User, int64, error) {
+	var total int64
+	if err := s.db.Model(&models.User{}).Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var users []models.User
+	result := s.db.Order("id").Offset(offset).Limit(limit).Find(&users)
+	if result.Error != nil {
+		return nil, 0, result.Error
+	}
+	return users, total, nil
+}
+
+// SetUserDisabled disables or re-enables a user account
+func (s *AdminServiceImpl) SetUserDisabled(userID uint, disabled bool) error {
+	var disabledAt *time.Time
+	if disabled {
+		now := time.Now()
+		disabledAt = &now
+	}
+
+	result := s.db.Model(&models.User{}).Where("id = ?", userID).Update("disabled_at", disabledAt)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("user not found")
+	}
+	return nil
+}
+
+// SetUserRole changes a user's role
+func (s *AdminServiceImpl) SetUserRole(userID uint, role string) error {
+	if role != RoleUser && role != RoleAdmin {
+		return fmt.Errorf("invalid role: %s", role)
+	}
+
+	result := s.db.Model(&models.User{}).Where("id = ?", userID).Update("role", role)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("user not found")
+	}
+	return nil
+}
+
+// PromoteAdmins gives the admin role to the users with the given emails,
+// compared without case, and returns the emails that have no account yet.
+// It bootstraps the first admins, since only an admin can change roles.
+func (s *AdminServiceImpl) PromoteAdmins(emails []string) ([]string, error) {
+	wanted := make([]string, 0, len(emails))
+	for _, email := range emails {
+		if email = strings.ToLower(strings.TrimSpace(email)); email != "" {
+			wanted = append(wanted, email)
+		}
+	}
+	if len(wanted) == 0 {
+		return nil, nil
+	}
+
+	if err := s.db.Model(&models.User{}).Where("LOWER(email) IN ?", wanted).Update("role", RoleAdmin).Error; err != nil {
+		return nil, err
+	}
+
+	var found []string
+	if err := s.db.Model(&models.User{}).Where("LOWER(email) IN ?", wanted).Pluck("LOWER(email)", &found).Error; err != nil {
+		return nil, err
+	}
+	missing := []string{}
+	for _, email := range wanted {
+		if !slices.Contains(found, email) {
+			missing = append(missing, email)
+		}
+	}
+	return missing, nil
+}
+
+// StorageUsage walks the upload directory and returns disk usage per user
+func (s *AdminServiceImpl) StorageUsage() ([]UserStorageUsage, error) {
+	entries, err := os.ReadDir(s.uploadDir)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return []UserStorageUsage{}, nil
+		}
+		return nil, err
+	}
+
+	usage := make([]UserStorageUsage, 0, len(entries))
+	for _, entry := range entries {
+		// User directories are named user_<id>
+		if !entry.IsDir() || !strings.HasPrefix(entry.Name(), "user_") {
+			continue
+		}
+		userID, err := strconv.ParseUint(strings.TrimPrefix(entry.Name(), "user_"), 10, 32)
+		if err != nil {
+			continue
+		}
+
+		u := UserStorageUsage{UserID: uint(userID)}
+		err = filepath.WalkDir(filepath.Join(s.uploadDir, entry.Name()), func(path string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			u.Bytes += info.Size()
+			u.FileCount++
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		if err := s.db.Model(&models.Image{}).Where("user_id = ?", u.UserID).Count(&u.ImageCount).Error; err != nil {
+			return nil, err
+		}
+
+		usage = append(usage, u)
+	}
+
+	return usage, nil
+}
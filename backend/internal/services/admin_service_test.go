@@ -0,0 +1,42 @@
+package services
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/amityadav9314/goinkgrid/internal/db/models"
+)
+
+func TestPromoteAdmins(t *testing.T) {
+	gdb := newTestDB(t)
+	for _, email := range []string{"Alice@Example.com", "bob@example.com", "carol@example.com"} {
+		if err := gdb.Create(&models.User{Email: email, PasswordHash: "x", Role: RoleUser}).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := NewAdminService(gdb, t.TempDir())
+
+	// Emails are matched without case or surrounding space, and the ones
+	// without an account are reported
+	missing, err := s.PromoteAdmins([]string{" alice@example.com", "BOB@example.com", "dave@example.com", ""})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(missing, []string{"dave@example.com"}) {
+		t.Fatalf("got missing %v, want [dave@example.com]", missing)
+	}
+
+	var admins []string
+	gdb.Model(&models.User{}).Where("role = ?", RoleAdmin).Order("id").Pluck("email", &admins)
+	if !slices.Equal(admins, []string{"Alice@Example.com", "bob@example.com"}) {
+		t.Fatalf("got admins %v, want alice and bob", admins)
+	}
+
+	// Running it again, as on every start, changes nothing
+	if missing, err := s.PromoteAdmins([]string{"alice@example.com"}); err != nil || len(missing) != 0 {
+		t.Fatalf("got %v, %v on a second run", missing, err)
+	}
+	if missing, err := s.PromoteAdmins(nil); err != nil || missing != nil {
+		t.Fatalf("got %v, %v without emails", missing, err)
+	}
+}
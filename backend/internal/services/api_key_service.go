@@ -0,0 +1,160 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/amityadav9314/goinkgrid/internal/db/models"
+	"github.com/amityadav9314/goinkgrid/utils"
+	"gorm.io/gorm"
+)
+
+// API key scopes
+const (
+	ScopeImagesRead    = "images:read"
+	ScopeImagesWrite   = "images:write"
+	ScopeProjectsRead  = "projects:read"
+	ScopeProjectsWrite = "projects:write"
+	ScopeGenerate      = "generate"
+)
+
+// APIKeyPrefix is prepended to every generated API key so keys can be told apart from JWTs
+const APIKeyPrefix = "igk_"
+
+// ValidScopes lists every scope that can be granted to an API key
+var ValidScopes = []string{
+	ScopeImagesRead,
+	ScopeImagesWrite,
+	ScopeProjectsRead,
+	ScopeProjectsWrite,
+	ScopeGenerate,
+}
+
+// APIKeyServiceImpl implements the APIKeyService interface
+type APIKeyServiceImpl struct {
+	db *gorm.DB
+}
+
+// NewAPIKeyService creates a new APIKeyService implementation
+func NewAPIKeyService(db *gorm.DB) APIKeyService {
+	return &APIKeyServiceImpl{
+		db: db,
+	}
+}
+
+// Create generates a new API key for a user. The plaintext key is only
+// returned here and cannot be recovered later.
+func (s *APIKeyServiceImpl) Create(userID uint, name string, scopes []string, expiresAt *time.Time) (string, *models.APIKey, error) {
+	for _, scope := range scopes {
+		if !utils.StringInSlice(scope, ValidScopes) {
+			return "", nil, fmt.Errorf("invalid scope: %s", scope)
+		}
+	}
+	if len(scopes) == 0 {
+		return "", nil, errors.New("at least one scope is required")
+	}
+
+	prefix, err := randomHex(4)
+	if err != nil {
+		return "", nil, err
+	}
+	secret, err := randomHex(24)
+	if err != nil {
+		return "", nil, err
+	}
+	rawKey := APIKeyPrefix + prefix + "_" + secret
+
+	apiKey := &models.APIKey{
+		UserID:    userID,
+		Name:      name,
+		Prefix:    prefix,
+		KeyHash:   hashToken(rawKey),
+		Scopes:    strings.Join(scopes, " "),
+		ExpiresAt: expiresAt,
+	}
+	if err := s.db.Create(apiKey).Error; err != nil {
+		return "", nil, err
+	}
+
+	return rawKey, apiKey, nil
+}
+
+// FindByUserID lists the API keys of a user
+func (s *APIKeyServiceImpl) FindByUserID(userID uint) ([]models.APIKey, error) {
+	var keys []models.APIKey
+	result := s.db.Where("user_id = ?", userID).Order("created_at DESC").Find(&keys)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return keys, nil
+}
+
+// Revoke revokes an API key owned by the user
+func (s *APIKeyServiceImpl) Revoke(id uint, userID uint) error {
+	result := s.db.Model(&models.APIKey{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", id, userID).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("api key not found or you don't have permission to revoke it")
+	}
+	return nil
+}
+
+// Authenticate validates a plaintext API key and records its use
+func (s *APIKeyServiceImpl) Authenticate(rawKey string) (*models.APIKey, error) {
+	// Keys look like igk_<prefix>_<secret>
+	parts := strings.SplitN(strings.TrimPrefix(rawKey, APIKeyPrefix), "_", 2)
+	if !strings.HasPrefix(rawKey, APIKeyPrefix) || len(parts) != 2 {
+		return nil, errors.New("malformed api key")
+	}
+
+	var apiKey models.APIKey
+	result := s.db.Where("prefix = ?", parts[0]).First(&apiKey)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, errors.New("invalid api key")
+		}
+		return nil, result.Error
+	}
+
+	if subtle.ConstantTimeCompare([]byte(apiKey.KeyHash), []byte(hashToken(rawKey))) != 1 {
+		return nil, errors.New("invalid api key")
+	}
+	if apiKey.RevokedAt != nil {
+		return nil, errors.New("api key has been revoked")
+	}
+	now := time.Now()
+	if apiKey.ExpiresAt != nil && now.After(*apiKey.ExpiresAt) {
+		return nil, errors.New("api key has expired")
+	}
+
+	// Track last use without touching updated_at
+	s.db.Model(&apiKey).UpdateColumn("last_used_at", now)
+	apiKey.LastUsedAt = &now
+
+	return &apiKey, nil
+}
+
+// hashToken returns the hex encoded SHA-256 hash of a secret token
+func hashToken(rawKey string) string {
+	sum := sha256.Sum256([]byte(rawKey))
+	return hex.EncodeToString(sum[:])
+}
+
+// randomHex returns n random bytes encoded as hex
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,26 @@
+package services
+
+import (
+	"path/filepath"
+	"testing"
+
+	db "github.com/amityadav9314/goinkgrid/internal/db/postgres"
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// newTestDB opens an empty SQLite database with every table migrated
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	gdb, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	if err := db.Migrate(gdb); err != nil {
+		t.Fatalf("migrate database: %v", err)
+	}
+	return gdb
+}
@@ -41,6 +41,16 @@ func (s *ImageServiceImpl) FindByUserID(userID uint) ([]models.Image, error) {
 	return images, nil
 }
 
+// FindByPath finds the user's image stored at a path
+func (s *ImageServiceImpl) FindByPath(userID uint, storedPath string) (*models.Image, error) {
+	var image models.Image
+	result := s.db.Where("user_id = ? AND path = ?", userID, storedPath).First(&image)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &image, nil
+}
+
 // FindByProjectID finds images by project ID
 func (s *ImageServiceImpl) FindByProjectID(projectID uint) ([]models.Image, error) {
 	var images []models.Image
@@ -63,7 +73,7 @@ func (s *ImageServiceImpl) Update(image *models.Image) error {
 	return result.Error
 }
 
-// Delete deletes an image
+// Delete moves an image to the trash
 func (s *ImageServiceImpl) Delete(id uint, userID uint) error {
 	result := s.db.Where("id = ? AND user_id = ?", id, userID).Delete(&models.Image{})
 	if result.Error != nil {
@@ -1,19 +1,31 @@
 package services
 
 import (
+	"context"
+	"image"
+	"io"
+	"time"
+
 	"github.com/amityadav9314/goinkgrid/internal/db/models"
+	"github.com/amityadav9314/goinkgrid/pkg/render"
 )
 
 // UserService defines user-related operations
 type UserService interface {
 	FindByEmail(email string) (*models.User, error)
+	FindByID(id uint) (*models.User, error)
+	FindByOIDCIdentity(issuer, subject string) (*models.User, error)
 	Create(user *models.User) error
+	Update(user *models.User) error
 }
 
 // ProjectService defines project-related operations
 type ProjectService interface {
 	FindByID(id uint) (*models.Project, error)
 	FindByUserID(userID uint) ([]models.Project, error)
+	FindByIDs(ids []uint) ([]models.Project, error)
+	FindTemplates() ([]models.Project, error)
+	Duplicate(sourceID uint, userID uint, name string, includeImages bool) (*models.Project, error)
 	Create(project *models.Project) error
 	Update(project *models.Project) error
 	Delete(id uint, userID uint) error
@@ -23,6 +35,7 @@ type ProjectService interface {
 type ImageService interface {
 	FindByID(id uint) (*models.Image, error)
 	FindByUserID(userID uint) ([]models.Image, error)
+	FindByPath(userID uint, storedPath string) (*models.Image, error)
 	FindByProjectID(projectID uint) ([]models.Image, error)
 	Create(image *models.Image) error
 	Update(image *models.Image) error
@@ -34,7 +47,91 @@ type ImageService interface {
 type MosaicService interface {
 	SaveSettings(userID uint, settings *models.MosaicSettings) error
 	GetSettings(userID uint, projectID *uint) (*models.MosaicSettings, error)
-	GenerateMosaic(userID uint, projectID uint, mainImageID uint, tileImageIDs []uint, settings *models.MosaicSettings) (*models.GeneratedMosaic, error)
-	GetMosaicStatus(userID uint, mosaicID uint) (*models.GeneratedMosaic, error)
-	GetProjectMosaics(userID uint, projectID uint) ([]models.GeneratedMosaic, error)
+	GenerateMosaic(userID uint, projectID uint, snapshot *MosaicSnapshot, sourceMosaicID *uint, exports []render.ExportSpec) (*models.GeneratedMosaic, error)
+	RenderPreview(ctx context.Context, snapshot *MosaicSnapshot, images []models.Image) (*image.RGBA, error)
+	GetMosaicStatus(mosaicID uint) (*models.GeneratedMosaic, error)
+	GetProjectMosaics(projectID uint) ([]models.GeneratedMosaic, error)
+	GetJobStats() (*JobStats, error)
+	CancelMosaic(mosaicID uint) error
+	ExportMosaic(mosaic *models.GeneratedMosaic, specs []render.ExportSpec) ([]models.MosaicArtifact, error)
+	GetArtifacts(mosaicIDs []uint) ([]models.MosaicArtifact, error)
+	DeepZoomFile(mosaicID uint, tile string) (string, error)
+	GetPlacementMap(mosaic *models.GeneratedMosaic) (*PlacementMap, error)
+	RenderPlacementHighlight(mosaic *models.GeneratedMosaic, imageID uint) (*image.RGBA, error)
+}
+
+// APIKeyService defines personal API key operations
+type APIKeyService interface {
+	Create(userID uint, name string, scopes []string, expiresAt *time.Time) (string, *models.APIKey, error)
+	FindByUserID(userID uint) ([]models.APIKey, error)
+	Revoke(id uint, userID uint) error
+	Authenticate(rawKey string) (*models.APIKey, error)
+}
+
+// LoginGuardService defines brute-force protection and auth auditing
+type LoginGuardService interface {
+	Check(event, email, ip string) (time.Duration, error)
+	Record(entry *models.AuthAuditLog) error
+}
+
+// AdminService defines administrative operations
+type AdminService interface {
+	ListUsers(offset, limit int) ([]models.User, int64, error)
+	SetUserDisabled(userID uint, disabled bool) error
+	SetUserRole(userID uint, role string) error
+	PromoteAdmins(emails []string) ([]string, error)
+	StorageUsage() ([]UserStorageUsage, error)
+}
+
+// ProjectMemberService defines project sharing and access operations
+type ProjectMemberService interface {
+	RoleFor(projectID uint, userID uint) (*models.Project, string, error)
+	FindByProjectID(projectID uint) ([]models.ProjectMember, error)
+	FindMembershipsForUser(userID uint) ([]models.ProjectMember, error)
+	FindByID(projectID uint, memberID uint) (*models.ProjectMember, error)
+	Invite(projectID uint, invitedByID uint, email string, role string) (string, *models.ProjectMember, error)
+	UpdateRole(projectID uint, memberID uint, role string) error
+	Remove(projectID uint, memberID uint) error
+	FindPendingByEmail(email string) ([]models.ProjectMember, error)
+	Accept(token string, user *models.User) (*models.ProjectMember, error)
+}
+
+// AccessPolicy defines the authorization checks shared by all handlers
+type AccessPolicy interface {
+	AuthorizeProject(userID uint, projectID uint, required string) (*models.Project, string, error)
+	AuthorizeMosaic(userID uint, mosaicID uint, required string) (*models.GeneratedMosaic, error)
+	AuthorizeImages(userID uint, projectID uint, imageIDs []uint) ([]models.Image, error)
+	AuthorizeDuplicate(userID uint, projectID uint) (*models.Project, error)
+}
+
+// ShareLinkService defines public share link operations for mosaics
+type ShareLinkService interface {
+	Create(mosaicID uint, createdByID uint, passwordHash string, expiresAt *time.Time) (*models.MosaicShareLink, error)
+	FindByMosaicID(mosaicID uint) ([]models.MosaicShareLink, error)
+	Revoke(mosaicID uint, linkID uint) error
+	Resolve(token string) (*models.MosaicShareLink, *models.GeneratedMosaic, error)
+	RecordView(linkID uint) error
+}
+
+// ProjectArchiveService defines project export and import operations
+type ProjectArchiveService interface {
+	Export(projectID uint, w io.Writer) error
+	Import(userID uint, r io.ReaderAt, size int64) (*models.Project, error)
+}
+
+// TrashService defines trash listing, restore and purge operations
+type TrashService interface {
+	Retention() time.Duration
+	List(userID uint) (*Trash, error)
+	RestoreProject(userID uint, projectID uint) error
+	RestoreImage(userID uint, imageID uint) error
+	PurgeProject(userID uint, projectID uint) error
+	PurgeImage(userID uint, imageID uint) error
+	PurgeExpired() (int, error)
+	RunPurger(ctx context.Context)
+}
+
+// Mailer delivers emails to users
+type Mailer interface {
+	SendInvite(invite InviteEmail) error
 }
@@ -0,0 +1,145 @@
+package services
+
+import (
+	"time"
+
+	"github.com/amityadav9314/goinkgrid/internal/db/models"
+	"gorm.io/gorm"
+)
+
+// Auth audit events
+const (
+	AuthEventLogin          = "login"
+	AuthEventRegister       = "register"
+	AuthEventOIDCLogin      = "oidc_login"
+	AuthEventPasswordChange = "password_change"
+)
+
+// AuthReasonThrottled marks attempts rejected by the login guard. They are
+// audited but not counted as failures so a locked account cannot be kept
+// locked forever by repeated attempts.
+const AuthReasonThrottled = "throttled"
+
+// LoginGuardConfig holds the brute-force protection thresholds
+type LoginGuardConfig struct {
+	// Failures older than Window are forgotten
+	Window time.Duration
+	// Consecutive failures for one account before it is locked
+	MaxAccountFailures int
+	// Failures from one IP address before it is locked
+	MaxIPFailures int
+	// Delay after the first failure, doubled for every further failure
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+	// How long an account or IP stays locked after reaching its limit
+	LockoutDuration time.Duration
+}
+
+// LoginGuardServiceImpl implements the LoginGuardService interface on top of
+// the auth audit log
+type LoginGuardServiceImpl struct {
+	db  *gorm.DB
+	cfg LoginGuardConfig
+}
+
+// NewLoginGuardService creates a new LoginGuardService implementation.
+// Zero values in cfg are replaced with defaults.
+func NewLoginGuardService(db *gorm.DB, cfg LoginGuardConfig) LoginGuardService {
+	if cfg.Window <= 0 {
+		cfg.Window = 15 * time.Minute
+	}
+	if cfg.MaxAccountFailures <= 0 {
+		cfg.MaxAccountFailures = 5
+	}
+	if cfg.MaxIPFailures <= 0 {
+		cfg.MaxIPFailures = 20
+	}
+	if cfg.BaseBackoff <= 0 {
+		cfg.BaseBackoff = time.Second
+	}
+	if cfg.MaxBackoff <= 0 {
+		cfg.MaxBackoff = time.Minute
+	}
+	if cfg.LockoutDuration <= 0 {
+		cfg.LockoutDuration = 15 * time.Minute
+	}
+	return &LoginGuardServiceImpl{
+		db:  db,
+		cfg: cfg,
+	}
+}
+
+// Check returns how long the caller must wait before another attempt for
+// this email from this IP is allowed. Zero means the attempt may proceed.
+func (s *LoginGuardServiceImpl) Check(event, email, ip string) (time.Duration, error) {
+	now := time.Now()
+	since := now.Add(-s.cfg.Window)
+
+	// Account failures only count since the last successful attempt
+	var lastSuccess models.AuthAuditLog
+	err := s.db.Select("created_at").
+		Where("email = ? AND event = ? AND success = ?", email, event, true).
+		Order("created_at DESC").Limit(1).Find(&lastSuccess).Error
+	if err != nil {
+		return 0, err
+	}
+	accountSince := since
+	if lastSuccess.CreatedAt.After(accountSince) {
+		accountSince = lastSuccess.CreatedAt
+	}
+
+	accountWait, err := s.wait(now, "email = ?", email, event, accountSince, s.cfg.MaxAccountFailures)
+	if err != nil {
+		return 0, err
+	}
+	ipWait, err := s.wait(now, "ip = ?", ip, event, since, s.cfg.MaxIPFailures)
+	if err != nil {
+		return 0, err
+	}
+
+	if ipWait > accountWait {
+		return ipWait, nil
+	}
+	return accountWait, nil
+}
+
+// Record writes an attempt to the auth audit log
+func (s *LoginGuardServiceImpl) Record(entry *models.AuthAuditLog) error {
+	return s.db.Create(entry).Error
+}
+
+// wait computes the remaining backoff or lockout for one key
+func (s *LoginGuardServiceImpl) wait(now time.Time, keyQuery string, key string, event string, since time.Time, maxFailures int) (time.Duration, error) {
+	failed := func() *gorm.DB {
+		return s.db.Model(&models.AuthAuditLog{}).
+			Where(keyQuery, key).
+			Where("event = ? AND success = ? AND reason <> ? AND created_at > ?", event, false, AuthReasonThrottled, since)
+	}
+	var failures int64
+	if err := failed().Count(&failures).Error; err != nil {
+		return 0, err
+	}
+	if failures == 0 {
+		return 0, nil
+	}
+	var lastFailure models.AuthAuditLog
+	if err := failed().Select("created_at").Order("created_at DESC").Limit(1).Find(&lastFailure).Error; err != nil {
+		return 0, err
+	}
+
+	var delay time.Duration
+	if failures >= int64(maxFailures) {
+		delay = s.cfg.LockoutDuration
+	} else {
+		delay = s.cfg.BaseBackoff << (failures - 1)
+		if delay > s.cfg.MaxBackoff || delay <= 0 {
+			delay = s.cfg.MaxBackoff
+		}
+	}
+
+	remaining := lastFailure.CreatedAt.Add(delay).Sub(now)
+	if remaining < 0 {
+		return 0, nil
+	}
+	return remaining, nil
+}
@@ -0,0 +1,111 @@
+package services
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// InviteEmail invites a collaborator to a project. The token is accepted
+// through POST /goinkgrid/api/invites/:token/accept.
+type InviteEmail struct {
+	To          string
+	ProjectName string
+	Role        string
+	Token       string
+}
+
+// SMTPConfig configures invite delivery through an SMTP server
+type SMTPConfig struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	// From is the sender address
+	From string
+	// InviteURL is the page that accepts invites; the token is appended to
+	// it. Without it the email carries the bare token.
+	InviteURL string
+}
+
+// SMTPMailer delivers emails through an SMTP server
+type SMTPMailer struct {
+	config SMTPConfig
+	send   func(addr string, auth smtp.Auth, from string, to []string, msg []byte) error
+}
+
+// NewMailer creates the Mailer for config, logging invites instead of
+// sending them when no SMTP host is set
+func NewMailer(config SMTPConfig) Mailer {
+	if config.Host == "" {
+		return NewLogMailer()
+	}
+	if config.Port == 0 {
+		config.Port = 587
+	}
+	return &SMTPMailer{config: config, send: smtp.SendMail}
+}
+
+// SendInvite emails the invite, with a link to accept it where one is configured
+func (m *SMTPMailer) SendInvite(invite InviteEmail) error {
+	to, err := mail.ParseAddress(invite.To)
+	if err != nil {
+		return fmt.Errorf("invalid recipient: %w", err)
+	}
+	from, err := mail.ParseAddress(m.config.From)
+	if err != nil {
+		return fmt.Errorf("invalid sender: %w", err)
+	}
+
+	var body bytes.Buffer
+	fmt.Fprintf(&body, "You have been invited to join the project %q as %s.\r\n\r\n", invite.ProjectName, invite.Role)
+	if m.config.InviteURL != "" {
+		fmt.Fprintf(&body, "Accept the invite at %s%s\r\n", m.config.InviteURL, url.PathEscape(invite.Token))
+	} else {
+		fmt.Fprintf(&body, "Accept the invite with this token: %s\r\n", invite.Token)
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", from)
+	fmt.Fprintf(&msg, "To: %s\r\n", to)
+	// The project name is chosen by users, so it is encoded rather than
+	// trusted not to break the header
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", "Invitation to "+invite.ProjectName))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.Write(body.Bytes())
+
+	var auth smtp.Auth
+	if m.config.Username != "" {
+		auth = smtp.PlainAuth("", m.config.Username, m.config.Password, m.config.Host)
+	}
+	addr := net.JoinHostPort(m.config.Host, strconv.Itoa(m.config.Port))
+	if err := m.send(addr, auth, from.Address, []string{to.Address}, msg.Bytes()); err != nil {
+		return fmt.Errorf("sending invite to %s: %w", to.Address, err)
+	}
+	return nil
+}
+
+// LogMailer is the Mailer used when no email delivery is configured. It
+// only logs that an email was not sent, leaving out the invite token; the
+// API returns the token to the owner to share instead.
+type LogMailer struct{}
+
+// NewLogMailer creates a Mailer that logs instead of sending
+func NewLogMailer() Mailer {
+	return LogMailer{}
+}
+
+// SendInvite logs the invite without delivering it
+func (LogMailer) SendInvite(invite InviteEmail) error {
+	log.Printf("mailer: email delivery is not configured, invite to project %q for %s was not sent", invite.ProjectName, invite.To)
+	return nil
+}
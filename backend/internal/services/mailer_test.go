@@ -0,0 +1,46 @@
+package services
+
+import (
+	"net/smtp"
+	"strings"
+	"testing"
+)
+
+func TestSMTPMailerSendsInvite(t *testing.T) {
+	var gotAddr, gotFrom string
+	var gotTo []string
+	var gotMsg string
+	mailer := NewMailer(SMTPConfig{
+		Host: "smtp.example.com", From: "GoInkGrid <noreply@example.com>",
+		InviteURL: "https://goinkgrid.example.com/invites/",
+	}).(*SMTPMailer)
+	mailer.send = func(addr string, auth smtp.Auth, from string, to []string, msg []byte) error {
+		gotAddr, gotFrom, gotTo, gotMsg = addr, from, to, string(msg)
+		return nil
+	}
+
+	err := mailer.SendInvite(InviteEmail{To: "grace@example.com", ProjectName: "Garden\r\nBcc: eve@example.com", Role: "editor", Token: "abc123"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotAddr != "smtp.example.com:587" || gotFrom != "noreply@example.com" || len(gotTo) != 1 || gotTo[0] != "grace@example.com" {
+		t.Fatalf("sent to %s from %s to %v", gotAddr, gotFrom, gotTo)
+	}
+	header, body, _ := strings.Cut(gotMsg, "\r\n\r\n")
+	if strings.Contains(header, "\r\nBcc:") {
+		t.Fatalf("project name broke the headers:\n%s", header)
+	}
+	if !strings.Contains(body, "https://goinkgrid.example.com/invites/abc123") {
+		t.Fatalf("body has no accept link:\n%s", body)
+	}
+
+	if err := mailer.SendInvite(InviteEmail{To: "not an address", Token: "abc123"}); err == nil {
+		t.Fatal("sent to an invalid address")
+	}
+}
+
+func TestNewMailerLogsWithoutAHost(t *testing.T) {
+	if _, ok := NewMailer(SMTPConfig{}).(LogMailer); !ok {
+		t.Fatal("no SMTP host should fall back to logging")
+	}
+}
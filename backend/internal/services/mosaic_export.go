@@ -0,0 +1,341 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	models "github.com/amityadav9314/goinkgrid/internal/db/models"
+	db "github.com/amityadav9314/goinkgrid/internal/db/postgres"
+	"github.com/amityadav9314/goinkgrid/pkg/render"
+	"gorm.io/gorm"
+)
+
+// createArtifacts records the exports requested for a mosaic. A layered
+// export is recorded as one artifact per layer.
+func createArtifacts(tx *gorm.DB, mosaicID uint, specs []render.ExportSpec) ([]models.MosaicArtifact, error) {
+	artifacts := []models.MosaicArtifact{}
+	for _, spec := range specs {
+		options, err := json.Marshal(spec)
+		if err != nil {
+			return nil, err
+		}
+		layers := []string{""}
+		if spec.Format == render.FormatLayers {
+			layers = []string{render.LayerTiles, render.LayerGuide}
+		}
+		for _, layer := range layers {
+			artifacts = append(artifacts, models.MosaicArtifact{
+				MosaicID: mosaicID,
+				Format:   spec.Format,
+				Layer:    layer,
+				Options:  options,
+				Status:   "processing",
+			})
+		}
+	}
+	if len(artifacts) == 0 {
+		return artifacts, nil
+	}
+	if err := tx.Create(&artifacts).Error; err != nil {
+		return nil, err
+	}
+	return artifacts, nil
+}
+
+// renderArtifacts renders the processing artifacts of a mosaic from its
+// scene into dir. An artifact that fails is marked failed without failing
+// the others; only cancellation is returned as an error.
+func (s *MosaicServiceImpl) renderArtifacts(ctx context.Context, mosaicID uint, scene *render.Scene, sample image.Image, dir string) error {
+	var artifacts []models.MosaicArtifact
+	if err := db.DB.Where("mosaic_id = ? AND status = ?", mosaicID, "processing").Order("id").Find(&artifacts).Error; err != nil {
+		return err
+	}
+
+	for i := range artifacts {
+		artifact := &artifacts[i]
+		err := s.renderArtifact(ctx, artifact, scene, sample, dir)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			artifact.Status = "failed"
+			artifact.ErrorMessage = fmt.Sprintf("Failed to export mosaic: %v", err)
+		} else {
+			artifact.Status = "completed"
+		}
+		db.DB.Save(artifact)
+	}
+	return nil
+}
+
+// renderArtifact streams one artifact to a file in dir and records its
+// path and size on the artifact
+func (s *MosaicServiceImpl) renderArtifact(ctx context.Context, artifact *models.MosaicArtifact, scene *render.Scene, sample image.Image, dir string) error {
+	var spec render.ExportSpec
+	if err := json.Unmarshal(artifact.Options, &spec); err != nil {
+		return err
+	}
+
+	// The tiles layer is the mosaic without the guide blended in, and the
+	// guide layer is the blend on its own, transparent where it is absent.
+	// Formats without transparency draw a transparent background white.
+	var layer render.Layer = scene
+	if !spec.Transparent() {
+		layer = scene.Flat()
+	}
+	name := fmt.Sprintf("mosaic_export_%d", artifact.ID)
+	switch artifact.Layer {
+	case render.LayerTiles:
+		tiles := *scene
+		tiles.Overlay = 0
+		layer = &tiles
+		name += "_" + artifact.Layer
+	case render.LayerGuide:
+		guide := &render.GuideLayer{Guide: scene.Guide, Opacity: scene.Overlay}
+		if scene.Background != nil {
+			guide.Plan = scene.Plan
+		}
+		layer = guide
+		name += "_" + artifact.Layer
+	}
+	path := filepath.Join(dir, name+spec.Extension())
+	if spec.Format == render.FormatDZI {
+		return s.renderDeepZoom(ctx, artifact, layer, spec, path)
+	}
+	if spec.Animated() {
+		return s.renderAnimation(ctx, artifact, scene, spec, path)
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	err = render.Stream(ctx, layer, func(img image.Image) error {
+		return spec.Encode(file, img, scene.Guide.Size().DPI, sample)
+	})
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	artifact.Path = storedUploadPath(s.uploadDir, path)
+	artifact.Size = info.Size()
+	return nil
+}
+
+// renderDeepZoom writes a Deep Zoom pyramid of the layer: the descriptor
+// at path and the tiles in the directory next to it
+func (s *MosaicServiceImpl) renderDeepZoom(ctx context.Context, artifact *models.MosaicArtifact, layer render.Layer, spec render.ExportSpec, path string) error {
+	tilesDir := deepZoomTilesDir(path)
+	var size int64
+	var buf bytes.Buffer
+	err := render.WriteDeepZoom(ctx, layer, func(level, col, row int, tile *image.RGBA) error {
+		levelDir := filepath.Join(tilesDir, strconv.Itoa(level))
+		if col == 0 && row == 0 {
+			if err := os.MkdirAll(levelDir, 0755); err != nil {
+				return err
+			}
+		}
+		buf.Reset()
+		if err := render.EncodeJPEG(&buf, tile, spec.JPEGQuality(), render.DefaultDPI); err != nil {
+			return err
+		}
+		size += int64(buf.Len())
+		return os.WriteFile(filepath.Join(levelDir, fmt.Sprintf("%d_%d.jpg", col, row)), buf.Bytes(), 0644)
+	})
+	if err == nil {
+		b := layer.Bounds()
+		descriptor := render.DeepZoomDescriptor(b.Dx(), b.Dy(), "jpg")
+		size += int64(len(descriptor))
+		err = os.WriteFile(path, descriptor, 0644)
+	}
+	if err != nil {
+		os.RemoveAll(tilesDir)
+		os.Remove(path)
+		return err
+	}
+
+	artifact.Path = storedUploadPath(s.uploadDir, path)
+	artifact.Size = size
+	return nil
+}
+
+// renderAnimation draws the frames of an animated export of the scene and
+// writes them to path
+func (s *MosaicServiceImpl) renderAnimation(ctx context.Context, artifact *models.MosaicArtifact, scene *render.Scene, spec render.ExportSpec, path string) error {
+	var animationSpec render.AnimationSpec
+	if spec.Animation != nil {
+		animationSpec = *spec.Animation
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(file)
+	err = render.WriteAnimation(ctx, writer, spec.Format, render.NewAnimation(scene, animationSpec))
+	if err == nil {
+		err = writer.Flush()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	artifact.Path = storedUploadPath(s.uploadDir, path)
+	artifact.Size = info.Size()
+	return nil
+}
+
+// deepZoomTilesDir returns the tile directory of a Deep Zoom descriptor,
+// named the way viewers expect
+func deepZoomTilesDir(descriptorPath string) string {
+	return strings.TrimSuffix(descriptorPath, ".dzi") + "_files"
+}
+
+// deepZoomTileName matches the path of a tile below the tile directory
+var deepZoomTileName = regexp.MustCompile(`^\d{1,2}/\d{1,6}_\d{1,6}\.jpg$`)
+
+// DeepZoomFile returns the location of a file of the latest Deep Zoom
+// export of a mosaic: the descriptor if tile is empty, otherwise the tile
+// at a path like 12/3_4.jpg below the tile directory
+func (s *MosaicServiceImpl) DeepZoomFile(mosaicID uint, tile string) (string, error) {
+	if tile != "" && !deepZoomTileName.MatchString(tile) {
+		return "", ErrResourceNotFound
+	}
+
+	var artifact models.MosaicArtifact
+	err := db.DB.Where("mosaic_id = ? AND format = ? AND status = ?", mosaicID, render.FormatDZI, "completed").
+		Order("id DESC").First(&artifact).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", ErrResourceNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+
+	path := resolveUploadPath(s.uploadDir, artifact.Path)
+	if tile != "" {
+		path = filepath.Join(deepZoomTilesDir(path), filepath.FromSlash(tile))
+	}
+	if _, err := os.Stat(path); err != nil {
+		return "", ErrResourceNotFound
+	}
+	return path, nil
+}
+
+// finishArtifacts marks the artifacts of a mosaic that were never rendered
+// with the mosaic's final status
+func finishArtifacts(mosaicID uint, status, message string) {
+	db.DB.Model(&models.MosaicArtifact{}).
+		Where("mosaic_id = ? AND status = ?", mosaicID, "processing").
+		Updates(map[string]interface{}{"status": status, "error_message": message})
+}
+
+// ExportMosaic exports a completed mosaic in more formats. The mosaic is
+// laid out again from its snapshot, which places every tile exactly where
+// the original render did, and the exports are rendered in the background.
+func (s *MosaicServiceImpl) ExportMosaic(mosaic *models.GeneratedMosaic, specs []render.ExportSpec) ([]models.MosaicArtifact, error) {
+	if mosaic.Status != "completed" {
+		return nil, errors.New("only completed mosaics can be exported")
+	}
+	snapshot, err := DecodeMosaicSnapshot(mosaic)
+	if err != nil {
+		return nil, err
+	}
+	if !snapshot.Reproducible() || snapshot.EngineVersion != MosaicEngineVersion {
+		return nil, errors.New("this mosaic was rendered by an older engine; re-render it to export it")
+	}
+
+	var artifacts []models.MosaicArtifact
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		artifacts, err = createArtifacts(tx, mosaic.ID, specs)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	go s.exportMosaicAsync(mosaic, snapshot)
+
+	return artifacts, nil
+}
+
+// exportMosaicAsync renders the pending artifacts of a completed mosaic
+func (s *MosaicServiceImpl) exportMosaicAsync(mosaic *models.GeneratedMosaic, snapshot *MosaicSnapshot) {
+	ctx := context.Background()
+	fail := func(message string) {
+		finishArtifacts(mosaic.ID, "failed", message)
+	}
+
+	var mainImage models.Image
+	if err := db.DB.First(&mainImage, snapshot.MainImageID).Error; err != nil {
+		fail("Failed to find main image")
+		return
+	}
+	var tileImages []models.Image
+	if err := db.DB.Where("id IN ?", snapshot.TileImageIDs).Order("id").Find(&tileImages).Error; err != nil {
+		fail("Failed to find tile images")
+		return
+	}
+
+	mainImg, err := openMainImage(mainImage.Path)
+	if err != nil {
+		fail(fmt.Sprintf("Failed to export mosaic: %v", err))
+		return
+	}
+	scene, _, err := s.buildScene(ctx, mainImg, tileImages, snapshot, func(int) {})
+	if err != nil {
+		fail(fmt.Sprintf("Failed to export mosaic: %v", err))
+		return
+	}
+
+	// The SD preview is a small copy of the same render
+	sample, err := openImage(resolveUploadPath(s.uploadDir, mosaic.SDPath))
+	if err != nil {
+		fail("Failed to open the SD mosaic")
+		return
+	}
+
+	dir := filepath.Dir(resolveUploadPath(s.uploadDir, mosaic.HDPath))
+	if err := s.renderArtifacts(ctx, mosaic.ID, scene, sample, dir); err != nil {
+		fail(fmt.Sprintf("Failed to export mosaic: %v", err))
+	}
+}
+
+// GetArtifacts returns the artifacts of the given mosaics in the order they
+// were requested
+func (s *MosaicServiceImpl) GetArtifacts(mosaicIDs []uint) ([]models.MosaicArtifact, error) {
+	artifacts := []models.MosaicArtifact{}
+	if len(mosaicIDs) == 0 {
+		return artifacts, nil
+	}
+	if err := db.DB.Where("mosaic_id IN ?", mosaicIDs).Order("id").Find(&artifacts).Error; err != nil {
+		return nil, err
+	}
+	return artifacts, nil
+}
@@ -0,0 +1,45 @@
+package services
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/amityadav9314/goinkgrid/internal/db/models"
+	db "github.com/amityadav9314/goinkgrid/internal/db/postgres"
+	"github.com/amityadav9314/goinkgrid/pkg/render"
+)
+
+func TestGenerateRejectsTilesOverTheMemoryLimit(t *testing.T) {
+	previous := db.DB
+	db.DB = newTestDB(t)
+	t.Cleanup(func() { db.DB = previous })
+
+	main := models.Image{UserID: 1, Type: "main", Width: 1200, Height: 900}
+	if err := db.DB.Create(&main).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// Doubling the output doubles the 50px cells, so three tiles hold
+	// 3 x 100 x 100 x 4 bytes
+	snapshot := &MosaicSnapshot{
+		MainImageID:  main.ID,
+		TileImageIDs: []uint{2, 3, 4},
+		TileSize:     50,
+		Output:       &render.OutputSpec{Width: 2400},
+	}
+	s := NewMosaicService(t.TempDir(), RenderConfig{TileCacheBytes: 100_000}, PreviewConfig{}).(*MosaicServiceImpl)
+	if _, err := s.GenerateMosaic(1, 1, snapshot, nil, nil); !errors.Is(err, ErrTileMemory) {
+		t.Fatalf("got error %v, want ErrTileMemory", err)
+	}
+
+	var count int64
+	db.DB.Model(&models.GeneratedMosaic{}).Count(&count)
+	if count != 0 {
+		t.Fatal("a generation was started")
+	}
+
+	grid := render.NewLayoutGrid(render.Size{Width: 2400, Height: 1800}, 1200, 900, 50, render.ShapeSpec{})
+	if err := s.checkTileMemory(2, grid); err != nil {
+		t.Fatalf("two tiles fit in the limit: %v", err)
+	}
+}
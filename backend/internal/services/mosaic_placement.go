@@ -0,0 +1,326 @@
+package services
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	models "github.com/amityadav9314/goinkgrid/internal/db/models"
+	"github.com/amityadav9314/goinkgrid/pkg/render"
+)
+
+// PlacementMap records which tile photo was placed in every cell of a
+// generation. It is saved next to the mosaic as compact JSON and expanded
+// into Placements on request.
+type PlacementMap struct {
+	Width        int       `json:"width"` // HD output size in pixels
+	Height       int       `json:"height"`
+	CellSize     int       `json:"cell_size"`
+	Cols         int       `json:"cols"`
+	Rows         int       `json:"rows"`
+	Shape        string    `json:"shape,omitempty"` // see render.ShapeSpec, square if empty
+	Grout        float64   `json:"grout,omitempty"` // grout width in HD pixels
+	Overlay      float64   `json:"overlay"`
+	TileImageIDs []uint    `json:"tile_image_ids"` // indexed by the numbers in Cells
+	TileSizes    [][2]int  `json:"tile_sizes"`     // original size of each tile photo, zero if unknown
+	Cells        []int     `json:"cells"`          // tile of each cell, row by row, or render.NoTile outside a mask
+	Errors       []float32 `json:"errors"`         // match error of each cell, see render.Scene.MatchErrors
+}
+
+// Placement is one cell of a generation and the tile photo drawn in it.
+// The rectangle is in HD output pixels, clipped to the output; for shapes
+// other than squares it bounds the shape and overlaps its neighbours.
+type Placement struct {
+	Col         int      `json:"col"`
+	Row         int      `json:"row"`
+	X           int      `json:"x"`
+	Y           int      `json:"y"`
+	Width       int      `json:"width"`
+	Height      int      `json:"height"`
+	TileImageID uint     `json:"tile_image_id"`
+	MatchError  float64  `json:"match_error"`
+	Transforms  []string `json:"transforms"` // such as scale:1.5x2, clip:40x64, mask:hex and overlay:0.3
+}
+
+// newPlacementMap records the placement of a scene whose tiles were loaded
+// from tileImages, in order
+func newPlacementMap(scene *render.Scene, tileImages []models.Image) *PlacementMap {
+	grid := scene.Plan.Grid
+	m := &PlacementMap{
+		Width:        grid.Size.Width,
+		Height:       grid.Size.Height,
+		CellSize:     grid.Cell,
+		Cols:         grid.Cols,
+		Rows:         grid.Rows,
+		Shape:        grid.Shape,
+		Grout:        math.Round(grid.Grout*100) / 100,
+		Overlay:      scene.Overlay,
+		TileImageIDs: make([]uint, len(tileImages)),
+		TileSizes:    make([][2]int, len(tileImages)),
+		Cells:        scene.Plan.Assign,
+		Errors:       make([]float32, grid.Len()),
+	}
+	for i, img := range tileImages {
+		m.TileImageIDs[i] = img.ID
+		m.TileSizes[i] = [2]int{img.Width, img.Height}
+	}
+	for i, e := range scene.MatchErrors() {
+		m.Errors[i] = float32(math.Round(e*100) / 100)
+	}
+	return m
+}
+
+// savePlacementMap writes a placement map to path
+func savePlacementMap(m *PlacementMap, path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := bufio.NewWriter(file)
+	if err := json.NewEncoder(writer).Encode(m); err != nil {
+		return err
+	}
+	return writer.Flush()
+}
+
+// Len returns the number of cells
+func (m *PlacementMap) Len() int {
+	return len(m.Cells)
+}
+
+// grid returns the layout the map was recorded from
+func (m *PlacementMap) grid() render.Grid {
+	return render.Grid{
+		Size:  render.Size{Width: m.Width, Height: m.Height},
+		Cell:  m.CellSize,
+		Cols:  m.Cols,
+		Rows:  m.Rows,
+		Shape: m.Shape,
+		Grout: m.Grout,
+	}
+}
+
+// visible reports whether cell i shows a tile in the output. Rows of
+// shapes that are offset have cells that fall outside it, and masks leave
+// cells without a tile.
+func (m *PlacementMap) visible(i int) bool {
+	return m.Cells[i] != render.NoTile && !m.grid().CellRect(i%m.Cols, i/m.Cols).Intersect(image.Rect(0, 0, m.Width, m.Height)).Empty()
+}
+
+// Placement returns cell i, counted row by row
+func (m *PlacementMap) Placement(i int) Placement {
+	grid := m.grid()
+	col, row := i%m.Cols, i/m.Cols
+	cellRect := grid.CellRect(col, row)
+	rect := cellRect.Intersect(image.Rect(0, 0, m.Width, m.Height))
+	tile := m.Cells[i]
+
+	p := Placement{
+		Col:         col,
+		Row:         row,
+		X:           rect.Min.X,
+		Y:           rect.Min.Y,
+		Width:       rect.Dx(),
+		Height:      rect.Dy(),
+		TileImageID: m.TileImageIDs[tile],
+		MatchError:  float64(m.Errors[i]),
+		Transforms:  []string{},
+	}
+
+	// Tile photos are stretched to a square covering the cell, masked to
+	// its shape, clipped at the edges of the output and blended with the
+	// main image
+	side := float64(grid.TileSide())
+	if size := m.TileSizes[tile]; size[0] > 0 && size[1] > 0 {
+		p.Transforms = append(p.Transforms, "scale:"+formatFactor(side/float64(size[0]))+"x"+formatFactor(side/float64(size[1])))
+	}
+	if !grid.Plain() {
+		shape := m.Shape
+		if shape == "" {
+			shape = render.ShapeSquare
+		}
+		p.Transforms = append(p.Transforms, "mask:"+shape)
+	}
+	if rect != cellRect {
+		p.Transforms = append(p.Transforms, fmt.Sprintf("clip:%dx%d", rect.Dx(), rect.Dy()))
+	}
+	if m.Overlay > 0 {
+		p.Transforms = append(p.Transforms, "overlay:"+formatFactor(m.Overlay))
+	}
+	return p
+}
+
+// At returns the placement covering HD output pixel (x, y), if a tile
+// covers it
+func (m *PlacementMap) At(x, y int) (Placement, bool) {
+	if x < 0 || y < 0 || x >= m.Width || y >= m.Height {
+		return Placement{}, false
+	}
+	col, row := m.grid().CellAt(float64(x)+0.5, float64(y)+0.5)
+	if m.Cells[row*m.Cols+col] == render.NoTile {
+		return Placement{}, false
+	}
+	return m.Placement(row*m.Cols + col), true
+}
+
+// ForTile returns every placement of a tile photo, row by row
+func (m *PlacementMap) ForTile(imageID uint) []Placement {
+	placements := []Placement{}
+	for i, tile := range m.Cells {
+		if m.visible(i) && m.TileImageIDs[tile] == imageID {
+			placements = append(placements, m.Placement(i))
+		}
+	}
+	return placements
+}
+
+// WriteJSON writes every visible placement as a JSON document, one cell
+// at a time
+func (m *PlacementMap) WriteJSON(w io.Writer) error {
+	shape := m.Shape
+	if shape == "" {
+		shape = render.ShapeSquare
+	}
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, `{"width":%d,"height":%d,"cell_size":%d,"cols":%d,"rows":%d,"shape":%q,"placements":[`,
+		m.Width, m.Height, m.CellSize, m.Cols, m.Rows, shape)
+	first := true
+	for i := 0; i < m.Len(); i++ {
+		if !m.visible(i) {
+			continue
+		}
+		if !first {
+			bw.WriteByte(',')
+		}
+		first = false
+		encoded, err := json.Marshal(m.Placement(i))
+		if err != nil {
+			return err
+		}
+		bw.Write(encoded)
+	}
+	bw.WriteString("]}\n")
+	return bw.Flush()
+}
+
+// WriteCSV writes every visible placement as CSV with a header row.
+// Transforms are separated by semicolons.
+func (m *PlacementMap) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"col", "row", "x", "y", "width", "height", "tile_image_id", "match_error", "transforms"})
+	for i := 0; i < m.Len(); i++ {
+		if !m.visible(i) {
+			continue
+		}
+		p := m.Placement(i)
+		cw.Write([]string{
+			strconv.Itoa(p.Col), strconv.Itoa(p.Row),
+			strconv.Itoa(p.X), strconv.Itoa(p.Y), strconv.Itoa(p.Width), strconv.Itoa(p.Height),
+			strconv.FormatUint(uint64(p.TileImageID), 10),
+			strconv.FormatFloat(p.MatchError, 'f', -1, 64),
+			strings.Join(p.Transforms, ";"),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// formatFactor formats a factor with at most three decimals
+func formatFactor(v float64) string {
+	return strconv.FormatFloat(math.Round(v*1000)/1000, 'f', -1, 64)
+}
+
+// GetPlacementMap loads the placement map saved with a generation.
+// Generations from before placement maps were saved have none.
+func (s *MosaicServiceImpl) GetPlacementMap(mosaic *models.GeneratedMosaic) (*PlacementMap, error) {
+	if mosaic.PlacementPath == "" {
+		return nil, ErrResourceNotFound
+	}
+	data, err := os.ReadFile(resolveUploadPath(s.uploadDir, mosaic.PlacementPath))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrResourceNotFound
+		}
+		return nil, err
+	}
+
+	var m PlacementMap
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	if m.Cols <= 0 || m.CellSize <= 0 || len(m.Cells) != m.Cols*m.Rows || len(m.Errors) != len(m.Cells) {
+		return nil, fmt.Errorf("placement map of mosaic %d is corrupt", mosaic.ID)
+	}
+	for _, tile := range m.Cells {
+		if (tile < 0 && tile != render.NoTile) || tile >= len(m.TileImageIDs) {
+			return nil, fmt.Errorf("placement map of mosaic %d is corrupt", mosaic.ID)
+		}
+	}
+	// Maps come back from archives too, so the sizes are checked before
+	// placements index them; a map without any has unknown sizes
+	if m.TileSizes == nil {
+		m.TileSizes = make([][2]int, len(m.TileImageIDs))
+	}
+	if len(m.TileSizes) != len(m.TileImageIDs) {
+		return nil, fmt.Errorf("placement map of mosaic %d is corrupt", mosaic.ID)
+	}
+	return &m, nil
+}
+
+// RenderPlacementHighlight draws the SD image of a generation dimmed, with
+// the cells holding a tile photo at full brightness and outlined
+func (s *MosaicServiceImpl) RenderPlacementHighlight(mosaic *models.GeneratedMosaic, imageID uint) (*image.RGBA, error) {
+	m, err := s.GetPlacementMap(mosaic)
+	if err != nil {
+		return nil, err
+	}
+	sd, err := openImage(resolveUploadPath(s.uploadDir, mosaic.SDPath))
+	if err != nil {
+		return nil, err
+	}
+
+	b := sd.Bounds()
+	out := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(out, out.Bounds(), sd, b.Min, draw.Src)
+	for i := 0; i < len(out.Pix); i += 4 {
+		out.Pix[i] = out.Pix[i] / 4
+		out.Pix[i+1] = out.Pix[i+1] / 4
+		out.Pix[i+2] = out.Pix[i+2] / 4
+	}
+
+	sx := float64(b.Dx()) / float64(m.Width)
+	sy := float64(b.Dy()) / float64(m.Height)
+	outline := image.NewUniform(color.RGBA{R: 255, G: 196, B: 0, A: 255})
+	for _, p := range m.ForTile(imageID) {
+		r := image.Rect(
+			int(math.Floor(float64(p.X)*sx)),
+			int(math.Floor(float64(p.Y)*sy)),
+			int(math.Ceil(float64(p.X+p.Width)*sx)),
+			int(math.Ceil(float64(p.Y+p.Height)*sy)),
+		).Intersect(out.Bounds())
+		draw.Draw(out, r, sd, b.Min.Add(r.Min), draw.Src)
+
+		// A two pixel outline, drawn outside small cells so they stay visible
+		o := r.Inset(-2).Intersect(out.Bounds())
+		for _, edge := range []image.Rectangle{
+			image.Rect(o.Min.X, o.Min.Y, o.Max.X, r.Min.Y),
+			image.Rect(o.Min.X, r.Max.Y, o.Max.X, o.Max.Y),
+			image.Rect(o.Min.X, o.Min.Y, r.Min.X, o.Max.Y),
+			image.Rect(r.Max.X, o.Min.Y, o.Max.X, o.Max.Y),
+		} {
+			draw.Draw(out, edge, outline, image.Point{}, draw.Src)
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,162 @@
+package services
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"image"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/amityadav9314/goinkgrid/internal/db/models"
+	"github.com/amityadav9314/goinkgrid/pkg/render"
+)
+
+// testPlacementMap records a grid with tiles 10, 20 and 30 in turn and no
+// tile in cell 1
+func testPlacementMap(grid render.Grid) *PlacementMap {
+	m := &PlacementMap{
+		Width: grid.Size.Width, Height: grid.Size.Height, CellSize: grid.Cell,
+		Cols: grid.Cols, Rows: grid.Rows, Shape: grid.Shape,
+		TileImageIDs: []uint{10, 20, 30},
+		TileSizes:    [][2]int{{40, 40}, {80, 40}, {0, 0}},
+		Cells:        make([]int, grid.Len()),
+		Errors:       make([]float32, grid.Len()),
+	}
+	for i := range m.Cells {
+		m.Cells[i] = i % 3
+		m.Errors[i] = float32(i)
+	}
+	m.Cells[1] = render.NoTile
+	return m
+}
+
+func TestPlacementAtOnASquareGrid(t *testing.T) {
+	// Five columns of 20px cells, the last cut to 10px by the edge
+	m := testPlacementMap(render.NewGrid(render.Size{Width: 90, Height: 60}, 20))
+
+	p, ok := m.At(45, 25)
+	want := Placement{Col: 2, Row: 1, X: 40, Y: 20, Width: 20, Height: 20, TileImageID: 20, MatchError: 7,
+		Transforms: []string{"scale:0.25x0.5"}}
+	if !ok || !reflect.DeepEqual(p, want) {
+		t.Fatalf("At(45, 25) = %+v, %v, want %+v", p, ok, want)
+	}
+
+	p, ok = m.At(89, 59)
+	if !ok || p.Col != 4 || p.Row != 2 || p.Width != 10 || p.TileImageID != 30 || !reflect.DeepEqual(p.Transforms, []string{"clip:10x20"}) {
+		t.Fatalf("At(89, 59) = %+v, %v", p, ok)
+	}
+
+	for _, point := range [][2]int{{25, 5}, {-1, 0}, {0, -1}, {90, 0}, {0, 60}} {
+		if p, ok := m.At(point[0], point[1]); ok {
+			t.Errorf("At(%d, %d) = %+v, want no tile", point[0], point[1], p)
+		}
+	}
+}
+
+func TestPlacementAtOnAHexGrid(t *testing.T) {
+	grid := render.NewLayoutGrid(render.Size{Width: 200, Height: 150}, 200, 150, 20, render.ShapeSpec{Shape: render.ShapeHex})
+	m := testPlacementMap(grid)
+
+	// The centre of every cell in the output finds that cell
+	for row := 0; row < grid.Rows; row++ {
+		for col := 0; col < grid.Cols; col++ {
+			i := row*grid.Cols + col
+			cx, cy := grid.Center(col, row)
+			if i == 1 || cx < 0 || cy < 0 || cx >= 200 || cy >= 150 {
+				continue
+			}
+			p, ok := m.At(int(cx), int(cy))
+			if !ok || p.Col != col || p.Row != row || p.TileImageID != m.TileImageIDs[m.Cells[i]] {
+				t.Fatalf("At(%d, %d) = %+v, %v, want cell %d, %d", int(cx), int(cy), p, ok, col, row)
+			}
+			if p.Transforms[len(p.Transforms)-1] != "mask:hex" && !strings.HasPrefix(p.Transforms[len(p.Transforms)-1], "clip:") {
+				t.Fatalf("transforms %v", p.Transforms)
+			}
+		}
+	}
+
+	// A corner of a cell's bounds lies in the hexagon of the row above
+	corner := grid.CellRect(1, 1).Min.Add(image.Pt(1, 1))
+	p, ok := m.At(corner.X, corner.Y)
+	if !ok || p.Row != 0 {
+		t.Fatalf("At(%v) = %+v, %v, want a cell of row 0", corner, p, ok)
+	}
+	cx, cy := grid.Center(p.Col, p.Row)
+	if d := math.Hypot(float64(corner.X)-cx, float64(corner.Y)-cy); d > float64(grid.Cell) {
+		t.Fatalf("found cell %d, %d centred %.1f px away", p.Col, p.Row, d)
+	}
+}
+
+func TestPlacementsForTileAndCSV(t *testing.T) {
+	m := testPlacementMap(render.NewGrid(render.Size{Width: 60, Height: 40}, 20))
+
+	// Cells 0 and 3 hold tile 10; cell 1 has no tile, so tile 20 is only in cell 4
+	var cells [][2]int
+	for _, p := range m.ForTile(10) {
+		cells = append(cells, [2]int{p.Col, p.Row})
+	}
+	if !reflect.DeepEqual(cells, [][2]int{{0, 0}, {0, 1}}) {
+		t.Fatalf("tile 10 is in %v", cells)
+	}
+	if got := m.ForTile(20); len(got) != 1 || got[0].Col != 1 || got[0].Row != 1 {
+		t.Fatalf("tile 20 is in %+v", got)
+	}
+	if got := m.ForTile(99); got == nil || len(got) != 0 {
+		t.Fatalf("an unused tile is in %+v", got)
+	}
+
+	var buf strings.Builder
+	if err := m.WriteCSV(&buf); err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(strings.NewReader(buf.String())).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{
+		{"col", "row", "x", "y", "width", "height", "tile_image_id", "match_error", "transforms"},
+		{"0", "0", "0", "0", "20", "20", "10", "0", "scale:0.5x0.5"},
+		{"2", "0", "40", "0", "20", "20", "30", "2", ""},
+		{"0", "1", "0", "20", "20", "20", "10", "3", "scale:0.5x0.5"},
+		{"1", "1", "20", "20", "20", "20", "20", "4", "scale:0.25x0.5"},
+		{"2", "1", "40", "20", "20", "20", "30", "5", ""},
+	}
+	if !reflect.DeepEqual(records, want) {
+		t.Fatalf("CSV:\n%v\nwant:\n%v", records, want)
+	}
+}
+
+func TestGetPlacementMapChecksTileSizes(t *testing.T) {
+	uploadDir := t.TempDir()
+	s := &MosaicServiceImpl{uploadDir: uploadDir}
+	load := func(m *PlacementMap) (*PlacementMap, error) {
+		data, err := json.Marshal(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(uploadDir, "placement.json"), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return s.GetPlacementMap(&models.GeneratedMosaic{PlacementPath: "/placement.json"})
+	}
+
+	m := testPlacementMap(render.NewGrid(render.Size{Width: 60, Height: 40}, 20))
+	m.TileSizes = m.TileSizes[:2]
+	if _, err := load(m); err == nil {
+		t.Fatal("loaded a map with fewer tile sizes than tiles")
+	}
+
+	// Without any sizes the scale is unknown and left out
+	m.TileSizes = nil
+	got, err := load(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p, ok := got.At(0, 0); !ok || len(p.Transforms) != 0 {
+		t.Fatalf("At(0, 0) = %+v, %v", p, ok)
+	}
+}
@@ -0,0 +1,246 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"log"
+	"math/rand"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/amityadav9314/goinkgrid/internal/db/models"
+	"github.com/amityadav9314/goinkgrid/pkg/render"
+)
+
+// maxPreviewSources is the number of main images kept in memory for previews
+const maxPreviewSources = 16
+
+// ErrPreviewTimeout is returned when a preview does not fit its time budget
+var ErrPreviewTimeout = errors.New("preview took too long to render")
+
+// PreviewConfig limits live previews
+type PreviewConfig struct {
+	MaxEdge int           // long edge of a preview in pixels
+	Timeout time.Duration // time budget of a preview
+}
+
+// previewSource is a main image kept in memory at a reduced size, so that
+// previews do not decode the original on every request
+type previewSource struct {
+	path       string
+	img        image.Image
+	srcW, srcH int // size of the original
+	used       time.Time
+	mask       *cachedMask // the last silhouette mask previewed over it
+}
+
+// cachedMask is a decoded silhouette mask image, kept with the preview
+// source it was last used with
+type cachedMask struct {
+	id   uint
+	path string
+	img  image.Image
+}
+
+// RenderPreview renders a small mosaic in memory from the same layout plan
+// a generation with the snapshot would use. It places the same list of
+// tiles, but draws them from their cached color data, which is computed
+// and stored for tiles that have none.
+func (s *MosaicServiceImpl) RenderPreview(ctx context.Context, snapshot *MosaicSnapshot, images []models.Image) (*image.RGBA, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.preview.Timeout)
+	defer cancel()
+
+	var mainImage, maskImage *models.Image
+	tileIDs := make(map[uint]bool, len(snapshot.TileImageIDs))
+	for _, id := range snapshot.TileImageIDs {
+		tileIDs[id] = true
+	}
+	var tileImages []models.Image
+	for i := range images {
+		if images[i].ID == snapshot.MainImageID && mainImage == nil {
+			mainImage = &images[i]
+		}
+		if snapshot.Mask != nil && snapshot.Mask.ImageID != 0 && images[i].ID == snapshot.Mask.ImageID {
+			maskImage = &images[i]
+		}
+		if tileIDs[images[i].ID] {
+			tileImages = append(tileImages, images[i])
+			delete(tileIDs, images[i].ID)
+		}
+	}
+	if mainImage == nil {
+		return nil, errors.New("main image not found")
+	}
+
+	// Same order and the same checks as generations, so the seed picks
+	// the same tiles
+	sort.Slice(tileImages, func(i, j int) bool { return tileImages[i].ID < tileImages[j].ID })
+	tileImages = usableTiles(tileImages)
+	if len(tileImages) == 0 {
+		return nil, errors.New("no valid tile images found")
+	}
+
+	source, err := s.previewSource(mainImage)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open main image: %v", err)
+	}
+
+	hdSize, err := snapshot.outputSpec().Resolve(source.srcW, source.srcH)
+	if err != nil {
+		return nil, err
+	}
+	size := fitSize(hdSize, s.preview.MaxEdge)
+
+	projectRoot, _ := filepath.Abs(".")
+	failed := make([]error, len(tileImages))
+	tiles, err := render.LoadTiles(ctx, len(tileImages), []int{TileThumbSize}, 0,
+		func(i int) (image.Image, error) {
+			if data := tileColorData(&tileImages[i]); data != nil {
+				return data.Thumbnail(), nil
+			}
+			img, err := openImage(filepath.Join(projectRoot, strings.TrimPrefix(tileImages[i].Path, "/")))
+			if err != nil {
+				return nil, err
+			}
+			data := NewTileColorData(img)
+			if err := saveTileColorData(&tileImages[i], data); err != nil {
+				log.Printf("Failed to cache color data of tile image %d: %v", tileImages[i].ID, err)
+			}
+			return data.Thumbnail(), nil
+		},
+		func(i int, err error) {
+			failed[i] = err
+		},
+	)
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			return nil, ErrPreviewTimeout
+		}
+		return nil, err
+	}
+	for i, err := range failed {
+		if err != nil {
+			return nil, fmt.Errorf("failed to open tile image %d: %v", tileImages[i].ID, err)
+		}
+	}
+
+	// The plan is laid out at full size exactly as the generation would be
+	rng := rand.New(rand.NewSource(snapshot.Seed))
+	plan := render.NewRandomPlan(render.NewLayoutGrid(hdSize, source.srcW, source.srcH, snapshot.TileSize, snapshot.shapeSpec()), tiles.Len(), rng)
+	var silhouette image.Image
+	if snapshot.Mask != nil && snapshot.Mask.ImageID != 0 {
+		if maskImage == nil {
+			return nil, errors.New("mask image not found")
+		}
+		if silhouette, err = s.previewSilhouette(source, maskImage); err != nil {
+			return nil, err
+		}
+	}
+	background, err := applyMask(plan, snapshot, silhouette)
+	if err != nil {
+		return nil, err
+	}
+
+	// Previews are JPEGs, so a transparent background is shown white
+	if background != nil && background.Color.A < 255 {
+		background = nil
+	}
+
+	thumbs := make([]*image.RGBA, tiles.Len())
+	for i := range thumbs {
+		thumbs[i] = tiles.Get(i, TileThumbSize)
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, size.Width, size.Height))
+	render.DrawPreview(dst, render.NewGuide(source.img, size), plan, thumbs, snapshot.OverlayRatio, background)
+	if ctx.Err() != nil {
+		return nil, ErrPreviewTimeout
+	}
+	return dst, nil
+}
+
+// previewSource returns a main image from the preview cache, decoding and
+// reducing it to twice the preview size if it is not cached yet
+func (s *MosaicServiceImpl) previewSource(mainImage *models.Image) (*previewSource, error) {
+	s.previewLock.Lock()
+	source := s.previewSources[mainImage.ID]
+	if source != nil && source.path == mainImage.Path {
+		source.used = time.Now()
+		s.previewLock.Unlock()
+		return source, nil
+	}
+	s.previewLock.Unlock()
+
+	projectRoot, _ := filepath.Abs(".")
+	img, err := openImage(filepath.Join(projectRoot, strings.TrimPrefix(mainImage.Path, "/")))
+	if err != nil {
+		return nil, err
+	}
+	b := img.Bounds()
+	if b.Dx() == 0 || b.Dy() == 0 {
+		return nil, errors.New("main image has no pixels")
+	}
+
+	reduced := fitSize(render.Size{Width: b.Dx(), Height: b.Dy()}, 2*s.preview.MaxEdge)
+	source = &previewSource{
+		path: mainImage.Path,
+		img:  render.ScaleImage(img, reduced.Width, reduced.Height),
+		srcW: b.Dx(),
+		srcH: b.Dy(),
+		used: time.Now(),
+	}
+
+	s.previewLock.Lock()
+	defer s.previewLock.Unlock()
+	if len(s.previewSources) >= maxPreviewSources {
+		var oldest uint
+		for id, cached := range s.previewSources {
+			if oldest == 0 || cached.used.Before(s.previewSources[oldest].used) {
+				oldest = id
+			}
+		}
+		delete(s.previewSources, oldest)
+	}
+	s.previewSources[mainImage.ID] = source
+	return source, nil
+}
+
+// previewSilhouette returns a silhouette mask image, decoding it only if
+// it is not the one last previewed over the source, so that moving a
+// slider does not read it from disk again
+func (s *MosaicServiceImpl) previewSilhouette(source *previewSource, maskImage *models.Image) (image.Image, error) {
+	s.previewLock.Lock()
+	cached := source.mask
+	s.previewLock.Unlock()
+	if cached != nil && cached.id == maskImage.ID && cached.path == maskImage.Path {
+		return cached.img, nil
+	}
+
+	img, err := openMaskImage(maskImage)
+	if err != nil {
+		return nil, err
+	}
+	s.previewLock.Lock()
+	source.mask = &cachedMask{id: maskImage.ID, path: maskImage.Path, img: img}
+	s.previewLock.Unlock()
+	return img, nil
+}
+
+// fitSize scales a size down so its long edge is at most maxEdge, keeping
+// its aspect ratio
+func fitSize(size render.Size, maxEdge int) render.Size {
+	long := max(size.Width, size.Height)
+	if long <= maxEdge {
+		return render.Size{Width: size.Width, Height: size.Height, DPI: render.DefaultDPI}
+	}
+	scale := float64(maxEdge) / float64(long)
+	return render.Size{
+		Width:  max(int(float64(size.Width)*scale+0.5), 1),
+		Height: max(int(float64(size.Height)*scale+0.5), 1),
+		DPI:    render.DefaultDPI,
+	}
+}
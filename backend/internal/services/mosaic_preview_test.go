@@ -0,0 +1,209 @@
+package services
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/amityadav9314/goinkgrid/internal/db/models"
+	db "github.com/amityadav9314/goinkgrid/internal/db/postgres"
+	"github.com/amityadav9314/goinkgrid/pkg/render"
+)
+
+// previewFixture is a main image and solid red, green and blue tiles with
+// cached color data, stored under a temporary working directory
+type previewFixture struct {
+	service *MosaicServiceImpl
+	main    models.Image
+	tiles   []models.Image
+}
+
+func newPreviewFixture(t *testing.T) *previewFixture {
+	t.Helper()
+	previous := db.DB
+	db.DB = newTestDB(t)
+	t.Cleanup(func() { db.DB = previous })
+
+	// Stored paths are resolved from the working directory
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	f := &previewFixture{
+		service: NewMosaicService(filepath.Join(dir, "uploads"), RenderConfig{TileCacheBytes: 1 << 30},
+			PreviewConfig{MaxEdge: 256, Timeout: time.Minute}).(*MosaicServiceImpl),
+		main: models.Image{UserID: 1, Type: "main", Path: "/uploads/main.png", Width: 64, Height: 64},
+	}
+	writeTestImage(t, f.main.Path, solidImage(64, 64, color.RGBA{128, 128, 128, 255}))
+	if err := db.DB.Create(&f.main).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	for i, c := range []color.RGBA{{255, 0, 0, 255}, {0, 255, 0, 255}, {0, 0, 255, 255}} {
+		tile := models.Image{UserID: 1, Type: "tile", Path: "/uploads/tile" + string(rune('a'+i)) + ".png"}
+		img := solidImage(16, 16, c)
+		writeTestImage(t, tile.Path, img)
+		if err := db.DB.Create(&tile).Error; err != nil {
+			t.Fatal(err)
+		}
+		if err := saveTileColorData(&tile, NewTileColorData(img)); err != nil {
+			t.Fatal(err)
+		}
+		f.tiles = append(f.tiles, tile)
+	}
+	return f
+}
+
+// snapshot places the fixture's tiles over its main image with 8px cells
+func (f *previewFixture) snapshot() *MosaicSnapshot {
+	ids := make([]uint, len(f.tiles))
+	for i, tile := range f.tiles {
+		ids[i] = tile.ID
+	}
+	return &MosaicSnapshot{MainImageID: f.main.ID, TileImageIDs: ids, TileSize: 8, Seed: 42}
+}
+
+// images returns every image the fixture stored
+func (f *previewFixture) images(t *testing.T) []models.Image {
+	t.Helper()
+	var images []models.Image
+	if err := db.DB.Order("id").Find(&images).Error; err != nil {
+		t.Fatal(err)
+	}
+	return images
+}
+
+func solidImage(w, h int, c color.RGBA) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(img, img.Bounds(), image.NewUniform(c), image.Point{}, draw.Src)
+	return img
+}
+
+func writeTestImage(t *testing.T, storedPath string, img image.Image) {
+	t.Helper()
+	path := filepath.Join(".", storedPath)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if err := png.Encode(file, img); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPreviewPlacesTheSameTilesAsTheGeneration(t *testing.T) {
+	f := newPreviewFixture(t)
+
+	// The green tile's color data is still cached, but its file is gone
+	if err := os.Remove(filepath.Join(".", f.tiles[1].Path)); err != nil {
+		t.Fatal(err)
+	}
+
+	snapshot := f.snapshot()
+	preview, err := f.service.RenderPreview(context.Background(), snapshot, f.images(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	scene, loaded, err := f.service.buildScene(context.Background(), solidImage(64, 64, color.RGBA{128, 128, 128, 255}), f.tiles, snapshot, func(int) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded) != 2 || loaded[0].ID != f.tiles[0].ID || loaded[1].ID != f.tiles[2].ID {
+		t.Fatalf("the generation placed %v, want the red and blue tiles", loaded)
+	}
+	generated := image.NewRGBA(scene.Bounds())
+	scene.Draw(generated)
+
+	if preview.Bounds() != generated.Bounds() {
+		t.Fatalf("preview is %v, generation is %v", preview.Bounds(), generated.Bounds())
+	}
+	for y := 0; y < preview.Bounds().Dy(); y++ {
+		for x := 0; x < preview.Bounds().Dx(); x++ {
+			got, want := preview.RGBAAt(x, y), generated.RGBAAt(x, y)
+			if got != want {
+				t.Fatalf("preview pixel (%d, %d) is %v, the generation has %v", x, y, got, want)
+			}
+		}
+	}
+}
+
+func TestPreviewFailsWhenATileCannotBeDecoded(t *testing.T) {
+	f := newPreviewFixture(t)
+
+	// A readable header with broken pixels passes the check the
+	// generation and the preview share, so neither may drop it silently
+	if err := db.DB.Model(&f.tiles[2]).Update("color_data", nil).Error; err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(".", f.tiles[2].Path)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data[:len(data)-20], 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := f.service.RenderPreview(context.Background(), f.snapshot(), f.images(t)); err == nil {
+		t.Fatal("the preview left out a tile the generation would fail on")
+	}
+}
+
+func TestPreviewKeepsTheSilhouetteDecoded(t *testing.T) {
+	f := newPreviewFixture(t)
+
+	mask := models.Image{UserID: 1, Type: "mask", Path: "/uploads/mask.png"}
+	silhouette := solidImage(64, 64, color.RGBA{255, 255, 255, 255})
+	draw.Draw(silhouette, image.Rect(16, 16, 48, 48), image.NewUniform(color.Black), image.Point{}, draw.Src)
+	writeTestImage(t, mask.Path, silhouette)
+	if err := db.DB.Create(&mask).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	snapshot := f.snapshot()
+	snapshot.Mask = &render.MaskSpec{ImageID: mask.ID}
+	first, err := f.service.RenderPreview(context.Background(), snapshot, f.images(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Later previews over the same main image do not read the mask again
+	if err := os.Remove(filepath.Join(".", mask.Path)); err != nil {
+		t.Fatal(err)
+	}
+	again, err := f.service.RenderPreview(context.Background(), snapshot, f.images(t))
+	if err != nil {
+		t.Fatalf("the silhouette was read again: %v", err)
+	}
+	if string(again.Pix) != string(first.Pix) {
+		t.Fatal("the cached silhouette gave a different preview")
+	}
+
+	// A mask image stored elsewhere is a different silhouette
+	images := f.images(t)
+	for i := range images {
+		if images[i].ID == mask.ID {
+			images[i].Path = "/uploads/other.png"
+		}
+	}
+	if _, err := f.service.RenderPreview(context.Background(), snapshot, images); err == nil {
+		t.Fatal("a stale silhouette was used for a moved mask image")
+	}
+}
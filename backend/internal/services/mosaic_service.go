@@ -1,14 +1,17 @@
 package services
 
 import (
+	"bufio"
+	"context"
 	"errors"
 	"fmt"
 	models "github.com/amityadav9314/goinkgrid/internal/db/models"
 	db "github.com/amityadav9314/goinkgrid/internal/db/postgres"
+	"github.com/amityadav9314/goinkgrid/pkg/render"
 	"image"
 	"image/color"
-	"image/draw"
-	"image/jpeg"
+	"log"
+	"math"
 	"math/rand"
 	"os"
 	"os/exec"
@@ -17,30 +20,70 @@ import (
 	"sync"
 	"time"
 
-	xdraw "golang.org/x/image/draw"
 	"gorm.io/gorm"
 )
 
 type MosaicServiceImpl struct {
 	uploadDir string
-	// Map to track active generation tasks
-	activeTasks     map[uint]bool
+	// Map to track active generation tasks, keyed by project ID
+	activeTasks     map[uint]*activeTask
 	activeTasksLock sync.Mutex
+	render          RenderConfig
+	// Main images kept in memory for live previews, keyed by image ID
+	preview        PreviewConfig
+	previewSources map[uint]*previewSource
+	previewLock    sync.Mutex
 }
 
-// NewMosaicService creates a new mosaic service
-func NewMosaicService(uploadDir string) MosaicService {
+// RenderConfig holds the limits of full size generations
+type RenderConfig struct {
+	// Memory the tiles of one generation may hold once scaled to the cell
+	// size. Outputs whose tiles need more are rejected.
+	TileCacheBytes int64
+}
+
+// ErrTileMemory is returned for generations whose scaled tiles would not
+// fit in RenderConfig.TileCacheBytes
+var ErrTileMemory = errors.New("tiles do not fit in the memory limit")
+
+// activeTask tracks a running mosaic generation so it can be listed and cancelled
+type activeTask struct {
+	mosaicID uint
+	cancel   context.CancelFunc
+}
+
+// JobStats summarises the state of mosaic generation jobs
+type JobStats struct {
+	Running   []models.GeneratedMosaic `json:"running"`
+	StaleJobs int64                    `json:"stale_jobs"` // processing records without a running task, left by a restart or crash
+}
+
+// NewMosaicService creates a new mosaic service. Previews default to a
+// 512 pixel long edge and a two second budget.
+func NewMosaicService(uploadDir string, renderConfig RenderConfig, preview PreviewConfig) MosaicService {
+	if renderConfig.TileCacheBytes <= 0 {
+		renderConfig.TileCacheBytes = 1 << 30
+	}
+	if preview.MaxEdge <= 0 {
+		preview.MaxEdge = 512
+	}
+	if preview.Timeout <= 0 {
+		preview.Timeout = 2 * time.Second
+	}
 	return &MosaicServiceImpl{
-		uploadDir:   uploadDir,
-		activeTasks: make(map[uint]bool),
+		uploadDir:      uploadDir,
+		activeTasks:    make(map[uint]*activeTask),
+		render:         renderConfig,
+		preview:        preview,
+		previewSources: make(map[uint]*previewSource),
 	}
 }
 
 // SaveSettings saves or updates mosaic settings for a user
 func (s *MosaicServiceImpl) SaveSettings(userID uint, settings *models.MosaicSettings) error {
-	// Check if settings already exist for this user
+	// Check if settings already exist for this user and project
 	var existingSettings models.MosaicSettings
-	result := db.DB.Where("user_id = ? and project_id = ?", userID, settings.ProjectID).First(&existingSettings)
+	result := settingsQuery(userID, settings.ProjectID).First(&existingSettings)
 
 	if result.Error == nil {
 		// Update existing settings
@@ -48,6 +91,7 @@ func (s *MosaicServiceImpl) SaveSettings(userID uint, settings *models.MosaicSet
 		existingSettings.TileDensity = settings.TileDensity
 		existingSettings.ColorAdjustment = settings.ColorAdjustment
 		existingSettings.Style = settings.Style
+		existingSettings.Seed = settings.Seed
 		return db.DB.Save(&existingSettings).Error
 	}
 
@@ -56,17 +100,21 @@ func (s *MosaicServiceImpl) SaveSettings(userID uint, settings *models.MosaicSet
 	return db.DB.Create(settings).Error
 }
 
+// settingsQuery selects the settings of a user for a project, or the
+// user's own settings if projectID is nil
+func settingsQuery(userID uint, projectID *uint) *gorm.DB {
+	query := db.DB.Where("user_id = ?", userID)
+	if projectID == nil {
+		return query.Where("project_id IS NULL")
+	}
+	return query.Where("project_id = ?", *projectID)
+}
+
 // GetSettings retrieves mosaic settings for a user
 func (s *MosaicServiceImpl) GetSettings(userID uint, projectID *uint) (*models.MosaicSettings, error) {
 	var settings models.MosaicSettings
 
-	// Build query based on whether projectID is provided
-	query := db.DB.Where("user_id = ?", userID)
-	if projectID != nil {
-		query = query.Where("project_id = ?", *projectID)
-	}
-
-	result := query.First(&settings)
+	result := settingsQuery(userID, projectID).First(&settings)
 
 	if result.Error != nil {
 		// If no settings found, return default settings
@@ -78,6 +126,15 @@ func (s *MosaicServiceImpl) GetSettings(userID uint, projectID *uint) (*models.M
 			Style:           "classic",
 		}
 
+		// Prefer the user's own default preferences when they exist
+		var user models.User
+		if err := db.DB.First(&user, userID).Error; err == nil {
+			defaultSettings.TileSize = user.DefaultTileSize
+			defaultSettings.TileDensity = user.DefaultTileDensity
+			defaultSettings.ColorAdjustment = user.DefaultColorAdjustment
+			defaultSettings.Style = user.DefaultStyle
+		}
+
 		// Set project ID if provided
 		if projectID != nil {
 			defaultSettings.ProjectID = projectID
@@ -89,51 +146,102 @@ func (s *MosaicServiceImpl) GetSettings(userID uint, projectID *uint) (*models.M
 	return &settings, nil
 }
 
-// GenerateMosaic generates a mosaic image from a main image and tile images
-func (s *MosaicServiceImpl) GenerateMosaic(userID uint, projectID uint, mainImageID uint, tileImageIDs []uint, settings *models.MosaicSettings) (*models.GeneratedMosaic, error) {
+// GenerateMosaic generates a mosaic image from the inputs in snapshot. The
+// snapshot is completed with the engine version and stored on the record,
+// so the generation can be compared and rendered again later.
+// sourceMosaicID is set when re-rendering an earlier generation. Each of
+// exports is rendered alongside the HD image as an artifact of the mosaic.
+func (s *MosaicServiceImpl) GenerateMosaic(userID uint, projectID uint, snapshot *MosaicSnapshot, sourceMosaicID *uint, exports []render.ExportSpec) (*models.GeneratedMosaic, error) {
+	snapshot.EngineVersion = MosaicEngineVersion
+	snapshotJSON, err := EncodeMosaicSnapshot(snapshot)
+	if err != nil {
+		return nil, err
+	}
+
+	// Refuse outputs whose tiles cannot be held before a job is started.
+	// The main image's recorded size may be missing, in which case the
+	// job checks again once it has decoded the image.
+	var mainImage models.Image
+	if err := db.DB.Select("width", "height").First(&mainImage, snapshot.MainImageID).Error; err == nil && mainImage.Width > 0 && mainImage.Height > 0 {
+		hdSize, err := snapshot.outputSpec().Resolve(mainImage.Width, mainImage.Height)
+		if err != nil {
+			return nil, err
+		}
+		grid := render.NewLayoutGrid(hdSize, mainImage.Width, mainImage.Height, snapshot.TileSize, snapshot.shapeSpec())
+		if err := s.checkTileMemory(len(snapshot.TileImageIDs), grid); err != nil {
+			return nil, err
+		}
+	}
+
 	// Check if we already have an active task for this project
+	ctx, cancel := context.WithCancel(context.Background())
+	task := &activeTask{cancel: cancel}
+
 	s.activeTasksLock.Lock()
-	if s.activeTasks[projectID] {
+	if s.activeTasks[projectID] != nil {
 		s.activeTasksLock.Unlock()
+		cancel()
 		return nil, errors.New("a mosaic generation is already in progress for this project")
 	}
-	s.activeTasks[projectID] = true
+	s.activeTasks[projectID] = task
 	s.activeTasksLock.Unlock()
 
 	// Create a new GeneratedMosaic record
 	mosaic := &models.GeneratedMosaic{
 		UserID:          userID,
 		ProjectID:       projectID,
-		MainImageID:     mainImageID,
+		MainImageID:     snapshot.MainImageID,
 		Status:          "processing",
-		TileSize:        settings.TileSize,
-		TileDensity:     settings.TileDensity,
-		ColorAdjustment: settings.ColorAdjustment,
-		Style:           settings.Style,
+		TileSize:        snapshot.TileSize,
+		TileDensity:     snapshot.TileDensity,
+		ColorAdjustment: int(snapshot.OverlayRatio * 100),
+		Style:           snapshot.Style,
 		Progress:        0,
+		Snapshot:        snapshotJSON,
+		SourceMosaicID:  sourceMosaicID,
 	}
 
-	// Save the initial record
-	if err := db.DB.Create(mosaic).Error; err != nil {
+	// Save the initial record along with the requested exports
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(mosaic).Error; err != nil {
+			return err
+		}
+		_, err := createArtifacts(tx, mosaic.ID, exports)
+		return err
+	})
+	if err != nil {
 		s.activeTasksLock.Lock()
 		delete(s.activeTasks, projectID)
 		s.activeTasksLock.Unlock()
+		cancel()
 		return nil, err
 	}
 
+	s.activeTasksLock.Lock()
+	task.mosaicID = mosaic.ID
+	s.activeTasksLock.Unlock()
+
 	// Start the generation process in a goroutine
-	go s.generateMosaicAsync(mosaic, mainImageID, tileImageIDs)
+	go s.generateMosaicAsync(ctx, mosaic, snapshot)
 
 	return mosaic, nil
 }
 
 // generateMosaicAsync handles the asynchronous mosaic generation process
-func (s *MosaicServiceImpl) generateMosaicAsync(mosaic *models.GeneratedMosaic, mainImageID uint, tileImageIDs []uint) {
+func (s *MosaicServiceImpl) generateMosaicAsync(ctx context.Context, mosaic *models.GeneratedMosaic, snapshot *MosaicSnapshot) {
 	defer func() {
 		// Remove from active tasks when done
 		s.activeTasksLock.Lock()
+		if task := s.activeTasks[mosaic.ProjectID]; task != nil {
+			task.cancel()
+		}
 		delete(s.activeTasks, mosaic.ProjectID)
 		s.activeTasksLock.Unlock()
+
+		// Exports are not rendered once the generation has failed
+		if mosaic.Status != "completed" {
+			finishArtifacts(mosaic.ID, mosaic.Status, mosaic.ErrorMessage)
+		}
 	}()
 
 	// Update progress to 10%
@@ -142,16 +250,16 @@ func (s *MosaicServiceImpl) generateMosaicAsync(mosaic *models.GeneratedMosaic,
 
 	// Get the main image
 	var mainImage models.Image
-	if err := db.DB.First(&mainImage, mainImageID).Error; err != nil {
+	if err := db.DB.First(&mainImage, snapshot.MainImageID).Error; err != nil {
 		mosaic.Status = "failed"
 		mosaic.ErrorMessage = "Failed to find main image"
 		db.DB.Save(mosaic)
 		return
 	}
 
-	// Get the tile images
+	// Get the tile images, in a stable order so the seed picks the same tiles
 	var tileImages []models.Image
-	if err := db.DB.Where("id IN ?", tileImageIDs).Find(&tileImages).Error; err != nil {
+	if err := db.DB.Where("id IN ?", snapshot.TileImageIDs).Order("id").Find(&tileImages).Error; err != nil {
 		mosaic.Status = "failed"
 		mosaic.ErrorMessage = "Failed to find tile images"
 		db.DB.Save(mosaic)
@@ -175,8 +283,10 @@ func (s *MosaicServiceImpl) generateMosaicAsync(mosaic *models.GeneratedMosaic,
 	timestamp := time.Now().Format("20060102150405")
 	sdFilename := fmt.Sprintf("mosaic_sd_%s.jpg", timestamp)
 	hdFilename := fmt.Sprintf("mosaic_hd_%s.jpg", timestamp)
+	placementFilename := fmt.Sprintf("mosaic_placement_%s.json", timestamp)
 	sdPath := filepath.Join(mosaicDir, sdFilename)
 	hdPath := filepath.Join(mosaicDir, hdFilename)
+	placementPath := filepath.Join(mosaicDir, placementFilename)
 
 	// Update progress to 30%
 	mosaic.Progress = 30
@@ -184,7 +294,16 @@ func (s *MosaicServiceImpl) generateMosaicAsync(mosaic *models.GeneratedMosaic,
 
 	// Simulate mosaic generation (in a real implementation, this would be the actual generation code)
 	// For now, we'll just create placeholder images
-	if err := s.createPlaceholderMosaics(mainImage.Path, tileImages, sdPath, hdPath, mosaic); err != nil {
+	if err := s.createPlaceholderMosaics(ctx, mainImage.Path, tileImages, sdPath, hdPath, placementPath, mosaic, snapshot); err != nil {
+		if ctx.Err() != nil {
+			mosaic.Status = "cancelled"
+			mosaic.ErrorMessage = "Generation was cancelled"
+			db.DB.Save(mosaic)
+			os.Remove(sdPath)
+			os.Remove(hdPath)
+			os.Remove(placementPath)
+			return
+		}
 		mosaic.Status = "failed"
 		mosaic.ErrorMessage = fmt.Sprintf("Failed to generate mosaic: %v", err)
 		db.DB.Save(mosaic)
@@ -201,6 +320,7 @@ func (s *MosaicServiceImpl) generateMosaicAsync(mosaic *models.GeneratedMosaic,
 	if !strings.HasPrefix(mosaic.HDPath, "/") {
 		mosaic.HDPath = "/" + mosaic.HDPath
 	}
+	mosaic.PlacementPath = storedUploadPath(s.uploadDir, placementPath)
 
 	mosaic.Status = "completed"
 	mosaic.Progress = 100
@@ -209,8 +329,66 @@ func (s *MosaicServiceImpl) generateMosaicAsync(mosaic *models.GeneratedMosaic,
 
 // createPlaceholderMosaics creates placeholder mosaic images for development
 // In a real implementation, this would be replaced with actual mosaic generation logic
-func (s *MosaicServiceImpl) createPlaceholderMosaics(mainImagePath string, tileImages []models.Image, sdPath, hdPath string, mosaic *models.GeneratedMosaic) error {
-	// Open the main image
+func (s *MosaicServiceImpl) createPlaceholderMosaics(ctx context.Context, mainImagePath string, tileImages []models.Image, sdPath, hdPath, placementPath string, mosaic *models.GeneratedMosaic, snapshot *MosaicSnapshot) error {
+	mainImg, err := openMainImage(mainImagePath)
+	if err != nil {
+		return err
+	}
+
+	// Update progress to 40%
+	mosaic.Progress = 40
+	db.DB.Save(mosaic)
+
+	hdScene, loadedTiles, err := s.buildScene(ctx, mainImg, tileImages, snapshot, func(progress int) {
+		mosaic.Progress = progress
+		db.DB.Save(mosaic)
+	})
+	if err != nil {
+		return err
+	}
+	hdSize := hdScene.Guide.Size()
+	mosaic.Width = hdSize.Width
+	mosaic.Height = hdSize.Height
+	mosaic.DPI = hdSize.DPI
+
+	// The SD preview is the HD render scaled down to at most half the
+	// cropped main image, so both show exactly the same tile placement
+	crop := hdScene.Guide.Crop()
+	sdSize := previewSize(hdSize, crop.Dx()/2)
+
+	// Update progress to 70%
+	mosaic.Progress = 70
+	db.DB.Save(mosaic)
+
+	// Render the HD image band by band, feeding the SD preview as it goes
+	sd := render.NewDownsampler(hdSize.Width, hdSize.Height, sdSize.Width, sdSize.Height)
+	if err := streamJPEG(ctx, hdScene.Flat(), hdPath, 90, hdSize.DPI, sd); err != nil {
+		return fmt.Errorf("failed to save HD image: %w", err)
+	}
+
+	// Update progress to 80%
+	mosaic.Progress = 80
+	db.DB.Save(mosaic)
+
+	if err := saveJPEG(sd.Image(), sdPath, 90, sdSize.DPI); err != nil {
+		return fmt.Errorf("failed to save SD image: %w", err)
+	}
+
+	// Record which tile photo went where
+	if err := savePlacementMap(newPlacementMap(hdScene, loadedTiles), placementPath); err != nil {
+		return fmt.Errorf("failed to save placement map: %w", err)
+	}
+
+	// Update progress to 90%
+	mosaic.Progress = 90
+	db.DB.Save(mosaic)
+
+	// Render the extra formats requested with the generation
+	return s.renderArtifacts(ctx, mosaic.ID, hdScene, sd.Image(), filepath.Dir(hdPath))
+}
+
+// openMainImage opens the main image of a generation from its stored path
+func openMainImage(mainImagePath string) (image.Image, error) {
 	// Get the absolute path to the project root directory
 	projectRoot, _ := filepath.Abs(".")
 
@@ -260,7 +438,7 @@ func (s *MosaicServiceImpl) createPlaceholderMosaics(mainImagePath string, tileI
 				fmt.Printf("Found file at: %s\n", foundPath)
 				fullMainImagePath = foundPath
 			} else {
-				return fmt.Errorf("failed to find main image: %v (tried paths: %s and %s)",
+				return nil, fmt.Errorf("failed to find main image: %v (tried paths: %s and %s)",
 					err, fullMainImagePath, alternativePath)
 			}
 		} else {
@@ -270,117 +448,146 @@ func (s *MosaicServiceImpl) createPlaceholderMosaics(mainImagePath string, tileI
 
 	mainImg, err := openImage(fullMainImagePath)
 	if err != nil {
-		return fmt.Errorf("failed to open main image: %v", err)
+		return nil, fmt.Errorf("failed to open main image: %v", err)
 	}
+	return mainImg, nil
+}
 
-	// Update progress to 40%
-	mosaic.Progress = 40
-	db.DB.Save(mosaic)
-
-	// Get the dimensions of the main image
+// buildScene lays out a generation at its output size: the guide, the tile
+// placement and the tiles scaled to the cell size. progress is called as
+// the steps complete.
+func (s *MosaicServiceImpl) buildScene(ctx context.Context, mainImg image.Image, tileImages []models.Image, snapshot *MosaicSnapshot, progress func(int)) (*render.Scene, []models.Image, error) {
+	// The HD output has the requested size. The main image is cropped to
+	// its aspect ratio and upscaled as the guide, while tiles are scaled
+	// straight from their originals so they stay sharp at any size.
 	bounds := mainImg.Bounds()
-	width, height := bounds.Max.X, bounds.Max.Y
-
-	// Create SD and HD images
-	sdWidth, sdHeight := width/2, height/2
-	hdWidth, hdHeight := width, height
-
-	// Create new images
-	sdImg := image.NewRGBA(image.Rect(0, 0, sdWidth, sdHeight))
-	hdImg := image.NewRGBA(image.Rect(0, 0, hdWidth, hdHeight))
+	hdSize, err := snapshot.outputSpec().Resolve(bounds.Dx(), bounds.Dy())
+	if err != nil {
+		return nil, nil, err
+	}
+	hdGuide := render.NewGuide(mainImg, hdSize)
 
 	// Update progress to 50%
-	mosaic.Progress = 50
-	db.DB.Save(mosaic)
+	progress(50)
 
-	// Draw a grid of tiles on the images
-	tileSize := mosaic.TileSize
-	rand.Seed(time.Now().UnixNano())
-
-	// Load tile images
-	tileImgList := make([]image.Image, 0, len(tileImages))
-	for _, tile := range tileImages {
-		fullTilePath := filepath.Join(projectRoot, strings.TrimPrefix(tile.Path, "/"))
-		tileImg, err := openImage(fullTilePath)
-		if err != nil {
-			fmt.Printf("Failed to open tile image %s: %v\n", tile.Path, err)
-			continue
-		}
-		tileImgList = append(tileImgList, tileImg)
+	// Tiles whose file is gone or unreadable are left out here, the same
+	// way previews leave them out, so both place the same tiles
+	tileImages = usableTiles(tileImages)
+	if len(tileImages) == 0 {
+		return nil, nil, errors.New("no valid tile images found")
 	}
 
-	if len(tileImgList) == 0 {
-		return errors.New("no valid tile images found")
+	hdGrid := render.NewLayoutGrid(hdSize, bounds.Dx(), bounds.Dy(), snapshot.TileSize, snapshot.shapeSpec())
+	if err := s.checkTileMemory(len(tileImages), hdGrid); err != nil {
+		return nil, nil, err
 	}
 
-	// Update progress to 60%
-	mosaic.Progress = 60
-	db.DB.Save(mosaic)
-
-	// Draw tiles on SD image
-	for y := 0; y < sdHeight; y += tileSize / 2 {
-		for x := 0; x < sdWidth; x += tileSize / 2 {
-			// Get average color from main image for this region
-			//avgColor := getAverageColor(mainImg, x*2, y*2, tileSize, tileSize)
-
-			// Find best matching tile
-			tileIdx := rand.Intn(len(tileImgList))
-			tileImg := tileImgList[tileIdx]
-
-			// Scale tile to fit
-			scaledTile := scaleImage(tileImg, tileSize/2, tileSize/2)
-
-			// Draw tile on SD image
-			xdraw.Draw(sdImg, image.Rect(x, y, x+tileSize/2, y+tileSize/2), scaledTile, image.Point{0, 0}, draw.Over)
-		}
+	// Load the tile images in parallel, scaled once to the size they are
+	// drawn at, so large photos are not held in memory at full size. A
+	// tile that cannot be decoded after passing the check fails the
+	// generation rather than shifting every later tile of the plan.
+	projectRoot, _ := filepath.Abs(".")
+	failed := make([]error, len(tileImages))
+	tiles, err := render.LoadTiles(ctx, len(tileImages), []int{hdGrid.TileSide()}, 0,
+		func(i int) (image.Image, error) {
+			return openImage(filepath.Join(projectRoot, strings.TrimPrefix(tileImages[i].Path, "/")))
+		},
+		func(i int, err error) {
+			failed[i] = err
+		},
+	)
+	if err != nil {
+		return nil, nil, err
 	}
-
-	// Update progress to 70%
-	mosaic.Progress = 70
-	db.DB.Save(mosaic)
-
-	// Draw tiles on HD image
-	for y := 0; y < hdHeight; y += tileSize {
-		for x := 0; x < hdWidth; x += tileSize {
-			// Get average color from main image for this region
-			//avgColor := getAverageColor(mainImg, x, y, tileSize, tileSize)
-
-			// Find best matching tile
-			tileIdx := rand.Intn(len(tileImgList))
-			tileImg := tileImgList[tileIdx]
-
-			// Scale tile to fit
-			scaledTile := scaleImage(tileImg, tileSize, tileSize)
-
-			// Draw tile on HD image
-			xdraw.Draw(hdImg, image.Rect(x, y, x+tileSize, y+tileSize), scaledTile, image.Point{0, 0}, draw.Over)
+	for i, err := range failed {
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to open tile image %d: %v", tileImages[i].ID, err)
 		}
 	}
 
-	// Update progress to 80%
-	mosaic.Progress = 80
-	db.DB.Save(mosaic)
+	// Update progress to 60%
+	progress(60)
+
+	// Pick a tile for every cell up front, so that bands can be rendered
+	// independently and cells split across bands get the same tile. Every
+	// random choice comes from this job's own generator, so the same inputs
+	// and seed always render the same image.
+	rng := rand.New(rand.NewSource(snapshot.Seed))
+	plan := render.NewRandomPlan(hdGrid, tiles.Len(), rng)
+	silhouette, err := openSilhouette(snapshot)
+	if err != nil {
+		return nil, nil, err
+	}
+	background, err := applyMask(plan, snapshot, silhouette)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &render.Scene{
+		Guide:      hdGuide,
+		Plan:       plan,
+		Tiles:      tiles,
+		Overlay:    snapshot.OverlayRatio,
+		Background: background,
+	}, tileImages, nil
+}
 
-	// Save the images
-	if err := saveJPEG(sdImg, sdPath, 90); err != nil {
-		return fmt.Errorf("failed to save SD image: %v", err)
+// checkTileMemory returns ErrTileMemory if count tiles scaled to the cells
+// of grid need more memory than the configured limit
+func (s *MosaicServiceImpl) checkTileMemory(count int, grid render.Grid) error {
+	need := render.TileSetBytes(count, grid.TileSide())
+	if need <= s.render.TileCacheBytes {
+		return nil
 	}
+	return fmt.Errorf("%w: %d tiles of %dpx need %d MB, the limit is %d MB; choose a smaller output, larger tiles or fewer tiles",
+		ErrTileMemory, count, grid.TileSide(), need>>20, s.render.TileCacheBytes>>20)
+}
 
-	// Update progress to 90%
-	mosaic.Progress = 90
-	db.DB.Save(mosaic)
+// applyMask leaves the cells of plan outside the snapshot's mask without a
+// tile and returns the background that fills them, or nil if the snapshot
+// has no mask. silhouette is the image of a silhouette mask.
+func applyMask(plan *render.Plan, snapshot *MosaicSnapshot, silhouette image.Image) (*render.Background, error) {
+	if snapshot.Mask == nil {
+		return nil, nil
+	}
+	mask, err := render.NewMask(*snapshot.Mask, plan.Grid.Size, silhouette)
+	if err != nil {
+		return nil, err
+	}
+	if err := mask.Apply(plan); err != nil {
+		return nil, err
+	}
+	background := snapshot.Mask.Fill()
+	return &background, nil
+}
 
-	if err := saveJPEG(hdImg, hdPath, 90); err != nil {
-		return fmt.Errorf("failed to save HD image: %v", err)
+// openSilhouette reads the uploaded image of the snapshot's silhouette
+// mask, or returns nil if the snapshot has no silhouette
+func openSilhouette(snapshot *MosaicSnapshot) (image.Image, error) {
+	if snapshot.Mask == nil || snapshot.Mask.ImageID == 0 {
+		return nil, nil
+	}
+	var maskImage models.Image
+	if err := db.DB.First(&maskImage, snapshot.Mask.ImageID).Error; err != nil {
+		return nil, errors.New("mask image not found")
 	}
+	return openMaskImage(&maskImage)
+}
 
-	return nil
+// openMaskImage decodes a silhouette mask's image from its stored path
+func openMaskImage(maskImage *models.Image) (image.Image, error) {
+	projectRoot, _ := filepath.Abs(".")
+	img, err := openImage(filepath.Join(projectRoot, strings.TrimPrefix(maskImage.Path, "/")))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open mask image: %v", err)
+	}
+	return img, nil
 }
 
-// GetMosaicStatus retrieves the status of a mosaic generation task
-func (s *MosaicServiceImpl) GetMosaicStatus(userID uint, mosaicID uint) (*models.GeneratedMosaic, error) {
+// GetMosaicStatus retrieves the status of a mosaic generation task.
+// Callers are responsible for checking access to the mosaic's project.
+func (s *MosaicServiceImpl) GetMosaicStatus(mosaicID uint) (*models.GeneratedMosaic, error) {
 	var mosaic models.GeneratedMosaic
-	result := db.DB.Where("id = ? AND user_id = ?", mosaicID, userID).First(&mosaic)
+	result := db.DB.Where("id = ?", mosaicID).First(&mosaic)
 	if result.Error != nil {
 		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
 			return nil, errors.New("mosaic not found")
@@ -390,18 +597,102 @@ func (s *MosaicServiceImpl) GetMosaicStatus(userID uint, mosaicID uint) (*models
 	return &mosaic, nil
 }
 
-// GetProjectMosaics retrieves all mosaics for a project
-func (s *MosaicServiceImpl) GetProjectMosaics(userID uint, projectID uint) ([]models.GeneratedMosaic, error) {
+// GetProjectMosaics retrieves all mosaics for a project, including those
+// generated by collaborators. Callers are responsible for access checks.
+func (s *MosaicServiceImpl) GetProjectMosaics(projectID uint) ([]models.GeneratedMosaic, error) {
 	var mosaics []models.GeneratedMosaic
-	result := db.DB.Where("user_id = ? AND project_id = ?", userID, projectID).Order("created_at DESC").Find(&mosaics)
+	result := db.DB.Where("project_id = ?", projectID).Order("created_at DESC").Find(&mosaics)
 	if result.Error != nil {
 		return nil, result.Error
 	}
 	return mosaics, nil
 }
 
+// GetJobStats returns the running generation jobs and the number of
+// processing records that have no running task
+func (s *MosaicServiceImpl) GetJobStats() (*JobStats, error) {
+	s.activeTasksLock.Lock()
+	runningIDs := make([]uint, 0, len(s.activeTasks))
+	for _, task := range s.activeTasks {
+		if task.mosaicID != 0 {
+			runningIDs = append(runningIDs, task.mosaicID)
+		}
+	}
+	s.activeTasksLock.Unlock()
+
+	stats := &JobStats{Running: []models.GeneratedMosaic{}}
+	if len(runningIDs) > 0 {
+		if err := db.DB.Where("id IN ?", runningIDs).Order("created_at").Find(&stats.Running).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	query := db.DB.Model(&models.GeneratedMosaic{}).Where("status = ?", "processing")
+	if len(runningIDs) > 0 {
+		query = query.Where("id NOT IN ?", runningIDs)
+	}
+	if err := query.Count(&stats.StaleJobs).Error; err != nil {
+		return nil, err
+	}
+
+	return stats, nil
+}
+
+// CancelMosaic cancels a mosaic generation regardless of its owner. Records
+// left in processing without a running task are marked cancelled directly.
+func (s *MosaicServiceImpl) CancelMosaic(mosaicID uint) error {
+	s.activeTasksLock.Lock()
+	for _, task := range s.activeTasks {
+		if task.mosaicID == mosaicID {
+			task.cancel()
+			s.activeTasksLock.Unlock()
+			return nil
+		}
+	}
+	s.activeTasksLock.Unlock()
+
+	result := db.DB.Model(&models.GeneratedMosaic{}).
+		Where("id = ? AND status = ?", mosaicID, "processing").
+		Updates(map[string]interface{}{"status": "cancelled", "error_message": "Generation was cancelled"})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("no running generation found for this mosaic")
+	}
+	return nil
+}
+
 // Helper functions for image processing
 
+// usableTiles returns the tile images whose file can still be read, in
+// their order. Generations and previews both place tiles from this list,
+// so a seed puts the same tile in the same cell in both.
+func usableTiles(tileImages []models.Image) []models.Image {
+	projectRoot, _ := filepath.Abs(".")
+	usable := make([]models.Image, 0, len(tileImages))
+	for _, img := range tileImages {
+		if err := checkImage(filepath.Join(projectRoot, strings.TrimPrefix(img.Path, "/"))); err != nil {
+			log.Printf("Skipping tile image %s: %v", img.Path, err)
+			continue
+		}
+		usable = append(usable, img)
+	}
+	return usable
+}
+
+// checkImage reads the header of an image file without decoding its pixels
+func checkImage(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, _, err = image.DecodeConfig(file)
+	return err
+}
+
 // openImage opens an image file and returns an image.Image
 func openImage(path string) (image.Image, error) {
 	file, err := os.Open(path)
@@ -418,15 +709,48 @@ func openImage(path string) (image.Image, error) {
 	return img, nil
 }
 
-// saveJPEG saves an image as JPEG with the specified quality
-func saveJPEG(img image.Image, path string, quality int) error {
+// streamJPEG renders a scene band by band straight into a JPEG file with
+// the specified quality and resolution, feeding the rendered rows to any
+// previews
+func streamJPEG(ctx context.Context, scene *render.Scene, path string, quality int, dpi int, previews ...*render.Downsampler) error {
 	file, err := os.Create(path)
 	if err != nil {
 		return err
 	}
 	defer file.Close()
 
-	return jpeg.Encode(file, img, &jpeg.Options{Quality: quality})
+	writer := bufio.NewWriter(file)
+	err = render.Stream(ctx, scene, func(img image.Image) error {
+		return render.EncodeJPEG(writer, img, quality, dpi)
+	}, previews...)
+	if err != nil {
+		return err
+	}
+	return writer.Flush()
+}
+
+// saveJPEG saves an image held in memory to a JPEG file with the specified
+// quality and resolution
+func saveJPEG(img image.Image, path string, quality int, dpi int) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := bufio.NewWriter(file)
+	if err := render.EncodeJPEG(writer, img, quality, dpi); err != nil {
+		return err
+	}
+	return writer.Flush()
+}
+
+// previewSize returns the size of a preview of an output that is at most
+// maxWidth wide, never larger than the output, keeping its aspect ratio
+func previewSize(out render.Size, maxWidth int) render.Size {
+	width := min(max(maxWidth, 1), out.Width)
+	height := max(int(math.Round(float64(out.Height)*float64(width)/float64(out.Width))), 1)
+	return render.Size{Width: width, Height: min(height, out.Height), DPI: render.DefaultDPI}
 }
 
 // getAverageColor calculates the average color of a region in an image
@@ -461,10 +785,3 @@ func getAverageColor(img image.Image, x, y, width, height int) color.RGBA {
 		A: uint8(a / uint32(count) >> 8),
 	}
 }
-
-// scaleImage scales an image to the specified width and height
-func scaleImage(img image.Image, width, height int) image.Image {
-	dst := image.NewRGBA(image.Rect(0, 0, width, height))
-	xdraw.BiLinear.Scale(dst, dst.Bounds(), img, img.Bounds(), draw.Over, nil)
-	return dst
-}
@@ -0,0 +1,52 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/amityadav9314/goinkgrid/internal/db/models"
+	db "github.com/amityadav9314/goinkgrid/internal/db/postgres"
+)
+
+func TestSettingsWithAndWithoutProject(t *testing.T) {
+	previous := db.DB
+	db.DB = newTestDB(t)
+	t.Cleanup(func() { db.DB = previous })
+	s := &MosaicServiceImpl{}
+	projectID := uint(7)
+
+	// Project settings saved first must not be mistaken for the user's own
+	if err := s.SaveSettings(1, &models.MosaicSettings{ProjectID: &projectID, TileSize: 30, Style: "random"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, tileSize := range []int{40, 60} {
+		if err := s.SaveSettings(1, &models.MosaicSettings{TileSize: tileSize, Style: "classic"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var count int64
+	db.DB.Model(&models.MosaicSettings{}).Where("user_id = ?", 1).Count(&count)
+	if count != 2 {
+		t.Fatalf("got %d settings rows, want one for the user and one for the project", count)
+	}
+
+	own, err := s.GetSettings(1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if own.ProjectID != nil || own.TileSize != 60 {
+		t.Fatalf("user settings: got project %v and tile size %d, want none and 60", own.ProjectID, own.TileSize)
+	}
+	project, err := s.GetSettings(1, &projectID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if project.ProjectID == nil || *project.ProjectID != projectID || project.TileSize != 30 {
+		t.Fatalf("project settings: got %+v", project)
+	}
+
+	// The database refuses a second row without a project
+	if err := db.DB.Create(&models.MosaicSettings{UserID: 1, TileSize: 10}).Error; err == nil {
+		t.Fatal("a second settings row without a project was inserted")
+	}
+}
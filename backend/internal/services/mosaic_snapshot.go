@@ -0,0 +1,156 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/amityadav9314/goinkgrid/internal/db/models"
+	"github.com/amityadav9314/goinkgrid/pkg/render"
+)
+
+// MosaicEngineVersion identifies the renderer that produced a generation.
+// It is bumped whenever the same inputs would render a different image.
+const MosaicEngineVersion = "5"
+
+// MosaicSnapshot records every input of a generation, so that a result can
+// be compared with others and rendered again
+type MosaicSnapshot struct {
+	MainImageID     uint               `json:"main_image_id"`
+	TileImageIDs    []uint             `json:"tile_image_ids"`
+	TileSize        int                `json:"tile_size"`
+	TileDensity     int                `json:"tile_density"`
+	OverlayRatio    float64            `json:"overlay_ratio"`
+	Style           string             `json:"style"`
+	ColorCorrection bool               `json:"color_correction"`
+	Seed            int64              `json:"seed"`
+	Output          *render.OutputSpec `json:"output,omitempty"`
+	Shape           *render.ShapeSpec  `json:"shape,omitempty"`
+	Mask            *render.MaskSpec   `json:"mask,omitempty"`
+	EngineVersion   string             `json:"engine_version"`
+}
+
+// MosaicDiff lists the differences between two generations
+type MosaicDiff struct {
+	From         uint                 `json:"from"`
+	To           uint                 `json:"to"`
+	Changes      []MosaicSnapshotDiff `json:"changes"`
+	TilesAdded   []uint               `json:"tiles_added"`
+	TilesRemoved []uint               `json:"tiles_removed"`
+	Identical    bool                 `json:"identical"`
+}
+
+// MosaicSnapshotDiff is a single parameter that differs between two generations
+type MosaicSnapshotDiff struct {
+	Field string      `json:"field"`
+	From  interface{} `json:"from"`
+	To    interface{} `json:"to"`
+}
+
+// EncodeMosaicSnapshot serializes a snapshot for storage on a GeneratedMosaic
+func EncodeMosaicSnapshot(snapshot *MosaicSnapshot) ([]byte, error) {
+	return json.Marshal(snapshot)
+}
+
+// DecodeMosaicSnapshot returns the inputs a mosaic was generated with.
+// Mosaics created before snapshots were recorded only know the parameters
+// stored on the record; their tile set and seed are unknown.
+func DecodeMosaicSnapshot(mosaic *models.GeneratedMosaic) (*MosaicSnapshot, error) {
+	if len(mosaic.Snapshot) == 0 {
+		return &MosaicSnapshot{
+			MainImageID:  mosaic.MainImageID,
+			TileSize:     mosaic.TileSize,
+			TileDensity:  mosaic.TileDensity,
+			OverlayRatio: float64(mosaic.ColorAdjustment) / 100,
+			Style:        mosaic.Style,
+		}, nil
+	}
+
+	var snapshot MosaicSnapshot
+	if err := json.Unmarshal(mosaic.Snapshot, &snapshot); err != nil {
+		return nil, fmt.Errorf("invalid snapshot for mosaic %d: %v", mosaic.ID, err)
+	}
+	return &snapshot, nil
+}
+
+// Reproducible reports whether the snapshot holds enough to render the
+// generation again
+func (s *MosaicSnapshot) Reproducible() bool {
+	return s.MainImageID != 0 && len(s.TileImageIDs) > 0 && s.EngineVersion != ""
+}
+
+// outputSpec returns the requested output size, or the zero spec that
+// renders at the size of the main image
+func (s *MosaicSnapshot) outputSpec() render.OutputSpec {
+	if s.Output == nil {
+		return render.OutputSpec{}
+	}
+	return *s.Output
+}
+
+// shapeSpec returns the requested tile shape, or the zero spec of square
+// tiles without grout
+func (s *MosaicSnapshot) shapeSpec() render.ShapeSpec {
+	if s.Shape == nil {
+		return render.ShapeSpec{}
+	}
+	return *s.Shape
+}
+
+// maskSpec returns the requested mask, or the zero spec if the tiles fill
+// the whole output
+func (s *MosaicSnapshot) maskSpec() render.MaskSpec {
+	if s.Mask == nil {
+		return render.MaskSpec{}
+	}
+	return *s.Mask
+}
+
+// DiffMosaicSnapshots compares the inputs of two generations
+func DiffMosaicSnapshots(fromID uint, from *MosaicSnapshot, toID uint, to *MosaicSnapshot) *MosaicDiff {
+	diff := &MosaicDiff{
+		From:    fromID,
+		To:      toID,
+		Changes: []MosaicSnapshotDiff{},
+	}
+
+	add := func(field string, a, b interface{}) {
+		if a != b {
+			diff.Changes = append(diff.Changes, MosaicSnapshotDiff{Field: field, From: a, To: b})
+		}
+	}
+	add("main_image_id", from.MainImageID, to.MainImageID)
+	add("tile_size", from.TileSize, to.TileSize)
+	add("tile_density", from.TileDensity, to.TileDensity)
+	add("overlay_ratio", from.OverlayRatio, to.OverlayRatio)
+	add("style", from.Style, to.Style)
+	add("color_correction", from.ColorCorrection, to.ColorCorrection)
+	add("seed", from.Seed, to.Seed)
+	add("output", from.outputSpec(), to.outputSpec())
+	add("shape", from.shapeSpec(), to.shapeSpec())
+	add("mask", from.maskSpec(), to.maskSpec())
+	add("engine_version", from.EngineVersion, to.EngineVersion)
+
+	diff.TilesAdded = subtractIDs(to.TileImageIDs, from.TileImageIDs)
+	diff.TilesRemoved = subtractIDs(from.TileImageIDs, to.TileImageIDs)
+	diff.Identical = len(diff.Changes) == 0 && len(diff.TilesAdded) == 0 && len(diff.TilesRemoved) == 0
+	return diff
+}
+
+// subtractIDs returns the sorted IDs in a that are not in b
+func subtractIDs(a, b []uint) []uint {
+	exclude := make(map[uint]bool, len(b))
+	for _, id := range b {
+		exclude[id] = true
+	}
+
+	result := []uint{}
+	for _, id := range a {
+		if !exclude[id] {
+			result = append(result, id)
+			exclude[id] = true
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i] < result[j] })
+	return result
+}
@@ -0,0 +1,788 @@
+package services
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/amityadav9314/goinkgrid/internal/db/models"
+	"github.com/amityadav9314/goinkgrid/pkg/render"
+	"github.com/google/uuid"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+// Archive format identifiers. ArchiveVersion is bumped whenever the
+// manifest changes in a way older importers cannot read. Version 2 added
+// placement maps and exported artifacts to mosaics.
+const (
+	ArchiveFormat       = "inkgrid-project"
+	ArchiveVersion      = 2
+	archiveManifestName = "manifest.json"
+)
+
+// Errors returned by Import for archives that cannot be imported, as
+// opposed to failures on the server
+var (
+	ErrInvalidArchive     = errors.New("invalid archive")
+	ErrUnsupportedArchive = errors.New("unsupported archive version")
+)
+
+// Limits applied when importing untrusted archives
+const (
+	maxArchiveEntries   = 10000
+	maxArchiveFileBytes = 200 << 20
+	maxArchiveBytes     = 4 << 30
+	maxManifestBytes    = 20 << 20
+)
+
+// ArchiveManifest describes the contents of a project archive
+type ArchiveManifest struct {
+	Format         string                  `json:"format"`
+	Version        int                     `json:"version"`
+	ExportedAt     time.Time               `json:"exported_at"`
+	Project        ArchiveProject          `json:"project"`
+	MosaicSettings []ArchiveMosaicSettings `json:"mosaic_settings"`
+	Images         []ArchiveImage          `json:"images"`
+	Collections    []ArchiveCollection     `json:"collections"`
+	Mosaics        []ArchiveMosaic         `json:"mosaics"`
+}
+
+// ArchiveProject is the project record in an archive
+type ArchiveProject struct {
+	ID          uint           `json:"id"`
+	Name        string         `json:"name"`
+	Description string         `json:"description"`
+	Status      string         `json:"status"`
+	Settings    datatypes.JSON `json:"settings,omitempty"`
+	CreatedAt   time.Time      `json:"created_at"`
+}
+
+// ArchiveMosaicSettings is a saved settings record in an archive
+type ArchiveMosaicSettings struct {
+	TileSize        int    `json:"tile_size"`
+	TileDensity     int    `json:"tile_density"`
+	ColorAdjustment int    `json:"color_adjustment"`
+	Style           string `json:"style"`
+	Seed            *int64 `json:"seed,omitempty"`
+}
+
+// ArchiveImage is an image record in an archive. File is the entry name of
+// the image inside the archive.
+type ArchiveImage struct {
+	ID        uint           `json:"id"`
+	Type      string         `json:"type"`
+	File      string         `json:"file"`
+	Filename  string         `json:"filename"`
+	Width     int            `json:"width"`
+	Height    int            `json:"height"`
+	Format    string         `json:"format"`
+	ColorData datatypes.JSON `json:"color_data,omitempty"`
+	CreatedAt time.Time      `json:"created_at"`
+}
+
+// ArchiveCollection is a tile collection in an archive
+type ArchiveCollection struct {
+	ID       uint   `json:"id"`
+	Name     string `json:"name"`
+	ImageIDs []uint `json:"image_ids"`
+}
+
+// ArchiveMosaic is a generated mosaic in an archive
+type ArchiveMosaic struct {
+	ID              uint              `json:"id"`
+	MainImageID     uint              `json:"main_image_id"`
+	Status          string            `json:"status"`
+	SDFile          string            `json:"sd_file,omitempty"`
+	HDFile          string            `json:"hd_file,omitempty"`
+	Width           int               `json:"width,omitempty"`
+	Height          int               `json:"height,omitempty"`
+	DPI             int               `json:"dpi,omitempty"`
+	TileSize        int               `json:"tile_size"`
+	TileDensity     int               `json:"tile_density"`
+	ColorAdjustment int               `json:"color_adjustment"`
+	Style           string            `json:"style"`
+	ErrorMessage    string            `json:"error_message,omitempty"`
+	Snapshot        *MosaicSnapshot   `json:"snapshot,omitempty"`
+	SourceMosaicID  *uint             `json:"source_mosaic_id,omitempty"`
+	PlacementFile   string            `json:"placement_file,omitempty"`
+	Artifacts       []ArchiveArtifact `json:"artifacts,omitempty"`
+	CreatedAt       time.Time         `json:"created_at"`
+}
+
+// ArchiveArtifact is an exported file of a mosaic in an archive. The tiles
+// of a Deep Zoom export are stored in the directory next to File, named
+// the way viewers expect.
+type ArchiveArtifact struct {
+	ID           uint           `json:"id"`
+	Format       string         `json:"format"`
+	Layer        string         `json:"layer,omitempty"`
+	Options      datatypes.JSON `json:"options,omitempty"`
+	Status       string         `json:"status"`
+	File         string         `json:"file,omitempty"`
+	Size         int64          `json:"size,omitempty"`
+	ErrorMessage string         `json:"error_message,omitempty"`
+	CreatedAt    time.Time      `json:"created_at"`
+}
+
+// ProjectArchiveServiceImpl implements the ProjectArchiveService interface
+type ProjectArchiveServiceImpl struct {
+	db        *gorm.DB
+	uploadDir string
+}
+
+// NewProjectArchiveService creates a new ProjectArchiveService implementation
+func NewProjectArchiveService(db *gorm.DB, uploadDir string) ProjectArchiveService {
+	return &ProjectArchiveServiceImpl{
+		db:        db,
+		uploadDir: uploadDir,
+	}
+}
+
+// Export writes a ZIP archive of a project to w. The manifest is written
+// first, followed by every referenced file. Missing files are left out of
+// the archive and their records point to no file.
+func (s *ProjectArchiveServiceImpl) Export(projectID uint, w io.Writer) error {
+	var project models.Project
+	if err := s.db.First(&project, projectID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errors.New("project not found")
+		}
+		return err
+	}
+
+	// Members keep settings of their own on a project, but an imported
+	// project has a single owner, so only the owner's settings are kept
+	var settings []models.MosaicSettings
+	if err := s.db.Where("project_id = ? AND user_id = ?", projectID, project.UserID).Find(&settings).Error; err != nil {
+		return err
+	}
+	var images []models.Image
+	if err := s.db.Where("project_id = ?", projectID).Order("id").Find(&images).Error; err != nil {
+		return err
+	}
+	var collections []models.TileCollection
+	if err := s.db.Preload("Images").Where("project_id = ?", projectID).Order("id").Find(&collections).Error; err != nil {
+		return err
+	}
+	var mosaics []models.GeneratedMosaic
+	if err := s.db.Where("project_id = ?", projectID).Order("id").Find(&mosaics).Error; err != nil {
+		return err
+	}
+	artifacts := make(map[uint][]models.MosaicArtifact, len(mosaics))
+	if len(mosaics) > 0 {
+		mosaicIDs := make([]uint, len(mosaics))
+		for i, mosaic := range mosaics {
+			mosaicIDs[i] = mosaic.ID
+		}
+		var all []models.MosaicArtifact
+		if err := s.db.Where("mosaic_id IN ?", mosaicIDs).Order("id").Find(&all).Error; err != nil {
+			return err
+		}
+		for _, artifact := range all {
+			artifacts[artifact.MosaicID] = append(artifacts[artifact.MosaicID], artifact)
+		}
+	}
+
+	manifest := ArchiveManifest{
+		Format:     ArchiveFormat,
+		Version:    ArchiveVersion,
+		ExportedAt: time.Now().UTC(),
+		Project: ArchiveProject{
+			ID:          project.ID,
+			Name:        project.Name,
+			Description: project.Description,
+			Status:      project.Status,
+			Settings:    project.Settings,
+			CreatedAt:   project.CreatedAt,
+		},
+		MosaicSettings: make([]ArchiveMosaicSettings, 0, len(settings)),
+		Images:         make([]ArchiveImage, 0, len(images)),
+		Collections:    make([]ArchiveCollection, 0, len(collections)),
+		Mosaics:        make([]ArchiveMosaic, 0, len(mosaics)),
+	}
+
+	// files maps archive entry names to files on disk
+	files := make(map[string]string)
+	addFile := func(dir string, id uint, storedPath string) string {
+		if storedPath == "" {
+			return ""
+		}
+		diskPath := resolveUploadPath(s.uploadDir, storedPath)
+		if _, err := os.Stat(diskPath); err != nil {
+			return ""
+		}
+		name := fmt.Sprintf("files/%s/%d%s", dir, id, strings.ToLower(filepath.Ext(diskPath)))
+		files[name] = diskPath
+		return name
+	}
+	// addDeepZoomTiles adds the tile directory of a Deep Zoom descriptor
+	// that was added as name
+	addDeepZoomTiles := func(name string) error {
+		tilesDir := deepZoomTilesDir(files[name])
+		return filepath.WalkDir(tilesDir, func(p string, d fs.DirEntry, err error) error {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			if err != nil || d.IsDir() {
+				return err
+			}
+			rel, err := filepath.Rel(tilesDir, p)
+			if err != nil {
+				return err
+			}
+			files[deepZoomTilesDir(name)+"/"+filepath.ToSlash(rel)] = p
+			return nil
+		})
+	}
+
+	for _, st := range settings {
+		manifest.MosaicSettings = append(manifest.MosaicSettings, ArchiveMosaicSettings{
+			TileSize:        st.TileSize,
+			TileDensity:     st.TileDensity,
+			ColorAdjustment: st.ColorAdjustment,
+			Style:           st.Style,
+			Seed:            st.Seed,
+		})
+	}
+	for _, img := range images {
+		manifest.Images = append(manifest.Images, ArchiveImage{
+			ID:        img.ID,
+			Type:      img.Type,
+			File:      addFile("images", img.ID, img.Path),
+			Filename:  img.Filename,
+			Width:     img.Width,
+			Height:    img.Height,
+			Format:    img.Format,
+			ColorData: img.ColorData,
+			CreatedAt: img.CreatedAt,
+		})
+	}
+	for _, collection := range collections {
+		imageIDs := make([]uint, 0, len(collection.Images))
+		for _, img := range collection.Images {
+			imageIDs = append(imageIDs, img.ID)
+		}
+		manifest.Collections = append(manifest.Collections, ArchiveCollection{
+			ID:       collection.ID,
+			Name:     collection.Name,
+			ImageIDs: imageIDs,
+		})
+	}
+	for _, mosaic := range mosaics {
+		var snapshot *MosaicSnapshot
+		if len(mosaic.Snapshot) > 0 {
+			decoded, err := DecodeMosaicSnapshot(&mosaic)
+			if err != nil {
+				return err
+			}
+			snapshot = decoded
+		}
+		archiveArtifacts := make([]ArchiveArtifact, 0, len(artifacts[mosaic.ID]))
+		for _, artifact := range artifacts[mosaic.ID] {
+			file := addFile("artifacts", artifact.ID, artifact.Path)
+			if file != "" && artifact.Format == render.FormatDZI {
+				if err := addDeepZoomTiles(file); err != nil {
+					return err
+				}
+			}
+			archiveArtifacts = append(archiveArtifacts, ArchiveArtifact{
+				ID:           artifact.ID,
+				Format:       artifact.Format,
+				Layer:        artifact.Layer,
+				Options:      artifact.Options,
+				Status:       artifact.Status,
+				File:         file,
+				Size:         artifact.Size,
+				ErrorMessage: artifact.ErrorMessage,
+				CreatedAt:    artifact.CreatedAt,
+			})
+		}
+		manifest.Mosaics = append(manifest.Mosaics, ArchiveMosaic{
+			ID:              mosaic.ID,
+			MainImageID:     mosaic.MainImageID,
+			Status:          mosaic.Status,
+			SDFile:          addFile("mosaics/sd", mosaic.ID, mosaic.SDPath),
+			HDFile:          addFile("mosaics/hd", mosaic.ID, mosaic.HDPath),
+			Width:           mosaic.Width,
+			Height:          mosaic.Height,
+			DPI:             mosaic.DPI,
+			TileSize:        mosaic.TileSize,
+			TileDensity:     mosaic.TileDensity,
+			ColorAdjustment: mosaic.ColorAdjustment,
+			Style:           mosaic.Style,
+			ErrorMessage:    mosaic.ErrorMessage,
+			Snapshot:        snapshot,
+			SourceMosaicID:  mosaic.SourceMosaicID,
+			PlacementFile:   addFile("mosaics/placement", mosaic.ID, mosaic.PlacementPath),
+			Artifacts:       archiveArtifacts,
+			CreatedAt:       mosaic.CreatedAt,
+		})
+	}
+
+	zw := zip.NewWriter(w)
+
+	manifestWriter, err := zw.Create(archiveManifestName)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(manifestWriter)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(manifest); err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := writeArchiveFile(zw, name, files[name]); err != nil {
+			return err
+		}
+	}
+
+	return zw.Close()
+}
+
+// Import recreates a project from a ZIP archive under the given user. All
+// records get new IDs and references between them are remapped. Files are
+// copied into the user's upload directory and removed again if the import
+// fails.
+func (s *ProjectArchiveServiceImpl) Import(userID uint, r io.ReaderAt, size int64) (*models.Project, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("%w: not a ZIP file", ErrInvalidArchive)
+	}
+	if len(zr.File) > maxArchiveEntries {
+		return nil, fmt.Errorf("%w: too many entries", ErrInvalidArchive)
+	}
+
+	entries := make(map[string]*zip.File, len(zr.File))
+	var total uint64
+	for _, f := range zr.File {
+		entries[f.Name] = f
+		total += f.UncompressedSize64
+	}
+	if total > maxArchiveBytes {
+		return nil, fmt.Errorf("%w: contents are too large", ErrInvalidArchive)
+	}
+
+	manifest, err := readArchiveManifest(entries[archiveManifestName])
+	if err != nil {
+		return nil, err
+	}
+
+	project := &models.Project{
+		UserID:      userID,
+		Name:        manifest.Project.Name,
+		Description: manifest.Project.Description,
+		Status:      manifest.Project.Status,
+		Settings:    manifest.Project.Settings,
+	}
+	if project.Name == "" {
+		project.Name = "Imported project"
+	}
+
+	// Files and directories written so far, removed if anything fails
+	var written []string
+	cleanup := func() {
+		for _, p := range written {
+			os.RemoveAll(p)
+		}
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(project).Error; err != nil {
+			return err
+		}
+
+		projectDir := filepath.Join(s.uploadDir, fmt.Sprintf("user_%d", userID), fmt.Sprintf("project_%d", project.ID))
+		extract := func(entryName, dir, prefix string, allowed func(ext string) bool) (string, error) {
+			if entryName == "" {
+				return "", nil
+			}
+			entry := entries[entryName]
+			if entry == nil {
+				return "", fmt.Errorf("%w: missing file %s", ErrInvalidArchive, entryName)
+			}
+			ext := strings.ToLower(path.Ext(entryName))
+			if !allowed(ext) {
+				return "", fmt.Errorf("%w: unsupported file %s", ErrInvalidArchive, entryName)
+			}
+			dst := filepath.Join(projectDir, dir, prefix+uuid.New().String()+ext)
+			if err := extractArchiveFile(entry, dst); err != nil {
+				return "", err
+			}
+			written = append(written, dst)
+			return storedUploadPath(s.uploadDir, dst), nil
+		}
+
+		if len(manifest.MosaicSettings) > 0 {
+			st := manifest.MosaicSettings[0]
+			settings := models.MosaicSettings{
+				UserID:          userID,
+				ProjectID:       &project.ID,
+				TileSize:        st.TileSize,
+				TileDensity:     st.TileDensity,
+				ColorAdjustment: st.ColorAdjustment,
+				Style:           st.Style,
+				Seed:            st.Seed,
+			}
+			if err := tx.Create(&settings).Error; err != nil {
+				return err
+			}
+		}
+
+		imageIDs := make(map[uint]uint, len(manifest.Images))
+		for _, img := range manifest.Images {
+			dir, prefix := "", "main_"
+			if img.Type == "tile" {
+				dir, prefix = "tiles", "tile_"
+			}
+			storedPath, err := extract(img.File, dir, prefix, isArchiveImageExt)
+			if err != nil {
+				return err
+			}
+			image := models.Image{
+				UserID:    userID,
+				ProjectID: &project.ID,
+				Type:      img.Type,
+				Path:      storedPath,
+				Filename:  img.Filename,
+				Width:     img.Width,
+				Height:    img.Height,
+				Format:    img.Format,
+				ColorData: img.ColorData,
+			}
+			if err := tx.Create(&image).Error; err != nil {
+				return err
+			}
+			imageIDs[img.ID] = image.ID
+		}
+
+		for _, collection := range manifest.Collections {
+			created := models.TileCollection{
+				UserID:    userID,
+				ProjectID: &project.ID,
+				Name:      collection.Name,
+			}
+			if err := tx.Create(&created).Error; err != nil {
+				return err
+			}
+			for _, oldID := range collection.ImageIDs {
+				newID, ok := imageIDs[oldID]
+				if !ok {
+					continue
+				}
+				if err := tx.Create(&models.CollectionImage{CollectionID: created.ID, ImageID: newID}).Error; err != nil {
+					return err
+				}
+			}
+		}
+
+		mosaicIDs := make(map[uint]uint, len(manifest.Mosaics))
+		for _, m := range manifest.Mosaics {
+			sdPath, err := extract(m.SDFile, "mosaics", "mosaic_sd_", isArchiveImageExt)
+			if err != nil {
+				return err
+			}
+			hdPath, err := extract(m.HDFile, "mosaics", "mosaic_hd_", isArchiveImageExt)
+			if err != nil {
+				return err
+			}
+			placementPath, err := s.importPlacementMap(entries, m.PlacementFile, filepath.Join(projectDir, "mosaics"), imageIDs)
+			if err != nil {
+				return err
+			}
+			if placementPath != "" {
+				written = append(written, resolveUploadPath(s.uploadDir, placementPath))
+			}
+
+			// Jobs cannot resume on another instance
+			status := m.Status
+			if status == "processing" {
+				status = "cancelled"
+			}
+
+			mosaic := models.GeneratedMosaic{
+				UserID:          userID,
+				ProjectID:       project.ID,
+				MainImageID:     imageIDs[m.MainImageID],
+				Status:          status,
+				SDPath:          sdPath,
+				HDPath:          hdPath,
+				PlacementPath:   placementPath,
+				Width:           m.Width,
+				Height:          m.Height,
+				DPI:             m.DPI,
+				TileSize:        m.TileSize,
+				TileDensity:     m.TileDensity,
+				ColorAdjustment: m.ColorAdjustment,
+				Style:           m.Style,
+				Progress:        100,
+				ErrorMessage:    m.ErrorMessage,
+			}
+			if status != "completed" {
+				mosaic.Progress = 0
+			}
+			if m.SourceMosaicID != nil {
+				if sourceID, ok := mosaicIDs[*m.SourceMosaicID]; ok {
+					mosaic.SourceMosaicID = &sourceID
+				}
+			}
+			if m.Snapshot != nil {
+				// Point the snapshot at the imported images. Tiles that were
+				// not part of the archive are dropped.
+				snapshot := *m.Snapshot
+				snapshot.MainImageID = imageIDs[snapshot.MainImageID]
+				snapshot.TileImageIDs = make([]uint, 0, len(m.Snapshot.TileImageIDs))
+				for _, oldID := range m.Snapshot.TileImageIDs {
+					if newID, ok := imageIDs[oldID]; ok {
+						snapshot.TileImageIDs = append(snapshot.TileImageIDs, newID)
+					}
+				}
+				if m.Snapshot.Mask != nil && m.Snapshot.Mask.ImageID != 0 {
+					mask := *m.Snapshot.Mask
+					mask.ImageID = imageIDs[mask.ImageID]
+					snapshot.Mask = &mask
+				}
+				encoded, err := EncodeMosaicSnapshot(&snapshot)
+				if err != nil {
+					return err
+				}
+				mosaic.Snapshot = encoded
+			}
+			if err := tx.Create(&mosaic).Error; err != nil {
+				return err
+			}
+			mosaicIDs[m.ID] = mosaic.ID
+
+			for _, a := range m.Artifacts {
+				artifactPath, err := extract(a.File, "mosaics", "mosaic_export_", isArchiveArtifactExt)
+				if err != nil {
+					return err
+				}
+				if artifactPath != "" && a.Format == render.FormatDZI {
+					tilesDir := deepZoomTilesDir(resolveUploadPath(s.uploadDir, artifactPath))
+					written = append(written, tilesDir)
+					if err := extractDeepZoomTiles(entries, a.File, tilesDir); err != nil {
+						return err
+					}
+				}
+
+				status := a.Status
+				if status == "processing" {
+					status = "cancelled"
+				}
+				artifact := models.MosaicArtifact{
+					MosaicID:     mosaic.ID,
+					Format:       a.Format,
+					Layer:        a.Layer,
+					Options:      a.Options,
+					Status:       status,
+					Path:         artifactPath,
+					Size:         a.Size,
+					ErrorMessage: a.ErrorMessage,
+				}
+				if err := tx.Create(&artifact).Error; err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		cleanup()
+		return nil, err
+	}
+
+	return project, nil
+}
+
+// importPlacementMap writes the placement map stored as entryName into dir
+// with its tile images pointed at the imported ones, and returns its stored
+// path. Tiles that were not part of the archive are recorded as unknown.
+func (s *ProjectArchiveServiceImpl) importPlacementMap(entries map[string]*zip.File, entryName, dir string, imageIDs map[uint]uint) (string, error) {
+	if entryName == "" {
+		return "", nil
+	}
+	entry := entries[entryName]
+	if entry == nil {
+		return "", fmt.Errorf("%w: missing file %s", ErrInvalidArchive, entryName)
+	}
+	if entry.UncompressedSize64 > maxArchiveFileBytes {
+		return "", fmt.Errorf("%w: %s is too large", ErrInvalidArchive, entry.Name)
+	}
+
+	rc, err := entry.Open()
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+
+	var m PlacementMap
+	if err := json.NewDecoder(io.LimitReader(rc, maxArchiveFileBytes)).Decode(&m); err != nil {
+		return "", fmt.Errorf("%w: malformed %s", ErrInvalidArchive, entryName)
+	}
+	for i, oldID := range m.TileImageIDs {
+		m.TileImageIDs[i] = imageIDs[oldID]
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	dst := filepath.Join(dir, "mosaic_placement_"+uuid.New().String()+".json")
+	if err := savePlacementMap(&m, dst); err != nil {
+		os.Remove(dst)
+		return "", err
+	}
+	return storedUploadPath(s.uploadDir, dst), nil
+}
+
+// extractDeepZoomTiles copies the tiles of the Deep Zoom descriptor stored
+// as entryName into tilesDir. Entries that are not named like tiles are
+// ignored, so nothing is written outside the directory.
+func extractDeepZoomTiles(entries map[string]*zip.File, entryName, tilesDir string) error {
+	prefix := deepZoomTilesDir(entryName) + "/"
+	for name, entry := range entries {
+		tile, ok := strings.CutPrefix(name, prefix)
+		if !ok || !deepZoomTileName.MatchString(tile) {
+			continue
+		}
+		if err := extractArchiveFile(entry, filepath.Join(tilesDir, filepath.FromSlash(tile))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readArchiveManifest reads and validates the manifest entry
+func readArchiveManifest(entry *zip.File) (*ArchiveManifest, error) {
+	if entry == nil {
+		return nil, fmt.Errorf("%w: missing manifest.json", ErrInvalidArchive)
+	}
+	if entry.UncompressedSize64 > maxManifestBytes {
+		return nil, fmt.Errorf("%w: manifest is too large", ErrInvalidArchive)
+	}
+
+	rc, err := entry.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	var manifest ArchiveManifest
+	if err := json.NewDecoder(io.LimitReader(rc, maxManifestBytes)).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("%w: malformed manifest.json", ErrInvalidArchive)
+	}
+	if manifest.Format != ArchiveFormat {
+		return nil, fmt.Errorf("%w: not an InkGrid project archive", ErrInvalidArchive)
+	}
+	if manifest.Version < 1 || manifest.Version > ArchiveVersion {
+		return nil, fmt.Errorf("%w %d", ErrUnsupportedArchive, manifest.Version)
+	}
+
+	return &manifest, nil
+}
+
+// writeArchiveFile copies a file from disk into the archive
+func writeArchiveFile(zw *zip.Writer, name, diskPath string) error {
+	src, err := os.Open(diskPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	// Images are already compressed, so they are stored as-is
+	dst, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, src)
+	return err
+}
+
+// extractArchiveFile copies an archive entry to dst, enforcing the per-file
+// size limit regardless of what the entry header claims
+func extractArchiveFile(entry *zip.File, dst string) error {
+	if entry.UncompressedSize64 > maxArchiveFileBytes {
+		return fmt.Errorf("%w: %s is too large", ErrInvalidArchive, entry.Name)
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+
+	rc, err := entry.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+
+	n, err := io.Copy(out, io.LimitReader(rc, maxArchiveFileBytes+1))
+	closeErr := out.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil && n > maxArchiveFileBytes {
+		err = fmt.Errorf("%w: %s is too large", ErrInvalidArchive, entry.Name)
+	}
+	if err != nil {
+		os.Remove(dst)
+		return err
+	}
+	return nil
+}
+
+// isArchiveImageExt reports whether an archive entry has an image extension
+func isArchiveImageExt(ext string) bool {
+	switch ext {
+	case ".jpg", ".jpeg", ".png", ".webp", ".heic":
+		return true
+	}
+	return false
+}
+
+// isArchiveArtifactExt reports whether an archive entry has the extension
+// of an exported mosaic file
+func isArchiveArtifactExt(ext string) bool {
+	switch ext {
+	case ".jpg", ".png", ".webp", ".tif", ".pdf", ".dzi", ".gif", ".zip":
+		return true
+	}
+	return false
+}
+
+// resolveUploadPath resolves a stored file path to its location on disk.
+// Stored paths are relative to the uploads directory and may carry a
+// leading /uploads prefix.
+func resolveUploadPath(uploadDir, storedPath string) string {
+	cleaned := path.Clean("/" + strings.TrimPrefix(filepath.ToSlash(storedPath), "/uploads/"))
+	return filepath.Join(uploadDir, filepath.FromSlash(cleaned))
+}
+
+// storedUploadPath converts a file location under the uploads directory to
+// the path format stored on records
+func storedUploadPath(uploadDir, dst string) string {
+	storedPath := strings.TrimPrefix(dst, uploadDir)
+	if !strings.HasPrefix(storedPath, "/") {
+		storedPath = "/" + storedPath
+	}
+	return storedPath
+}
@@ -0,0 +1,192 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/amityadav9314/goinkgrid/internal/db/models"
+	"github.com/amityadav9314/goinkgrid/pkg/render"
+)
+
+// writeStored writes data to a stored path below uploadDir
+func writeStored(t *testing.T, uploadDir, storedPath, data string) {
+	t.Helper()
+	diskPath := resolveUploadPath(uploadDir, storedPath)
+	if err := os.MkdirAll(filepath.Dir(diskPath), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(diskPath, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func create(t *testing.T, s *ProjectArchiveServiceImpl, value interface{}) {
+	t.Helper()
+	if err := s.db.Create(value).Error; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestArchiveKeepsPlacementMapsAndArtifacts(t *testing.T) {
+	uploadDir := t.TempDir()
+	s := &ProjectArchiveServiceImpl{db: newTestDB(t), uploadDir: uploadDir}
+
+	project := models.Project{UserID: 1, Name: "Garden"}
+	create(t, s, &project)
+	main := models.Image{UserID: 1, ProjectID: &project.ID, Type: "main", Path: "/user_1/main.jpg"}
+	create(t, s, &main)
+	tile := models.Image{UserID: 1, ProjectID: &project.ID, Type: "tile", Path: "/user_1/tile.jpg"}
+	create(t, s, &tile)
+	writeStored(t, uploadDir, main.Path, "main")
+	writeStored(t, uploadDir, tile.Path, "tile")
+
+	placement := PlacementMap{Width: 10, Height: 10, CellSize: 10, Cols: 1, Rows: 1,
+		TileImageIDs: []uint{tile.ID}, TileSizes: [][2]int{{64, 64}}, Cells: []int{0}, Errors: []float32{0}}
+	data, err := json.Marshal(placement)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mosaic := models.GeneratedMosaic{UserID: 1, ProjectID: project.ID, MainImageID: main.ID, Status: "completed",
+		PlacementPath: "/user_1/mosaics/placement.json", TileSize: 20, TileDensity: 50, Style: "classic"}
+	create(t, s, &mosaic)
+	writeStored(t, uploadDir, mosaic.PlacementPath, string(data))
+
+	png := models.MosaicArtifact{MosaicID: mosaic.ID, Format: render.FormatPNG, Status: "completed", Path: "/user_1/mosaics/export.png", Size: 3}
+	create(t, s, &png)
+	writeStored(t, uploadDir, png.Path, "png")
+	dzi := models.MosaicArtifact{MosaicID: mosaic.ID, Format: render.FormatDZI, Status: "completed", Path: "/user_1/mosaics/export.dzi"}
+	create(t, s, &dzi)
+	writeStored(t, uploadDir, dzi.Path, "dzi")
+	writeStored(t, uploadDir, "/user_1/mosaics/export_files/0/0_0.jpg", "zoom tile")
+
+	var buf bytes.Buffer
+	if err := s.Export(project.ID, &buf); err != nil {
+		t.Fatalf("Export: %v", err)
+	}
+	imported, err := s.Import(2, bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("Import: %v", err)
+	}
+
+	var newTile models.Image
+	if err := s.db.Where("project_id = ? AND type = ?", imported.ID, "tile").First(&newTile).Error; err != nil {
+		t.Fatal(err)
+	}
+	var newMosaic models.GeneratedMosaic
+	if err := s.db.Where("project_id = ?", imported.ID).First(&newMosaic).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// The placement map points at the imported tile
+	got, err := (&MosaicServiceImpl{uploadDir: uploadDir}).GetPlacementMap(&newMosaic)
+	if err != nil {
+		t.Fatalf("GetPlacementMap: %v", err)
+	}
+	if len(got.TileImageIDs) != 1 || got.TileImageIDs[0] != newTile.ID {
+		t.Fatalf("placement tiles %v, want [%d]", got.TileImageIDs, newTile.ID)
+	}
+
+	var artifacts []models.MosaicArtifact
+	if err := s.db.Where("mosaic_id = ?", newMosaic.ID).Order("id").Find(&artifacts).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(artifacts) != 2 || artifacts[0].Format != render.FormatPNG || artifacts[1].Format != render.FormatDZI {
+		t.Fatalf("imported artifacts %+v", artifacts)
+	}
+	dziPath := resolveUploadPath(uploadDir, artifacts[1].Path)
+	wantFiles := map[string]string{
+		resolveUploadPath(uploadDir, artifacts[0].Path): "png",
+		dziPath: "dzi",
+		filepath.Join(deepZoomTilesDir(dziPath), "0", "0_0.jpg"): "zoom tile",
+	}
+	for diskPath, want := range wantFiles {
+		data, err := os.ReadFile(diskPath)
+		if err != nil || string(data) != want {
+			t.Errorf("%s: got %q, %v, want %q", diskPath, data, err, want)
+		}
+	}
+}
+
+func TestArchiveKeepsTheOwnersSettings(t *testing.T) {
+	s := &ProjectArchiveServiceImpl{db: newTestDB(t), uploadDir: t.TempDir()}
+	project := models.Project{UserID: 1, Name: "Garden"}
+	create(t, s, &project)
+
+	// A member's settings are saved before the owner's, so taking the first
+	// row of the project would pick them
+	create(t, s, &models.MosaicSettings{UserID: 2, ProjectID: &project.ID, TileSize: 90, Style: "random"})
+	create(t, s, &models.MosaicSettings{UserID: 1, ProjectID: &project.ID, TileSize: 30, Style: "classic"})
+
+	var buf bytes.Buffer
+	if err := s.Export(project.ID, &buf); err != nil {
+		t.Fatalf("Export: %v", err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entry *zip.File
+	for _, f := range zr.File {
+		if f.Name == archiveManifestName {
+			entry = f
+		}
+	}
+	manifest, err := readArchiveManifest(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.MosaicSettings) != 1 || manifest.MosaicSettings[0].TileSize != 30 {
+		t.Fatalf("exported settings %+v, want only the owner's", manifest.MosaicSettings)
+	}
+
+	imported, err := s.Import(3, bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("Import: %v", err)
+	}
+	var settings []models.MosaicSettings
+	s.db.Where("project_id = ?", imported.ID).Find(&settings)
+	if len(settings) != 1 || settings[0].UserID != 3 || settings[0].TileSize != 30 {
+		t.Fatalf("imported settings %+v, want the owner's for the importer", settings)
+	}
+}
+
+func TestImportRejectsInvalidArchives(t *testing.T) {
+	s := &ProjectArchiveServiceImpl{db: newTestDB(t), uploadDir: t.TempDir()}
+
+	archive := func(manifest string) []byte {
+		var buf bytes.Buffer
+		zw := zip.NewWriter(&buf)
+		entry, err := zw.Create(archiveManifestName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entry.Write([]byte(manifest))
+		if err := zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"not a ZIP file", []byte("not a zip"), ErrInvalidArchive},
+		{"malformed manifest", archive("{"), ErrInvalidArchive},
+		{"other format", archive(`{"format":"other","version":1}`), ErrInvalidArchive},
+		{"newer version", archive(`{"format":"inkgrid-project","version":99}`), ErrUnsupportedArchive},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := s.Import(1, bytes.NewReader(tt.data), int64(len(tt.data))); !errors.Is(err, tt.want) {
+				t.Fatalf("got error %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
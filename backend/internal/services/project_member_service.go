@@ -0,0 +1,207 @@
+package services
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/amityadav9314/goinkgrid/internal/db/models"
+	"gorm.io/gorm"
+)
+
+// Project roles, in increasing order of privilege
+const (
+	ProjectRoleViewer = "viewer"
+	ProjectRoleEditor = "editor"
+	ProjectRoleOwner  = "owner"
+)
+
+var projectRoleRank = map[string]int{
+	ProjectRoleViewer: 1,
+	ProjectRoleEditor: 2,
+	ProjectRoleOwner:  3,
+}
+
+// ProjectRoleAllows reports whether role grants at least the required role
+func ProjectRoleAllows(role, required string) bool {
+	return projectRoleRank[role] > 0 && projectRoleRank[role] >= projectRoleRank[required]
+}
+
+// ProjectMemberServiceImpl implements the ProjectMemberService interface
+type ProjectMemberServiceImpl struct {
+	db *gorm.DB
+}
+
+// NewProjectMemberService creates a new ProjectMemberService implementation
+func NewProjectMemberService(db *gorm.DB) ProjectMemberService {
+	return &ProjectMemberServiceImpl{
+		db: db,
+	}
+}
+
+// RoleFor returns the project and the role the user holds on it. The
+// project creator is always an owner. An empty role means no access.
+func (s *ProjectMemberServiceImpl) RoleFor(projectID uint, userID uint) (*models.Project, string, error) {
+	var project models.Project
+	result := s.db.First(&project, projectID)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, "", errors.New("project not found")
+		}
+		return nil, "", result.Error
+	}
+
+	if project.UserID == userID {
+		return &project, ProjectRoleOwner, nil
+	}
+
+	var member models.ProjectMember
+	result = s.db.Where("project_id = ? AND user_id = ? AND accepted_at IS NOT NULL", projectID, userID).First(&member)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return &project, "", nil
+		}
+		return nil, "", result.Error
+	}
+
+	return &project, member.Role, nil
+}
+
+// FindByProjectID lists the members and pending invites of a project
+func (s *ProjectMemberServiceImpl) FindByProjectID(projectID uint) ([]models.ProjectMember, error) {
+	var members []models.ProjectMember
+	result := s.db.Where("project_id = ?", projectID).Order("created_at").Find(&members)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return members, nil
+}
+
+// FindMembershipsForUser returns the accepted memberships of a user
+func (s *ProjectMemberServiceImpl) FindMembershipsForUser(userID uint) ([]models.ProjectMember, error) {
+	var members []models.ProjectMember
+	result := s.db.Where("user_id = ? AND accepted_at IS NOT NULL", userID).Find(&members)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return members, nil
+}
+
+// Invite creates an invite for an email address and returns the plaintext
+// invite token, which is only available here
+func (s *ProjectMemberServiceImpl) Invite(projectID uint, invitedByID uint, email string, role string) (string, *models.ProjectMember, error) {
+	if projectRoleRank[role] == 0 {
+		return "", nil, errors.New("invalid role")
+	}
+	email = strings.ToLower(strings.TrimSpace(email))
+
+	var count int64
+	if err := s.db.Model(&models.ProjectMember{}).Where("project_id = ? AND email = ?", projectID, email).Count(&count).Error; err != nil {
+		return "", nil, err
+	}
+	if count > 0 {
+		return "", nil, errors.New("this email has already been invited to the project")
+	}
+
+	token, err := randomHex(24)
+	if err != nil {
+		return "", nil, err
+	}
+
+	member := &models.ProjectMember{
+		ProjectID:       projectID,
+		Email:           email,
+		Role:            role,
+		InvitedByID:     invitedByID,
+		InviteTokenHash: hashToken(token),
+	}
+	if err := s.db.Create(member).Error; err != nil {
+		return "", nil, err
+	}
+
+	return token, member, nil
+}
+
+// UpdateRole changes the role of a project member
+func (s *ProjectMemberServiceImpl) UpdateRole(projectID uint, memberID uint, role string) error {
+	if projectRoleRank[role] == 0 {
+		return errors.New("invalid role")
+	}
+
+	result := s.db.Model(&models.ProjectMember{}).
+		Where("id = ? AND project_id = ?", memberID, projectID).
+		Update("role", role)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("member not found")
+	}
+	return nil
+}
+
+// FindByID finds a member of a project
+func (s *ProjectMemberServiceImpl) FindByID(projectID uint, memberID uint) (*models.ProjectMember, error) {
+	var member models.ProjectMember
+	result := s.db.Where("id = ? AND project_id = ?", memberID, projectID).First(&member)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, errors.New("member not found")
+		}
+		return nil, result.Error
+	}
+	return &member, nil
+}
+
+// Remove removes a member or pending invite from a project
+func (s *ProjectMemberServiceImpl) Remove(projectID uint, memberID uint) error {
+	result := s.db.Where("id = ? AND project_id = ?", memberID, projectID).Delete(&models.ProjectMember{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("member not found")
+	}
+	return nil
+}
+
+// FindPendingByEmail lists invites that have not been accepted yet
+func (s *ProjectMemberServiceImpl) FindPendingByEmail(email string) ([]models.ProjectMember, error) {
+	var members []models.ProjectMember
+	result := s.db.Where("email = ? AND accepted_at IS NULL", strings.ToLower(email)).Order("created_at DESC").Find(&members)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return members, nil
+}
+
+// Accept binds an invite to the user. The invite must have been sent to the
+// user's email address.
+func (s *ProjectMemberServiceImpl) Accept(token string, user *models.User) (*models.ProjectMember, error) {
+	var member models.ProjectMember
+	result := s.db.Where("invite_token_hash = ?", hashToken(token)).First(&member)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, errors.New("invite not found")
+		}
+		return nil, result.Error
+	}
+
+	if member.Email != strings.ToLower(user.Email) {
+		return nil, errors.New("this invite was sent to a different email address")
+	}
+	if member.AcceptedAt != nil {
+		return nil, errors.New("invite has already been accepted")
+	}
+
+	now := time.Now()
+	member.UserID = &user.ID
+	member.AcceptedAt = &now
+	// The token is single use
+	member.InviteTokenHash = ""
+	if err := s.db.Save(&member).Error; err != nil {
+		return nil, err
+	}
+
+	return &member, nil
+}
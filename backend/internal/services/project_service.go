@@ -37,6 +37,18 @@ func (s *ProjectServiceImpl) FindByUserID(userID uint) ([]models.Project, error)
 	return projects, nil
 }
 
+func (s *ProjectServiceImpl) FindByIDs(ids []uint) ([]models.Project, error) {
+	var projects []models.Project
+	if len(ids) == 0 {
+		return projects, nil
+	}
+	result := s.db.Where("id IN ?", ids).Find(&projects)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return projects, nil
+}
+
 func (s *ProjectServiceImpl) Create(project *models.Project) error {
 	result := s.db.Create(project)
 	return result.Error
@@ -47,6 +59,8 @@ func (s *ProjectServiceImpl) Update(project *models.Project) error {
 	return result.Error
 }
 
+// Delete moves a project to the trash. The trash service purges it and its
+// files once the retention period has passed.
 func (s *ProjectServiceImpl) Delete(id uint, userID uint) error {
 	result := s.db.Where("id = ? AND user_id = ?", id, userID).Delete(&models.Project{})
 	if result.Error != nil {
@@ -57,3 +71,132 @@ func (s *ProjectServiceImpl) Delete(id uint, userID uint) error {
 	}
 	return nil
 }
+
+// FindTemplates lists projects that have been marked as templates
+func (s *ProjectServiceImpl) FindTemplates() ([]models.Project, error) {
+	var projects []models.Project
+	result := s.db.Where("is_template = ?", true).Order("updated_at DESC").Find(&projects)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return projects, nil
+}
+
+// Duplicate copies a project, its settings JSON and mosaic settings into a
+// new project owned by userID. When includeImages is set the project's
+// images and tile collections are copied too. Image rows are copied by
+// reference, so the copies share the stored files with the source.
+func (s *ProjectServiceImpl) Duplicate(sourceID uint, userID uint, name string, includeImages bool) (*models.Project, error) {
+	var source models.Project
+	if err := s.db.First(&source, sourceID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("project not found")
+		}
+		return nil, err
+	}
+
+	if name == "" {
+		name = source.Name + " (copy)"
+	}
+
+	project := &models.Project{
+		UserID:      userID,
+		Name:        name,
+		Description: source.Description,
+		Settings:    source.Settings,
+		Status:      "new",
+	}
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(project).Error; err != nil {
+			return err
+		}
+
+		// Prefer the caller's own settings for the source project, then the owner's
+		var settings models.MosaicSettings
+		result := tx.Where("project_id = ?", source.ID).
+			Order(gorm.Expr("CASE WHEN user_id = ? THEN 0 WHEN user_id = ? THEN 1 ELSE 2 END", userID, source.UserID)).
+			First(&settings)
+		if result.Error == nil {
+			copied := models.MosaicSettings{
+				UserID:          userID,
+				ProjectID:       &project.ID,
+				TileSize:        settings.TileSize,
+				TileDensity:     settings.TileDensity,
+				ColorAdjustment: settings.ColorAdjustment,
+				Style:           settings.Style,
+				Seed:            settings.Seed,
+			}
+			if err := tx.Create(&copied).Error; err != nil {
+				return err
+			}
+		} else if !errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return result.Error
+		}
+
+		if !includeImages {
+			return nil
+		}
+		return copyProjectImages(tx, source.ID, project.ID, userID)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return project, nil
+}
+
+// copyProjectImages copies the image rows and tile collections of a project
+// into another project, remapping collection membership to the new rows
+func copyProjectImages(tx *gorm.DB, sourceID uint, targetID uint, userID uint) error {
+	var images []models.Image
+	if err := tx.Where("project_id = ?", sourceID).Find(&images).Error; err != nil {
+		return err
+	}
+
+	imageIDs := make(map[uint]uint, len(images))
+	for _, img := range images {
+		copied := models.Image{
+			UserID:    userID,
+			ProjectID: &targetID,
+			Type:      img.Type,
+			Path:      img.Path,
+			Filename:  img.Filename,
+			Width:     img.Width,
+			Height:    img.Height,
+			Format:    img.Format,
+			ColorData: img.ColorData,
+		}
+		if err := tx.Create(&copied).Error; err != nil {
+			return err
+		}
+		imageIDs[img.ID] = copied.ID
+	}
+
+	var collections []models.TileCollection
+	if err := tx.Preload("Images").Where("project_id = ?", sourceID).Find(&collections).Error; err != nil {
+		return err
+	}
+	for _, collection := range collections {
+		copied := models.TileCollection{
+			UserID:    userID,
+			ProjectID: &targetID,
+			Name:      collection.Name,
+		}
+		if err := tx.Create(&copied).Error; err != nil {
+			return err
+		}
+		for _, img := range collection.Images {
+			newID, ok := imageIDs[img.ID]
+			if !ok {
+				// Collections may hold images from outside the project
+				continue
+			}
+			if err := tx.Create(&models.CollectionImage{CollectionID: copied.ID, ImageID: newID}).Error; err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,127 @@
+package services
+
+import (
+	"errors"
+	"time"
+
+	"github.com/amityadav9314/goinkgrid/internal/db/models"
+	"gorm.io/gorm"
+)
+
+// AuthEventSharePassword is recorded for password attempts on share links.
+// The link token is stored in place of the email so attempts are throttled
+// per link.
+const AuthEventSharePassword = "share_password"
+
+// ShareLinkServiceImpl implements the ShareLinkService interface
+type ShareLinkServiceImpl struct {
+	db *gorm.DB
+}
+
+// NewShareLinkService creates a new ShareLinkService implementation
+func NewShareLinkService(db *gorm.DB) ShareLinkService {
+	return &ShareLinkServiceImpl{
+		db: db,
+	}
+}
+
+// Create creates a share link for a completed mosaic
+func (s *ShareLinkServiceImpl) Create(mosaicID uint, createdByID uint, passwordHash string, expiresAt *time.Time) (*models.MosaicShareLink, error) {
+	var mosaic models.GeneratedMosaic
+	if err := s.db.First(&mosaic, mosaicID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("mosaic not found")
+		}
+		return nil, err
+	}
+	if mosaic.Status != "completed" {
+		return nil, errors.New("only completed mosaics can be shared")
+	}
+	if expiresAt != nil && expiresAt.Before(time.Now()) {
+		return nil, errors.New("expiry must be in the future")
+	}
+
+	token, err := randomHex(16)
+	if err != nil {
+		return nil, err
+	}
+
+	link := &models.MosaicShareLink{
+		MosaicID:     mosaicID,
+		CreatedByID:  createdByID,
+		Token:        token,
+		PasswordHash: passwordHash,
+		ExpiresAt:    expiresAt,
+	}
+	if err := s.db.Create(link).Error; err != nil {
+		return nil, err
+	}
+
+	return link, nil
+}
+
+// FindByMosaicID lists the share links of a mosaic, including revoked ones
+func (s *ShareLinkServiceImpl) FindByMosaicID(mosaicID uint) ([]models.MosaicShareLink, error) {
+	var links []models.MosaicShareLink
+	result := s.db.Where("mosaic_id = ?", mosaicID).Order("created_at DESC").Find(&links)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return links, nil
+}
+
+// Revoke revokes a share link of a mosaic
+func (s *ShareLinkServiceImpl) Revoke(mosaicID uint, linkID uint) error {
+	result := s.db.Model(&models.MosaicShareLink{}).
+		Where("id = ? AND mosaic_id = ? AND revoked_at IS NULL", linkID, mosaicID).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("share link not found")
+	}
+	return nil
+}
+
+// Resolve finds an active share link by its token along with its mosaic.
+// Revoked and expired links are reported as not found.
+func (s *ShareLinkServiceImpl) Resolve(token string) (*models.MosaicShareLink, *models.GeneratedMosaic, error) {
+	var link models.MosaicShareLink
+	result := s.db.Where("token = ? AND revoked_at IS NULL", token).First(&link)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, nil, errors.New("share link not found")
+		}
+		return nil, nil, result.Error
+	}
+	if link.ExpiresAt != nil && link.ExpiresAt.Before(time.Now()) {
+		return nil, nil, errors.New("share link not found")
+	}
+
+	var mosaic models.GeneratedMosaic
+	if err := s.db.First(&mosaic, link.MosaicID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, errors.New("share link not found")
+		}
+		return nil, nil, err
+	}
+
+	// Links stop resolving while the mosaic's project is in the trash
+	var projects int64
+	if err := s.db.Model(&models.Project{}).Where("id = ?", mosaic.ProjectID).Count(&projects).Error; err != nil {
+		return nil, nil, err
+	}
+	if projects == 0 {
+		return nil, nil, errors.New("share link not found")
+	}
+
+	return &link, &mosaic, nil
+}
+
+// RecordView increments the view counter of a share link
+func (s *ShareLinkServiceImpl) RecordView(linkID uint) error {
+	return s.db.Model(&models.MosaicShareLink{}).
+		Where("id = ?", linkID).
+		UpdateColumn("view_count", gorm.Expr("view_count + 1")).Error
+}
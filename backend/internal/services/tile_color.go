@@ -0,0 +1,81 @@
+package services
+
+import (
+	"encoding/json"
+	"image"
+
+	"github.com/amityadav9314/goinkgrid/internal/db/models"
+	db "github.com/amityadav9314/goinkgrid/internal/db/postgres"
+	"github.com/amityadav9314/goinkgrid/pkg/render"
+)
+
+// TileThumbSize is the side of the thumbnail kept in a tile's color data
+const TileThumbSize = 16
+
+// TileColorData is the color summary cached on a tile image, so previews
+// can be drawn without opening the tile photo
+type TileColorData struct {
+	Average   [3]uint8 `json:"average"`
+	ThumbSize int      `json:"thumb_size"`
+	Thumb     []byte   `json:"thumb"` // RGB pixels, row by row
+}
+
+// NewTileColorData summarises a tile photo
+func NewTileColorData(img image.Image) *TileColorData {
+	scaled := render.ScaleTile(img, TileThumbSize)
+	data := &TileColorData{
+		ThumbSize: TileThumbSize,
+		Thumb:     make([]byte, 0, TileThumbSize*TileThumbSize*3),
+	}
+
+	var sum [3]int
+	for i := 0; i < len(scaled.Pix); i += 4 {
+		data.Thumb = append(data.Thumb, scaled.Pix[i], scaled.Pix[i+1], scaled.Pix[i+2])
+		for c := 0; c < 3; c++ {
+			sum[c] += int(scaled.Pix[i+c])
+		}
+	}
+	n := len(scaled.Pix) / 4
+	for c := 0; c < 3; c++ {
+		data.Average[c] = uint8((sum[c] + n/2) / n)
+	}
+	return data
+}
+
+// tileColorData returns the color data cached on an image, or nil if it has
+// none or it was made with a different thumbnail size
+func tileColorData(img *models.Image) *TileColorData {
+	if len(img.ColorData) == 0 {
+		return nil
+	}
+	var data TileColorData
+	if err := json.Unmarshal(img.ColorData, &data); err != nil {
+		return nil
+	}
+	if data.ThumbSize != TileThumbSize || len(data.Thumb) != TileThumbSize*TileThumbSize*3 {
+		return nil
+	}
+	return &data
+}
+
+// saveTileColorData caches color data on an image
+func saveTileColorData(img *models.Image, data *TileColorData) error {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	img.ColorData = encoded
+	return db.DB.Model(&models.Image{}).Where("id = ?", img.ID).Update("color_data", img.ColorData).Error
+}
+
+// Thumbnail returns the cached thumbnail as an image
+func (d *TileColorData) Thumbnail() *image.RGBA {
+	thumb := image.NewRGBA(image.Rect(0, 0, d.ThumbSize, d.ThumbSize))
+	for i, j := 0, 0; j+2 < len(d.Thumb); i, j = i+4, j+3 {
+		thumb.Pix[i] = d.Thumb[j]
+		thumb.Pix[i+1] = d.Thumb[j+1]
+		thumb.Pix[i+2] = d.Thumb[j+2]
+		thumb.Pix[i+3] = 255
+	}
+	return thumb
+}
@@ -0,0 +1,346 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/amityadav9314/goinkgrid/internal/db/models"
+	"github.com/amityadav9314/goinkgrid/pkg/render"
+	"gorm.io/gorm"
+)
+
+// TrashConfig configures how long deleted items are kept
+type TrashConfig struct {
+	Retention     time.Duration // how long items stay in the trash
+	PurgeInterval time.Duration // how often the purger runs
+}
+
+// Trash lists a user's deleted projects and images
+type Trash struct {
+	Projects []models.Project `json:"projects"`
+	Images   []models.Image   `json:"images"`
+}
+
+// TrashServiceImpl implements the TrashService interface
+type TrashServiceImpl struct {
+	db        *gorm.DB
+	uploadDir string
+	cfg       TrashConfig
+}
+
+// NewTrashService creates a new TrashService implementation
+func NewTrashService(db *gorm.DB, uploadDir string, cfg TrashConfig) TrashService {
+	if cfg.Retention <= 0 {
+		cfg.Retention = 30 * 24 * time.Hour
+	}
+	if cfg.PurgeInterval <= 0 {
+		cfg.PurgeInterval = time.Hour
+	}
+	return &TrashServiceImpl{
+		db:        db,
+		uploadDir: uploadDir,
+		cfg:       cfg,
+	}
+}
+
+// Retention returns how long deleted items are kept before being purged
+func (s *TrashServiceImpl) Retention() time.Duration {
+	return s.cfg.Retention
+}
+
+// List returns the user's deleted projects and images, most recent first
+func (s *TrashServiceImpl) List(userID uint) (*Trash, error) {
+	trash := &Trash{}
+	if err := s.db.Unscoped().
+		Where("user_id = ? AND deleted_at IS NOT NULL", userID).
+		Order("deleted_at DESC").
+		Find(&trash.Projects).Error; err != nil {
+		return nil, err
+	}
+	if err := s.db.Unscoped().
+		Where("user_id = ? AND deleted_at IS NOT NULL", userID).
+		Order("deleted_at DESC").
+		Find(&trash.Images).Error; err != nil {
+		return nil, err
+	}
+	return trash, nil
+}
+
+// RestoreProject moves a project out of the trash
+func (s *TrashServiceImpl) RestoreProject(userID uint, projectID uint) error {
+	result := s.db.Unscoped().Model(&models.Project{}).
+		Where("id = ? AND user_id = ? AND deleted_at IS NOT NULL", projectID, userID).
+		Update("deleted_at", nil)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("project not found in trash")
+	}
+	return nil
+}
+
+// RestoreImage moves an image out of the trash
+func (s *TrashServiceImpl) RestoreImage(userID uint, imageID uint) error {
+	result := s.db.Unscoped().Model(&models.Image{}).
+		Where("id = ? AND user_id = ? AND deleted_at IS NOT NULL", imageID, userID).
+		Update("deleted_at", nil)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("image not found in trash")
+	}
+	return nil
+}
+
+// PurgeProject permanently deletes a trashed project of the user
+func (s *TrashServiceImpl) PurgeProject(userID uint, projectID uint) error {
+	var project models.Project
+	result := s.db.Unscoped().Where("id = ? AND user_id = ? AND deleted_at IS NOT NULL", projectID, userID).First(&project)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return errors.New("project not found in trash")
+		}
+		return result.Error
+	}
+	return s.purgeProject(&project)
+}
+
+// PurgeImage permanently deletes a trashed image of the user
+func (s *TrashServiceImpl) PurgeImage(userID uint, imageID uint) error {
+	var image models.Image
+	result := s.db.Unscoped().Where("id = ? AND user_id = ? AND deleted_at IS NOT NULL", imageID, userID).First(&image)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return errors.New("image not found in trash")
+		}
+		return result.Error
+	}
+	return s.purgeImage(&image)
+}
+
+// PurgeExpired permanently deletes every project and image that has been in
+// the trash for longer than the retention period. An item that fails to
+// purge is logged and left for the next run, so it does not hold back the
+// others.
+func (s *TrashServiceImpl) PurgeExpired() (int, error) {
+	cutoff := time.Now().Add(-s.cfg.Retention)
+	purged := 0
+
+	var projects []models.Project
+	if err := s.db.Unscoped().Where("deleted_at IS NOT NULL AND deleted_at < ?", cutoff).Find(&projects).Error; err != nil {
+		return purged, err
+	}
+	for i := range projects {
+		if err := s.purgeProject(&projects[i]); err != nil {
+			log.Printf("trash purger: failed to purge project %d: %v", projects[i].ID, err)
+			continue
+		}
+		purged++
+	}
+
+	var images []models.Image
+	if err := s.db.Unscoped().Where("deleted_at IS NOT NULL AND deleted_at < ?", cutoff).Find(&images).Error; err != nil {
+		return purged, err
+	}
+	for i := range images {
+		if err := s.purgeImage(&images[i]); err != nil {
+			log.Printf("trash purger: failed to purge image %d: %v", images[i].ID, err)
+			continue
+		}
+		purged++
+	}
+
+	return purged, nil
+}
+
+// RunPurger purges expired trash on every interval until ctx is cancelled
+func (s *TrashServiceImpl) RunPurger(ctx context.Context) {
+	ticker := time.NewTicker(s.cfg.PurgeInterval)
+	defer ticker.Stop()
+
+	for {
+		if purged, err := s.PurgeExpired(); err != nil {
+			log.Printf("trash purger: %v", err)
+		} else if purged > 0 {
+			log.Printf("trash purger: purged %d items", purged)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// purgeProject deletes a project and everything that belongs to it in one
+// transaction, then removes the files that are no longer referenced
+func (s *TrashServiceImpl) purgeProject(project *models.Project) error {
+	var images []models.Image
+	if err := s.db.Unscoped().Where("project_id = ?", project.ID).Find(&images).Error; err != nil {
+		return err
+	}
+	var mosaics []models.GeneratedMosaic
+	if err := s.db.Where("project_id = ?", project.ID).Find(&mosaics).Error; err != nil {
+		return err
+	}
+
+	imageIDs := make([]uint, 0, len(images))
+	files := make([]string, 0, len(images)+3*len(mosaics))
+	for _, img := range images {
+		imageIDs = append(imageIDs, img.ID)
+		files = append(files, img.Path)
+	}
+	mosaicIDs := make([]uint, 0, len(mosaics))
+	var tileDirs []string
+	for _, mosaic := range mosaics {
+		mosaicIDs = append(mosaicIDs, mosaic.ID)
+		files = append(files, mosaic.SDPath, mosaic.HDPath, mosaic.PlacementPath)
+	}
+	if len(mosaicIDs) > 0 {
+		var artifacts []models.MosaicArtifact
+		if err := s.db.Where("mosaic_id IN ?", mosaicIDs).Find(&artifacts).Error; err != nil {
+			return err
+		}
+		for _, artifact := range artifacts {
+			files = append(files, artifact.Path)
+			if artifact.Format == render.FormatDZI && artifact.Path != "" {
+				tileDirs = append(tileDirs, deepZoomTilesDir(resolveUploadPath(s.uploadDir, artifact.Path)))
+			}
+		}
+	}
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		collections := tx.Model(&models.TileCollection{}).Select("id").Where("project_id = ?", project.ID)
+		if err := tx.Where("collection_id IN (?)", collections).Delete(&models.CollectionImage{}).Error; err != nil {
+			return err
+		}
+		if len(imageIDs) > 0 {
+			if err := tx.Where("image_id IN ?", imageIDs).Delete(&models.CollectionImage{}).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Where("project_id = ?", project.ID).Delete(&models.TileCollection{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Where("project_id = ?", project.ID).Delete(&models.Image{}).Error; err != nil {
+			return err
+		}
+		if len(mosaicIDs) > 0 {
+			if err := tx.Where("mosaic_id IN ?", mosaicIDs).Delete(&models.MosaicShareLink{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("mosaic_id IN ?", mosaicIDs).Delete(&models.MosaicArtifact{}).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Where("project_id = ?", project.ID).Delete(&models.GeneratedMosaic{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("project_id = ?", project.ID).Delete(&models.MosaicSettings{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("project_id = ?", project.ID).Delete(&models.ProjectMember{}).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Delete(project).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	s.removeUnreferencedFiles(files)
+	for _, dir := range tileDirs {
+		if err := os.RemoveAll(dir); err != nil {
+			log.Printf("trash purger: failed to remove %s: %v", dir, err)
+		}
+	}
+	s.removeEmptyDirs(filepath.Join(s.uploadDir, fmt.Sprintf("user_%d", project.UserID), fmt.Sprintf("project_%d", project.ID)))
+	return nil
+}
+
+// purgeImage deletes an image row and its collection memberships, then
+// removes its file if nothing else references it
+func (s *TrashServiceImpl) purgeImage(image *models.Image) error {
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("image_id = ?", image.ID).Delete(&models.CollectionImage{}).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Delete(image).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	s.removeUnreferencedFiles([]string{image.Path})
+	return nil
+}
+
+// removeUnreferencedFiles deletes stored files unless another image, avatar
+// or mosaic still points at them. Duplicated projects share files with
+// their source, so a file may outlive the row it was uploaded with.
+func (s *TrashServiceImpl) removeUnreferencedFiles(storedPaths []string) {
+	for _, storedPath := range storedPaths {
+		if storedPath == "" {
+			continue
+		}
+
+		// A file is kept when its references cannot be counted
+		references, err := s.countFileReferences(storedPath)
+		if err != nil {
+			log.Printf("trash purger: keeping %s, failed to count references: %v", storedPath, err)
+			continue
+		}
+		if references > 0 {
+			continue
+		}
+
+		if err := os.Remove(resolveUploadPath(s.uploadDir, storedPath)); err != nil && !os.IsNotExist(err) {
+			log.Printf("trash purger: failed to remove %s: %v", storedPath, err)
+		}
+	}
+}
+
+// countFileReferences returns the number of images, avatars, mosaics and
+// artifacts that point at a stored file
+func (s *TrashServiceImpl) countFileReferences(storedPath string) (int64, error) {
+	queries := []*gorm.DB{
+		s.db.Unscoped().Model(&models.Image{}).Where("path = ?", storedPath),
+		s.db.Model(&models.User{}).Where("avatar_path = ?", storedPath),
+		s.db.Model(&models.GeneratedMosaic{}).Where("sd_path = ? OR hd_path = ? OR placement_path = ?", storedPath, storedPath, storedPath),
+		s.db.Model(&models.MosaicArtifact{}).Where("path = ?", storedPath),
+	}
+
+	var references int64
+	for _, query := range queries {
+		var count int64
+		if err := query.Count(&count).Error; err != nil {
+			return 0, err
+		}
+		references += count
+	}
+	return references, nil
+}
+
+// removeEmptyDirs removes a directory tree bottom-up, leaving any directory
+// that still has files in it
+func (s *TrashServiceImpl) removeEmptyDirs(dir string) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			s.removeEmptyDirs(filepath.Join(dir, entry.Name()))
+		}
+	}
+	// Fails harmlessly when the directory is not empty
+	os.Remove(dir)
+}
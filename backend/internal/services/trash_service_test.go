@@ -0,0 +1,291 @@
+package services
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/amityadav9314/goinkgrid/internal/db/models"
+	"github.com/amityadav9314/goinkgrid/pkg/render"
+	"gorm.io/gorm"
+)
+
+func TestRemoveUnreferencedFiles(t *testing.T) {
+	gdb := newTestDB(t)
+	uploadDir := t.TempDir()
+	s := &TrashServiceImpl{db: gdb, uploadDir: uploadDir}
+
+	for _, name := range []string{"shared.jpg", "orphan.jpg"} {
+		if err := os.WriteFile(filepath.Join(uploadDir, name), []byte("data"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := gdb.Create(&models.Image{UserID: 1, Path: "/uploads/shared.jpg"}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	s.removeUnreferencedFiles([]string{"/uploads/shared.jpg", "/uploads/orphan.jpg"})
+	if _, err := os.Stat(filepath.Join(uploadDir, "shared.jpg")); err != nil {
+		t.Errorf("a file still in use was removed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(uploadDir, "orphan.jpg")); !os.IsNotExist(err) {
+		t.Errorf("an unused file was kept: %v", err)
+	}
+}
+
+func TestRemoveUnreferencedFilesKeepsFilesWhenTheDatabaseFails(t *testing.T) {
+	gdb := newTestDB(t)
+	uploadDir := t.TempDir()
+	s := &TrashServiceImpl{db: gdb, uploadDir: uploadDir}
+
+	if err := os.WriteFile(filepath.Join(uploadDir, "file.jpg"), []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := gdb.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.Close()
+
+	s.removeUnreferencedFiles([]string{"/uploads/file.jpg"})
+	if _, err := os.Stat(filepath.Join(uploadDir, "file.jpg")); err != nil {
+		t.Errorf("file was removed although its references could not be counted: %v", err)
+	}
+}
+
+// trashFixture is a user's project with a main image and a tile, stored
+// under a temporary upload directory
+type trashFixture struct {
+	db        *gorm.DB
+	uploadDir string
+	trash     *TrashServiceImpl
+	projects  ProjectService
+	images    ImageService
+	project   models.Project
+	main      models.Image
+	tile      models.Image
+}
+
+func newTrashFixture(t *testing.T) *trashFixture {
+	t.Helper()
+	gdb := newTestDB(t)
+	f := &trashFixture{
+		db:        gdb,
+		uploadDir: t.TempDir(),
+		projects:  NewProjectService(gdb),
+		images:    NewImageService(gdb),
+		project:   models.Project{UserID: 1, Name: "Holiday"},
+	}
+	f.trash = NewTrashService(gdb, f.uploadDir, TrashConfig{}).(*TrashServiceImpl)
+	f.create(t, &f.project)
+	f.main = f.image(t, "main", "/uploads/user_1/project_1/main.jpg")
+	f.tile = f.image(t, "tile", "/uploads/user_1/project_1/tile.jpg")
+	return f
+}
+
+func (f *trashFixture) create(t *testing.T, value interface{}) {
+	t.Helper()
+	if err := f.db.Create(value).Error; err != nil {
+		t.Fatalf("create %T: %v", value, err)
+	}
+}
+
+// image stores an image of the fixture's project and its file
+func (f *trashFixture) image(t *testing.T, kind, storedPath string) models.Image {
+	t.Helper()
+	f.writeFile(t, storedPath)
+	img := models.Image{UserID: 1, ProjectID: &f.project.ID, Type: kind, Path: storedPath}
+	f.create(t, &img)
+	return img
+}
+
+func (f *trashFixture) writeFile(t *testing.T, storedPath string) {
+	t.Helper()
+	path := resolveUploadPath(f.uploadDir, storedPath)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (f *trashFixture) exists(storedPath string) bool {
+	_, err := os.Stat(resolveUploadPath(f.uploadDir, storedPath))
+	return err == nil
+}
+
+// count returns the rows of a model, including the ones in the trash
+func (f *trashFixture) count(t *testing.T, model interface{}, query string, args ...interface{}) int64 {
+	t.Helper()
+	var count int64
+	if err := f.db.Unscoped().Model(model).Where(query, args...).Count(&count).Error; err != nil {
+		t.Fatal(err)
+	}
+	return count
+}
+
+func TestTrashedItemsAreHiddenAndCanBeRestored(t *testing.T) {
+	f := newTrashFixture(t)
+	if err := f.images.Delete(f.tile.ID, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.projects.Delete(f.project.ID, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	if projects, _ := f.projects.FindByUserID(1); len(projects) != 0 {
+		t.Fatalf("a trashed project is listed: %v", projects)
+	}
+	if _, err := f.projects.FindByID(f.project.ID); err == nil {
+		t.Fatal("a trashed project can be found")
+	}
+	if images, _ := f.images.FindByUserID(1); len(images) != 1 || images[0].ID != f.main.ID {
+		t.Fatalf("got images %v, want only the main image", images)
+	}
+
+	trash, err := f.trash.List(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trash.Projects) != 1 || len(trash.Images) != 1 || trash.Images[0].ID != f.tile.ID {
+		t.Fatalf("got trash %+v, want the project and the tile", trash)
+	}
+	if other, _ := f.trash.List(2); len(other.Projects)+len(other.Images) != 0 {
+		t.Fatalf("another user's trash shows %+v", other)
+	}
+
+	// Only the owner restores, and only what is in the trash
+	if err := f.trash.RestoreProject(2, f.project.ID); err == nil {
+		t.Fatal("another user restored the project")
+	}
+	if err := f.trash.RestoreImage(1, f.main.ID); err == nil {
+		t.Fatal("an image outside the trash was restored")
+	}
+	if err := f.trash.RestoreProject(1, f.project.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.trash.RestoreImage(1, f.tile.ID); err != nil {
+		t.Fatal(err)
+	}
+	if projects, _ := f.projects.FindByUserID(1); len(projects) != 1 {
+		t.Fatalf("the restored project is not listed: %v", projects)
+	}
+	if images, _ := f.images.FindByUserID(1); len(images) != 2 {
+		t.Fatalf("the restored tile is not listed: %v", images)
+	}
+	if trash, _ := f.trash.List(1); len(trash.Projects)+len(trash.Images) != 0 {
+		t.Fatalf("restored items are still in the trash: %+v", trash)
+	}
+}
+
+func TestPurgeProjectDeletesEverythingItOwns(t *testing.T) {
+	f := newTrashFixture(t)
+
+	collection := models.TileCollection{UserID: 1, ProjectID: &f.project.ID, Name: "Beach"}
+	f.create(t, &collection)
+	f.create(t, &models.CollectionImage{CollectionID: collection.ID, ImageID: f.tile.ID})
+
+	dir := "/user_1/project_1/mosaics/"
+	mosaic := models.GeneratedMosaic{UserID: 1, ProjectID: f.project.ID, Status: "completed",
+		SDPath: dir + "sd.jpg", HDPath: dir + "hd.jpg", PlacementPath: dir + "placement.json"}
+	f.create(t, &mosaic)
+	f.create(t, &models.MosaicArtifact{MosaicID: mosaic.ID, Format: render.FormatDZI, Status: "completed", Path: dir + "mosaic.dzi"})
+	f.create(t, &models.MosaicShareLink{MosaicID: mosaic.ID, CreatedByID: 1, Token: "token"})
+	f.create(t, &models.MosaicSettings{UserID: 1, ProjectID: &f.project.ID, TileSize: 20})
+	f.create(t, &models.ProjectMember{ProjectID: f.project.ID, Email: "bob@example.com", Role: ProjectRoleViewer, InvitedByID: 1})
+	for _, name := range []string{"sd.jpg", "hd.jpg", "placement.json", "mosaic.dzi", "mosaic_files/0/0_0.jpg"} {
+		f.writeFile(t, dir+name)
+	}
+
+	// A duplicate in another project shares the main image's file
+	other := models.Project{UserID: 1, Name: "Copy"}
+	f.create(t, &other)
+	f.create(t, &models.Image{UserID: 1, ProjectID: &other.ID, Type: "main", Path: f.main.Path})
+
+	if err := f.trash.PurgeProject(1, f.project.ID); err == nil {
+		t.Fatal("a project outside the trash was purged")
+	}
+	if err := f.projects.Delete(f.project.ID, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.trash.PurgeProject(1, f.project.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, check := range []struct {
+		model interface{}
+		query string
+		arg   uint
+	}{
+		{&models.Project{}, "id = ?", f.project.ID},
+		{&models.Image{}, "project_id = ?", f.project.ID},
+		{&models.TileCollection{}, "id = ?", collection.ID},
+		{&models.CollectionImage{}, "collection_id = ?", collection.ID},
+		{&models.GeneratedMosaic{}, "project_id = ?", f.project.ID},
+		{&models.MosaicArtifact{}, "mosaic_id = ?", mosaic.ID},
+		{&models.MosaicShareLink{}, "mosaic_id = ?", mosaic.ID},
+		{&models.MosaicSettings{}, "project_id = ?", f.project.ID},
+		{&models.ProjectMember{}, "project_id = ?", f.project.ID},
+	} {
+		if n := f.count(t, check.model, check.query, check.arg); n != 0 {
+			t.Errorf("%d %T rows were kept", n, check.model)
+		}
+	}
+	if n := f.count(t, &models.Image{}, "project_id = ?", other.ID); n != 1 {
+		t.Errorf("the other project has %d images, want 1", n)
+	}
+
+	if !f.exists(f.main.Path) {
+		t.Error("a file shared with another project was removed")
+	}
+	for _, storedPath := range []string{f.tile.Path, dir + "sd.jpg", dir + "hd.jpg", dir + "placement.json", dir + "mosaic.dzi", dir + "mosaic_files/0/0_0.jpg"} {
+		if f.exists(storedPath) {
+			t.Errorf("%s was kept", storedPath)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(f.uploadDir, "user_1", "project_1", "mosaics")); !os.IsNotExist(err) {
+		t.Errorf("the emptied project directory was kept: %v", err)
+	}
+}
+
+func TestPurgeExpiredSkipsItemsThatFail(t *testing.T) {
+	f := newTrashFixture(t)
+	extra := f.image(t, "tile", "/uploads/user_1/project_1/extra.jpg")
+	for _, img := range []models.Image{f.main, f.tile, extra} {
+		if err := f.images.Delete(img.ID, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The main image and the tile expired, the extra tile was deleted today
+	expired := time.Now().Add(-f.trash.Retention() - time.Hour)
+	if err := f.db.Unscoped().Model(&models.Image{}).Where("id IN ?", []uint{f.main.ID, f.tile.ID}).Update("deleted_at", expired).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// The main image cannot be deleted, as if its row were locked
+	trigger := fmt.Sprintf("CREATE TRIGGER keep_main BEFORE DELETE ON images WHEN OLD.id = %d BEGIN SELECT RAISE(ABORT, 'locked'); END", f.main.ID)
+	if err := f.db.Exec(trigger).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	purged, err := f.trash.PurgeExpired()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if purged != 1 {
+		t.Fatalf("purged %d items, want 1", purged)
+	}
+	if n := f.count(t, &models.Image{}, "id = ?", f.tile.ID); n != 0 || f.exists(f.tile.Path) {
+		t.Error("the expired tile after the failing image was not purged")
+	}
+	if n := f.count(t, &models.Image{}, "id IN ?", []uint{f.main.ID, extra.ID}); n != 2 {
+		t.Errorf("got %d of the failing and unexpired images, want 2", n)
+	}
+	if !f.exists(f.main.Path) || !f.exists(extra.Path) {
+		t.Error("a file of an image that was not purged was removed")
+	}
+}
@@ -31,8 +31,41 @@ func (s *UserServiceImpl) FindByEmail(email string) (*models.User, error) {
 	return &user, nil
 }
 
+// FindByID finds a user by ID
+func (s *UserServiceImpl) FindByID(id uint) (*models.User, error) {
+	var user models.User
+	result := s.db.First(&user, id)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, errors.New("record not found")
+		}
+		return nil, result.Error
+	}
+	return &user, nil
+}
+
+// FindByOIDCIdentity finds a user linked to an external OIDC identity
+func (s *UserServiceImpl) FindByOIDCIdentity(issuer, subject string) (*models.User, error) {
+	var user models.User
+	// Struct conditions use the column names gorm derives from the fields
+	result := s.db.Where(&models.User{OIDCIssuer: issuer, OIDCSubject: subject}).First(&user)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, errors.New("record not found")
+		}
+		return nil, result.Error
+	}
+	return &user, nil
+}
+
 // Create creates a new user
 func (s *UserServiceImpl) Create(user *models.User) error {
 	result := s.db.Create(user)
 	return result.Error
 }
+
+// Update updates a user
+func (s *UserServiceImpl) Update(user *models.User) error {
+	result := s.db.Save(user)
+	return result.Error
+}
@@ -33,6 +33,13 @@ func main() {
 	mainRouter := gin.Default()
 	mainRouter.MaxMultipartMemory = 10 << 20 // 100 MiB
 
+	// X-Forwarded-For is only believed from the configured proxies, so
+	// clients cannot pick the IP that login throttling and the audit log see
+	if err := mainRouter.SetTrustedProxies(config.Config.GetStringSlice("server.trusted_proxies")); err != nil {
+		log.Fatalf("Invalid server.trusted_proxies: %v", err)
+	}
+	mainRouter.TrustedPlatform = config.Config.GetString("server.trusted_platform")
+
 	// Configure CORS - Use the most permissive configuration for development
 	mainRouter.Use(func(c *gin.Context) {
 		// Allow all origins in development
@@ -44,10 +51,10 @@ func main() {
 		// Set CORS headers with maximum permissiveness for development
 		c.Writer.Header().Set("Access-Control-Allow-Origin", origin)
 		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, Accept, Origin, Cache-Control, X-Requested-With")
+		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, Accept, Origin, Cache-Control, X-Requested-With, X-Share-Password")
 		c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS, PATCH, HEAD")
 		c.Writer.Header().Set("Access-Control-Max-Age", "86400") // 24 hours
-		c.Writer.Header().Set("Access-Control-Expose-Headers", "Content-Length, Content-Type, Authorization")
+		c.Writer.Header().Set("Access-Control-Expose-Headers", "Content-Length, Content-Type, Authorization, Retry-After, X-Mosaic-Seed")
 
 		// Handle preflight requests
 		if c.Request.Method == "OPTIONS" {
@@ -82,6 +89,11 @@ func main() {
 	// Set up routes with the service provider
 	routers.InitRoutes(mainRouter, ENVIRONMENT, serviceProvider)
 
+	// Purge expired trash in the background until shutdown
+	purgerCtx, stopPurger := context.WithCancel(context.Background())
+	defer stopPurger()
+	go serviceProvider.TrashService().RunPurger(purgerCtx)
+
 	// Create HTTP server
 	server := &http.Server{
 		Addr:    ":" + PORT,
@@ -101,6 +113,7 @@ func main() {
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 	log.Println("Shutting down server...")
+	stopPurger()
 
 	// Create a deadline for server shutdown
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
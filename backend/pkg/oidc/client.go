@@ -0,0 +1,351 @@
+// Package oidc implements a minimal OpenID Connect relying party using the
+// authorization code flow with PKCE. Provider endpoints are read from the
+// issuer's discovery document and ID tokens are verified against its JWKS.
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// LoginTTL is how long a started login may take before its state expires
+const LoginTTL = 10 * time.Minute
+
+// Config holds the relying party configuration
+type Config struct {
+	DiscoveryURL string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+}
+
+// Identity is the verified identity returned by the provider
+type Identity struct {
+	Issuer        string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+// providerMetadata is the subset of the discovery document that is used
+type providerMetadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// authRequest holds the per-login secrets between redirect and callback
+type authRequest struct {
+	nonce        string
+	codeVerifier string
+	expiresAt    time.Time
+}
+
+// Client is an OIDC relying party client
+type Client struct {
+	cfg        Config
+	httpClient *http.Client
+
+	mu       sync.Mutex
+	provider *providerMetadata
+	keys     map[string]*rsa.PublicKey
+	pending  map[string]*authRequest
+}
+
+// NewClient creates a new OIDC client. The discovery document is fetched
+// lazily on first use so the server can start while the provider is down.
+func NewClient(cfg Config) *Client {
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{"openid", "email", "profile"}
+	}
+	return &Client{
+		cfg:        cfg,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+		keys:       make(map[string]*rsa.PublicKey),
+		pending:    make(map[string]*authRequest),
+	}
+}
+
+// AuthCodeURL starts a login and returns the provider URL to redirect to
+// and the login's state. The caller must bind the state to the browser
+// that started the login, so a callback can only complete a login that
+// the same browser started.
+func (c *Client) AuthCodeURL(ctx context.Context) (string, string, error) {
+	provider, err := c.discover(ctx)
+	if err != nil {
+		return "", "", err
+	}
+
+	state, err := randomString(32)
+	if err != nil {
+		return "", "", err
+	}
+	nonce, err := randomString(32)
+	if err != nil {
+		return "", "", err
+	}
+	codeVerifier, err := randomString(48)
+	if err != nil {
+		return "", "", err
+	}
+
+	c.mu.Lock()
+	c.prunePendingLocked()
+	c.pending[state] = &authRequest{
+		nonce:        nonce,
+		codeVerifier: codeVerifier,
+		expiresAt:    time.Now().Add(LoginTTL),
+	}
+	c.mu.Unlock()
+
+	challenge := sha256.Sum256([]byte(codeVerifier))
+	params := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {c.cfg.ClientID},
+		"redirect_uri":          {c.cfg.RedirectURL},
+		"scope":                 {strings.Join(c.cfg.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+
+	separator := "?"
+	if strings.Contains(provider.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return provider.AuthorizationEndpoint + separator + params.Encode(), state, nil
+}
+
+// Exchange completes a login by redeeming the authorization code and
+// verifying the returned ID token
+func (c *Client) Exchange(ctx context.Context, state, code string) (*Identity, error) {
+	c.mu.Lock()
+	req, ok := c.pending[state]
+	delete(c.pending, state)
+	c.mu.Unlock()
+
+	if !ok || time.Now().After(req.expiresAt) {
+		return nil, errors.New("unknown or expired login state")
+	}
+
+	provider, err := c.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {c.cfg.RedirectURL},
+		"client_id":     {c.cfg.ClientID},
+		"code_verifier": {req.codeVerifier},
+	}
+	if c.cfg.ClientSecret != "" {
+		form.Set("client_secret", c.cfg.ClientSecret)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, provider.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	httpReq.Header.Set("Accept", "application/json")
+
+	resp, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("token request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("token endpoint returned status %d", resp.StatusCode)
+	}
+
+	var tokenResp struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tokenResp); err != nil {
+		return nil, fmt.Errorf("invalid token response: %v", err)
+	}
+	if tokenResp.IDToken == "" {
+		return nil, errors.New("token response has no id_token")
+	}
+
+	return c.verifyIDToken(ctx, provider, tokenResp.IDToken, req.nonce)
+}
+
+// verifyIDToken checks the ID token signature and standard claims
+func (c *Client) verifyIDToken(ctx context.Context, provider *providerMetadata, rawToken, nonce string) (*Identity, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(rawToken, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		kid, _ := token.Header["kid"].(string)
+		return c.publicKey(ctx, provider, kid)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid id_token: %v", err)
+	}
+
+	if !claims.VerifyIssuer(provider.Issuer, true) {
+		return nil, errors.New("id_token issuer mismatch")
+	}
+	if !claims.VerifyAudience(c.cfg.ClientID, true) {
+		return nil, errors.New("id_token audience mismatch")
+	}
+	if tokenNonce, _ := claims["nonce"].(string); tokenNonce != nonce {
+		return nil, errors.New("id_token nonce mismatch")
+	}
+
+	identity := &Identity{Issuer: provider.Issuer}
+	identity.Subject, _ = claims["sub"].(string)
+	identity.Email, _ = claims["email"].(string)
+	identity.EmailVerified, _ = claims["email_verified"].(bool)
+	identity.Name, _ = claims["name"].(string)
+	if identity.Subject == "" {
+		return nil, errors.New("id_token has no subject")
+	}
+
+	return identity, nil
+}
+
+// discover fetches and caches the provider discovery document
+func (c *Client) discover(ctx context.Context) (*providerMetadata, error) {
+	c.mu.Lock()
+	provider := c.provider
+	c.mu.Unlock()
+	if provider != nil {
+		return provider, nil
+	}
+
+	var metadata providerMetadata
+	if err := c.getJSON(ctx, c.cfg.DiscoveryURL, &metadata); err != nil {
+		return nil, fmt.Errorf("failed to fetch discovery document: %v", err)
+	}
+	if metadata.Issuer == "" || metadata.AuthorizationEndpoint == "" || metadata.TokenEndpoint == "" || metadata.JWKSURI == "" {
+		return nil, errors.New("discovery document is missing required endpoints")
+	}
+
+	c.mu.Lock()
+	c.provider = &metadata
+	c.mu.Unlock()
+
+	return &metadata, nil
+}
+
+// publicKey returns the signing key with the given ID, refreshing the
+// key set when the ID is unknown to support provider key rotation
+func (c *Client) publicKey(ctx context.Context, provider *providerMetadata, kid string) (*rsa.PublicKey, error) {
+	c.mu.Lock()
+	key, ok := c.keys[kid]
+	c.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+
+	var jwks struct {
+		Keys []struct {
+			Kid string `json:"kid"`
+			Kty string `json:"kty"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := c.getJSON(ctx, provider.JWKSURI, &jwks); err != nil {
+		return nil, fmt.Errorf("failed to fetch jwks: %v", err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, k := range jwks.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			continue
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			continue
+		}
+		keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+
+	c.mu.Lock()
+	c.keys = keys
+	c.mu.Unlock()
+
+	key, ok = keys[kid]
+	if !ok {
+		// Providers with a single key may omit kid from the token header
+		if kid == "" && len(keys) == 1 {
+			for _, k := range keys {
+				return k, nil
+			}
+		}
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	return key, nil
+}
+
+// getJSON performs a GET request and decodes the JSON response into v
+func (c *Client) getJSON(ctx context.Context, rawURL string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d from %s", resp.StatusCode, rawURL)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// prunePendingLocked drops expired login states. c.mu must be held.
+func (c *Client) prunePendingLocked() {
+	now := time.Now()
+	for state, req := range c.pending {
+		if now.After(req.expiresAt) {
+			delete(c.pending, state)
+		}
+	}
+}
+
+// randomString returns n random bytes encoded as unpadded base64url
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
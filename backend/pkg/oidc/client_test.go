@@ -0,0 +1,120 @@
+package oidc
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/amityadav9314/goinkgrid/pkg/oidc/oidctest"
+)
+
+const testRedirectURL = "http://app.test/auth/oidc/callback"
+
+func newTestClient(t *testing.T) (*Client, *oidctest.Server) {
+	t.Helper()
+	provider := oidctest.NewServer("inkgrid")
+	t.Cleanup(provider.Close)
+	client := NewClient(Config{
+		DiscoveryURL: provider.DiscoveryURL(),
+		ClientID:     "inkgrid",
+		RedirectURL:  testRedirectURL,
+	})
+	return client, provider
+}
+
+// authorize starts a login and follows it to the provider, returning the
+// state and code the provider redirects back with
+func authorize(t *testing.T, client *Client) (string, string) {
+	t.Helper()
+	authURL, state, err := client.AuthCodeURL(context.Background())
+	if err != nil {
+		t.Fatalf("AuthCodeURL: %v", err)
+	}
+
+	noRedirect := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := noRedirect.Get(authURL)
+	if err != nil {
+		t.Fatalf("authorize: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("authorize: got status %d", resp.StatusCode)
+	}
+
+	callback, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(callback.String(), testRedirectURL) {
+		t.Fatalf("redirected to %s", callback)
+	}
+	if got := callback.Query().Get("state"); got != state {
+		t.Fatalf("callback state %q, want %q", got, state)
+	}
+	return state, callback.Query().Get("code")
+}
+
+func TestLoginRoundTrip(t *testing.T) {
+	client, provider := newTestClient(t)
+	provider.SetIdentity(oidctest.Identity{Subject: "abc", Email: "ada@example.com", EmailVerified: true, Name: "Ada"})
+
+	state, code := authorize(t, client)
+	identity, err := client.Exchange(context.Background(), state, code)
+	if err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+
+	want := Identity{Issuer: provider.URL, Subject: "abc", Email: "ada@example.com", EmailVerified: true, Name: "Ada"}
+	if *identity != want {
+		t.Fatalf("got identity %+v, want %+v", *identity, want)
+	}
+}
+
+func TestExchangeRejectsUnknownState(t *testing.T) {
+	client, _ := newTestClient(t)
+	_, code := authorize(t, client)
+
+	if _, err := client.Exchange(context.Background(), "forged", code); err == nil {
+		t.Fatal("Exchange accepted a state it never issued")
+	}
+}
+
+func TestExchangeRejectsReplayedState(t *testing.T) {
+	client, _ := newTestClient(t)
+	state, code := authorize(t, client)
+
+	if _, err := client.Exchange(context.Background(), state, code); err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+	if _, err := client.Exchange(context.Background(), state, code); err == nil {
+		t.Fatal("Exchange accepted the same state twice")
+	}
+}
+
+func TestExchangeRejectsNonceMismatch(t *testing.T) {
+	client, provider := newTestClient(t)
+	state, code := authorize(t, client)
+	provider.SetNonce("another-login")
+
+	_, err := client.Exchange(context.Background(), state, code)
+	if err == nil || !strings.Contains(err.Error(), "nonce") {
+		t.Fatalf("got error %v, want a nonce mismatch", err)
+	}
+}
+
+func TestExchangeSendsTheCodeVerifier(t *testing.T) {
+	client, _ := newTestClient(t)
+	state, code := authorize(t, client)
+
+	// A verifier that does not match the challenge is refused by the
+	// provider, so the code is useless to anyone without it
+	client.mu.Lock()
+	client.pending[state].codeVerifier = "not-the-verifier"
+	client.mu.Unlock()
+
+	if _, err := client.Exchange(context.Background(), state, code); err == nil {
+		t.Fatal("Exchange succeeded with the wrong code verifier")
+	}
+}
@@ -0,0 +1,392 @@
+package render
+
+import (
+	"archive/zip"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"math"
+	"math/rand"
+	"time"
+
+	xdraw "golang.org/x/image/draw"
+	"golang.org/x/image/math/f64"
+)
+
+// Animation effects
+const (
+	EffectFade  = "fade"  // tiles fade in one by one on a blank canvas
+	EffectZoom  = "zoom"  // zooms out from the centre tile to the whole mosaic
+	EffectMorph = "morph" // the main image dissolves into the mosaic
+)
+
+// Animation limits and defaults. Delays are in milliseconds.
+const (
+	DefaultAnimationWidth  = 640
+	MaxAnimationWidth      = 1920
+	DefaultAnimationFrames = 30
+	MaxAnimationFrames     = 150
+	DefaultFrameDelay      = 100
+	MinFrameDelay          = 20
+	MaxFrameDelay          = 5000
+	DefaultAnimationHold   = 1500
+	MaxAnimationHold       = 10000
+)
+
+// fadeWindow is the part of a fade each tile takes to appear
+const fadeWindow = 0.25
+
+// AnimationSpec selects the effect and timing of an animated export
+type AnimationSpec struct {
+	Effect     string `json:"effect,omitempty"`      // fade, zoom or morph, fade if empty
+	Width      int    `json:"width,omitempty"`       // frame width in pixels, 640 if zero; the height follows the mosaic
+	Frames     int    `json:"frames,omitempty"`      // number of frames, 30 if zero
+	FrameDelay int    `json:"frame_delay,omitempty"` // milliseconds per frame, 100 if zero
+	Hold       int    `json:"hold,omitempty"`        // milliseconds the last frame is shown, 1500 if zero
+	Once       bool   `json:"once,omitempty"`        // play once instead of looping
+}
+
+// Validate checks the effect and the limits of the spec
+func (s AnimationSpec) Validate() error {
+	switch s.Effect {
+	case "", EffectFade, EffectZoom, EffectMorph:
+	default:
+		return fmt.Errorf("unknown animation effect %q", s.Effect)
+	}
+	if s.Width != 0 && (s.Width < 16 || s.Width > MaxAnimationWidth) {
+		return fmt.Errorf("animation width must be between 16 and %d", MaxAnimationWidth)
+	}
+	if s.Frames != 0 && (s.Frames < 2 || s.Frames > MaxAnimationFrames) {
+		return fmt.Errorf("animation frames must be between 2 and %d", MaxAnimationFrames)
+	}
+	if s.FrameDelay != 0 && (s.FrameDelay < MinFrameDelay || s.FrameDelay > MaxFrameDelay) {
+		return fmt.Errorf("frame delay must be between %d and %d milliseconds", MinFrameDelay, MaxFrameDelay)
+	}
+	if s.Hold < 0 || s.Hold > MaxAnimationHold {
+		return fmt.Errorf("hold must be at most %d milliseconds", MaxAnimationHold)
+	}
+	return nil
+}
+
+// withDefaults returns the spec with its zero values replaced by defaults
+func (s AnimationSpec) withDefaults() AnimationSpec {
+	if s.Effect == "" {
+		s.Effect = EffectFade
+	}
+	if s.Width == 0 {
+		s.Width = DefaultAnimationWidth
+	}
+	if s.Frames == 0 {
+		s.Frames = DefaultAnimationFrames
+	}
+	if s.FrameDelay == 0 {
+		s.FrameDelay = DefaultFrameDelay
+	}
+	if s.Hold == 0 {
+		s.Hold = DefaultAnimationHold
+	}
+	return s
+}
+
+// View is a region of a mosaic's output in output pixels, with fractional
+// edges so a zoom can move smoothly
+type View struct {
+	X, Y, W, H float64
+}
+
+// Animation is a reveal of a mosaic drawn from its scene, so the last
+// frame shows the same placement as every other output. Frames are small
+// and drawn one at a time, in any order.
+type Animation struct {
+	scene  *Scene
+	spec   AnimationSpec
+	bounds image.Rectangle
+	mosaic *image.RGBA // the whole mosaic at frame size
+	start  *image.RGBA // what a fade or a morph starts from
+	reveal []float64   // when each cell starts to fade in, from 0 to 1
+	owners []int32     // the cell each frame pixel belongs to, for a fade
+}
+
+// NewAnimation prepares an animation of a scene. The spec must be valid.
+// Animations are opaque, so a transparent background is drawn white.
+func NewAnimation(scene *Scene, spec AnimationSpec) *Animation {
+	spec = spec.withDefaults()
+	scene = scene.Flat()
+	size := scene.Plan.Grid.Size
+	w := min(spec.Width, size.Width)
+	h := max(int(math.Round(float64(w)*float64(size.Height)/float64(size.Width))), 1)
+
+	a := &Animation{scene: scene, spec: spec, bounds: image.Rect(0, 0, w, h)}
+	a.mosaic = image.NewRGBA(a.bounds)
+	a.drawView(a.mosaic, a.fullView())
+
+	switch spec.Effect {
+	case EffectFade:
+		a.start = image.NewRGBA(a.bounds)
+		draw.Draw(a.start, a.bounds, image.White, image.Point{}, draw.Src)
+
+		// Cells appear in a shuffled order that is the same every time
+		grid := scene.Plan.Grid
+		order := rand.New(rand.NewSource(int64(grid.Len()))).Perm(grid.Len())
+		a.reveal = make([]float64, grid.Len())
+		for i, cell := range order {
+			a.reveal[cell] = float64(i) / float64(grid.Len()) * (1 - fadeWindow)
+		}
+
+		// Pixels fade in with the cell their centre falls in, which
+		// follows the outline of shaped cells
+		k := float64(w) / float64(size.Width)
+		a.owners = make([]int32, w*h)
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				col, row := grid.CellAt((float64(x)+0.5)/k, (float64(y)+0.5)/k)
+				a.owners[y*w+x] = int32(row*grid.Cols + col)
+			}
+		}
+	case EffectMorph:
+		a.start = image.NewRGBA(a.bounds)
+		scene.Guide.OverlayView(a.start, a.fullView(), 1)
+	}
+	return a
+}
+
+// Bounds returns the frame rectangle
+func (a *Animation) Bounds() image.Rectangle {
+	return a.bounds
+}
+
+// Len returns the number of frames
+func (a *Animation) Len() int {
+	return a.spec.Frames
+}
+
+// Loop reports whether the animation plays forever
+func (a *Animation) Loop() bool {
+	return !a.spec.Once
+}
+
+// Delay returns how long frame i is shown. The last frame is held.
+func (a *Animation) Delay(i int) time.Duration {
+	if i == a.Len()-1 {
+		return time.Duration(a.spec.Hold) * time.Millisecond
+	}
+	return time.Duration(a.spec.FrameDelay) * time.Millisecond
+}
+
+// Palette returns up to 256 colours for every frame of the animation,
+// chosen from the images it starts and ends on
+func (a *Animation) Palette() color.Palette {
+	images := []*image.RGBA{a.mosaic}
+	if a.start != nil {
+		images = append(images, a.start)
+	}
+	return quantize(images, 256)
+}
+
+// Frame draws frame i into dst, which must have the animation's bounds
+func (a *Animation) Frame(i int, dst *image.RGBA) {
+	t := float64(i) / float64(a.Len()-1)
+	switch a.spec.Effect {
+	case EffectFade:
+		weights := make([]int, len(a.reveal))
+		for i, reveal := range a.reveal {
+			weights[i] = mixWeight((t - reveal) / fadeWindow)
+		}
+		for i, cell := range a.owners {
+			mixPixel(dst.Pix[i*4:i*4+4], a.start.Pix[i*4:], a.mosaic.Pix[i*4:], weights[cell])
+		}
+	case EffectMorph:
+		mix(dst, a.bounds, a.start, a.mosaic, smoothstep(t))
+	case EffectZoom:
+		a.drawView(dst, a.zoomView(smoothstep(t)))
+	}
+}
+
+// fullView returns the view of the whole output
+func (a *Animation) fullView() View {
+	size := a.scene.Plan.Grid.Size
+	return View{W: float64(size.Width), H: float64(size.Height)}
+}
+
+// zoomView returns the view of a zoom at progress e from 0 to 1. The zoom
+// starts close on the centre tile, but enlarges tiles at most twice so
+// they stay sharp, and widens at a constant rate to the whole output.
+func (a *Animation) zoomView(e float64) View {
+	grid := a.scene.Plan.Grid
+	full := a.fullView()
+	w0 := math.Min(full.W, math.Max(float64(grid.TileSide()), float64(a.bounds.Dx())/2))
+	w := w0 * math.Pow(full.W/w0, e)
+
+	// Move from the centre tile to the centre of the output as the view
+	// widens
+	cx0, cy0 := grid.Center(grid.CellAt(full.W/2, full.H/2))
+	q := 1.0
+	if full.W > w0 {
+		q = (w - w0) / (full.W - w0)
+	}
+	cx := cx0 + (full.W/2-cx0)*q
+	cy := cy0 + (full.H/2-cy0)*q
+
+	h := w * full.H / full.W
+	return View{
+		X: math.Min(math.Max(cx-w/2, 0), full.W-w),
+		Y: math.Min(math.Max(cy-h/2, 0), full.H-h),
+		W: w,
+		H: h,
+	}
+}
+
+// drawView draws a view of the scene scaled to dst. Tiles are scaled once
+// per view to about the size they are drawn at and placed with sub-pixel
+// offsets, so zooms do not jitter.
+func (a *Animation) drawView(dst *image.RGBA, view View) {
+	grid := a.scene.Plan.Grid
+	k := float64(dst.Rect.Dx()) / view.W
+	side := grid.TileSide()
+	size := min(max(int(math.Ceil(float64(side)*k)), 1), side)
+
+	scaled := make([]*image.RGBA, a.scene.Tiles.Len())
+	tile := func(i int) *image.RGBA {
+		if scaled[i] == nil {
+			scaled[i] = a.scene.Tiles.Get(i, side)
+			if size < side {
+				scaled[i] = ScaleTile(scaled[i], size)
+			}
+		}
+		return scaled[i]
+	}
+	ox := float64(dst.Rect.Min.X) - view.X*k
+	oy := float64(dst.Rect.Min.Y) - view.Y*k
+	drawBackground := func(r image.Rectangle) *image.RGBA {
+		bg := a.scene.Background.orWhite()
+		img := image.NewRGBA(r)
+		draw.Draw(img, r, image.NewUniform(bg.Color), image.Point{}, draw.Src)
+		a.scene.Guide.OverlayView(img, view, bg.Fade)
+		return img
+	}
+	if !grid.Plain() {
+		drawShapedCells(dst, dst.Rect, a.scene.Plan, k, ox, oy, tile)
+		a.scene.Guide.OverlayView(dst, view, a.scene.Overlay)
+		drawEmptyCells(dst, dst.Rect, a.scene.Plan, k, ox, oy, drawBackground)
+		return
+	}
+
+	f := float64(grid.Cell) * k / float64(size)
+	col0 := max(int(view.X)/grid.Cell, 0)
+	col1 := min(int(math.Ceil((view.X+view.W)/float64(grid.Cell))), grid.Cols)
+	row0 := max(int(view.Y)/grid.Cell, 0)
+	row1 := min(int(math.Ceil((view.Y+view.H)/float64(grid.Cell))), grid.Rows)
+	for row := row0; row < row1; row++ {
+		for col := col0; col < col1; col++ {
+			index := a.scene.Plan.Tile(col, row)
+			if index == NoTile {
+				continue
+			}
+			img := tile(index)
+			t := f64.Aff3{
+				f, 0, (float64(col*grid.Cell)-view.X)*k + float64(dst.Rect.Min.X),
+				0, f, (float64(row*grid.Cell)-view.Y)*k + float64(dst.Rect.Min.Y),
+			}
+			xdraw.ApproxBiLinear.Transform(dst, t, img, img.Bounds(), draw.Src, nil)
+		}
+	}
+	a.scene.Guide.OverlayView(dst, view, a.scene.Overlay)
+	drawEmptyCells(dst, dst.Rect, a.scene.Plan, k, ox, oy, drawBackground)
+}
+
+// mix draws region r of from blended towards to by alpha, clamped to
+// between 0 and 1, into dst
+func mix(dst *image.RGBA, r image.Rectangle, from, to *image.RGBA, alpha float64) {
+	w := mixWeight(alpha)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		i := dst.PixOffset(r.Min.X, y)
+		mixPixel(dst.Pix[i:i+r.Dx()*4], from.Pix[i:], to.Pix[i:], w)
+	}
+}
+
+// mixWeight converts alpha, clamped to between 0 and 1, to a weight out
+// of 255
+func mixWeight(alpha float64) int {
+	return int(math.Round(math.Min(math.Max(alpha, 0), 1) * 255))
+}
+
+// mixPixel writes the pixels from blended towards to by w out of 255 to dst
+func mixPixel(dst, from, to []uint8, w int) {
+	for i := range dst {
+		dst[i] = uint8((int(from[i])*(255-w) + int(to[i])*w + 127) / 255)
+	}
+}
+
+// smoothstep eases t from 0 to 1 in and out
+func smoothstep(t float64) float64 {
+	return t * t * (3 - 2*t)
+}
+
+// frameWriter writes the frames of an animation in order
+type frameWriter interface {
+	WriteFrame(frame *image.RGBA, delay time.Duration) error
+	Close() error
+}
+
+// WriteAnimation draws every frame of an animation and writes them to w as
+// an animated GIF, an APNG or a zip of numbered PNGs
+func WriteAnimation(ctx context.Context, w io.Writer, format string, a *Animation) error {
+	var fw frameWriter
+	var err error
+	switch format {
+	case FormatGIF:
+		fw, err = newGIFWriter(w, a.Bounds(), a.Palette(), a.Loop())
+	case FormatAPNG:
+		// Viewers without APNG support show the finished mosaic
+		still := image.NewRGBA(a.Bounds())
+		a.Frame(a.Len()-1, still)
+		fw, err = newAPNGWriter(w, still, a.Len(), a.Loop())
+	case FormatFrames:
+		fw = &zipFrameWriter{zw: zip.NewWriter(w)}
+	default:
+		return errors.New("only gif, apng and frames exports are animated")
+	}
+	if err != nil {
+		return err
+	}
+
+	frame := image.NewRGBA(a.Bounds())
+	for i := 0; i < a.Len(); i++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		a.Frame(i, frame)
+		if err := fw.WriteFrame(frame, a.Delay(i)); err != nil {
+			return err
+		}
+	}
+	return fw.Close()
+}
+
+// zipFrameWriter writes frames as numbered PNGs in a zip archive. PNGs
+// are already compressed, so they are stored as they are.
+type zipFrameWriter struct {
+	zw *zip.Writer
+	n  int
+}
+
+func (z *zipFrameWriter) WriteFrame(frame *image.RGBA, delay time.Duration) error {
+	z.n++
+	w, err := z.zw.CreateHeader(&zip.FileHeader{
+		Name:     fmt.Sprintf("frame_%04d.png", z.n),
+		Method:   zip.Store,
+		Modified: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	return EncodePNG(w, frame, DefaultDPI)
+}
+
+func (z *zipFrameWriter) Close() error {
+	return z.zw.Close()
+}
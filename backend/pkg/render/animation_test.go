@@ -0,0 +1,241 @@
+package render
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"testing"
+)
+
+// testAnimation animates a small mosaic with five frames 40 ms apart,
+// holding the last for a second
+func testAnimation(t *testing.T, effect string, once bool) *Animation {
+	t.Helper()
+	spec := AnimationSpec{Effect: effect, Width: 120, Frames: 5, FrameDelay: 40, Hold: 1000, Once: once}
+	if err := spec.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	return NewAnimation(testScene(t, 600, 8, 64, 20, ShapeSpec{}, 42), spec)
+}
+
+// frame draws frame i of an animation
+func frame(a *Animation, i int) *image.RGBA {
+	dst := image.NewRGBA(a.Bounds())
+	a.Frame(i, dst)
+	return dst
+}
+
+func writeAnimation(t *testing.T, format string, a *Animation) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := WriteAnimation(context.Background(), &buf, format, a); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestAnimationEffectsEndOnTheMosaic(t *testing.T) {
+	for _, effect := range []string{EffectFade, EffectZoom, EffectMorph} {
+		t.Run(effect, func(t *testing.T) {
+			a := testAnimation(t, effect, false)
+			if a.Bounds() != image.Rect(0, 0, 120, 80) {
+				t.Fatalf("frames are %v, want 120x80 like the 3:2 mosaic", a.Bounds())
+			}
+			first, last := frame(a, 0), frame(a, a.Len()-1)
+			if !bytes.Equal(last.Pix, a.mosaic.Pix) {
+				t.Fatal("the last frame is not the mosaic")
+			}
+			if bytes.Equal(first.Pix, last.Pix) {
+				t.Fatal("the first frame is already the mosaic")
+			}
+			// Frames can be drawn in any order
+			if again := frame(a, 0); !bytes.Equal(again.Pix, first.Pix) {
+				t.Fatal("drawing a frame twice gives different pixels")
+			}
+
+			switch effect {
+			case EffectFade:
+				for i := 0; i < len(first.Pix); i += 4 {
+					if first.Pix[i] != 255 || first.Pix[i+1] != 255 || first.Pix[i+2] != 255 {
+						t.Fatalf("fade starts on %v, want white", first.Pix[i:i+4])
+					}
+				}
+			case EffectMorph:
+				if !bytes.Equal(first.Pix, a.start.Pix) {
+					t.Fatal("morph does not start on the main image")
+				}
+			}
+		})
+	}
+}
+
+func TestGIFFramesAndDelays(t *testing.T) {
+	for _, once := range []bool{false, true} {
+		t.Run(fmt.Sprintf("once=%v", once), func(t *testing.T) {
+			a := testAnimation(t, EffectFade, once)
+			decoded, err := gif.DecodeAll(bytes.NewReader(writeAnimation(t, FormatGIF, a)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(decoded.Image) != 5 {
+				t.Fatalf("%d frames, want 5", len(decoded.Image))
+			}
+			// Delays are in hundredths of a second
+			if fmt.Sprint(decoded.Delay) != "[4 4 4 4 100]" {
+				t.Fatalf("delays %v", decoded.Delay)
+			}
+			wantLoop := 0 // forever
+			if once {
+				wantLoop = -1 // no loop extension
+			}
+			if decoded.LoopCount != wantLoop {
+				t.Fatalf("loop count %d, want %d", decoded.LoopCount, wantLoop)
+			}
+			if decoded.Config.Width != 120 || decoded.Config.Height != 80 || decoded.Image[4].Bounds() != a.Bounds() {
+				t.Fatalf("screen %dx%d, frame %v", decoded.Config.Width, decoded.Config.Height, decoded.Image[4].Bounds())
+			}
+
+			// The palette is dithered, so the last frame is only close to
+			// the mosaic
+			if mean := comparePixels(t, decoded.Image[4], a.mosaic, 255); mean > 12 {
+				t.Fatalf("last frame is %.1f away from the mosaic on average", mean)
+			}
+		})
+	}
+}
+
+// apngChunk is one chunk of a PNG file
+type apngChunk struct {
+	name string
+	data []byte
+}
+
+func readPNGChunks(t *testing.T, data []byte) []apngChunk {
+	t.Helper()
+	if !bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")) {
+		t.Fatal("not a PNG file")
+	}
+	var chunks []apngChunk
+	for p := 8; p < len(data); {
+		n := int(binary.BigEndian.Uint32(data[p:]))
+		chunks = append(chunks, apngChunk{string(data[p+4 : p+8]), data[p+8 : p+8+n]})
+		p += 12 + n
+	}
+	return chunks
+}
+
+func TestAPNGFramesAndDelays(t *testing.T) {
+	a := testAnimation(t, EffectMorph, false)
+	data := writeAnimation(t, FormatAPNG, a)
+
+	var frames, delays []int
+	var seq []uint32
+	for _, c := range readPNGChunks(t, data) {
+		switch c.name {
+		case "acTL":
+			if n, plays := binary.BigEndian.Uint32(c.data), binary.BigEndian.Uint32(c.data[4:]); n != 5 || plays != 0 {
+				t.Fatalf("acTL: %d frames, %d plays, want 5 looping forever", n, plays)
+			}
+		case "fcTL":
+			seq = append(seq, binary.BigEndian.Uint32(c.data))
+			num, den := binary.BigEndian.Uint16(c.data[20:]), binary.BigEndian.Uint16(c.data[22:])
+			delays = append(delays, int(num)*1000/int(den))
+			frames = append(frames, int(binary.BigEndian.Uint32(c.data[4:])))
+		case "fdAT":
+			seq = append(seq, binary.BigEndian.Uint32(c.data))
+		}
+	}
+	if len(frames) != 5 || frames[0] != 120 {
+		t.Fatalf("%d frames of width %v, want 5 of 120", len(frames), frames)
+	}
+	if fmt.Sprint(delays) != "[40 40 40 40 1000]" {
+		t.Fatalf("delays %v ms", delays)
+	}
+	for i, n := range seq {
+		if n != uint32(i) {
+			t.Fatalf("sequence numbers %v", seq)
+		}
+	}
+
+	// Viewers that do not animate PNGs show the finished mosaic
+	still, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	comparePixels(t, still, a.mosaic, 0)
+}
+
+func TestFrameSequence(t *testing.T) {
+	a := testAnimation(t, EffectZoom, false)
+	data := writeAnimation(t, FormatFrames, a)
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(zr.File) != 5 {
+		t.Fatalf("%d frames, want 5", len(zr.File))
+	}
+	for i, f := range zr.File {
+		if want := fmt.Sprintf("frame_%04d.png", i+1); f.Name != want {
+			t.Fatalf("frame %d is named %s, want %s", i, f.Name, want)
+		}
+		r, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		img, err := png.Decode(r)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		comparePixels(t, img, frame(a, i), 0)
+	}
+}
+
+func TestAnimationSpecValidate(t *testing.T) {
+	valid := []AnimationSpec{{}, {Effect: EffectZoom, Width: 16, Frames: 2, FrameDelay: MinFrameDelay, Hold: MaxAnimationHold}}
+	for _, spec := range valid {
+		if err := spec.Validate(); err != nil {
+			t.Errorf("%+v rejected: %v", spec, err)
+		}
+	}
+	invalid := []AnimationSpec{
+		{Effect: "spin"},
+		{Width: 15},
+		{Width: MaxAnimationWidth + 1},
+		{Frames: 1},
+		{Frames: MaxAnimationFrames + 1},
+		{FrameDelay: MinFrameDelay - 1},
+		{Hold: -1},
+		{Hold: MaxAnimationHold + 1},
+	}
+	for _, spec := range invalid {
+		if err := spec.Validate(); err == nil {
+			t.Errorf("%+v accepted", spec)
+		}
+	}
+}
+
+// Frames are opaque even where the mosaic is transparent
+func TestAnimationFramesAreOpaque(t *testing.T) {
+	scene := testScene(t, 600, 8, 64, 20, ShapeSpec{Shape: ShapeCircle}, 42)
+	scene.Background = &Background{Color: color.RGBA{}}
+	for i := 0; i < 10; i++ {
+		scene.Plan.Assign[i] = NoTile
+	}
+	a := NewAnimation(scene, AnimationSpec{Width: 120, Frames: 3})
+	for i := 0; i < a.Len(); i++ {
+		f := frame(a, i)
+		for p := 3; p < len(f.Pix); p += 4 {
+			if f.Pix[p] != 255 {
+				t.Fatalf("frame %d has alpha %d", i, f.Pix[p])
+			}
+		}
+	}
+}
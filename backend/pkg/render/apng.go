@@ -0,0 +1,188 @@
+package render
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"io"
+	"time"
+)
+
+// apngWriter writes an animated PNG one frame at a time. The frames are
+// opaque, so they are stored as 8 bit RGB.
+type apngWriter struct {
+	w      io.Writer
+	width  int
+	height int
+	seq    uint32
+	buf    bytes.Buffer
+}
+
+// newAPNGWriter writes the header of an APNG of n frames and still as the
+// image shown by viewers that do not animate PNGs
+func newAPNGWriter(w io.Writer, still *image.RGBA, n int, loop bool) (*apngWriter, error) {
+	a := &apngWriter{w: w, width: still.Rect.Dx(), height: still.Rect.Dy()}
+	if _, err := w.Write([]byte("\x89PNG\r\n\x1a\n")); err != nil {
+		return nil, err
+	}
+
+	ihdr := binary.BigEndian.AppendUint32(nil, uint32(a.width))
+	ihdr = binary.BigEndian.AppendUint32(ihdr, uint32(a.height))
+	ihdr = append(ihdr, 8, 2, 0, 0, 0) // 8 bit RGB, not interlaced
+	if err := a.chunk("IHDR", ihdr); err != nil {
+		return nil, err
+	}
+	if err := a.chunk("sRGB", []byte{0}); err != nil {
+		return nil, err
+	}
+
+	plays := uint32(1)
+	if loop {
+		plays = 0
+	}
+	actl := binary.BigEndian.AppendUint32(nil, uint32(n))
+	actl = binary.BigEndian.AppendUint32(actl, plays)
+	if err := a.chunk("acTL", actl); err != nil {
+		return nil, err
+	}
+
+	// An IDAT before the first fcTL is not part of the animation
+	data, err := a.compress(still)
+	if err != nil {
+		return nil, err
+	}
+	if err := a.chunk("IDAT", data); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (a *apngWriter) WriteFrame(frame *image.RGBA, delay time.Duration) error {
+	fctl := binary.BigEndian.AppendUint32(nil, a.seq)
+	fctl = binary.BigEndian.AppendUint32(fctl, uint32(a.width))
+	fctl = binary.BigEndian.AppendUint32(fctl, uint32(a.height))
+	fctl = binary.BigEndian.AppendUint32(fctl, 0) // x offset
+	fctl = binary.BigEndian.AppendUint32(fctl, 0) // y offset
+	fctl = binary.BigEndian.AppendUint16(fctl, uint16(delay.Milliseconds()))
+	fctl = binary.BigEndian.AppendUint16(fctl, 1000)
+	fctl = append(fctl, 0, 0) // leave the frame in place, replace the canvas
+	a.seq++
+	if err := a.chunk("fcTL", fctl); err != nil {
+		return err
+	}
+
+	data, err := a.compress(frame)
+	if err != nil {
+		return err
+	}
+	fdat := binary.BigEndian.AppendUint32(make([]byte, 0, 4+len(data)), a.seq)
+	a.seq++
+	return a.chunk("fdAT", append(fdat, data...))
+}
+
+func (a *apngWriter) Close() error {
+	return a.chunk("IEND", nil)
+}
+
+// chunk writes a PNG chunk with its length and checksum
+func (a *apngWriter) chunk(name string, data []byte) error {
+	head := binary.BigEndian.AppendUint32(nil, uint32(len(data)))
+	head = append(head, name...)
+	crc := crc32.NewIEEE()
+	crc.Write(head[4:])
+	crc.Write(data)
+
+	if _, err := a.w.Write(head); err != nil {
+		return err
+	}
+	if _, err := a.w.Write(data); err != nil {
+		return err
+	}
+	_, err := a.w.Write(binary.BigEndian.AppendUint32(nil, crc.Sum32()))
+	return err
+}
+
+// compress filters each row of img with the PNG filter that leaves the
+// smallest sum of differences, as image/png does, and deflates the rows
+func (a *apngWriter) compress(img *image.RGBA) ([]byte, error) {
+	a.buf.Reset()
+	zw := zlib.NewWriter(&a.buf)
+
+	const bpp = 3
+	n := a.width * bpp
+	prev := make([]uint8, n)
+	cur := make([]uint8, n)
+	var filtered [5][]uint8
+	for f := range filtered {
+		filtered[f] = make([]uint8, 1+n)
+		filtered[f][0] = uint8(f)
+	}
+
+	for y := 0; y < a.height; y++ {
+		row := img.Pix[img.PixOffset(img.Rect.Min.X, img.Rect.Min.Y+y):]
+		for x := 0; x < a.width; x++ {
+			copy(cur[x*bpp:x*bpp+bpp], row[x*4:x*4+3])
+		}
+
+		best, bestSum := 0, -1
+		for f := range filtered {
+			out := filtered[f][1:]
+			sum := 0
+			for i := 0; i < n; i++ {
+				var left, upLeft uint8
+				if i >= bpp {
+					left, upLeft = cur[i-bpp], prev[i-bpp]
+				}
+				up := prev[i]
+				var v uint8
+				switch f {
+				case 0:
+					v = cur[i]
+				case 1:
+					v = cur[i] - left
+				case 2:
+					v = cur[i] - up
+				case 3:
+					v = cur[i] - uint8((int(left)+int(up))/2)
+				case 4:
+					v = cur[i] - paeth(left, up, upLeft)
+				}
+				out[i] = v
+				sum += abs(int(int8(v)))
+			}
+			if bestSum < 0 || sum < bestSum {
+				best, bestSum = f, sum
+			}
+		}
+		if _, err := zw.Write(filtered[best]); err != nil {
+			return nil, err
+		}
+		prev, cur = cur, prev
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return bytes.Clone(a.buf.Bytes()), nil
+}
+
+// paeth returns whichever of a, b and c is closest to a + b - c
+func paeth(a, b, c uint8) uint8 {
+	p := int(a) + int(b) - int(c)
+	pa, pb, pc := abs(p-int(a)), abs(p-int(b)), abs(p-int(c))
+	if pa <= pb && pa <= pc {
+		return a
+	}
+	if pb <= pc {
+		return b
+	}
+	return c
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
@@ -0,0 +1,162 @@
+package render
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"math/bits"
+)
+
+// Deep Zoom tile layout, the defaults of most viewers
+const (
+	DeepZoomTileSize = 254
+	DeepZoomOverlap  = 1
+)
+
+// DeepZoomDescriptor returns the .dzi descriptor of a w x h pyramid of
+// tiles in the given format
+func DeepZoomDescriptor(w, h int, format string) []byte {
+	return []byte(fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<Image xmlns="http://schemas.microsoft.com/deepzoom/2008" Format="%s" Overlap="%d" TileSize="%d">
+  <Size Width="%d" Height="%d"/>
+</Image>
+`, format, DeepZoomOverlap, DeepZoomTileSize, w, h))
+}
+
+// DeepZoomLevels returns the number of levels of a Deep Zoom pyramid of a
+// w x h image. Level 0 is one pixel and the last level is full size.
+func DeepZoomLevels(w, h int) int {
+	return bits.Len(uint(max(w, h)-1)) + 1
+}
+
+// WriteDeepZoom renders a layer once, band by band, and cuts every level
+// of a Deep Zoom pyramid from it as the rows go by. Each level is the one
+// above it halved, so only a strip of tile rows per level is held in
+// memory. write is called with every tile, each level from the top row
+// down.
+func WriteDeepZoom(ctx context.Context, layer Layer, write func(level, col, row int, tile *image.RGBA) error) error {
+	b := layer.Bounds()
+	levels := DeepZoomLevels(b.Dx(), b.Dy())
+	var top *pyramidLevel
+	for level := 0; level < levels; level++ {
+		shift := levels - 1 - level
+		top = &pyramidLevel{
+			level:  level,
+			width:  (b.Dx() + 1<<shift - 1) >> shift,
+			height: (b.Dy() + 1<<shift - 1) >> shift,
+			write:  write,
+			next:   top,
+		}
+	}
+
+	return Stream(ctx, layer, func(img image.Image) error {
+		buf := make([]uint8, 0, b.Dx()*4)
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if err := top.addRow(rgbaRow(img, y, buf)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// pyramidLevel buffers the rows of one level until a row of tiles is
+// complete, and feeds the next level the rows halved
+type pyramidLevel struct {
+	level, width, height int
+	write                func(level, col, row int, tile *image.RGBA) error
+	next                 *pyramidLevel
+
+	rows    [][]uint8 // buffered rows, from row top
+	top     int
+	y       int // the next row to be added
+	tileRow int // the next row of tiles to be written
+	free    [][]uint8
+	pending []uint8 // the even row of a pair being halved
+	halved  []uint8
+}
+
+func (l *pyramidLevel) addRow(pix []uint8) error {
+	var row []uint8
+	if n := len(l.free); n > 0 {
+		row, l.free = l.free[n-1], l.free[:n-1]
+	} else {
+		row = make([]uint8, l.width*4)
+	}
+	copy(row, pix)
+	l.rows = append(l.rows, row)
+	y := l.y
+	l.y++
+
+	if l.next != nil {
+		if err := l.halve(y, row); err != nil {
+			return err
+		}
+	}
+
+	// Tiles overlap their neighbours, so the last row of a tile row is a
+	// little past its edge. The last row of the level can complete two.
+	for l.tileRow*DeepZoomTileSize < l.height && l.y >= min((l.tileRow+1)*DeepZoomTileSize+DeepZoomOverlap, l.height) {
+		if err := l.writeTileRow(); err != nil {
+			return err
+		}
+		l.tileRow++
+
+		// Keep the rows the next tile row overlaps
+		keep := l.tileRow*DeepZoomTileSize - DeepZoomOverlap
+		for l.top < keep && len(l.rows) > 0 {
+			l.free = append(l.free, l.rows[0])
+			l.rows = l.rows[1:]
+			l.top++
+		}
+	}
+	return nil
+}
+
+// halve averages each pair of rows and two columns into one pixel of the
+// next level. An odd last row or column is averaged with itself.
+func (l *pyramidLevel) halve(y int, row []uint8) error {
+	if y%2 == 0 && y < l.height-1 {
+		l.pending = append(l.pending[:0], row...)
+		return nil
+	}
+	upper := row
+	if y%2 == 1 {
+		upper = l.pending
+	}
+
+	if l.halved == nil {
+		l.halved = make([]uint8, l.next.width*4)
+	}
+	for x := 0; x < l.next.width; x++ {
+		x0, x1 := 2*x*4, min(2*x+1, l.width-1)*4
+		for c := 0; c < 4; c++ {
+			sum := int(upper[x0+c]) + int(upper[x1+c]) + int(row[x0+c]) + int(row[x1+c])
+			l.halved[x*4+c] = uint8((sum + 2) / 4)
+		}
+	}
+	return l.next.addRow(l.halved)
+}
+
+// writeTileRow cuts the buffered rows into tiles
+func (l *pyramidLevel) writeTileRow() error {
+	y0 := max(l.tileRow*DeepZoomTileSize-DeepZoomOverlap, 0)
+	y1 := min((l.tileRow+1)*DeepZoomTileSize+DeepZoomOverlap, l.height)
+
+	for col := 0; col*DeepZoomTileSize < l.width; col++ {
+		x0 := max(col*DeepZoomTileSize-DeepZoomOverlap, 0)
+		x1 := min((col+1)*DeepZoomTileSize+DeepZoomOverlap, l.width)
+
+		tile := image.NewRGBA(image.Rect(0, 0, x1-x0, y1-y0))
+		for y := y0; y < y1; y++ {
+			copy(tile.Pix[(y-y0)*tile.Stride:], l.rows[y-l.top][x0*4:x1*4])
+		}
+		if err := l.write(l.level, col, l.tileRow, tile); err != nil {
+			return err
+		}
+	}
+	return nil
+}
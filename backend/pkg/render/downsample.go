@@ -0,0 +1,134 @@
+package render
+
+import (
+	"image"
+	"math"
+)
+
+// Downsampler builds a smaller copy of an image from its rows, fed top to
+// bottom, by averaging the area each output pixel covers. Previews made
+// this way show exactly the full size render without holding it in memory.
+type Downsampler struct {
+	srcW, srcH int
+	dst        *image.RGBA
+	scaleY     float64
+	spans      []span       // horizontal contribution of each source column
+	row        []float64    // current source row reduced to the output width
+	acc        [2][]float64 // output rows base and base+1 being accumulated
+	base       int
+	next       int
+}
+
+// span is the contribution of one source pixel to at most two output pixels
+type span struct {
+	index  int
+	w0, w1 float64
+}
+
+// NewDownsampler prepares a downsampler from a srcW x srcH image to one of
+// at most the same size
+func NewDownsampler(srcW, srcH, dstW, dstH int) *Downsampler {
+	dstW = min(max(dstW, 1), srcW)
+	dstH = min(max(dstH, 1), srcH)
+
+	d := &Downsampler{
+		srcW:   srcW,
+		srcH:   srcH,
+		dst:    image.NewRGBA(image.Rect(0, 0, dstW, dstH)),
+		scaleY: float64(srcH) / float64(dstH),
+		spans:  coverage(srcW, dstW),
+		row:    make([]float64, dstW*4),
+	}
+	d.acc[0] = make([]float64, dstW*4)
+	d.acc[1] = make([]float64, dstW*4)
+	return d
+}
+
+// coverage returns how each of n source pixels spreads over m <= n output
+// pixels, as fractions of an output pixel
+func coverage(n, m int) []span {
+	scale := float64(n) / float64(m)
+	spans := make([]span, n)
+	for i := range spans {
+		start, end := float64(i)/scale, float64(i+1)/scale
+		index := int(math.Floor(start))
+		split := math.Min(end, float64(index+1))
+		spans[i] = span{index: index, w0: split - start, w1: end - split}
+		if index+1 >= m {
+			spans[i].w0 += spans[i].w1
+			spans[i].w1 = 0
+		}
+	}
+	return spans
+}
+
+// Next returns the source row the downsampler expects next
+func (d *Downsampler) Next() int {
+	return d.next
+}
+
+// AddRow adds the next source row, given as RGBA pixels
+func (d *Downsampler) AddRow(pix []uint8) {
+	if d.next >= d.srcH {
+		return
+	}
+
+	clear(d.row)
+	for x, sp := range d.spans {
+		p := pix[x*4 : x*4+4]
+		i := sp.index * 4
+		for c := 0; c < 4; c++ {
+			d.row[i+c] += float64(p[c]) * sp.w0
+		}
+		if sp.w1 > 0 {
+			for c := 0; c < 4; c++ {
+				d.row[i+4+c] += float64(p[c]) * sp.w1
+			}
+		}
+	}
+
+	y := d.next
+	d.next++
+	start, end := float64(y)/d.scaleY, float64(y+1)/d.scaleY
+	index := int(math.Floor(start))
+	for d.base < index {
+		d.flush()
+	}
+
+	split := math.Min(end, float64(index+1))
+	w0, w1 := split-start, end-split
+	if index+1 >= d.dst.Rect.Dy() {
+		w0, w1 = w0+w1, 0
+	}
+	for i, v := range d.row {
+		d.acc[0][i] += v * w0
+		if w1 > 0 {
+			d.acc[1][i] += v * w1
+		}
+	}
+
+	if d.next == d.srcH {
+		for d.base < d.dst.Rect.Dy() {
+			d.flush()
+		}
+	}
+}
+
+// flush writes the finished output row and starts accumulating the next
+func (d *Downsampler) flush() {
+	if d.base < d.dst.Rect.Dy() {
+		out := d.dst.Pix[d.base*d.dst.Stride : d.base*d.dst.Stride+len(d.acc[0])]
+		for i, v := range d.acc[0] {
+			out[i] = uint8(math.Min(math.Max(math.Round(v), 0), 255))
+		}
+	}
+	d.acc[0], d.acc[1] = d.acc[1], d.acc[0]
+	clear(d.acc[1])
+	d.base++
+}
+
+// Image returns the downsampled image, complete once every source row has
+// been added
+func (d *Downsampler) Image() *image.RGBA {
+	return d.dst
+}
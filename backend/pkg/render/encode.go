@@ -0,0 +1,136 @@
+package render
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"math"
+)
+
+// EncodeJPEG writes img as a JPEG whose JFIF header records dpi, so print
+// software picks up the intended physical size
+func EncodeJPEG(w io.Writer, img image.Image, quality int, dpi int) error {
+	iw := &insertWriter{w: w, offset: 2, segment: jfifSegment(dpi)}
+	if err := jpeg.Encode(iw, img, &jpeg.Options{Quality: quality}); err != nil {
+		return err
+	}
+	return iw.flush()
+}
+
+// EncodePNG writes img as a lossless PNG whose pHYs chunk records dpi
+func EncodePNG(w io.Writer, img image.Image, dpi int) error {
+	// The chunk goes right after the signature and the IHDR chunk
+	iw := &insertWriter{w: w, offset: 8 + 25, segment: physChunk(dpi)}
+	if err := png.Encode(iw, img); err != nil {
+		return err
+	}
+	return iw.flush()
+}
+
+// insertWriter inserts a segment at a fixed offset of a stream, for the
+// headers that image/jpeg and image/png do not write themselves
+type insertWriter struct {
+	w       io.Writer
+	offset  int
+	segment []byte
+	head    []byte
+	written bool
+}
+
+func (iw *insertWriter) Write(p []byte) (int, error) {
+	if iw.written {
+		return iw.w.Write(p)
+	}
+
+	n := len(p)
+	iw.head = append(iw.head, p...)
+	if len(iw.head) < iw.offset {
+		return n, nil
+	}
+	if err := iw.flush(); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// flush writes the buffered start of the stream with the segment
+func (iw *insertWriter) flush() error {
+	if iw.written {
+		return nil
+	}
+	iw.written = true
+
+	head := iw.head
+	iw.head = nil
+	if len(head) < iw.offset {
+		_, err := iw.w.Write(head)
+		return err
+	}
+
+	if _, err := iw.w.Write(head[:iw.offset]); err != nil {
+		return err
+	}
+	if _, err := iw.w.Write(iw.segment); err != nil {
+		return err
+	}
+	_, err := iw.w.Write(head[iw.offset:])
+	return err
+}
+
+// jfifSegment builds a JFIF 1.02 APP0 segment with the density in dots per inch
+func jfifSegment(dpi int) []byte {
+	if dpi <= 0 || dpi > 0xFFFF {
+		dpi = DefaultDPI
+	}
+	d0, d1 := byte(dpi>>8), byte(dpi)
+	return []byte{
+		0xFF, 0xE0, // APP0 marker
+		0x00, 0x10, // segment length
+		'J', 'F', 'I', 'F', 0x00,
+		0x01, 0x02, // version 1.02
+		0x01,   // density in dots per inch
+		d0, d1, // horizontal density
+		d0, d1, // vertical density
+		0x00, 0x00, // no thumbnail
+	}
+}
+
+// physChunk builds a PNG pHYs chunk with the density converted to pixels
+// per metre
+func physChunk(dpi int) []byte {
+	if dpi <= 0 {
+		dpi = DefaultDPI
+	}
+	ppm := uint32(math.Round(float64(dpi) / 0.0254))
+
+	chunk := make([]byte, 0, 21)
+	chunk = binary.BigEndian.AppendUint32(chunk, 9)
+	chunk = append(chunk, 'p', 'H', 'Y', 's')
+	chunk = binary.BigEndian.AppendUint32(chunk, ppm)
+	chunk = binary.BigEndian.AppendUint32(chunk, ppm)
+	chunk = append(chunk, 1) // unit is the metre
+	return binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+}
+
+// rgbaRow returns the RGBA pixels of row y of img, using buf for images
+// that do not hold their pixels in that layout
+func rgbaRow(img image.Image, y int, buf []uint8) []uint8 {
+	b := img.Bounds()
+	switch m := img.(type) {
+	case interface{ RGBARow(y int) []uint8 }:
+		return m.RGBARow(y)
+	case *image.RGBA:
+		offset := m.PixOffset(b.Min.X, y)
+		return m.Pix[offset : offset+b.Dx()*4]
+	}
+
+	buf = buf[:0]
+	for x := b.Min.X; x < b.Max.X; x++ {
+		r, g, bl, a := img.At(x, y).RGBA()
+		buf = append(buf, uint8(r>>8), uint8(g>>8), uint8(bl>>8), uint8(a>>8))
+	}
+	return buf
+}
@@ -0,0 +1,163 @@
+package render
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"io"
+)
+
+// Export formats
+const (
+	FormatJPEG   = "jpeg"
+	FormatPNG    = "png"
+	FormatWebP   = "webp"
+	FormatTIFF   = "tiff"
+	FormatPDF    = "pdf"    // a print-ready page, see PrintSpec
+	FormatDZI    = "dzi"    // a Deep Zoom pyramid of JPEG tiles, see WriteDeepZoom
+	FormatLayers = "layers" // the tiles and the guide as separate PNGs
+	FormatGIF    = "gif"    // an animated GIF, see AnimationSpec
+	FormatAPNG   = "apng"   // an animated PNG
+	FormatFrames = "frames" // the frames of an animation as numbered PNGs in a zip
+)
+
+// DefaultJPEGQuality is used when an export does not set a quality
+const DefaultJPEGQuality = 90
+
+// Layers of a layered export
+const (
+	LayerTiles = "tiles"
+	LayerGuide = "guide"
+)
+
+// ExportSpec selects the format of an exported mosaic and its options
+type ExportSpec struct {
+	Format      string         `json:"format"`                // jpeg, png, webp, tiff, pdf, dzi, layers, gif, apng or frames
+	Quality     int            `json:"quality,omitempty"`     // jpeg quality from 1 to 100, 90 if zero; also used for pdf images and dzi tiles
+	Progressive bool           `json:"progressive,omitempty"` // jpeg only
+	Print       *PrintSpec     `json:"print,omitempty"`       // pdf only, an edge to edge A4 page if omitted
+	Animation   *AnimationSpec `json:"animation,omitempty"`   // gif, apng and frames only, a fade if omitted
+}
+
+// Validate checks that an output of the given size can be exported with
+// the spec
+func (s ExportSpec) Validate(size Size) error {
+	switch s.Format {
+	case FormatJPEG:
+		if s.Quality < 0 || s.Quality > 100 {
+			return errors.New("jpeg quality must be between 1 and 100")
+		}
+		if s.Progressive && size.Width*size.Height > MaxProgressivePixels {
+			return fmt.Errorf("progressive jpeg is limited to %d megapixels", MaxProgressivePixels/1_000_000)
+		}
+	case FormatWebP:
+		if size.Width > MaxWebPSide || size.Height > MaxWebPSide {
+			return fmt.Errorf("webp is limited to %d pixels per side", MaxWebPSide)
+		}
+	case FormatPDF, FormatDZI:
+		if s.Quality < 0 || s.Quality > 100 {
+			return errors.New("jpeg quality must be between 1 and 100")
+		}
+		if s.Print != nil {
+			if err := s.Print.Validate(size); err != nil {
+				return err
+			}
+		}
+	case FormatGIF, FormatAPNG, FormatFrames:
+		if s.Animation != nil {
+			if err := s.Animation.Validate(); err != nil {
+				return err
+			}
+		}
+	case FormatPNG, FormatTIFF, FormatLayers:
+	default:
+		return fmt.Errorf("unknown export format %q", s.Format)
+	}
+
+	if s.Format != FormatJPEG && s.Format != FormatPDF && s.Format != FormatDZI && s.Quality != 0 {
+		return fmt.Errorf("quality only applies to jpeg, pdf and dzi, not %s", s.Format)
+	}
+	if s.Format != FormatJPEG && s.Progressive {
+		return fmt.Errorf("progressive only applies to jpeg, not %s", s.Format)
+	}
+	if s.Format != FormatPDF && s.Print != nil {
+		return fmt.Errorf("print layout only applies to pdf, not %s", s.Format)
+	}
+	if !s.Animated() && s.Animation != nil {
+		return fmt.Errorf("animation only applies to gif, apng and frames, not %s", s.Format)
+	}
+	return nil
+}
+
+// Animated reports whether the spec's format is an animation
+func (s ExportSpec) Animated() bool {
+	return s.Format == FormatGIF || s.Format == FormatAPNG || s.Format == FormatFrames
+}
+
+// Transparent reports whether the spec's format keeps the transparency of
+// a mosaic with a transparent background; other formats are drawn on white
+func (s ExportSpec) Transparent() bool {
+	return s.Format == FormatPNG || s.Format == FormatWebP || s.Format == FormatLayers
+}
+
+// Extension returns the file extension of the spec's format
+func (s ExportSpec) Extension() string {
+	switch s.Format {
+	case FormatJPEG:
+		return ".jpg"
+	case FormatTIFF:
+		return ".tif"
+	case FormatWebP:
+		return ".webp"
+	case FormatPDF:
+		return ".pdf"
+	case FormatDZI:
+		return ".dzi"
+	case FormatGIF:
+		return ".gif"
+	case FormatFrames:
+		return ".zip"
+	default:
+		return ".png"
+	}
+}
+
+// JPEGQuality returns the quality JPEG data of the export is encoded at
+func (s ExportSpec) JPEGQuality() int {
+	if s.Quality == 0 {
+		return DefaultJPEGQuality
+	}
+	return s.Quality
+}
+
+// Encode writes img in the spec's format. The sample is a small copy of
+// img that WebP builds its codes from; layered exports are encoded one
+// layer at a time as PNGs. Deep Zoom pyramids are many files and are
+// written with WriteDeepZoom instead, and animations with WriteAnimation.
+func (s ExportSpec) Encode(w io.WriteSeeker, img image.Image, dpi int, sample image.Image) error {
+	quality := s.JPEGQuality()
+	switch s.Format {
+	case FormatJPEG:
+		if s.Progressive {
+			return EncodeProgressiveJPEG(w, img, quality, dpi)
+		}
+		return EncodeJPEG(w, img, quality, dpi)
+	case FormatWebP:
+		return EncodeWebP(w, img, sample)
+	case FormatTIFF:
+		return EncodeTIFF16(w, img, dpi)
+	case FormatPDF:
+		var layout PrintSpec
+		if s.Print != nil {
+			layout = *s.Print
+		}
+		return EncodePDF(w, img, layout, quality)
+	case FormatPNG, FormatLayers:
+		return EncodePNG(w, img, dpi)
+	case FormatDZI:
+		return errors.New("deep zoom pyramids are written with WriteDeepZoom")
+	case FormatGIF, FormatAPNG, FormatFrames:
+		return errors.New("animations are written with WriteAnimation")
+	}
+	return fmt.Errorf("unknown export format %q", s.Format)
+}
@@ -0,0 +1,272 @@
+package render
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/image/tiff"
+	"golang.org/x/image/webp"
+)
+
+// encodeSizes are the sizes each encoder is checked at: a single pixel,
+// sizes that do not fill whole blocks or MCUs, and a row longer than a
+// WebP predictor block
+var encodeSizes = []image.Point{{1, 1}, {17, 9}, {1, 33}, {600, 5}}
+
+// translucentImage returns an image whose pixels vary in colour and alpha
+func translucentImage(w, h int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{uint8(x * 7), uint8(y * 13), uint8(x ^ y), uint8(x*29 + y*3)})
+		}
+	}
+	return img
+}
+
+// encodeFile encodes into a temporary file and reads it back, since WebP
+// seeks to fill in its chunk sizes
+func encodeFile(t *testing.T, encode func(f *os.File) error) []byte {
+	t.Helper()
+	f, err := os.Create(filepath.Join(t.TempDir(), "out"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	// Start part way into the file, as an encoder writing after other data would
+	if _, err := f.Write([]byte("prefix")); err != nil {
+		t.Fatal(err)
+	}
+	if err := encode(f); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data[len("prefix"):]
+}
+
+// comparePixels fails if a pixel of got is further than tolerance from
+// want in any premultiplied 8-bit channel, returning the mean difference
+func comparePixels(t *testing.T, got, want image.Image, tolerance int) float64 {
+	t.Helper()
+	if got.Bounds().Size() != want.Bounds().Size() {
+		t.Fatalf("decoded %v, want %v", got.Bounds().Size(), want.Bounds().Size())
+	}
+	var total, count int
+	gb, wb := got.Bounds(), want.Bounds()
+	for y := 0; y < wb.Dy(); y++ {
+		for x := 0; x < wb.Dx(); x++ {
+			r1, g1, b1, a1 := got.At(gb.Min.X+x, gb.Min.Y+y).RGBA()
+			r2, g2, b2, a2 := want.At(wb.Min.X+x, wb.Min.Y+y).RGBA()
+			for c, pair := range [4][2]uint32{{r1, r2}, {g1, g2}, {b1, b2}, {a1, a2}} {
+				d := int(pair[0]>>8) - int(pair[1]>>8)
+				if d < 0 {
+					d = -d
+				}
+				if d > tolerance {
+					t.Fatalf("pixel %d,%d channel %d: got %v, want %v", x, y, c, got.At(gb.Min.X+x, gb.Min.Y+y), want.At(wb.Min.X+x, wb.Min.Y+y))
+				}
+				total += d
+				count++
+			}
+		}
+	}
+	return float64(total) / float64(count)
+}
+
+func TestWebPRoundTrip(t *testing.T) {
+	for _, size := range encodeSizes {
+		opaque := syntheticImage(size.X, size.Y, 3)
+		translucent := translucentImage(size.X, size.Y)
+		cases := []struct {
+			name   string
+			img    image.Image
+			sample image.Image
+		}{
+			{"opaque", opaque, nil},
+			{"translucent", translucent, nil},
+			// The sample only sizes the codes, so any sample gives the same pixels
+			{"other sample", opaque, translucentImage(3, 2)},
+			{"translucent other sample", translucent, syntheticImage(4, 4, 9)},
+		}
+		for _, tc := range cases {
+			t.Run(tc.name, func(t *testing.T) {
+				data := encodeFile(t, func(f *os.File) error { return EncodeWebP(f, tc.img, tc.sample) })
+				if got := binary.LittleEndian.Uint32(data[4:]); int(got) != len(data)-8 {
+					t.Fatalf("RIFF size %d, file is %d bytes", got, len(data))
+				}
+				decoded, err := webp.Decode(bytes.NewReader(data))
+				if err != nil {
+					t.Fatalf("%v: %v", size, err)
+				}
+				// WebP stores colours without premultiplied alpha, which
+				// can move a premultiplied channel by one
+				tolerance := 0
+				if tc.img == translucent {
+					tolerance = 1
+				}
+				comparePixels(t, decoded, tc.img, tolerance)
+			})
+		}
+	}
+
+	if err := EncodeWebP(nil, image.NewRGBA(image.Rect(0, 0, MaxWebPSide+1, 1)), nil); err == nil {
+		t.Fatal("encoded a WebP wider than the format allows")
+	}
+}
+
+// tiffResolution reads the X and Y resolutions of the first directory of
+// a little-endian TIFF file
+func tiffResolution(t *testing.T, data []byte) (x, y float64) {
+	t.Helper()
+	le := binary.LittleEndian
+	ifd := le.Uint32(data[4:])
+	count := int(le.Uint16(data[ifd:]))
+	rational := func(entry []byte) float64 {
+		offset := le.Uint32(entry[8:])
+		return float64(le.Uint32(data[offset:])) / float64(le.Uint32(data[offset+4:]))
+	}
+	for i := 0; i < count; i++ {
+		entry := data[int(ifd)+2+12*i:]
+		switch le.Uint16(entry) {
+		case tiffXResolution:
+			x = rational(entry)
+		case tiffYResolution:
+			y = rational(entry)
+		case tiffResolutionUnit:
+			if unit := le.Uint16(entry[8:]); unit != 2 {
+				t.Fatalf("resolution unit %d, want inches", unit)
+			}
+		}
+	}
+	return x, y
+}
+
+func TestTIFF16RoundTrip(t *testing.T) {
+	for _, size := range encodeSizes {
+		img := syntheticImage(size.X, size.Y, 5)
+		var buf bytes.Buffer
+		if err := EncodeTIFF16(&buf, img, 300); err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := tiff.Decode(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatalf("%v: %v", size, err)
+		}
+		if _, ok := decoded.(*image.RGBA64); !ok {
+			t.Fatalf("decoded a %T, want 16 bits per sample", decoded)
+		}
+		comparePixels(t, decoded, img, 0)
+		if x, y := tiffResolution(t, buf.Bytes()); x != 300 || y != 300 {
+			t.Fatalf("resolution %vx%v, want 300 dpi", x, y)
+		}
+	}
+
+	// Alpha is dropped and the default resolution applies
+	var buf bytes.Buffer
+	if err := EncodeTIFF16(&buf, translucentImage(5, 3), 0); err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := tiff.Decode(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, a := decoded.At(4, 2).RGBA(); a != 0xFFFF {
+		t.Fatalf("alpha %d, want opaque", a)
+	}
+	if x, _ := tiffResolution(t, buf.Bytes()); x != DefaultDPI {
+		t.Fatalf("resolution %v, want %d", x, DefaultDPI)
+	}
+}
+
+func TestProgressiveJPEGRoundTrip(t *testing.T) {
+	for _, size := range append(encodeSizes, image.Point{X: 45, Y: 31}) {
+		img := syntheticImage(size.X, size.Y, 7)
+		var buf bytes.Buffer
+		if err := EncodeProgressiveJPEG(&buf, img, 95, 240); err != nil {
+			t.Fatal(err)
+		}
+		data := buf.Bytes()
+		if !bytes.Contains(data, []byte{0xFF, jpegSOF2}) {
+			t.Fatal("no progressive frame header")
+		}
+		decoded, err := jpeg.Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("%v: %v", size, err)
+		}
+		if mean := comparePixels(t, decoded, img, 48); mean > 4 {
+			t.Fatalf("%v: mean difference %.1f", size, mean)
+		}
+
+		// The JFIF header records the density in dots per inch
+		jfif := data[2:20]
+		if !bytes.Equal(jfif[4:9], []byte("JFIF\x00")) || jfif[11] != 1 ||
+			binary.BigEndian.Uint16(jfif[12:]) != 240 || binary.BigEndian.Uint16(jfif[14:]) != 240 {
+			t.Fatalf("JFIF segment % x", jfif)
+		}
+	}
+
+	// Lower quality gives a smaller file of the same image
+	img := syntheticImage(64, 48, 2)
+	var high, low bytes.Buffer
+	if err := EncodeProgressiveJPEG(&high, img, 95, 72); err != nil {
+		t.Fatal(err)
+	}
+	if err := EncodeProgressiveJPEG(&low, img, 20, 72); err != nil {
+		t.Fatal(err)
+	}
+	if low.Len() >= high.Len() {
+		t.Fatalf("quality 20 is %d bytes, quality 95 is %d", low.Len(), high.Len())
+	}
+}
+
+func TestExportSpecValidate(t *testing.T) {
+	size := Size{Width: 4000, Height: 3000}
+	tests := []struct {
+		name  string
+		spec  ExportSpec
+		size  Size
+		valid bool
+	}{
+		{"jpeg", ExportSpec{Format: FormatJPEG, Quality: 80}, size, true},
+		{"jpeg default quality", ExportSpec{Format: FormatJPEG}, size, true},
+		{"jpeg quality too high", ExportSpec{Format: FormatJPEG, Quality: 101}, size, false},
+		{"jpeg negative quality", ExportSpec{Format: FormatJPEG, Quality: -1}, size, false},
+		{"progressive jpeg", ExportSpec{Format: FormatJPEG, Progressive: true}, size, true},
+		{"progressive jpeg too large", ExportSpec{Format: FormatJPEG, Progressive: true}, Size{Width: 10000, Height: 5001}, false},
+		{"webp", ExportSpec{Format: FormatWebP}, Size{Width: MaxWebPSide, Height: 10}, true},
+		{"webp too wide", ExportSpec{Format: FormatWebP}, Size{Width: MaxWebPSide + 1, Height: 10}, false},
+		{"webp too tall", ExportSpec{Format: FormatWebP}, Size{Width: 10, Height: MaxWebPSide + 1}, false},
+		{"webp quality", ExportSpec{Format: FormatWebP, Quality: 80}, size, false},
+		{"png progressive", ExportSpec{Format: FormatPNG, Progressive: true}, size, false},
+		{"tiff", ExportSpec{Format: FormatTIFF}, size, true},
+		{"tiff print layout", ExportSpec{Format: FormatTIFF, Print: &PrintSpec{Paper: "a4"}}, size, false},
+		{"pdf", ExportSpec{Format: FormatPDF, Quality: 70, Print: &PrintSpec{Paper: "a4", Bleed: 3}}, size, true},
+		{"pdf unknown paper", ExportSpec{Format: FormatPDF, Print: &PrintSpec{Paper: "napkin"}}, size, false},
+		{"dzi quality", ExportSpec{Format: FormatDZI, Quality: 60}, size, true},
+		{"gif", ExportSpec{Format: FormatGIF, Animation: &AnimationSpec{Frames: 10}}, size, true},
+		{"gif too few frames", ExportSpec{Format: FormatGIF, Animation: &AnimationSpec{Frames: 1}}, size, false},
+		{"png animation", ExportSpec{Format: FormatPNG, Animation: &AnimationSpec{}}, size, false},
+		{"unknown format", ExportSpec{Format: "bmp"}, size, false},
+		{"no format", ExportSpec{}, size, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.spec.Validate(tt.size)
+			if tt.valid && err != nil {
+				t.Fatalf("rejected: %v", err)
+			}
+			if !tt.valid && err == nil {
+				t.Fatal("accepted")
+			}
+		})
+	}
+}
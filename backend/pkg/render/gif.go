@@ -0,0 +1,287 @@
+package render
+
+import (
+	"bufio"
+	"compress/lzw"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"io"
+	"math"
+	"sort"
+	"time"
+)
+
+// gifWriter writes an animated GIF one frame at a time. image/gif only
+// encodes whole animations, which would hold every frame in memory. All
+// frames share one global palette and are dithered to it.
+type gifWriter struct {
+	w        *bufio.Writer
+	dither   *ditherer
+	paletted []uint8
+}
+
+// newGIFWriter writes the header of a GIF of frames of rectangle r. The
+// palette must have at most 256 colours.
+func newGIFWriter(w io.Writer, r image.Rectangle, palette color.Palette, loop bool) (*gifWriter, error) {
+	g := &gifWriter{
+		w:        bufio.NewWriter(w),
+		dither:   newDitherer(palette, r.Dx()),
+		paletted: make([]uint8, r.Dx()*r.Dy()),
+	}
+
+	// Logical screen with a 256 colour global table, padded with black
+	header := []byte("GIF89a")
+	header = binary.LittleEndian.AppendUint16(header, uint16(r.Dx()))
+	header = binary.LittleEndian.AppendUint16(header, uint16(r.Dy()))
+	header = append(header, 0xF7, 0, 0)
+	for i := 0; i < 256; i++ {
+		var cr, cg, cb uint32
+		if i < len(palette) {
+			cr, cg, cb, _ = palette[i].RGBA()
+		}
+		header = append(header, uint8(cr>>8), uint8(cg>>8), uint8(cb>>8))
+	}
+	if loop {
+		header = append(header, 0x21, 0xFF, 0x0B)
+		header = append(header, "NETSCAPE2.0"...)
+		header = append(header, 0x03, 0x01, 0x00, 0x00, 0x00) // loop forever
+	}
+	_, err := g.w.Write(header)
+	return g, err
+}
+
+func (g *gifWriter) WriteFrame(frame *image.RGBA, delay time.Duration) error {
+	b := frame.Bounds()
+	g.dither.draw(g.paletted, frame)
+
+	// Graphic control extension with the delay in hundredths of a second,
+	// then an image descriptor covering the whole screen
+	block := []byte{0x21, 0xF9, 0x04, 0x00}
+	block = binary.LittleEndian.AppendUint16(block, uint16(math.Round(delay.Seconds()*100)))
+	block = append(block, 0x00, 0x00)
+	block = append(block, 0x2C, 0, 0, 0, 0)
+	block = binary.LittleEndian.AppendUint16(block, uint16(b.Dx()))
+	block = binary.LittleEndian.AppendUint16(block, uint16(b.Dy()))
+	block = append(block, 0x00, 8) // no local table, 8 bit codes
+	if _, err := g.w.Write(block); err != nil {
+		return err
+	}
+
+	bw := &gifBlockWriter{w: g.w}
+	lw := lzw.NewWriter(bw, lzw.LSB, 8)
+	if _, err := lw.Write(g.paletted); err != nil {
+		return err
+	}
+	if err := lw.Close(); err != nil {
+		return err
+	}
+	if err := bw.flush(); err != nil {
+		return err
+	}
+	return g.w.WriteByte(0x00)
+}
+
+func (g *gifWriter) Close() error {
+	if err := g.w.WriteByte(0x3B); err != nil {
+		return err
+	}
+	return g.w.Flush()
+}
+
+// gifBlockWriter splits image data into the sub-blocks of up to 255 bytes
+// that GIF stores it in
+type gifBlockWriter struct {
+	w   io.Writer
+	buf [256]byte
+	n   int
+}
+
+func (b *gifBlockWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := copy(b.buf[1+b.n:], p)
+		b.n += n
+		p = p[n:]
+		written += n
+		if b.n == 255 {
+			if err := b.flush(); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// flush writes the buffered bytes as one sub-block
+func (b *gifBlockWriter) flush() error {
+	if b.n == 0 {
+		return nil
+	}
+	b.buf[0] = uint8(b.n)
+	_, err := b.w.Write(b.buf[:1+b.n])
+	b.n = 0
+	return err
+}
+
+// ditherer maps frames onto a palette with Floyd-Steinberg error
+// diffusion. Nearest colours are looked up once per 6 bit colour cell and
+// remembered, as every frame reuses most of the colours of the last.
+type ditherer struct {
+	palette [][3]int32
+	nearest []int16 // palette index per colour cell, -1 until looked up
+	errs    [2][]int32
+}
+
+func newDitherer(palette color.Palette, width int) *ditherer {
+	d := &ditherer{
+		palette: make([][3]int32, len(palette)),
+		nearest: make([]int16, 1<<18),
+	}
+	for i, c := range palette {
+		r, g, b, _ := c.RGBA()
+		d.palette[i] = [3]int32{int32(r >> 8), int32(g >> 8), int32(b >> 8)}
+	}
+	for i := range d.nearest {
+		d.nearest[i] = -1
+	}
+	// Error rows have a spare pixel on each side
+	d.errs[0] = make([]int32, (width+2)*3)
+	d.errs[1] = make([]int32, (width+2)*3)
+	return d
+}
+
+// draw writes the palette index of every pixel of frame to dst, row by row
+func (d *ditherer) draw(dst []uint8, frame *image.RGBA) {
+	b := frame.Bounds()
+	w := b.Dx()
+	clear(d.errs[0])
+	for y := 0; y < b.Dy(); y++ {
+		cur, next := d.errs[0], d.errs[1]
+		clear(next)
+		row := frame.Pix[frame.PixOffset(b.Min.X, b.Min.Y+y):]
+		for x := 0; x < w; x++ {
+			var v [3]int32
+			for c := 0; c < 3; c++ {
+				v[c] = min(max(int32(row[x*4+c])+(cur[(x+1)*3+c]+8)/16, 0), 255)
+			}
+			index := d.lookup(v)
+			dst[y*w+x] = uint8(index)
+
+			// Spread the error right and onto the next row
+			p := d.palette[index]
+			for c := 0; c < 3; c++ {
+				e := v[c] - p[c]
+				cur[(x+2)*3+c] += e * 7
+				next[x*3+c] += e * 3
+				next[(x+1)*3+c] += e * 5
+				next[(x+2)*3+c] += e
+			}
+		}
+		d.errs[0], d.errs[1] = next, cur
+	}
+}
+
+// lookup returns the palette index nearest to the centre of v's colour cell
+func (d *ditherer) lookup(v [3]int32) int {
+	key := v[0]>>2<<12 | v[1]>>2<<6 | v[2]>>2
+	if i := d.nearest[key]; i >= 0 {
+		return int(i)
+	}
+
+	best, bestDist := 0, int32(math.MaxInt32)
+	for i, p := range d.palette {
+		var dist int32
+		for c := 0; c < 3; c++ {
+			diff := v[c]>>2<<2 + 2 - p[c]
+			dist += diff * diff
+		}
+		if dist < bestDist {
+			best, bestDist = i, dist
+		}
+	}
+	d.nearest[key] = int16(best)
+	return best
+}
+
+// quantizeSamples caps the pixels a palette is chosen from, per image
+const quantizeSamples = 1 << 16
+
+// quantize chooses up to n colours for images by median cut: the box of
+// colours with the widest spread is split at its median until there are n
+// boxes, and each box gives its mean colour
+func quantize(images []*image.RGBA, n int) color.Palette {
+	var pixels [][3]uint8
+	for _, img := range images {
+		b := img.Bounds()
+		step := max(b.Dx()*b.Dy()/quantizeSamples, 1)
+		for i := 0; i < b.Dx()*b.Dy(); i += step {
+			p := img.PixOffset(b.Min.X+i%b.Dx(), b.Min.Y+i/b.Dx())
+			pixels = append(pixels, [3]uint8{img.Pix[p], img.Pix[p+1], img.Pix[p+2]})
+		}
+	}
+	if len(pixels) == 0 {
+		return color.Palette{color.Black}
+	}
+
+	boxes := [][][3]uint8{pixels}
+	for len(boxes) < n {
+		// Split the box whose widest channel spreads the most
+		best, channel, spread := -1, 0, 0
+		for i, box := range boxes {
+			if len(box) < 2 {
+				continue
+			}
+			c, s := widestChannel(box)
+			if s > spread {
+				best, channel, spread = i, c, s
+			}
+		}
+		if best < 0 {
+			break
+		}
+
+		box := boxes[best]
+		sort.Slice(box, func(i, j int) bool { return box[i][channel] < box[j][channel] })
+		half := len(box) / 2
+		boxes[best] = box[:half]
+		boxes = append(boxes, box[half:])
+	}
+
+	palette := make(color.Palette, len(boxes))
+	for i, box := range boxes {
+		var sum [3]int
+		for _, p := range box {
+			sum[0] += int(p[0])
+			sum[1] += int(p[1])
+			sum[2] += int(p[2])
+		}
+		palette[i] = color.RGBA{
+			R: uint8((sum[0] + len(box)/2) / len(box)),
+			G: uint8((sum[1] + len(box)/2) / len(box)),
+			B: uint8((sum[2] + len(box)/2) / len(box)),
+			A: 255,
+		}
+	}
+	return palette
+}
+
+// widestChannel returns the channel with the largest range in pixels and
+// the range
+func widestChannel(pixels [][3]uint8) (int, int) {
+	lo := pixels[0]
+	hi := pixels[0]
+	for _, p := range pixels[1:] {
+		for c := 0; c < 3; c++ {
+			lo[c] = min(lo[c], p[c])
+			hi[c] = max(hi[c], p[c])
+		}
+	}
+	channel := 0
+	for c := 1; c < 3; c++ {
+		if hi[c]-lo[c] > hi[channel]-lo[channel] {
+			channel = c
+		}
+	}
+	return channel, int(hi[channel] - lo[channel])
+}
@@ -0,0 +1,118 @@
+package render
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+
+	xdraw "golang.org/x/image/draw"
+	"golang.org/x/image/math/f64"
+)
+
+// Guide is the main image mapped onto the output canvas. It is cropped to
+// the output's aspect ratio and upscaled one region at a time, so a
+// poster-sized copy of the main image never has to exist in memory.
+type Guide struct {
+	src  image.Image
+	crop image.Rectangle
+	size Size
+}
+
+// NewGuide maps src onto an output of the given size
+func NewGuide(src image.Image, size Size) *Guide {
+	b := src.Bounds()
+	crop := CoverCrop(b.Dx(), b.Dy(), size).Add(b.Min)
+	return &Guide{src: src, crop: crop, size: size}
+}
+
+// Size returns the output size the guide is mapped to
+func (g *Guide) Size() Size {
+	return g.size
+}
+
+// Crop returns the region of the main image that is used
+func (g *Guide) Crop() image.Rectangle {
+	return g.crop
+}
+
+// Scale returns the number of output pixels per main image pixel
+func (g *Guide) Scale() float64 {
+	return float64(g.size.Width) / float64(g.crop.Dx())
+}
+
+// SourceRect maps a region of the output onto the main image
+func (g *Guide) SourceRect(r image.Rectangle) image.Rectangle {
+	sx := float64(g.crop.Dx()) / float64(g.size.Width)
+	sy := float64(g.crop.Dy()) / float64(g.size.Height)
+
+	src := image.Rect(
+		g.crop.Min.X+int(math.Floor(float64(r.Min.X)*sx)),
+		g.crop.Min.Y+int(math.Floor(float64(r.Min.Y)*sy)),
+		g.crop.Min.X+int(math.Ceil(float64(r.Max.X)*sx)),
+		g.crop.Min.Y+int(math.Ceil(float64(r.Max.Y)*sy)),
+	).Intersect(g.crop)
+	if src.Empty() {
+		// Regions smaller than a source pixel still sample one pixel
+		p := image.Pt(g.crop.Min.X+int(float64(r.Min.X)*sx), g.crop.Min.Y+int(float64(r.Min.Y)*sy))
+		src = image.Rectangle{Min: p, Max: p.Add(image.Pt(1, 1))}.Intersect(g.crop)
+	}
+	return src
+}
+
+// Overlay blends the guide over region r of dst with the given opacity
+// between 0 and 1. The guide is sampled with the same mapping whatever the
+// region, so regions can be drawn in any order or split across bands.
+func (g *Guide) Overlay(dst draw.Image, r image.Rectangle, opacity float64) {
+	r = r.Intersect(dst.Bounds())
+	if opacity <= 0 || r.Empty() {
+		return
+	}
+
+	scaled := image.NewRGBA(r)
+	xdraw.ApproxBiLinear.Transform(scaled, g.transform(), g.src, g.crop, draw.Src, nil)
+	blend(dst, r, scaled, opacity)
+}
+
+// OverlayView blends the guide over all of dst as seen through view, a
+// region of the output scaled to dst's bounds. Unlike Overlay it filters
+// the main image, so it also holds up when the view is scaled down.
+func (g *Guide) OverlayView(dst *image.RGBA, view View, opacity float64) {
+	r := dst.Bounds()
+	if opacity <= 0 || r.Empty() {
+		return
+	}
+
+	// Compose the mapping onto the output with the one from the view
+	// onto dst
+	t := g.transform()
+	k := float64(r.Dx()) / view.W
+	t = f64.Aff3{
+		t[0] * k, 0, (t[2]-view.X)*k + float64(r.Min.X),
+		0, t[4] * k, (t[5]-view.Y)*k + float64(r.Min.Y),
+	}
+
+	scaled := image.NewRGBA(r)
+	xdraw.CatmullRom.Transform(scaled, t, g.src, g.crop, draw.Src, nil)
+	blend(dst, r, scaled, opacity)
+}
+
+// blend draws region r of src over dst with the given opacity
+func blend(dst draw.Image, r image.Rectangle, src image.Image, opacity float64) {
+	if opacity >= 1 {
+		draw.Draw(dst, r, src, r.Min, draw.Src)
+		return
+	}
+	mask := image.NewUniform(color.Alpha{A: uint8(math.Round(opacity * 255))})
+	draw.DrawMask(dst, r, src, r.Min, mask, image.Point{}, draw.Over)
+}
+
+// transform returns the affine mapping from main image to output pixels
+func (g *Guide) transform() f64.Aff3 {
+	sx := float64(g.size.Width) / float64(g.crop.Dx())
+	sy := float64(g.size.Height) / float64(g.crop.Dy())
+	return f64.Aff3{
+		sx, 0, -float64(g.crop.Min.X) * sx,
+		0, sy, -float64(g.crop.Min.Y) * sy,
+	}
+}
@@ -0,0 +1,127 @@
+package render
+
+import (
+	"encoding/binary"
+	"math"
+	"sync"
+)
+
+// sRGB primaries and white point adapted to the D50 profile connection
+// space, as published with IEC 61966-2-1
+var (
+	iccD50   = [3]float64{0.9642, 1.0, 0.8249}
+	iccRed   = [3]float64{0.4360747, 0.2225045, 0.0139322}
+	iccGreen = [3]float64{0.3850649, 0.7168786, 0.0971045}
+	iccBlue  = [3]float64{0.1430804, 0.0606169, 0.7141733}
+)
+
+// iccCurvePoints is the number of entries of the tone curves
+const iccCurvePoints = 1024
+
+var (
+	srgbProfile     []byte
+	srgbProfileOnce sync.Once
+)
+
+// SRGBProfile returns a version 2 ICC profile of the sRGB colour space,
+// the space every mosaic is rendered in. It is built rather than shipped
+// as a file and is about 2.6 KB.
+func SRGBProfile() []byte {
+	srgbProfileOnce.Do(func() {
+		srgbProfile = buildSRGBProfile()
+	})
+	return srgbProfile
+}
+
+func buildSRGBProfile() []byte {
+	curve := iccType("curv")
+	curve = binary.BigEndian.AppendUint32(curve, iccCurvePoints)
+	for i := 0; i < iccCurvePoints; i++ {
+		v := float64(i) / (iccCurvePoints - 1)
+		if v <= 0.04045 {
+			v /= 12.92
+		} else {
+			v = math.Pow((v+0.055)/1.055, 2.4)
+		}
+		curve = binary.BigEndian.AppendUint16(curve, uint16(math.Round(v*0xFFFF)))
+	}
+
+	tags := []struct {
+		sig  string
+		data []byte
+	}{
+		{"desc", iccDescription("sRGB IEC61966-2.1")},
+		{"cprt", append(append(iccType("text"), "No copyright, use freely"...), 0)},
+		{"wtpt", iccXYZ(iccD50)},
+		{"rXYZ", iccXYZ(iccRed)},
+		{"gXYZ", iccXYZ(iccGreen)},
+		{"bXYZ", iccXYZ(iccBlue)},
+		{"rTRC", curve},
+		{"gTRC", curve},
+		{"bTRC", curve},
+	}
+
+	// The tag table follows the 128 byte header; tag data is 4 byte
+	// aligned and the three tone curves share one copy
+	offset := 128 + 4 + 12*len(tags)
+	table := binary.BigEndian.AppendUint32(nil, uint32(len(tags)))
+	var data []byte
+	var curveOffset int
+	for _, tag := range tags {
+		tagOffset := offset + len(data)
+		if tag.sig[1:] == "TRC" {
+			if curveOffset == 0 {
+				curveOffset = tagOffset
+				data = append(data, tag.data...)
+			}
+			tagOffset = curveOffset
+		} else {
+			data = append(data, tag.data...)
+		}
+		for len(data)%4 != 0 {
+			data = append(data, 0)
+		}
+		table = append(table, tag.sig...)
+		table = binary.BigEndian.AppendUint32(table, uint32(tagOffset))
+		table = binary.BigEndian.AppendUint32(table, uint32(len(tag.data)))
+	}
+
+	size := offset + len(data)
+	header := make([]byte, 0, 128)
+	header = binary.BigEndian.AppendUint32(header, uint32(size))
+	header = append(header, 0, 0, 0, 0)                        // preferred CMM
+	header = binary.BigEndian.AppendUint32(header, 0x02100000) // version 2.1
+	header = append(header, "mntrRGB XYZ "...)
+	header = append(header, 0x07, 0xD0, 0, 1, 0, 1, 0, 0, 0, 0, 0, 0) // 2000-01-01
+	header = append(header, "acsp"...)
+	header = append(header, make([]byte, 4+4+4+4+8+4)...) // platform to rendering intent
+	header = append(header, iccXYZ(iccD50)[8:]...)        // illuminant
+	header = append(header, make([]byte, 128-len(header))...)
+
+	profile := append(header, table...)
+	return append(profile, data...)
+}
+
+// iccType starts a tag with its type signature and reserved bytes
+func iccType(sig string) []byte {
+	return append([]byte(sig), 0, 0, 0, 0)
+}
+
+// iccXYZ builds an XYZ tag from values in s15Fixed16 format
+func iccXYZ(v [3]float64) []byte {
+	tag := iccType("XYZ ")
+	for _, c := range v {
+		tag = binary.BigEndian.AppendUint32(tag, uint32(int32(math.Round(c*65536))))
+	}
+	return tag
+}
+
+// iccDescription builds a version 2 text description tag with an ASCII
+// description and empty Unicode and ScriptCode descriptions
+func iccDescription(text string) []byte {
+	tag := iccType("desc")
+	tag = binary.BigEndian.AppendUint32(tag, uint32(len(text)+1))
+	tag = append(append(tag, text...), 0)
+	tag = append(tag, make([]byte, 4+4)...) // Unicode language and count
+	return append(tag, make([]byte, 2+1+67)...)
+}
@@ -0,0 +1,469 @@
+package render
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"image"
+	"io"
+	"math"
+	"math/bits"
+)
+
+// MaxProgressivePixels limits progressive JPEGs, which hold every DCT
+// coefficient of the image in memory until the scans are written
+const MaxProgressivePixels = 50_000_000
+
+// JPEG markers used by EncodeProgressiveJPEG
+const (
+	jpegSOI  = 0xD8
+	jpegEOI  = 0xD9
+	jpegSOF2 = 0xC2
+	jpegDHT  = 0xC4
+	jpegDQT  = 0xDB
+	jpegSOS  = 0xDA
+)
+
+// jpegZigzag maps zig-zag order to natural order within an 8x8 block
+var jpegZigzag = [64]int{
+	0, 1, 8, 16, 9, 2, 3, 10,
+	17, 24, 32, 25, 18, 11, 4, 5,
+	12, 19, 26, 33, 40, 48, 41, 34,
+	27, 20, 13, 6, 7, 14, 21, 28,
+	35, 42, 49, 56, 57, 50, 43, 36,
+	29, 22, 15, 23, 30, 37, 44, 51,
+	58, 59, 52, 45, 38, 31, 39, 46,
+	53, 60, 61, 54, 47, 55, 62, 63,
+}
+
+// jpegQuant are the luminance and chrominance quantization tables of
+// image/jpeg at quality 50, in zig-zag order
+var jpegQuant = [2][64]int{
+	{
+		16, 11, 12, 14, 12, 10, 16, 14,
+		13, 14, 18, 17, 16, 19, 24, 40,
+		26, 24, 22, 22, 24, 49, 35, 37,
+		29, 40, 58, 51, 61, 60, 57, 51,
+		56, 55, 64, 72, 92, 78, 64, 68,
+		87, 69, 55, 56, 80, 109, 81, 87,
+		95, 98, 103, 104, 103, 62, 77, 113,
+		121, 112, 100, 120, 92, 101, 103, 99,
+	},
+	{
+		17, 18, 18, 24, 21, 24, 47, 26,
+		26, 47, 99, 66, 56, 66, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+	},
+}
+
+// jpegHuffmanSpec is a Huffman table as stored in a DHT segment: the number
+// of codes of each length from 1 to 16 bits, then the values in code order
+type jpegHuffmanSpec struct {
+	class, id byte
+	counts    [16]byte
+	values    []byte
+}
+
+// jpegHuffmanSpecs are the standard tables of the JPEG specification,
+// annex K.3: luminance DC and AC, then chrominance DC and AC
+var jpegHuffmanSpecs = [4]jpegHuffmanSpec{
+	{0, 0, [16]byte{0, 1, 5, 1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0, 0, 0},
+		[]byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11}},
+	{1, 0, [16]byte{0, 2, 1, 3, 3, 2, 4, 3, 5, 5, 4, 4, 0, 0, 1, 125},
+		[]byte{
+			0x01, 0x02, 0x03, 0x00, 0x04, 0x11, 0x05, 0x12,
+			0x21, 0x31, 0x41, 0x06, 0x13, 0x51, 0x61, 0x07,
+			0x22, 0x71, 0x14, 0x32, 0x81, 0x91, 0xa1, 0x08,
+			0x23, 0x42, 0xb1, 0xc1, 0x15, 0x52, 0xd1, 0xf0,
+			0x24, 0x33, 0x62, 0x72, 0x82, 0x09, 0x0a, 0x16,
+			0x17, 0x18, 0x19, 0x1a, 0x25, 0x26, 0x27, 0x28,
+			0x29, 0x2a, 0x34, 0x35, 0x36, 0x37, 0x38, 0x39,
+			0x3a, 0x43, 0x44, 0x45, 0x46, 0x47, 0x48, 0x49,
+			0x4a, 0x53, 0x54, 0x55, 0x56, 0x57, 0x58, 0x59,
+			0x5a, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68, 0x69,
+			0x6a, 0x73, 0x74, 0x75, 0x76, 0x77, 0x78, 0x79,
+			0x7a, 0x83, 0x84, 0x85, 0x86, 0x87, 0x88, 0x89,
+			0x8a, 0x92, 0x93, 0x94, 0x95, 0x96, 0x97, 0x98,
+			0x99, 0x9a, 0xa2, 0xa3, 0xa4, 0xa5, 0xa6, 0xa7,
+			0xa8, 0xa9, 0xaa, 0xb2, 0xb3, 0xb4, 0xb5, 0xb6,
+			0xb7, 0xb8, 0xb9, 0xba, 0xc2, 0xc3, 0xc4, 0xc5,
+			0xc6, 0xc7, 0xc8, 0xc9, 0xca, 0xd2, 0xd3, 0xd4,
+			0xd5, 0xd6, 0xd7, 0xd8, 0xd9, 0xda, 0xe1, 0xe2,
+			0xe3, 0xe4, 0xe5, 0xe6, 0xe7, 0xe8, 0xe9, 0xea,
+			0xf1, 0xf2, 0xf3, 0xf4, 0xf5, 0xf6, 0xf7, 0xf8,
+			0xf9, 0xfa,
+		}},
+	{0, 1, [16]byte{0, 3, 1, 1, 1, 1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0},
+		[]byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11}},
+	{1, 1, [16]byte{0, 2, 1, 2, 4, 4, 3, 4, 7, 5, 4, 4, 0, 1, 2, 119},
+		[]byte{
+			0x00, 0x01, 0x02, 0x03, 0x11, 0x04, 0x05, 0x21,
+			0x31, 0x06, 0x12, 0x41, 0x51, 0x07, 0x61, 0x71,
+			0x13, 0x22, 0x32, 0x81, 0x08, 0x14, 0x42, 0x91,
+			0xa1, 0xb1, 0xc1, 0x09, 0x23, 0x33, 0x52, 0xf0,
+			0x15, 0x62, 0x72, 0xd1, 0x0a, 0x16, 0x24, 0x34,
+			0xe1, 0x25, 0xf1, 0x17, 0x18, 0x19, 0x1a, 0x26,
+			0x27, 0x28, 0x29, 0x2a, 0x35, 0x36, 0x37, 0x38,
+			0x39, 0x3a, 0x43, 0x44, 0x45, 0x46, 0x47, 0x48,
+			0x49, 0x4a, 0x53, 0x54, 0x55, 0x56, 0x57, 0x58,
+			0x59, 0x5a, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68,
+			0x69, 0x6a, 0x73, 0x74, 0x75, 0x76, 0x77, 0x78,
+			0x79, 0x7a, 0x82, 0x83, 0x84, 0x85, 0x86, 0x87,
+			0x88, 0x89, 0x8a, 0x92, 0x93, 0x94, 0x95, 0x96,
+			0x97, 0x98, 0x99, 0x9a, 0xa2, 0xa3, 0xa4, 0xa5,
+			0xa6, 0xa7, 0xa8, 0xa9, 0xaa, 0xb2, 0xb3, 0xb4,
+			0xb5, 0xb6, 0xb7, 0xb8, 0xb9, 0xba, 0xc2, 0xc3,
+			0xc4, 0xc5, 0xc6, 0xc7, 0xc8, 0xc9, 0xca, 0xd2,
+			0xd3, 0xd4, 0xd5, 0xd6, 0xd7, 0xd8, 0xd9, 0xda,
+			0xe2, 0xe3, 0xe4, 0xe5, 0xe6, 0xe7, 0xe8, 0xe9,
+			0xea, 0xf2, 0xf3, 0xf4, 0xf5, 0xf6, 0xf7, 0xf8,
+			0xf9, 0xfa,
+		}},
+}
+
+// jpegScan is one scan of a progressive JPEG: the DC coefficients of every
+// component, or a band of AC coefficients of one component
+type jpegScan struct {
+	component int // -1 for the DC scan of all components
+	start     int
+	end       int
+}
+
+// jpegScans sends a coarse image first, then the fine luminance detail last
+var jpegScans = []jpegScan{
+	{-1, 0, 0},
+	{0, 1, 5},
+	{1, 1, 63},
+	{2, 1, 63},
+	{0, 6, 63},
+}
+
+// jpegCode is a Huffman code, most significant bit first
+type jpegCode struct {
+	bits uint32
+	len  uint
+}
+
+// jpegComponent holds the quantized coefficients of one colour component,
+// block by block in zig-zag order, over the blocks of whole MCUs
+type jpegComponent struct {
+	coef     []int16
+	stride   int // blocks per row
+	blocksW  int // blocks per row covering the image
+	blocksH  int // block rows covering the image
+	table    int // quantization and Huffman table
+	sampling int // 2 for luminance, which has 2x2 blocks per MCU
+}
+
+// EncodeProgressiveJPEG writes img as a progressive JPEG with 4:2:0 chroma
+// subsampling and the quality scale of image/jpeg, whose JFIF header
+// records dpi. Browsers show a coarse version of a progressive JPEG while
+// the rest loads. Rows are read once from top to bottom, but the whole
+// image is held as coefficients, so it is limited to MaxProgressivePixels.
+func EncodeProgressiveJPEG(w io.Writer, img image.Image, quality int, dpi int) error {
+	b := img.Bounds()
+	width, height := b.Dx(), b.Dy()
+	if width < 1 || height < 1 || width > 0xFFFF || height > 0xFFFF {
+		return errors.New("invalid JPEG image size")
+	}
+	if width*height > MaxProgressivePixels {
+		return errors.New("image is too large for a progressive JPEG")
+	}
+
+	quant := jpegQuantTables(quality)
+	mcuCols, mcuRows := (width+15)/16, (height+15)/16
+	components := [3]*jpegComponent{
+		{stride: 2 * mcuCols, blocksW: (width + 7) / 8, blocksH: (height + 7) / 8, table: 0, sampling: 2},
+		{stride: mcuCols, blocksW: ((width+1)/2 + 7) / 8, blocksH: ((height+1)/2 + 7) / 8, table: 1, sampling: 1},
+		{stride: mcuCols, blocksW: ((width+1)/2 + 7) / 8, blocksH: ((height+1)/2 + 7) / 8, table: 1, sampling: 1},
+	}
+	for _, c := range components {
+		c.coef = make([]int16, c.stride*mcuRows*c.sampling*64)
+	}
+
+	// Transform the image one row of MCUs at a time
+	var ycc [3][16 * 16]float64
+	rows := make([][]uint8, 16)
+	buf := make([]uint8, 0, width*4)
+	var block [64]float64
+	for my := 0; my < mcuRows; my++ {
+		for r := 0; r < 16; r++ {
+			y := min(my*16+r, height-1)
+			rows[r] = append(rows[r][:0], rgbaRow(img, b.Min.Y+y, buf)...)
+		}
+		for mx := 0; mx < mcuCols; mx++ {
+			for r := 0; r < 16; r++ {
+				for c := 0; c < 16; c++ {
+					x := min(mx*16+c, width-1) * 4
+					red, green, blue := float64(rows[r][x]), float64(rows[r][x+1]), float64(rows[r][x+2])
+					ycc[0][r*16+c] = 0.299*red + 0.587*green + 0.114*blue
+					ycc[1][r*16+c] = -0.168736*red - 0.331264*green + 0.5*blue + 128
+					ycc[2][r*16+c] = 0.5*red - 0.418688*green - 0.081312*blue + 128
+				}
+			}
+
+			luma := components[0]
+			for i := 0; i < 4; i++ {
+				bx, by := i%2, i/2
+				for r := 0; r < 8; r++ {
+					for c := 0; c < 8; c++ {
+						block[r*8+c] = ycc[0][(by*8+r)*16+bx*8+c]
+					}
+				}
+				index := (my*2+by)*luma.stride + mx*2 + bx
+				quantizeBlock(luma.coef[index*64:index*64+64], &block, &quant[0])
+			}
+			for ch := 1; ch < 3; ch++ {
+				for r := 0; r < 8; r++ {
+					for c := 0; c < 8; c++ {
+						p := (2*r)*16 + 2*c
+						block[r*8+c] = (ycc[ch][p] + ycc[ch][p+1] + ycc[ch][p+16] + ycc[ch][p+17]) / 4
+					}
+				}
+				index := my*components[ch].stride + mx
+				quantizeBlock(components[ch].coef[index*64:index*64+64], &block, &quant[1])
+			}
+		}
+	}
+
+	bw := bufio.NewWriterSize(w, 1<<16)
+	var codes [4][256]jpegCode
+	for i, spec := range jpegHuffmanSpecs {
+		codes[i] = spec.codes()
+	}
+
+	writeJPEGHeader(bw, width, height, dpi, &quant)
+	for _, scan := range jpegScans {
+		if err := writeJPEGScan(bw, scan, components, mcuCols, mcuRows, &codes); err != nil {
+			return err
+		}
+	}
+	bw.Write([]byte{0xFF, jpegEOI})
+	return bw.Flush()
+}
+
+// jpegQuantTables scales the quantization tables to a quality from 1 to
+// 100 the way image/jpeg does
+func jpegQuantTables(quality int) [2][64]int {
+	quality = min(max(quality, 1), 100)
+	scale := 200 - quality*2
+	if quality < 50 {
+		scale = 5000 / quality
+	}
+	var quant [2][64]int
+	for i := range quant {
+		for j := range quant[i] {
+			quant[i][j] = min(max((jpegQuant[i][j]*scale+50)/100, 1), 255)
+		}
+	}
+	return quant
+}
+
+// jpegCosines holds cos((2x+1)uπ/16) for the DCT
+var jpegCosines = func() (c [8][8]float64) {
+	for x := 0; x < 8; x++ {
+		for u := 0; u < 8; u++ {
+			c[x][u] = math.Cos(float64(2*x+1) * float64(u) * math.Pi / 16)
+		}
+	}
+	return c
+}()
+
+// quantizeBlock applies the forward DCT to a block of samples and stores
+// the quantized coefficients in zig-zag order
+func quantizeBlock(dst []int16, block *[64]float64, quant *[64]int) {
+	var rows [64]float64
+	for y := 0; y < 8; y++ {
+		for u := 0; u < 8; u++ {
+			sum := 0.0
+			for x := 0; x < 8; x++ {
+				sum += (block[y*8+x] - 128) * jpegCosines[x][u]
+			}
+			rows[y*8+u] = sum
+		}
+	}
+	for k := 0; k < 64; k++ {
+		n := jpegZigzag[k]
+		u, v := n%8, n/8
+		sum := 0.0
+		for y := 0; y < 8; y++ {
+			sum += rows[y*8+u] * jpegCosines[y][v]
+		}
+		scale := 0.25
+		if u == 0 {
+			scale *= math.Sqrt2 / 2
+		}
+		if v == 0 {
+			scale *= math.Sqrt2 / 2
+		}
+		dst[k] = int16(math.Round(sum * scale / float64(quant[k])))
+	}
+}
+
+// writeJPEGHeader writes the segments that come before the first scan
+func writeJPEGHeader(w *bufio.Writer, width, height, dpi int, quant *[2][64]int) {
+	w.Write([]byte{0xFF, jpegSOI})
+	w.Write(jfifSegment(dpi))
+
+	dqt := []byte{}
+	for i := range quant {
+		dqt = append(dqt, byte(i))
+		for _, q := range quant[i] {
+			dqt = append(dqt, byte(q))
+		}
+	}
+	writeJPEGSegment(w, jpegDQT, dqt)
+
+	writeJPEGSegment(w, jpegSOF2, []byte{
+		8, // bits per sample
+		byte(height >> 8), byte(height), byte(width >> 8), byte(width),
+		3,
+		1, 0x22, 0, // Y, 2x2 sampling
+		2, 0x11, 1, // Cb
+		3, 0x11, 1, // Cr
+	})
+
+	dht := []byte{}
+	for _, spec := range jpegHuffmanSpecs {
+		dht = append(dht, spec.class<<4|spec.id)
+		dht = append(dht, spec.counts[:]...)
+		dht = append(dht, spec.values...)
+	}
+	writeJPEGSegment(w, jpegDHT, dht)
+}
+
+// writeJPEGSegment writes a marker segment with its length
+func writeJPEGSegment(w *bufio.Writer, marker byte, data []byte) {
+	w.Write([]byte{0xFF, marker})
+	binary.Write(w, binary.BigEndian, uint16(len(data)+2))
+	w.Write(data)
+}
+
+// writeJPEGScan writes one scan header and its entropy-coded data
+func writeJPEGScan(w *bufio.Writer, scan jpegScan, components [3]*jpegComponent, mcuCols, mcuRows int, codes *[4][256]jpegCode) error {
+	ew := &entropyWriter{w: w}
+
+	if scan.component < 0 {
+		writeJPEGSegment(w, jpegSOS, []byte{3, 1, 0x00, 2, 0x10, 3, 0x10, 0, 0, 0})
+
+		// DC coefficients of whole MCUs, as differences from the previous
+		// block of the same component
+		var prev [3]int
+		for my := 0; my < mcuRows; my++ {
+			for mx := 0; mx < mcuCols; mx++ {
+				for ci, c := range components {
+					for by := 0; by < c.sampling; by++ {
+						for bx := 0; bx < c.sampling; bx++ {
+							index := (my*c.sampling+by)*c.stride + mx*c.sampling + bx
+							dc := int(c.coef[index*64])
+							ew.writeValue(&codes[2*c.table], 0, dc-prev[ci])
+							prev[ci] = dc
+						}
+					}
+				}
+			}
+		}
+		return ew.flush()
+	}
+
+	c := components[scan.component]
+	writeJPEGSegment(w, jpegSOS, []byte{1, byte(scan.component + 1), byte(c.table), byte(scan.start), byte(scan.end), 0})
+
+	// A single component scan only covers the blocks inside the image
+	ac := &codes[2*c.table+1]
+	for by := 0; by < c.blocksH; by++ {
+		for bx := 0; bx < c.blocksW; bx++ {
+			coef := c.coef[(by*c.stride+bx)*64:]
+			run := 0
+			for k := scan.start; k <= scan.end; k++ {
+				if coef[k] == 0 {
+					run++
+					continue
+				}
+				for ; run > 15; run -= 16 {
+					ew.writeCode(ac[0xF0])
+				}
+				ew.writeValue(ac, run, int(coef[k]))
+				run = 0
+			}
+			if run > 0 {
+				ew.writeCode(ac[0x00]) // end of band
+			}
+		}
+	}
+	return ew.flush()
+}
+
+// codes returns the code of every value of a Huffman table
+func (s jpegHuffmanSpec) codes() [256]jpegCode {
+	var codes [256]jpegCode
+	code, k := uint32(0), 0
+	for length, count := range s.counts {
+		for i := 0; i < int(count); i++ {
+			codes[s.values[k]] = jpegCode{bits: code, len: uint(length + 1)}
+			code++
+			k++
+		}
+		code <<= 1
+	}
+	return codes
+}
+
+// entropyWriter writes Huffman coded data most significant bit first,
+// stuffing a zero byte after every 0xFF
+type entropyWriter struct {
+	w   *bufio.Writer
+	acc uint32
+	n   uint
+	err error
+}
+
+// writeBits writes the n low bits of v
+func (e *entropyWriter) writeBits(v uint32, n uint) {
+	e.acc = e.acc<<n | v&(1<<n-1)
+	e.n += n
+	for e.n >= 8 {
+		c := byte(e.acc >> (e.n - 8))
+		e.n -= 8
+		if e.err != nil {
+			continue
+		}
+		e.err = e.w.WriteByte(c)
+		if c == 0xFF && e.err == nil {
+			e.err = e.w.WriteByte(0)
+		}
+	}
+}
+
+// writeCode writes a Huffman code
+func (e *entropyWriter) writeCode(c jpegCode) {
+	e.writeBits(c.bits, c.len)
+}
+
+// writeValue writes a value as the code of its run and size category,
+// followed by its bits
+func (e *entropyWriter) writeValue(table *[256]jpegCode, run int, v int) {
+	magnitude := v
+	if v < 0 {
+		magnitude = -v
+		v--
+	}
+	size := uint(bits.Len(uint(magnitude)))
+	e.writeCode(table[run<<4|int(size)])
+	if size > 0 {
+		e.writeBits(uint32(v), size)
+	}
+}
+
+// flush pads the last byte with one bits
+func (e *entropyWriter) flush() error {
+	if e.n > 0 {
+		e.writeBits(1<<(8-e.n)-1, 8-e.n)
+	}
+	return e.err
+}
@@ -0,0 +1,491 @@
+package render
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	xdraw "golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+	"golang.org/x/image/vector"
+)
+
+// Built-in mask shapes. A mask without a shape is a silhouette image.
+const (
+	MaskHeart  = "heart"
+	MaskCircle = "circle"
+	MaskStar   = "star"
+	MaskText   = "text" // the words of MaskSpec.Text in a bold typeface
+)
+
+// Backgrounds of the cells outside a mask
+const (
+	BackgroundColor       = "color"       // a solid colour
+	BackgroundTransparent = "transparent" // transparent in png and webp, white in other formats
+	BackgroundFaded       = "faded"       // the main image faded into the background colour
+)
+
+// Mask limits and defaults
+const (
+	MaxMaskText           = 40  // characters of a text mask
+	MaxMaskLines          = 3   // lines of a text mask
+	DefaultBackgroundFade = 0.3 // opacity of the main image on a faded background
+	maskResolution        = 1024
+	maskMargin            = 0.04 // space left around built-in shapes, as a part of the short side
+	maskSamples           = 4    // samples per side of a cell when deciding if it is inside
+	maskCoverageThreshold = 128  // mean coverage out of 255 a cell needs to get a tile
+)
+
+// MaskSpec limits the tiles of a mosaic to a shape, either built in or
+// from a silhouette image, and fills the rest with a background
+type MaskSpec struct {
+	Shape           string  `json:"shape,omitempty"`            // heart, circle, star or text; a silhouette image if empty
+	Text            string  `json:"text,omitempty"`             // text shape only, lines separated by \n
+	ImageID         uint    `json:"image_id,omitempty"`         // silhouette: its opaque pixels, or its dark ones if it has no transparency
+	Invert          bool    `json:"invert,omitempty"`           // place tiles outside the shape instead
+	Background      string  `json:"background,omitempty"`       // color, transparent or faded; color if empty
+	BackgroundColor string  `json:"background_color,omitempty"` // #rrggbb or #rgb, white if empty; also under a faded main image
+	Fade            float64 `json:"fade,omitempty"`             // faded only, opacity of the main image from 0 to 1, 0.3 if zero
+}
+
+// Validate checks that the spec names exactly one shape and a background
+// it can be drawn with
+func (s MaskSpec) Validate() error {
+	switch s.Shape {
+	case "":
+		if s.ImageID == 0 {
+			return errors.New("a mask needs a shape or a silhouette image")
+		}
+	case MaskHeart, MaskCircle, MaskStar, MaskText:
+		if s.ImageID != 0 {
+			return errors.New("a mask is either a shape or a silhouette image, not both")
+		}
+	default:
+		return fmt.Errorf("unknown mask shape %q", s.Shape)
+	}
+
+	if s.Shape == MaskText {
+		if strings.TrimSpace(s.Text) == "" {
+			return errors.New("a text mask needs some text")
+		}
+		if utf8.RuneCountInString(s.Text) > MaxMaskText {
+			return fmt.Errorf("mask text is limited to %d characters", MaxMaskText)
+		}
+		if strings.Count(s.Text, "\n") >= MaxMaskLines {
+			return fmt.Errorf("mask text is limited to %d lines", MaxMaskLines)
+		}
+	} else if s.Text != "" {
+		return errors.New("text only applies to text masks")
+	}
+
+	switch s.Background {
+	case "", BackgroundColor, BackgroundFaded:
+	case BackgroundTransparent:
+		if s.BackgroundColor != "" {
+			return errors.New("a transparent background has no color")
+		}
+	default:
+		return fmt.Errorf("unknown mask background %q", s.Background)
+	}
+	if _, err := parseColor("background color", s.BackgroundColor); err != nil {
+		return err
+	}
+	if s.Fade < 0 || s.Fade > 1 {
+		return errors.New("fade must be between 0 and 1")
+	}
+	if s.Fade != 0 && s.Background != BackgroundFaded {
+		return errors.New("fade only applies to a faded background")
+	}
+	return nil
+}
+
+// Fill returns the background of the cells outside the mask. The spec
+// must be valid.
+func (s MaskSpec) Fill() Background {
+	if s.Background == BackgroundTransparent {
+		return Background{}
+	}
+	c, _ := parseColor("background color", s.BackgroundColor)
+	bg := Background{Color: c}
+	if s.Background == BackgroundFaded {
+		bg.Fade = s.Fade
+		if bg.Fade == 0 {
+			bg.Fade = DefaultBackgroundFade
+		}
+	}
+	return bg
+}
+
+// Background fills the cells of a plan that have no tile: a colour, with
+// the main image blended over it at Fade opacity. The zero Background is
+// transparent.
+type Background struct {
+	Color color.RGBA
+	Fade  float64
+}
+
+// orWhite returns the background b points to, or white if b is nil
+func (b *Background) orWhite() Background {
+	if b == nil {
+		return Background{Color: color.RGBA{R: 255, G: 255, B: 255, A: 255}}
+	}
+	return *b
+}
+
+// draw fills region r of dst with the background, taking the main image
+// from guide
+func (b Background) draw(dst *image.RGBA, r image.Rectangle, guide *Guide) {
+	draw.Draw(dst, r, image.NewUniform(b.Color), image.Point{}, draw.Src)
+	guide.Overlay(dst, r, b.Fade)
+}
+
+// Mask is the coverage of a mask shape over an output, held at a low
+// resolution with the output's aspect ratio. It only decides which cells
+// get a tile, so it never needs the output's detail.
+type Mask struct {
+	alpha *image.Alpha
+	size  Size
+}
+
+// NewMask draws the mask of spec for an output of the given size.
+// silhouette is the image of a silhouette mask and is ignored for built-in
+// shapes. Built-in shapes are centred with a small margin and silhouettes
+// are fitted to the output, keeping their aspect ratio.
+func NewMask(spec MaskSpec, size Size, silhouette image.Image) (*Mask, error) {
+	w, h := size.Width, size.Height
+	if long := max(w, h); long > maskResolution {
+		w = max(int(math.Round(float64(w)*maskResolution/float64(long))), 1)
+		h = max(int(math.Round(float64(h)*maskResolution/float64(long))), 1)
+	}
+	m := &Mask{alpha: image.NewAlpha(image.Rect(0, 0, w, h)), size: size}
+
+	// The box built-in shapes are fitted to
+	margin := int(math.Round(float64(min(w, h)) * maskMargin))
+	box := m.alpha.Rect.Inset(margin)
+
+	switch spec.Shape {
+	case "":
+		if silhouette == nil {
+			return nil, errors.New("a silhouette mask needs an image")
+		}
+		drawSilhouette(m.alpha, silhouette)
+	case MaskText:
+		if err := drawText(m.alpha, box, spec.Text); err != nil {
+			return nil, err
+		}
+	default:
+		drawShape(m.alpha, box, spec.Shape)
+	}
+
+	if spec.Invert {
+		for i, v := range m.alpha.Pix {
+			m.alpha.Pix[i] = 255 - v
+		}
+	}
+	return m, nil
+}
+
+// Covers reports whether most of a cell of grid, as far as it lies in the
+// output, is inside the mask
+func (m *Mask) Covers(grid Grid, col, row int) bool {
+	x0, y0, x1, y1 := grid.cellBounds(col, row)
+	cx, cy := grid.Center(col, row)
+	distance := grid.shapeDistance(col, row)
+	sx := float64(m.alpha.Rect.Dx()) / float64(m.size.Width)
+	sy := float64(m.alpha.Rect.Dy()) / float64(m.size.Height)
+
+	sum, n := 0, 0
+	for j := 0; j < maskSamples; j++ {
+		y := y0 + (y1-y0)*(float64(j)+0.5)/maskSamples
+		for i := 0; i < maskSamples; i++ {
+			x := x0 + (x1-x0)*(float64(i)+0.5)/maskSamples
+			if x < 0 || y < 0 || x >= float64(m.size.Width) || y >= float64(m.size.Height) || distance(x-cx, y-cy) > 0 {
+				continue
+			}
+			sum += int(m.alpha.AlphaAt(int(x*sx), int(y*sy)).A)
+			n++
+		}
+	}
+	return n > 0 && sum >= maskCoverageThreshold*n
+}
+
+// Apply leaves the cells of plan that the mask does not cover without a
+// tile. Tiles are picked before the mask is applied, so the cells inside
+// keep the tiles they would have without it.
+func (m *Mask) Apply(plan *Plan) error {
+	grid := plan.Grid
+	kept := 0
+	for row := 0; row < grid.Rows; row++ {
+		for col := 0; col < grid.Cols; col++ {
+			if m.Covers(grid, col, row) {
+				kept++
+			} else {
+				plan.Assign[row*grid.Cols+col] = NoTile
+			}
+		}
+	}
+	if kept == 0 {
+		return errors.New("the mask does not cover any tile")
+	}
+	return nil
+}
+
+// fitBox returns the largest rectangle of the given aspect ratio centred
+// in box, as its corner and size
+func fitBox(box image.Rectangle, aspect float64) (x, y, w, h float64) {
+	w, h = float64(box.Dx()), float64(box.Dy())
+	if w/h > aspect {
+		w = h * aspect
+	} else {
+		h = w / aspect
+	}
+	return float64(box.Min.X) + (float64(box.Dx())-w)/2, float64(box.Min.Y) + (float64(box.Dy())-h)/2, w, h
+}
+
+// drawShape fills a built-in shape fitted to box
+func drawShape(dst *image.Alpha, box image.Rectangle, shape string) {
+	b := dst.Bounds()
+	z := vector.NewRasterizer(b.Dx(), b.Dy())
+
+	// Shapes are outlined in a unit square and stretched to their aspect
+	// ratio inside box
+	var aspect float64
+	switch shape {
+	case MaskHeart:
+		aspect = 1.1
+	case MaskStar:
+		aspect = 2 * math.Sin(2*math.Pi/5) / (1 + math.Cos(math.Pi/5))
+	default:
+		aspect = 1
+	}
+	x, y, w, h := fitBox(box, aspect)
+	pt := func(u, v float64) (float32, float32) {
+		return float32(x + u*w), float32(y + v*h)
+	}
+	moveTo := func(u, v float64) { z.MoveTo(pt(u, v)) }
+	lineTo := func(u, v float64) { z.LineTo(pt(u, v)) }
+	cubeTo := func(u1, v1, u2, v2, u3, v3 float64) {
+		x1, y1 := pt(u1, v1)
+		x2, y2 := pt(u2, v2)
+		x3, y3 := pt(u3, v3)
+		z.CubeTo(x1, y1, x2, y2, x3, y3)
+	}
+
+	switch shape {
+	case MaskHeart:
+		moveTo(0.5, 0.26)
+		cubeTo(0.5, 0.1, 0.36, 0, 0.23, 0)
+		cubeTo(0.09, 0, 0, 0.12, 0, 0.28)
+		cubeTo(0, 0.56, 0.3, 0.74, 0.5, 1)
+		cubeTo(0.7, 0.74, 1, 0.56, 1, 0.28)
+		cubeTo(1, 0.12, 0.91, 0, 0.77, 0)
+		cubeTo(0.64, 0, 0.5, 0.1, 0.5, 0.26)
+	case MaskStar:
+		// Five points on a circle of radius 1 and five inner corners
+		// between them, mapped from their bounds to the unit square
+		top, bottom := -1.0, math.Cos(math.Pi/5)
+		half := math.Sin(2 * math.Pi / 5)
+		for i := 0; i < 10; i++ {
+			r := 1.0
+			if i%2 == 1 {
+				r = 0.4
+			}
+			a := float64(i)*math.Pi/5 - math.Pi/2
+			u := (r*math.Cos(a) + half) / (2 * half)
+			v := (r*math.Sin(a) - top) / (bottom - top)
+			if i == 0 {
+				moveTo(u, v)
+			} else {
+				lineTo(u, v)
+			}
+		}
+	default:
+		// A circle from four cubic arcs
+		const c = 0.5 * 0.5523
+		moveTo(1, 0.5)
+		cubeTo(1, 0.5+c, 0.5+c, 1, 0.5, 1)
+		cubeTo(0.5-c, 1, 0, 0.5+c, 0, 0.5)
+		cubeTo(0, 0.5-c, 0.5-c, 0, 0.5, 0)
+		cubeTo(0.5+c, 0, 1, 0.5-c, 1, 0.5)
+	}
+	z.ClosePath()
+	z.Draw(dst, b, image.Opaque, image.Point{})
+}
+
+// maskFont is the typeface of text masks, parsed on first use
+var maskFont = sync.OnceValues(func() (*opentype.Font, error) {
+	return opentype.Parse(gobold.TTF)
+})
+
+// drawText fills the lines of text, each centred, as large as they fit in
+// box
+func drawText(dst *image.Alpha, box image.Rectangle, text string) error {
+	f, err := maskFont()
+	if err != nil {
+		return err
+	}
+	lines := strings.Split(text, "\n")
+
+	// The ink bounds of the lines, with the first baseline at zero
+	measure := func(size float64) (font.Face, []fixed.Rectangle26_6, fixed.Int26_6, fixed.Rectangle26_6, error) {
+		face, err := opentype.NewFace(f, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingNone})
+		if err != nil {
+			return nil, nil, 0, fixed.Rectangle26_6{}, err
+		}
+		height := face.Metrics().Height
+		bounds := make([]fixed.Rectangle26_6, len(lines))
+		var ink fixed.Rectangle26_6
+		for i, line := range lines {
+			bounds[i], _ = font.BoundString(face, line)
+			shifted := bounds[i].Add(fixed.Point26_6{Y: height * fixed.Int26_6(i)})
+			if !bounds[i].Empty() {
+				if ink.Empty() {
+					ink = shifted
+				} else {
+					ink = ink.Union(shifted)
+				}
+			}
+		}
+		return face, bounds, height, ink, nil
+	}
+
+	// Measure at one size, then set the text at the size that fills box
+	const probe = 100
+	probeFace, _, _, ink, err := measure(probe)
+	if err != nil {
+		return err
+	}
+	probeFace.Close()
+	if ink.Empty() {
+		return errors.New("mask text has nothing to draw")
+	}
+	scale := math.Min(float64(box.Dx())/fixedFloat(ink.Max.X-ink.Min.X), float64(box.Dy())/fixedFloat(ink.Max.Y-ink.Min.Y))
+	face, bounds, height, ink, err := measure(probe * scale)
+	if err != nil {
+		return err
+	}
+	defer face.Close()
+
+	top := fixed.I(box.Min.Y) + (fixed.I(box.Dy())-(ink.Max.Y-ink.Min.Y))/2 - ink.Min.Y
+	d := &font.Drawer{Dst: dst, Src: image.Opaque, Face: face}
+	for i, line := range lines {
+		width := bounds[i].Max.X - bounds[i].Min.X
+		d.Dot = fixed.Point26_6{
+			X: fixed.I(box.Min.X) + (fixed.I(box.Dx())-width)/2 - bounds[i].Min.X,
+			Y: top + height*fixed.Int26_6(i),
+		}
+		d.DrawString(line)
+	}
+	return nil
+}
+
+// fixedFloat converts a 26.6 fixed point value to a float
+func fixedFloat(v fixed.Int26_6) float64 {
+	return float64(v) / 64
+}
+
+// drawSilhouette fits a silhouette image to dst. Images with transparency
+// give their alpha; opaque images give their darkness, so a black shape on
+// white works as well as a cut-out.
+func drawSilhouette(dst *image.Alpha, img image.Image) {
+	b := img.Bounds()
+	coverage := image.NewAlpha(image.Rect(0, 0, b.Dx(), b.Dy()))
+	transparent := false
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		transparent = !o.Opaque()
+	}
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			r, g, bl, a := img.At(b.Min.X+x, b.Min.Y+y).RGBA()
+			v := a >> 8
+			if !transparent {
+				luma := (19595*r + 38470*g + 7471*bl + 1<<15) >> 24
+				v = 255 - luma
+			}
+			coverage.Pix[y*coverage.Stride+x] = uint8(v)
+		}
+	}
+
+	x, y, w, h := fitBox(dst.Bounds(), float64(b.Dx())/float64(b.Dy()))
+	r := image.Rect(int(math.Round(x)), int(math.Round(y)), int(math.Round(x+w)), int(math.Round(y+h)))
+	xdraw.BiLinear.Scale(dst, r, coverage, coverage.Bounds(), draw.Src, nil)
+}
+
+// drawEmptyCells draws the background over the cells of a plan without a
+// tile that fall in region r of dst, with output pixel (x, y) landing on
+// dst at (x*k+ox, y*k+oy). background returns a region of the background
+// and is only called if some cell is empty. A pixel belongs to the empty
+// cells where it is nearer to them than to any tile, anti-aliased across
+// the line between them, so grout between tiles stays while the outline
+// of the tiles follows their shape.
+func drawEmptyCells(dst *image.RGBA, r image.Rectangle, plan *Plan, k, ox, oy float64, background func(r image.Rectangle) *image.RGBA) {
+	grid := plan.Grid
+	r = r.Intersect(dst.Bounds())
+	if r.Empty() {
+		return
+	}
+
+	// Only pixels around empty cells need a closer look
+	var near []bool
+	cellsIn(grid, r, k, ox, oy, func(col, row int, cr image.Rectangle) {
+		if plan.Tile(col, row) != NoTile {
+			return
+		}
+		if near == nil {
+			near = make([]bool, r.Dx()*r.Dy())
+		}
+		for y := cr.Min.Y; y < cr.Max.Y; y++ {
+			i := (y-r.Min.Y)*r.Dx() + cr.Min.X - r.Min.X
+			for x := cr.Min.X; x < cr.Max.X; x++ {
+				near[i] = true
+				i++
+			}
+		}
+	})
+	if near == nil {
+		return
+	}
+
+	bg := background(r)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			if !near[(y-r.Min.Y)*r.Dx()+x-r.Min.X] {
+				continue
+			}
+
+			// Distances to the nearest empty cell and the nearest tile
+			// differ by about two pixels per pixel across the line
+			// between them
+			px, py := (float64(x)+0.5-ox)/k, (float64(y)+0.5-oy)/k
+			empty, filled := math.Inf(1), math.Inf(1)
+			grid.nearCells(px, py, func(col, row int) {
+				d := grid.distance(col, row, px, py)
+				if plan.Tile(col, row) == NoTile {
+					empty = math.Min(empty, d)
+				} else {
+					filled = math.Min(filled, d)
+				}
+			})
+			cov := math.Min(math.Max(0.5+(filled-empty)*k/2, 0), 1)
+			if cov <= 0 {
+				continue
+			}
+
+			i := dst.PixOffset(x, y)
+			j := bg.PixOffset(x, y)
+			for ch := 0; ch < 4; ch++ {
+				v := float64(dst.Pix[i+ch])
+				dst.Pix[i+ch] = uint8(math.Round(v + (float64(bg.Pix[j+ch])-v)*cov))
+			}
+		}
+	}
+}
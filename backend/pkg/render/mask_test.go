@@ -0,0 +1,270 @@
+package render
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// maskAt returns the mask's coverage at a point given as a part of
+// the output's width and height
+func maskAt(m *Mask, u, v float64) uint8 {
+	b := m.alpha.Rect
+	return m.alpha.AlphaAt(int(u*float64(b.Dx())), int(v*float64(b.Dy()))).A
+}
+
+// covered returns the part of the mask that is covered
+func covered(m *Mask) float64 {
+	sum := 0
+	for _, v := range m.alpha.Pix {
+		sum += int(v)
+	}
+	return float64(sum) / 255 / float64(len(m.alpha.Pix))
+}
+
+func newMask(t *testing.T, spec MaskSpec, size Size, silhouette image.Image) *Mask {
+	t.Helper()
+	m, err := NewMask(spec, size, silhouette)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestMaskSpecValidate(t *testing.T) {
+	valid := []MaskSpec{
+		{Shape: MaskHeart},
+		{Shape: MaskText, Text: "LOVE\nYOU", Invert: true},
+		{ImageID: 3, Background: BackgroundTransparent},
+		{Shape: MaskStar, Background: BackgroundFaded, BackgroundColor: "#000", Fade: 0.5},
+	}
+	for _, spec := range valid {
+		if err := spec.Validate(); err != nil {
+			t.Errorf("%+v rejected: %v", spec, err)
+		}
+	}
+	invalid := []MaskSpec{
+		{},
+		{Shape: "moon"},
+		{Shape: MaskHeart, ImageID: 3},
+		{Shape: MaskText},
+		{Shape: MaskText, Text: " \n "},
+		{Shape: MaskText, Text: "ONE\nTWO\nTHREE\nFOUR"},
+		{Shape: MaskText, Text: "this text is much longer than a mask can hold"},
+		{Shape: MaskCircle, Text: "HI"},
+		{Shape: MaskCircle, Background: "pattern"},
+		{Shape: MaskCircle, Background: BackgroundTransparent, BackgroundColor: "#fff"},
+		{Shape: MaskCircle, BackgroundColor: "blue"},
+		{Shape: MaskCircle, Fade: 0.5},
+		{Shape: MaskCircle, Background: BackgroundFaded, Fade: 1.5},
+	}
+	for _, spec := range invalid {
+		if err := spec.Validate(); err == nil {
+			t.Errorf("%+v accepted", spec)
+		}
+	}
+}
+
+func TestMaskBackgroundFill(t *testing.T) {
+	if bg := (MaskSpec{Shape: MaskHeart, Background: BackgroundTransparent}).Fill(); bg != (Background{}) {
+		t.Fatalf("transparent fill %+v", bg)
+	}
+	if bg := (MaskSpec{Shape: MaskHeart, BackgroundColor: "#102030"}).Fill(); bg != (Background{Color: color.RGBA{0x10, 0x20, 0x30, 255}}) {
+		t.Fatalf("colour fill %+v", bg)
+	}
+	if bg := (MaskSpec{Shape: MaskHeart, Background: BackgroundFaded}).Fill(); bg.Fade != DefaultBackgroundFade || bg.Color != (color.RGBA{255, 255, 255, 255}) {
+		t.Fatalf("faded fill %+v", bg)
+	}
+}
+
+func TestHeartMask(t *testing.T) {
+	m := newMask(t, MaskSpec{Shape: MaskHeart}, Size{Width: 400, Height: 400}, nil)
+	for _, p := range []struct {
+		u, v float64
+		in   bool
+	}{
+		{0.5, 0.5, true},    // the middle
+		{0.25, 0.3, true},   // each lobe
+		{0.75, 0.3, true},   //
+		{0.5, 0.85, true},   // above the point
+		{0.5, 0.12, false},  // the notch between the lobes
+		{0.1, 0.85, false},  // beside the point
+		{0.02, 0.02, false}, // the margin
+		{0.5, 0.99, false},
+	} {
+		if got := maskAt(m, p.u, p.v) > 128; got != p.in {
+			t.Errorf("(%v, %v) covered %v, want %v", p.u, p.v, got, p.in)
+		}
+	}
+
+	// Inverted, the heart is a hole
+	inverted := newMask(t, MaskSpec{Shape: MaskHeart, Invert: true}, Size{Width: 400, Height: 400}, nil)
+	if maskAt(inverted, 0.5, 0.5) != 0 || maskAt(inverted, 0.02, 0.02) != 255 {
+		t.Fatal("inverting does not swap the inside and outside")
+	}
+	if total := covered(m) + covered(inverted); math.Abs(total-1) > 1e-9 {
+		t.Fatalf("a mask and its inverse cover %v", total)
+	}
+}
+
+func TestCircleMaskFitsTheShortSide(t *testing.T) {
+	// A wide output holds a circle as tall as it, less the margin
+	m := newMask(t, MaskSpec{Shape: MaskCircle}, Size{Width: 2000, Height: 1000}, nil)
+	if m.alpha.Rect != image.Rect(0, 0, maskResolution, maskResolution/2) {
+		t.Fatalf("mask held at %v", m.alpha.Rect)
+	}
+	diameter := 1 - 2*maskMargin // of the height
+	want := math.Pi / 4 * diameter * diameter / 2
+	if got := covered(m); math.Abs(got-want) > 0.005 {
+		t.Fatalf("covers %.4f, want %.4f", got, want)
+	}
+	if maskAt(m, 0.5, 0.5) != 255 || maskAt(m, 0.2, 0.5) != 0 {
+		t.Fatal("the circle is not centred")
+	}
+}
+
+func TestTextMask(t *testing.T) {
+	size := Size{Width: 600, Height: 300}
+	m := newMask(t, MaskSpec{Shape: MaskText, Text: "HI"}, size, nil)
+	if c := covered(m); c < 0.1 || c > 0.6 {
+		t.Fatalf("text covers %.2f of the mask", c)
+	}
+	// The letters fill the box: ink reaches close to the margin at the top
+	// and bottom, and the gap inside the H is empty
+	rows := func(v0, v1 float64) int {
+		sum := 0
+		b := m.alpha.Rect
+		for y := int(v0 * float64(b.Dy())); y < int(v1*float64(b.Dy())); y++ {
+			for x := 0; x < b.Dx(); x++ {
+				sum += int(m.alpha.AlphaAt(x, y).A)
+			}
+		}
+		return sum
+	}
+	if rows(0, maskMargin) != 0 || rows(1-maskMargin, 1) != 0 {
+		t.Fatal("text reaches into the margin")
+	}
+	if rows(maskMargin, 2*maskMargin) == 0 || rows(1-2*maskMargin, 1-maskMargin) == 0 {
+		t.Fatal("text does not fill the height")
+	}
+
+	// Lines are stacked
+	lines := newMask(t, MaskSpec{Shape: MaskText, Text: "I\nI"}, size, nil)
+	if maskAt(lines, 0.5, 0.25) == 0 || maskAt(lines, 0.5, 0.75) == 0 {
+		t.Fatal("both lines should be drawn")
+	}
+
+	if _, err := NewMask(MaskSpec{Shape: MaskText, Text: " "}, size, nil); err == nil {
+		t.Fatal("drew a text mask without ink")
+	}
+}
+
+func TestSilhouetteMask(t *testing.T) {
+	size := Size{Width: 400, Height: 200}
+
+	// An opaque silhouette covers where it is dark: black on the left
+	// half of a square, fitted to the middle of the wide output
+	opaque := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	draw.Draw(opaque, opaque.Rect, image.White, image.Point{}, draw.Src)
+	draw.Draw(opaque, image.Rect(0, 0, 50, 100), image.Black, image.Point{}, draw.Src)
+	m := newMask(t, MaskSpec{ImageID: 1}, size, opaque)
+	if maskAt(m, 0.3, 0.5) != 255 || maskAt(m, 0.6, 0.5) != 0 || maskAt(m, 0.1, 0.5) != 0 {
+		t.Fatalf("opaque silhouette: %d %d %d", maskAt(m, 0.3, 0.5), maskAt(m, 0.6, 0.5), maskAt(m, 0.1, 0.5))
+	}
+
+	// A transparent one covers where it is opaque, whatever its colour
+	cutout := image.NewNRGBA(image.Rect(0, 0, 100, 100))
+	draw.Draw(cutout, image.Rect(50, 0, 100, 100), image.White, image.Point{}, draw.Src)
+	m = newMask(t, MaskSpec{ImageID: 1}, size, cutout)
+	if maskAt(m, 0.3, 0.5) != 0 || maskAt(m, 0.6, 0.5) != 255 {
+		t.Fatalf("cut-out silhouette: %d %d", maskAt(m, 0.3, 0.5), maskAt(m, 0.6, 0.5))
+	}
+	inverted := newMask(t, MaskSpec{ImageID: 1, Invert: true}, size, cutout)
+	if maskAt(inverted, 0.3, 0.5) != 255 || maskAt(inverted, 0.6, 0.5) != 0 || maskAt(inverted, 0.1, 0.5) != 255 {
+		t.Fatal("inverting a silhouette does not swap the inside and outside")
+	}
+
+	if _, err := NewMask(MaskSpec{ImageID: 1}, size, nil); err == nil {
+		t.Fatal("made a silhouette mask without an image")
+	}
+}
+
+func TestMaskApplyKeepsTheTilesInside(t *testing.T) {
+	size := Size{Width: 400, Height: 400}
+	grid := NewGrid(size, 20)
+	plan := NewRandomPlan(grid, 5, rand.New(rand.NewSource(1)))
+	before := append([]int(nil), plan.Assign...)
+
+	m := newMask(t, MaskSpec{Shape: MaskCircle}, size, nil)
+	if err := m.Apply(plan); err != nil {
+		t.Fatal(err)
+	}
+	kept := 0
+	for row := 0; row < grid.Rows; row++ {
+		for col := 0; col < grid.Cols; col++ {
+			i := row*grid.Cols + col
+			cx, cy := grid.Center(col, row)
+			inside := math.Hypot(cx-200, cy-200) < 200*(1-2*maskMargin)-15
+			outside := math.Hypot(cx-200, cy-200) > 200*(1-2*maskMargin)+15
+			switch {
+			case plan.Assign[i] != NoTile:
+				kept++
+				if plan.Assign[i] != before[i] {
+					t.Fatalf("cell %d, %d changed tile", col, row)
+				}
+				if outside {
+					t.Fatalf("cell %d, %d outside the circle has a tile", col, row)
+				}
+			case inside:
+				t.Fatalf("cell %d, %d inside the circle has no tile", col, row)
+			}
+		}
+	}
+	if want := math.Pi / 4 * float64(grid.Len()) * 0.92 * 0.92; math.Abs(float64(kept)-want) > want/10 {
+		t.Fatalf("kept %d cells, want about %.0f", kept, want)
+	}
+
+	// A mask that leaves no tile is an error
+	blank := image.NewRGBA(image.Rect(0, 0, 10, 10))
+	draw.Draw(blank, blank.Rect, image.White, image.Point{}, draw.Src)
+	if err := newMask(t, MaskSpec{ImageID: 1}, size, blank).Apply(NewRandomPlan(grid, 5, rand.New(rand.NewSource(1)))); err == nil {
+		t.Fatal("applied a mask that covers no cell")
+	}
+}
+
+func TestMaskedSceneHasATransparentBackground(t *testing.T) {
+	for _, shape := range []string{ShapeSquare, ShapeHex} {
+		t.Run(shape, func(t *testing.T) {
+			scene := testScene(t, 400, 4, 32, 20, ShapeSpec{Shape: shape}, 1)
+			m := newMask(t, MaskSpec{Shape: MaskCircle}, scene.Plan.Grid.Size, nil)
+			if err := m.Apply(scene.Plan); err != nil {
+				t.Fatal(err)
+			}
+			bg := MaskSpec{Shape: MaskCircle, Background: BackgroundTransparent}.Fill()
+			scene.Background = &bg
+			if scene.Opaque() {
+				t.Fatal("a transparent background is opaque")
+			}
+
+			dst := image.NewRGBA(scene.Bounds())
+			scene.Draw(dst)
+			b := dst.Rect
+			if a := dst.RGBAAt(2, 2).A; a != 0 {
+				t.Fatalf("corner alpha %d, want transparent", a)
+			}
+			if a := dst.RGBAAt(b.Dx()/2, b.Dy()/2).A; a != 255 {
+				t.Fatalf("centre alpha %d, want opaque", a)
+			}
+
+			// Formats without transparency draw it white
+			flat := image.NewRGBA(scene.Bounds())
+			scene.Flat().Draw(flat)
+			if c := flat.RGBAAt(2, 2); c != (color.RGBA{255, 255, 255, 255}) {
+				t.Fatalf("flat corner %v, want white", c)
+			}
+		})
+	}
+}
@@ -0,0 +1,166 @@
+// Package render holds the parts of the mosaic renderer that do not depend
+// on storage: output sizing, the guide image and image encoding.
+package render
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"math"
+	"strings"
+)
+
+// Output limits. Sides are capped by the JPEG format.
+const (
+	MaxOutputSide   = 65000
+	MaxOutputPixels = 200_000_000
+	DefaultDPI      = 72
+	DefaultPrintDPI = 300
+	MaxDPI          = 2400
+)
+
+// Paper sizes in millimetres, portrait
+var paperSizes = map[string][2]float64{
+	"a0":      {841, 1189},
+	"a1":      {594, 841},
+	"a2":      {420, 594},
+	"a3":      {297, 420},
+	"a4":      {210, 297},
+	"a5":      {148, 210},
+	"a6":      {105, 148},
+	"letter":  {215.9, 279.4},
+	"legal":   {215.9, 355.6},
+	"tabloid": {279.4, 431.8},
+}
+
+// OutputSpec describes the size of a rendered mosaic, either in pixels or as
+// a physical print size and resolution. A zero spec renders at the size of
+// the main image.
+type OutputSpec struct {
+	Width       int     `json:"width,omitempty"`        // pixels
+	Height      int     `json:"height,omitempty"`       // pixels
+	Paper       string  `json:"paper,omitempty"`        // a0-a6, letter, legal or tabloid
+	PrintWidth  float64 `json:"print_width,omitempty"`  // physical width in Unit
+	PrintHeight float64 `json:"print_height,omitempty"` // physical height in Unit
+	Unit        string  `json:"unit,omitempty"`         // mm (default) or in
+	Orientation string  `json:"orientation,omitempty"`  // portrait, landscape, or empty to follow the main image
+	DPI         int     `json:"dpi,omitempty"`
+}
+
+// Size is a resolved output size
+type Size struct {
+	Width  int `json:"width"`
+	Height int `json:"height"`
+	DPI    int `json:"dpi"`
+}
+
+// IsPrint reports whether the spec asks for a physical size
+func (s OutputSpec) IsPrint() bool {
+	return s.Paper != "" || s.PrintWidth > 0 || s.PrintHeight > 0
+}
+
+// Validate checks the spec without knowing the main image
+func (s OutputSpec) Validate() error {
+	if s.Width < 0 || s.Height < 0 || s.PrintWidth < 0 || s.PrintHeight < 0 {
+		return errors.New("output size cannot be negative")
+	}
+	if s.DPI < 0 || s.DPI > MaxDPI {
+		return fmt.Errorf("dpi must be between 1 and %d", MaxDPI)
+	}
+	if s.IsPrint() && (s.Width > 0 || s.Height > 0) {
+		return errors.New("give the output size either in pixels or as a print size, not both")
+	}
+	if s.Paper != "" {
+		if _, ok := paperSizes[strings.ToLower(s.Paper)]; !ok {
+			return fmt.Errorf("unknown paper size %q", s.Paper)
+		}
+		if s.PrintWidth > 0 || s.PrintHeight > 0 {
+			return errors.New("give either a paper size or a print width and height, not both")
+		}
+	}
+	switch s.Unit {
+	case "", "mm", "in":
+	default:
+		return fmt.Errorf("unknown unit %q", s.Unit)
+	}
+	switch s.Orientation {
+	case "", "portrait", "landscape":
+	default:
+		return fmt.Errorf("unknown orientation %q", s.Orientation)
+	}
+	return nil
+}
+
+// Resolve returns the output size in pixels for a main image of srcW x srcH.
+// Sizes that give only one side keep the aspect ratio of the main image.
+func (s OutputSpec) Resolve(srcW, srcH int) (Size, error) {
+	if err := s.Validate(); err != nil {
+		return Size{}, err
+	}
+	if srcW <= 0 || srcH <= 0 {
+		return Size{}, errors.New("main image has no pixels")
+	}
+
+	size := Size{Width: s.Width, Height: s.Height, DPI: s.DPI}
+	if s.IsPrint() {
+		if size.DPI == 0 {
+			size.DPI = DefaultPrintDPI
+		}
+
+		printW, printH := s.PrintWidth, s.PrintHeight
+		if s.Paper != "" {
+			mm := paperSizes[strings.ToLower(s.Paper)]
+			printW, printH = mm[0], mm[1]
+			landscape := s.Orientation == "landscape" || (s.Orientation == "" && srcW > srcH)
+			if landscape {
+				printW, printH = printH, printW
+			}
+		}
+
+		perUnit := float64(size.DPI) / 25.4
+		if s.Unit == "in" {
+			perUnit = float64(size.DPI)
+		}
+		size.Width = int(math.Round(printW * perUnit))
+		size.Height = int(math.Round(printH * perUnit))
+	} else if size.DPI == 0 {
+		size.DPI = DefaultDPI
+	}
+
+	switch {
+	case size.Width == 0 && size.Height == 0:
+		size.Width, size.Height = srcW, srcH
+	case size.Height == 0:
+		size.Height = int(math.Round(float64(size.Width) * float64(srcH) / float64(srcW)))
+	case size.Width == 0:
+		size.Width = int(math.Round(float64(size.Height) * float64(srcW) / float64(srcH)))
+	}
+
+	if size.Width < 1 || size.Height < 1 {
+		return Size{}, errors.New("output size is too small")
+	}
+	if size.Width > MaxOutputSide || size.Height > MaxOutputSide || size.Width*size.Height > MaxOutputPixels {
+		return Size{}, fmt.Errorf("output size %dx%d exceeds the limit of %d megapixels", size.Width, size.Height, MaxOutputPixels/1_000_000)
+	}
+	return size, nil
+}
+
+// CoverCrop returns the centred region of a srcW x srcH image that has the
+// aspect ratio of the output, so the main image fills the output without
+// being stretched
+func CoverCrop(srcW, srcH int, out Size) image.Rectangle {
+	srcAspect := float64(srcW) / float64(srcH)
+	outAspect := float64(out.Width) / float64(out.Height)
+
+	if math.Abs(srcAspect-outAspect) < 1e-3 {
+		return image.Rect(0, 0, srcW, srcH)
+	}
+	if srcAspect > outAspect {
+		w := int(math.Round(float64(srcH) * outAspect))
+		x := (srcW - w) / 2
+		return image.Rect(x, 0, x+w, srcH)
+	}
+	h := int(math.Round(float64(srcW) / outAspect))
+	y := (srcH - h) / 2
+	return image.Rect(0, y, srcW, y+h)
+}
@@ -0,0 +1,451 @@
+package render
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Print limits. PDF viewers do not handle pages over 200 inches a side.
+const (
+	MaxPrintSide    = 5080 // millimetres
+	MaxBleed        = 20   // millimetres
+	MaxTitleLength  = 200
+	MaxCaptionLines = 10
+)
+
+// Points per millimetre, the unit of PDF page coordinates
+const pointsPerMM = 72 / 25.4
+
+// Title and caption type sizes and spacing, in points
+const (
+	titleSize      = 16
+	titleLeading   = 20
+	captionSize    = 10
+	captionLeading = 13
+	textGap        = 6 * pointsPerMM // between the mosaic and the text
+)
+
+// Crop marks sit outside the bleed, so they are cut off with it
+const (
+	cropMarkGap    = 3 * pointsPerMM // from the trim edge when there is no bleed
+	cropMarkLength = 5 * pointsPerMM
+	cropMarkWidth  = 0.25
+)
+
+// PrintSpec lays out a mosaic on a printed page. The page is the trim size;
+// the bleed is added around it and crop marks outside the bleed.
+type PrintSpec struct {
+	Paper       string  `json:"paper,omitempty"`        // a0-a6, letter, legal or tabloid; a4 if no size is given
+	PrintWidth  float64 `json:"print_width,omitempty"`  // trim width in Unit
+	PrintHeight float64 `json:"print_height,omitempty"` // trim height in Unit
+	Unit        string  `json:"unit,omitempty"`         // mm (default) or in, also used for bleed and margin
+	Orientation string  `json:"orientation,omitempty"`  // portrait, landscape, or empty to follow the mosaic
+	Bleed       float64 `json:"bleed,omitempty"`        // the mosaic extends this far past the trim edge
+	Margin      float64 `json:"margin,omitempty"`       // white border inside the trim edge; zero prints edge to edge
+	CropMarks   bool    `json:"crop_marks,omitempty"`
+	Title       string  `json:"title,omitempty"`
+	Caption     string  `json:"caption,omitempty"`
+}
+
+// pdfRect is a rectangle in PDF points, with the origin at the bottom left
+type pdfRect struct {
+	X, Y, W, H float64
+}
+
+// inset returns the rectangle shrunk by d on every side
+func (r pdfRect) inset(d float64) pdfRect {
+	return pdfRect{r.X + d, r.Y + d, r.W - 2*d, r.H - 2*d}
+}
+
+// printLayout is a PrintSpec resolved for a mosaic of a given size
+type printLayout struct {
+	media, bleed, trim pdfRect
+	image              pdfRect // where the whole mosaic is drawn
+	clip               bool    // whether the mosaic is cut to the bleed box
+	title, caption     []string
+	textTop            float64 // top of the text under the mosaic
+	cropMarks          bool
+	markOffset         float64 // from the trim edge to the start of the crop marks
+}
+
+// Validate checks that a mosaic of the given size can be laid out with the
+// spec. A zero size skips the checks that depend on it.
+func (s PrintSpec) Validate(size Size) error {
+	if size.Width <= 0 || size.Height <= 0 {
+		size = Size{Width: 1, Height: 1}
+	}
+	_, err := s.layout(size.Width, size.Height)
+	return err
+}
+
+// layout places a w x h mosaic on the page
+func (s PrintSpec) layout(w, h int) (*printLayout, error) {
+	perUnit := pointsPerMM
+	switch s.Unit {
+	case "", "mm":
+	case "in":
+		perUnit = 72
+	default:
+		return nil, fmt.Errorf("unknown unit %q", s.Unit)
+	}
+	if s.PrintWidth < 0 || s.PrintHeight < 0 || s.Bleed < 0 || s.Margin < 0 {
+		return nil, errors.New("print sizes cannot be negative")
+	}
+
+	// Trim size, in points
+	var trimW, trimH float64
+	switch {
+	case s.Paper != "":
+		if s.PrintWidth > 0 || s.PrintHeight > 0 {
+			return nil, errors.New("give either a paper size or a print width and height, not both")
+		}
+		mm, ok := paperSizes[strings.ToLower(s.Paper)]
+		if !ok {
+			return nil, fmt.Errorf("unknown paper size %q", s.Paper)
+		}
+		trimW, trimH = mm[0]*pointsPerMM, mm[1]*pointsPerMM
+	case s.PrintWidth > 0 && s.PrintHeight > 0:
+		trimW, trimH = s.PrintWidth*perUnit, s.PrintHeight*perUnit
+	case s.PrintWidth > 0 || s.PrintHeight > 0:
+		return nil, errors.New("give both a print width and height")
+	default:
+		mm := paperSizes["a4"]
+		trimW, trimH = mm[0]*pointsPerMM, mm[1]*pointsPerMM
+	}
+	if s.Paper != "" || (s.PrintWidth == 0 && s.PrintHeight == 0) {
+		switch s.Orientation {
+		case "":
+			if w > h {
+				trimW, trimH = trimH, trimW
+			}
+		case "portrait":
+		case "landscape":
+			trimW, trimH = trimH, trimW
+		default:
+			return nil, fmt.Errorf("unknown orientation %q", s.Orientation)
+		}
+	}
+
+	bleed, margin := s.Bleed*perUnit, s.Margin*perUnit
+	if max(trimW, trimH) > MaxPrintSide*pointsPerMM {
+		return nil, fmt.Errorf("print size is limited to %d mm a side", MaxPrintSide)
+	}
+	if bleed > MaxBleed*pointsPerMM {
+		return nil, fmt.Errorf("bleed is limited to %d mm", MaxBleed)
+	}
+
+	l := &printLayout{cropMarks: s.CropMarks}
+	slug := bleed
+	if s.CropMarks {
+		l.markOffset = max(bleed, cropMarkGap)
+		slug = l.markOffset + cropMarkLength + 2*pointsPerMM
+	}
+	l.media = pdfRect{0, 0, trimW + 2*slug, trimH + 2*slug}
+	l.trim = pdfRect{slug, slug, trimW, trimH}
+	l.bleed = l.trim.inset(-bleed)
+
+	if len([]rune(s.Title)) > MaxTitleLength {
+		return nil, fmt.Errorf("title is limited to %d characters", MaxTitleLength)
+	}
+	hasText := strings.TrimSpace(s.Title) != "" || strings.TrimSpace(s.Caption) != ""
+
+	// Edge to edge, the mosaic covers the bleed box and is cut to it
+	if margin == 0 {
+		if hasText {
+			return nil, errors.New("a title or caption needs a margin to be printed in")
+		}
+		l.image = coverRect(l.bleed, w, h)
+		l.clip = true
+		return l, nil
+	}
+
+	// With a margin, the mosaic fits inside it above the text
+	area := l.trim.inset(margin)
+	if area.W <= 0 || area.H <= 0 {
+		return nil, errors.New("margin leaves no room for the mosaic")
+	}
+	l.title = wrapText(s.Title, titleSize, area.W)
+	l.caption = wrapText(s.Caption, captionSize, area.W)
+	if len(l.caption) > MaxCaptionLines {
+		return nil, fmt.Errorf("caption is limited to %d lines at this paper size", MaxCaptionLines)
+	}
+
+	textHeight := float64(len(l.title)*titleLeading + len(l.caption)*captionLeading)
+	if textHeight > 0 {
+		textHeight += textGap
+	}
+	l.textTop = area.Y + textHeight - textGap
+	area.Y += textHeight
+	area.H -= textHeight
+	if area.H < area.W/10 {
+		return nil, errors.New("title and caption leave no room for the mosaic")
+	}
+	l.image = fitRect(area, w, h)
+	return l, nil
+}
+
+// coverRect returns the rectangle a w x h image is drawn in to cover r,
+// centred on it
+func coverRect(r pdfRect, w, h int) pdfRect {
+	scale := max(r.W/float64(w), r.H/float64(h))
+	return centredRect(r, float64(w)*scale, float64(h)*scale)
+}
+
+// fitRect returns the largest rectangle with the aspect ratio of a w x h
+// image that fits in r, centred on it
+func fitRect(r pdfRect, w, h int) pdfRect {
+	scale := min(r.W/float64(w), r.H/float64(h))
+	return centredRect(r, float64(w)*scale, float64(h)*scale)
+}
+
+func centredRect(r pdfRect, w, h float64) pdfRect {
+	return pdfRect{r.X + (r.W-w)/2, r.Y + (r.H-h)/2, w, h}
+}
+
+// EncodePDF writes img as a single page print-ready PDF laid out with spec.
+// The mosaic is embedded as a JPEG of the given quality tagged with an
+// sRGB profile, which is also the document's output intent. The page's
+// trim and bleed boxes are set, so imposition software can place it
+// without the crop marks.
+func EncodePDF(w io.Writer, img image.Image, spec PrintSpec, quality int) error {
+	b := img.Bounds()
+	layout, err := spec.layout(b.Dx(), b.Dy())
+	if err != nil {
+		return err
+	}
+
+	pw := &pdfWriter{w: bufio.NewWriterSize(w, 1<<16)}
+	content := layout.content()
+	profile := SRGBProfile()
+
+	// Objects are numbered as below; the image goes last because its
+	// length is only known once it has been streamed
+	const (
+		catalogObj = iota + 1
+		pagesObj
+		intentObj
+		pageObj
+		profileObj
+		contentObj
+		fontObj
+		infoObj
+		imageObj
+		imageLengthObj
+		objectCount = imageLengthObj
+	)
+
+	pw.printf("%%PDF-1.6\n%%\xe2\xe3\xcf\xd3\n")
+	pw.object(catalogObj, fmt.Sprintf("<< /Type /Catalog /Pages %d 0 R /OutputIntents [%d 0 R] >>", pagesObj, intentObj))
+	pw.object(pagesObj, fmt.Sprintf("<< /Type /Pages /Kids [%d 0 R] /Count 1 >>", pageObj))
+	pw.object(intentObj, fmt.Sprintf("<< /Type /OutputIntent /S /GTS_PDFX /OutputConditionIdentifier (sRGB IEC61966-2.1) "+
+		"/RegistryName (http://www.color.org) /Info (sRGB IEC61966-2.1) /DestOutputProfile %d 0 R >>", profileObj))
+	pw.object(pageObj, fmt.Sprintf("<< /Type /Page /Parent %d 0 R /MediaBox %s /BleedBox %s /TrimBox %s "+
+		"/Resources << /XObject << /Im0 %d 0 R >> /Font << /F1 %d 0 R >> /ColorSpace << /Reg %s >> >> /Contents %d 0 R >>",
+		pagesObj, layout.media.box(), layout.bleed.box(), layout.trim.box(), imageObj, fontObj, registrationColorSpace, contentObj))
+	pw.stream(profileObj, "/N 3 /Alternate /DeviceRGB", profile)
+	pw.stream(contentObj, "", content)
+	pw.object(fontObj, "<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+
+	info := "<< /Producer (inkgrid) /CreationDate " + pdfString("D:"+time.Now().UTC().Format("20060102150405")+"Z")
+	if spec.Title != "" {
+		info += " /Title " + pdfTextString(spec.Title)
+	}
+	pw.object(infoObj, info+" >>")
+
+	// The JPEG is streamed straight into the file
+	pw.begin(imageObj)
+	pw.printf("<< /Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace [/ICCBased %d 0 R] "+
+		"/BitsPerComponent 8 /Filter /DCTDecode /Length %d 0 R >>\nstream\n", b.Dx(), b.Dy(), profileObj, imageLengthObj)
+	start := pw.offset
+	if pw.err == nil {
+		pw.err = EncodeJPEG(pw, img, quality, DefaultDPI)
+	}
+	length := pw.offset - start
+	pw.printf("\nendstream\nendobj\n")
+	pw.object(imageLengthObj, strconv.FormatInt(length, 10))
+
+	id := md5.Sum(append(content, fmt.Sprintf("%dx%d", b.Dx(), b.Dy())...))
+	pw.trailer(objectCount, catalogObj, infoObj, id[:])
+	if pw.err != nil {
+		return pw.err
+	}
+	return pw.w.(*bufio.Writer).Flush()
+}
+
+// registrationColorSpace prints on every separation, so crop marks show on
+// all the printing plates
+const registrationColorSpace = "[/Separation /All /DeviceCMYK << /FunctionType 2 /Domain [0 1] /C0 [0 0 0 0] /C1 [1 1 1 1] /N 1 >>]"
+
+// content builds the page's content stream
+func (l *printLayout) content() []byte {
+	var buf bytes.Buffer
+	if l.clip {
+		fmt.Fprintf(&buf, "q %s re W n\n", l.bleed.coords())
+	} else {
+		buf.WriteString("q\n")
+	}
+	fmt.Fprintf(&buf, "%s 0 0 %s %s %s cm /Im0 Do\nQ\n",
+		pdfNum(l.image.W), pdfNum(l.image.H), pdfNum(l.image.X), pdfNum(l.image.Y))
+
+	// Text is centred under the mosaic
+	top := l.textTop
+	centre := l.trim.X + l.trim.W/2
+	for _, line := range l.title {
+		writeTextLine(&buf, line, titleSize, centre, top-titleSize)
+		top -= titleLeading
+	}
+	for _, line := range l.caption {
+		writeTextLine(&buf, line, captionSize, centre, top-captionSize)
+		top -= captionLeading
+	}
+
+	if l.cropMarks {
+		fmt.Fprintf(&buf, "q %s w /Reg CS 1 SCN\n", pdfNum(cropMarkWidth))
+		t := l.trim
+		for _, x := range []float64{t.X, t.X + t.W} {
+			for _, y := range []float64{t.Y, t.Y + t.H} {
+				// Each corner gets a horizontal and a vertical mark pointing
+				// away from the page
+				dx := math.Copysign(1, x-(t.X+t.W/2))
+				dy := math.Copysign(1, y-(t.Y+t.H/2))
+				fmt.Fprintf(&buf, "%s %s m %s %s l S\n",
+					pdfNum(x+dx*l.markOffset), pdfNum(y), pdfNum(x+dx*(l.markOffset+cropMarkLength)), pdfNum(y))
+				fmt.Fprintf(&buf, "%s %s m %s %s l S\n",
+					pdfNum(x), pdfNum(y+dy*l.markOffset), pdfNum(x), pdfNum(y+dy*(l.markOffset+cropMarkLength)))
+			}
+		}
+		buf.WriteString("Q\n")
+	}
+	return buf.Bytes()
+}
+
+// writeTextLine writes one line of text centred on x
+func writeTextLine(buf *bytes.Buffer, line string, size, x, y float64) {
+	width := textWidth(line, size)
+	fmt.Fprintf(buf, "BT /F1 %s Tf %s %s Td %s Tj ET\n", pdfNum(size), pdfNum(x-width/2), pdfNum(y), pdfString(string(winAnsi(line))))
+}
+
+// box formats the rectangle as a PDF box array
+func (r pdfRect) box() string {
+	return fmt.Sprintf("[%s %s %s %s]", pdfNum(r.X), pdfNum(r.Y), pdfNum(r.X+r.W), pdfNum(r.Y+r.H))
+}
+
+// coords formats the rectangle as the operands of the re operator
+func (r pdfRect) coords() string {
+	return fmt.Sprintf("%s %s %s %s", pdfNum(r.X), pdfNum(r.Y), pdfNum(r.W), pdfNum(r.H))
+}
+
+// pdfNum formats a number with at most three decimals
+func pdfNum(v float64) string {
+	s := strconv.FormatFloat(v, 'f', 3, 64)
+	s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+	if s == "-0" {
+		return "0"
+	}
+	return s
+}
+
+// pdfString formats bytes as a PDF literal string
+func pdfString(s string) string {
+	var b strings.Builder
+	b.WriteByte('(')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '(', ')', '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case '\r', '\n':
+			b.WriteString(`\n`)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte(')')
+	return b.String()
+}
+
+// pdfTextString formats text for the document information, which holds
+// UTF-16 with a byte order mark
+func pdfTextString(s string) string {
+	var b strings.Builder
+	b.WriteString("<FEFF")
+	for _, r := range s {
+		if r > 0xFFFF {
+			r -= 0x10000
+			fmt.Fprintf(&b, "%04X%04X", 0xD800+(r>>10), 0xDC00+(r&0x3FF))
+			continue
+		}
+		fmt.Fprintf(&b, "%04X", r)
+	}
+	b.WriteByte('>')
+	return b.String()
+}
+
+// pdfWriter writes numbered objects and keeps their offsets for the cross
+// reference table. The first error stops all further writes.
+type pdfWriter struct {
+	w       io.Writer
+	offset  int64
+	offsets map[int]int64
+	err     error
+}
+
+func (pw *pdfWriter) Write(p []byte) (int, error) {
+	if pw.err != nil {
+		return 0, pw.err
+	}
+	n, err := pw.w.Write(p)
+	pw.offset += int64(n)
+	pw.err = err
+	return n, err
+}
+
+func (pw *pdfWriter) printf(format string, args ...any) {
+	fmt.Fprintf(pw, format, args...)
+}
+
+// begin starts object n at the current offset
+func (pw *pdfWriter) begin(n int) {
+	if pw.offsets == nil {
+		pw.offsets = make(map[int]int64)
+	}
+	pw.offsets[n] = pw.offset
+	pw.printf("%d 0 obj\n", n)
+}
+
+func (pw *pdfWriter) object(n int, body string) {
+	pw.begin(n)
+	pw.printf("%s\nendobj\n", body)
+}
+
+// stream writes a stream object whose dictionary holds entries and the length
+func (pw *pdfWriter) stream(n int, entries string, data []byte) {
+	pw.begin(n)
+	if entries != "" {
+		entries += " "
+	}
+	pw.printf("<< %s/Length %d >>\nstream\n", entries, len(data))
+	pw.Write(data)
+	pw.printf("\nendstream\nendobj\n")
+}
+
+// trailer writes the cross reference table of objects 1 to count and the
+// trailer
+func (pw *pdfWriter) trailer(count, root, info int, id []byte) {
+	xref := pw.offset
+	pw.printf("xref\n0 %d\n0000000000 65535 f\r\n", count+1)
+	for n := 1; n <= count; n++ {
+		pw.printf("%010d 00000 n\r\n", pw.offsets[n])
+	}
+	pw.printf("trailer\n<< /Size %d /Root %d 0 R /Info %d 0 R /ID [<%X> <%X>] >>\nstartxref\n%d\n%%%%EOF\n",
+		count+1, root, info, id, id, xref)
+}
@@ -0,0 +1,227 @@
+package render
+
+import (
+	"bytes"
+	"fmt"
+	"image/jpeg"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// parsedPDF is a PDF written by EncodePDF, read back through its cross
+// reference table
+type parsedPDF struct {
+	data    []byte
+	offsets []int // by object number, 0 unused
+}
+
+// parsePDF reads the cross reference table and checks that every entry
+// points at the start of its object
+func parsePDF(t *testing.T, data []byte) *parsedPDF {
+	t.Helper()
+	if !bytes.HasPrefix(data, []byte("%PDF-1.6\n")) || !bytes.HasSuffix(data, []byte("%%EOF\n")) {
+		t.Fatal("not a PDF file")
+	}
+	match := regexp.MustCompile(`startxref\n(\d+)\n%%EOF\n$`).FindSubmatch(data)
+	if match == nil {
+		t.Fatal("no startxref")
+	}
+	xref, _ := strconv.Atoi(string(match[1]))
+	var first, count int
+	if _, err := fmt.Sscanf(string(data[xref:]), "xref\n%d %d\n", &first, &count); err != nil || first != 0 {
+		t.Fatalf("xref table at %d: %v", xref, err)
+	}
+	entries := xref + len(fmt.Sprintf("xref\n0 %d\n", count))
+	if string(data[entries:entries+20]) != "0000000000 65535 f\r\n" {
+		t.Fatalf("free entry %q", data[entries:entries+20])
+	}
+
+	p := &parsedPDF{data: data, offsets: make([]int, count)}
+	for n := 1; n < count; n++ {
+		entry := string(data[entries+20*n : entries+20*n+20])
+		if !strings.HasSuffix(entry, " 00000 n\r\n") {
+			t.Fatalf("xref entry %d is %q", n, entry)
+		}
+		offset, err := strconv.Atoi(entry[:10])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if header := fmt.Sprintf("%d 0 obj\n", n); !bytes.HasPrefix(data[offset:], []byte(header)) {
+			t.Fatalf("object %d: offset %d points at %q", n, offset, data[offset:min(offset+20, len(data))])
+		}
+		p.offsets[n] = offset
+	}
+	if trailer := string(data[entries+20*count:]); !strings.HasPrefix(trailer, fmt.Sprintf("trailer\n<< /Size %d /Root 1 0 R", count)) {
+		t.Fatalf("trailer %q", trailer)
+	}
+	return p
+}
+
+// object returns the body of object n
+func (p *parsedPDF) object(t *testing.T, n int) []byte {
+	t.Helper()
+	body := p.data[p.offsets[n]+len(fmt.Sprintf("%d 0 obj\n", n)):]
+	end := bytes.Index(body, []byte("endobj\n"))
+	if end < 0 {
+		t.Fatalf("object %d has no end", n)
+	}
+	return body[:end]
+}
+
+// stream returns the data of stream object n, checking it against its
+// length, which may be an indirect object
+func (p *parsedPDF) stream(t *testing.T, n int) []byte {
+	t.Helper()
+	body := p.object(t, n)
+	start := bytes.Index(body, []byte(">>\nstream\n"))
+	if start < 0 {
+		t.Fatalf("object %d is not a stream", n)
+	}
+	match := regexp.MustCompile(`/Length (\d+)( 0 R)?`).FindSubmatch(body[:start])
+	if match == nil {
+		t.Fatalf("object %d has no length", n)
+	}
+	length, _ := strconv.Atoi(string(match[1]))
+	if match[2] != nil {
+		length, _ = strconv.Atoi(strings.TrimSpace(string(p.object(t, length))))
+	}
+	data := body[start+len(">>\nstream\n"):]
+	if len(data) != length+len("\nendstream\n") || !bytes.HasSuffix(data, []byte("\nendstream\n")) {
+		t.Fatalf("object %d: stream of %d bytes does not match length %d", n, len(data)-len("\nendstream\n"), length)
+	}
+	return data[:length]
+}
+
+// box reads a page box as x0, y0, x1, y1
+func box(t *testing.T, page []byte, name string) pdfRect {
+	t.Helper()
+	match := regexp.MustCompile(`/` + name + ` \[([-\d.]+) ([-\d.]+) ([-\d.]+) ([-\d.]+)\]`).FindSubmatch(page)
+	if match == nil {
+		t.Fatalf("page has no %s", name)
+	}
+	var v [4]float64
+	for i := range v {
+		v[i], _ = strconv.ParseFloat(string(match[i+1]), 64)
+	}
+	return pdfRect{v[0], v[1], v[2] - v[0], v[3] - v[1]}
+}
+
+// near reports whether a and b are within the rounding of pdfNum
+func near(a, b pdfRect) bool {
+	return math.Abs(a.X-b.X) < 0.002 && math.Abs(a.Y-b.Y) < 0.002 &&
+		math.Abs(a.W-b.W) < 0.002 && math.Abs(a.H-b.H) < 0.002
+}
+
+func TestEncodePDFWithBleedAndCropMarks(t *testing.T) {
+	img := syntheticImage(300, 200, 1)
+	var buf bytes.Buffer
+	if err := EncodePDF(&buf, img, PrintSpec{Paper: "a4", Bleed: 3, CropMarks: true}, 80); err != nil {
+		t.Fatal(err)
+	}
+	pdf := parsePDF(t, buf.Bytes())
+
+	// A landscape mosaic turns the A4 page; the trim box is the paper, the
+	// bleed 3 mm around it, and the media box leaves room for the marks
+	// outside the bleed
+	mm := func(v float64) float64 { return v * pointsPerMM }
+	slug := mm(3) + cropMarkLength + mm(2)
+	page := pdf.object(t, 4)
+	trim := box(t, page, "TrimBox")
+	if want := (pdfRect{slug, slug, mm(297), mm(210)}); !near(trim, want) {
+		t.Fatalf("TrimBox %v, want %v", trim, want)
+	}
+	bleed := box(t, page, "BleedBox")
+	if want := trim.inset(-mm(3)); !near(bleed, want) {
+		t.Fatalf("BleedBox %v, want %v", bleed, want)
+	}
+	media := box(t, page, "MediaBox")
+	if want := (pdfRect{0, 0, mm(297) + 2*slug, mm(210) + 2*slug}); !near(media, want) {
+		t.Fatalf("MediaBox %v, want %v", media, want)
+	}
+
+	// The mosaic is clipped to the bleed box, and each crop mark lines up
+	// with a trim edge between the bleed and the media edge
+	content := string(pdf.stream(t, 6))
+	numbers := func(pattern string) [4]float64 {
+		match := regexp.MustCompile(pattern).FindStringSubmatch(content)
+		if match == nil {
+			t.Fatalf("content has no %s:\n%s", pattern, content)
+		}
+		var v [4]float64
+		for i := range v {
+			v[i], _ = strconv.ParseFloat(match[i+1], 64)
+		}
+		return v
+	}
+	if clip := numbers(`^q ([-\d.]+) ([-\d.]+) ([-\d.]+) ([-\d.]+) re W n\n`); !near(pdfRect{clip[0], clip[1], clip[2], clip[3]}, bleed) {
+		t.Fatalf("content clips to %v, want the bleed box %v", clip, bleed)
+	}
+	placed := numbers(`([-\d.]+) 0 0 ([-\d.]+) ([-\d.]+) ([-\d.]+) cm /Im0 Do`)
+	if placed[2] > bleed.X || placed[3] > bleed.Y || placed[2]+placed[0] < bleed.X+bleed.W || placed[3]+placed[1] < bleed.Y+bleed.H {
+		t.Fatalf("image at %v does not cover the bleed box %v", placed, bleed)
+	}
+	marks := regexp.MustCompile(`([-\d.]+) ([-\d.]+) m ([-\d.]+) ([-\d.]+) l S`).FindAllStringSubmatch(content, -1)
+	if len(marks) != 8 {
+		t.Fatalf("%d crop marks, want 8", len(marks))
+	}
+	for _, mark := range marks {
+		var v [4]float64
+		for i := range v {
+			v[i], _ = strconv.ParseFloat(mark[i+1], 64)
+		}
+		onTrimEdge := func(v, lo, size float64) bool { return math.Abs(v-lo) < 0.002 || math.Abs(v-lo-size) < 0.002 }
+		vertical := v[0] == v[2]
+		if vertical && !onTrimEdge(v[0], trim.X, trim.W) || !vertical && !onTrimEdge(v[1], trim.Y, trim.H) {
+			t.Errorf("crop mark %s is not on a trim edge", mark[0])
+		}
+		for _, p := range [][2]float64{{v[0], v[1]}, {v[2], v[3]}} {
+			insideBleed := p[0] > bleed.X && p[0] < bleed.X+bleed.W && p[1] > bleed.Y && p[1] < bleed.Y+bleed.H
+			insideMedia := p[0] >= 0 && p[0] <= media.W && p[1] >= 0 && p[1] <= media.H
+			if insideBleed || !insideMedia {
+				t.Errorf("crop mark %s reaches %v", mark[0], p)
+			}
+		}
+	}
+
+	// The output intent is the sRGB profile the image is tagged with
+	if catalog := string(pdf.object(t, 1)); !strings.Contains(catalog, "/OutputIntents [3 0 R]") {
+		t.Fatalf("catalog %s", catalog)
+	}
+	intent := string(pdf.object(t, 3))
+	if !strings.Contains(intent, "/S /GTS_PDFX") || !strings.Contains(intent, "/DestOutputProfile 5 0 R") {
+		t.Fatalf("output intent %s", intent)
+	}
+	profile := SRGBProfile()
+	if !bytes.Equal(pdf.stream(t, 5), profile) {
+		t.Fatal("the output intent's profile is not sRGB")
+	}
+
+	// The image's length is an indirect object written after it
+	if !strings.Contains(string(pdf.object(t, 9)), "/ColorSpace [/ICCBased 5 0 R]") {
+		t.Fatal("the image is not tagged with the profile")
+	}
+	decoded, err := jpeg.Decode(bytes.NewReader(pdf.stream(t, 9)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Bounds() != img.Bounds() {
+		t.Fatalf("embedded image is %v, want %v", decoded.Bounds(), img.Bounds())
+	}
+}
+
+func TestEncodePDFEdgeToEdge(t *testing.T) {
+	var buf bytes.Buffer
+	if err := EncodePDF(&buf, syntheticImage(200, 300, 1), PrintSpec{PrintWidth: 8, PrintHeight: 10, Unit: "in"}, 80); err != nil {
+		t.Fatal(err)
+	}
+	page := parsePDF(t, buf.Bytes()).object(t, 4)
+	want := pdfRect{0, 0, 8 * 72, 10 * 72}
+	for _, name := range []string{"MediaBox", "BleedBox", "TrimBox"} {
+		if got := box(t, page, name); !near(got, want) {
+			t.Errorf("%s %v, want %v", name, got, want)
+		}
+	}
+}
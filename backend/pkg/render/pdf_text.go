@@ -0,0 +1,111 @@
+package render
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// helveticaWidths holds the advance widths of Helvetica in thousandths of
+// the type size, indexed by WinAnsiEncoding code from 32. Codes without a
+// glyph are zero.
+var helveticaWidths = [224]uint16{
+	// 32-63: space to ?
+	278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278,
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556,
+	// 64-95: @ to _
+	1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778,
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556,
+	// 96-127: ` to ~
+	333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556,
+	556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584, 0,
+	// 128-159: the Windows punctuation block
+	556, 0, 222, 556, 333, 1000, 556, 556, 333, 1000, 667, 333, 1000, 0, 611, 0,
+	0, 222, 222, 333, 333, 350, 556, 1000, 333, 1000, 500, 333, 944, 0, 500, 667,
+	// 160-191: Latin-1 symbols
+	278, 333, 556, 556, 556, 556, 260, 556, 333, 737, 370, 556, 584, 333, 737, 333,
+	400, 584, 333, 333, 333, 556, 537, 278, 333, 333, 365, 556, 834, 834, 834, 611,
+	// 192-223: Latin-1 capitals
+	667, 667, 667, 667, 667, 667, 1000, 722, 667, 667, 667, 667, 278, 278, 278, 278,
+	722, 722, 778, 778, 778, 778, 778, 584, 778, 722, 722, 722, 722, 667, 667, 611,
+	// 224-255: Latin-1 small letters
+	556, 556, 556, 556, 556, 556, 889, 500, 556, 556, 556, 556, 278, 278, 278, 278,
+	556, 556, 556, 556, 556, 556, 556, 584, 611, 556, 556, 556, 556, 500, 556, 500,
+}
+
+// winAnsiSpecials maps the characters of WinAnsiEncoding's 128-159 block
+var winAnsiSpecials = map[rune]byte{
+	'€': 0x80, '‚': 0x82, 'ƒ': 0x83, '„': 0x84, '…': 0x85, '†': 0x86, '‡': 0x87,
+	'ˆ': 0x88, '‰': 0x89, 'Š': 0x8A, '‹': 0x8B, 'Œ': 0x8C, 'Ž': 0x8E,
+	'‘': 0x91, '’': 0x92, '“': 0x93, '”': 0x94, '•': 0x95, '–': 0x96, '—': 0x97,
+	'˜': 0x98, '™': 0x99, 'š': 0x9A, '›': 0x9B, 'œ': 0x9C, 'ž': 0x9E, 'Ÿ': 0x9F,
+}
+
+// winAnsi encodes text for the standard Helvetica font. Characters the
+// encoding lacks are printed as question marks.
+func winAnsi(s string) []byte {
+	out := make([]byte, 0, len(s))
+	for _, r := range s {
+		switch {
+		case r >= 32 && r < 127, r >= 160 && r <= 255:
+			out = append(out, byte(r))
+		case r == '\t':
+			out = append(out, ' ')
+		default:
+			if c, ok := winAnsiSpecials[r]; ok {
+				out = append(out, c)
+			} else {
+				out = append(out, '?')
+			}
+		}
+	}
+	return out
+}
+
+// textWidth returns the width in points of a line of text set in Helvetica
+func textWidth(s string, size float64) float64 {
+	var width int
+	for _, c := range winAnsi(s) {
+		width += int(helveticaWidths[c-32])
+	}
+	return float64(width) * size / 1000
+}
+
+// wrapText breaks text into lines that fit in width at the given size.
+// Line breaks in the text are kept, and words too long for a line are
+// broken between characters.
+func wrapText(text string, size, width float64) []string {
+	var lines []string
+	for _, paragraph := range strings.Split(strings.TrimSpace(text), "\n") {
+		line := ""
+		for _, word := range strings.Fields(paragraph) {
+			candidate := word
+			if line != "" {
+				candidate = line + " " + word
+			}
+			if textWidth(candidate, size) <= width {
+				line = candidate
+				continue
+			}
+			if line != "" {
+				lines = append(lines, line)
+			}
+			for textWidth(word, size) > width && utf8.RuneCountInString(word) > 1 {
+				cut := len(word)
+				for cut > 0 && textWidth(word[:cut], size) > width {
+					_, n := utf8.DecodeLastRuneInString(word[:cut])
+					cut -= n
+				}
+				if cut == 0 {
+					_, cut = utf8.DecodeRuneInString(word)
+				}
+				lines = append(lines, word[:cut])
+				word = word[cut:]
+			}
+			line = word
+		}
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
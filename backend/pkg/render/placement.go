@@ -0,0 +1,74 @@
+package render
+
+import (
+	"image"
+	"math"
+)
+
+// MatchErrors returns how far the tile in each cell is from the part of
+// the main image it stands in for, row by row: the distance between their
+// mean colours in RGB, from 0 for a perfect match to about 441 for black
+// against white. Cells without a tile have no error.
+func (s *Scene) MatchErrors() []float64 {
+	grid := s.Plan.Grid
+
+	tileMeans := make([][3]float64, s.Tiles.Len())
+	for i := range tileMeans {
+		tile := s.Tiles.Get(i, grid.TileSide())
+		tileMeans[i] = meanColor(tile, tile.Bounds())
+	}
+
+	matchErrors := make([]float64, grid.Len())
+	for row := 0; row < grid.Rows; row++ {
+		for col := 0; col < grid.Cols; col++ {
+			index := s.Plan.Tile(col, row)
+			if index == NoTile {
+				continue
+			}
+			cell := grid.CellRect(col, row).Intersect(s.Bounds())
+			target := meanColor(s.Guide.src, s.Guide.SourceRect(cell))
+			tile := tileMeans[index]
+
+			var sum float64
+			for c := 0; c < 3; c++ {
+				d := target[c] - tile[c]
+				sum += d * d
+			}
+			matchErrors[row*grid.Cols+col] = math.Sqrt(sum)
+		}
+	}
+	return matchErrors
+}
+
+// meanColor returns the mean RGB colour of region r of img, with channels
+// from 0 to 255
+func meanColor(img image.Image, r image.Rectangle) [3]float64 {
+	r = r.Intersect(img.Bounds())
+	var sum [3]float64
+	if r.Empty() {
+		return sum
+	}
+
+	if rgba, ok := img.(*image.RGBA); ok {
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			offset := rgba.PixOffset(r.Min.X, y)
+			for i := offset; i < offset+r.Dx()*4; i += 4 {
+				sum[0] += float64(rgba.Pix[i])
+				sum[1] += float64(rgba.Pix[i+1])
+				sum[2] += float64(rgba.Pix[i+2])
+			}
+		}
+	} else {
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			for x := r.Min.X; x < r.Max.X; x++ {
+				cr, cg, cb, _ := img.At(x, y).RGBA()
+				sum[0] += float64(cr >> 8)
+				sum[1] += float64(cg >> 8)
+				sum[2] += float64(cb >> 8)
+			}
+		}
+	}
+
+	n := float64(r.Dx() * r.Dy())
+	return [3]float64{sum[0] / n, sum[1] / n, sum[2] / n}
+}
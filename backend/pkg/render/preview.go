@@ -0,0 +1,60 @@
+package render
+
+import (
+	"image"
+	"image/draw"
+	"math"
+
+	xdraw "golang.org/x/image/draw"
+)
+
+// DrawPreview draws a plan scaled down to the size of dst, with thumbs as
+// small stand-ins for the tiles. Cells map to the same places as in the
+// full size render, so the preview shows the same placement however few
+// pixels each cell gets. Cells without a tile get the background, white if
+// it is nil.
+func DrawPreview(dst *image.RGBA, guide *Guide, plan *Plan, thumbs []*image.RGBA, overlay float64, background *Background) {
+	b := dst.Bounds()
+	grid := plan.Grid
+	sx := float64(b.Dx()) / float64(grid.Size.Width)
+	sy := float64(b.Dy()) / float64(grid.Size.Height)
+	drawBackground := func(r image.Rectangle) *image.RGBA {
+		bg := image.NewRGBA(r)
+		background.orWhite().draw(bg, r, guide)
+		return bg
+	}
+	if !grid.Plain() {
+		drawShapedCells(dst, b, plan, sx, float64(b.Min.X), float64(b.Min.Y), func(i int) *image.RGBA {
+			return thumbs[i]
+		})
+		guide.Overlay(dst, b, overlay)
+		drawEmptyCells(dst, b, plan, sx, float64(b.Min.X), float64(b.Min.Y), drawBackground)
+		return
+	}
+
+	for row := 0; row < grid.Rows; row++ {
+		for col := 0; col < grid.Cols; col++ {
+			cellRect := grid.CellRect(col, row)
+			r := image.Rect(
+				b.Min.X+int(math.Floor(float64(cellRect.Min.X)*sx)),
+				b.Min.Y+int(math.Floor(float64(cellRect.Min.Y)*sy)),
+				b.Min.X+int(math.Floor(float64(cellRect.Max.X)*sx)),
+				b.Min.Y+int(math.Floor(float64(cellRect.Max.Y)*sy)),
+			)
+			if r.Empty() {
+				continue
+			}
+
+			// Scale into the whole cell and let dst clip the edges, as the
+			// full size render clips tiles rather than squeezing them
+			index := plan.Tile(col, row)
+			if index == NoTile {
+				continue
+			}
+			thumb := thumbs[index]
+			xdraw.ApproxBiLinear.Scale(dst, r, thumb, thumb.Bounds(), draw.Src, nil)
+		}
+	}
+	guide.Overlay(dst, b, overlay)
+	drawEmptyCells(dst, b, plan, sx, float64(b.Min.X), float64(b.Min.Y), drawBackground)
+}
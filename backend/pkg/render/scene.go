@@ -0,0 +1,272 @@
+package render
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"math/rand"
+	"runtime"
+	"sync"
+)
+
+// Grid divides an output into cells of Cell pixels, square unless Shape
+// says otherwise. Cells on the edges may extend past the output and are
+// clipped.
+type Grid struct {
+	Size       Size
+	Cell       int
+	Cols       int
+	Rows       int
+	Shape      string     // see ShapeSpec, square if empty
+	Grout      float64    // width of the grout between cells in pixels
+	GroutColor color.RGBA // colour of the grout
+}
+
+// NewGrid covers an output with cells of the given size, rounded to whole
+// pixels so every cell has the same size
+func NewGrid(size Size, cell float64) Grid {
+	c := max(int(math.Round(cell)), 1)
+	return Grid{
+		Size: size,
+		Cell: c,
+		Cols: (size.Width + c - 1) / c,
+		Rows: (size.Height + c - 1) / c,
+	}
+}
+
+// NewLayoutGrid covers an output with cells of tileSize main image pixels
+// in the given shape, for a main image of srcW x srcH cropped to the
+// output's aspect ratio. The grout is scaled with the cells.
+func NewLayoutGrid(size Size, srcW, srcH, tileSize int, shape ShapeSpec) Grid {
+	crop := CoverCrop(srcW, srcH, size)
+	scale := float64(size.Width) / float64(crop.Dx())
+	return newShapedGrid(size, float64(tileSize)*scale, shape.GroutWidth*scale, shape)
+}
+
+// Len returns the number of cells
+func (g Grid) Len() int {
+	return g.Cols * g.Rows
+}
+
+// CellRect returns the output rectangle of a cell. Cells that are not
+// square overlap the rectangles of their neighbours.
+func (g Grid) CellRect(col, row int) image.Rectangle {
+	if g.Shape == "" || g.Shape == ShapeSquare {
+		return image.Rect(col*g.Cell, row*g.Cell, (col+1)*g.Cell, (row+1)*g.Cell)
+	}
+	x0, y0, x1, y1 := g.cellBounds(col, row)
+	return image.Rect(int(math.Floor(x0)), int(math.Floor(y0)), int(math.Ceil(x1)), int(math.Ceil(y1)))
+}
+
+// RowsIn returns the range of rows [first, last) that intersect r
+func (g Grid) RowsIn(r image.Rectangle) (int, int) {
+	if g.Shape == "" || g.Shape == ShapeSquare {
+		first := max(r.Min.Y/g.Cell, 0)
+		last := min((r.Max.Y+g.Cell-1)/g.Cell, g.Rows)
+		return first, last
+	}
+	first, last := 0, g.Rows
+	for first < last && g.CellRect(0, first).Max.Y <= r.Min.Y {
+		first++
+	}
+	for last > first && g.CellRect(0, last-1).Min.Y >= r.Max.Y {
+		last--
+	}
+	return first, last
+}
+
+// NoTile marks a cell of a plan that is left to the background, such as
+// the cells outside a mask
+const NoTile = -1
+
+// Plan is the layout of a mosaic: its grid and the tile placed in each
+// cell. It is computed once per generation and every output size is
+// rendered from it, so all of them show the same placement.
+type Plan struct {
+	Grid   Grid
+	Assign []int // tile index for each cell, row by row, or NoTile
+}
+
+// NewRandomPlan places a random tile in every cell of grid
+func NewRandomPlan(grid Grid, tileCount int, rng *rand.Rand) *Plan {
+	assign := make([]int, grid.Len())
+	for i := range assign {
+		assign[i] = rng.Intn(tileCount)
+	}
+	return &Plan{Grid: grid, Assign: assign}
+}
+
+// Tile returns the tile index placed at a cell, or NoTile
+func (p *Plan) Tile(col, row int) int {
+	return p.Assign[row*p.Grid.Cols+col]
+}
+
+// Scene is everything needed to draw any part of a mosaic: the guide, the
+// plan and the tile images
+type Scene struct {
+	Guide      *Guide
+	Plan       *Plan
+	Tiles      *TileSet
+	Overlay    float64     // opacity of the guide drawn over the tiles
+	Background *Background // fills the cells without a tile, white if nil
+	Workers    int         // goroutines drawing in parallel, GOMAXPROCS if zero
+}
+
+// Bounds returns the output rectangle of the scene
+func (s *Scene) Bounds() image.Rectangle {
+	return image.Rect(0, 0, s.Plan.Grid.Size.Width, s.Plan.Grid.Size.Height)
+}
+
+// Opaque reports whether a rendered scene has no transparent pixels,
+// which only a transparent background leaves
+func (s *Scene) Opaque() bool {
+	return s.Background == nil || s.Background.Color.A == 255
+}
+
+// Flat returns the scene with a transparent background replaced by white,
+// for formats that cannot store transparency
+func (s *Scene) Flat() *Scene {
+	if s.Opaque() {
+		return s
+	}
+	flat := *s
+	flat.Background = &Background{Color: color.RGBA{R: 255, G: 255, B: 255, A: 255}}
+	return &flat
+}
+
+// Draw renders the part of the scene covered by dst's bounds into dst. The
+// region is split into strips of whole cell rows that are drawn in parallel.
+func (s *Scene) Draw(dst *image.RGBA) {
+	region := dst.Bounds().Intersect(s.Bounds())
+	if region.Empty() {
+		return
+	}
+
+	workers := s.Workers
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	if !s.Plan.Grid.Plain() {
+		s.drawShaped(dst, region, workers)
+		return
+	}
+	first, last := s.Plan.Grid.RowsIn(region)
+	workers = min(workers, last-first)
+	if workers <= 1 {
+		s.drawRows(dst, region, first, last)
+		return
+	}
+
+	// Workers take the next cell row until none are left. Rows are
+	// disjoint, so the workers never write the same pixels.
+	rows := make(chan int, last-first)
+	for row := first; row < last; row++ {
+		rows <- row
+	}
+	close(rows)
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for row := range rows {
+				s.drawRows(dst, region, row, row+1)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// drawRows draws the cells of rows [first, last) that fall inside region
+func (s *Scene) drawRows(dst *image.RGBA, region image.Rectangle, first, last int) {
+	grid := s.Plan.Grid
+	for row := first; row < last; row++ {
+		for col := 0; col < grid.Cols; col++ {
+			cellRect := grid.CellRect(col, row)
+			visible := cellRect.Intersect(region)
+			if visible.Empty() {
+				continue
+			}
+
+			// Cells without a tile show the background, without the guide
+			index := s.Plan.Tile(col, row)
+			if index == NoTile {
+				s.Background.orWhite().draw(dst, visible, s.Guide)
+				continue
+			}
+
+			// Tiles on the edges are clipped rather than squeezed
+			tile := s.Tiles.Get(index, grid.Cell)
+			draw.Draw(dst, visible, tile, visible.Min.Sub(cellRect.Min), draw.Src)
+			s.Guide.Overlay(dst, visible, s.Overlay)
+		}
+	}
+}
+
+// drawShaped draws the cells of a shaped grid that fall inside region.
+// Shaped cells overlap the rows next to them, so workers take strips of
+// pixel rows instead of cell rows and each pixel is drawn by one worker.
+func (s *Scene) drawShaped(dst *image.RGBA, region image.Rectangle, workers int) {
+	grid := s.Plan.Grid
+	side := grid.TileSide()
+	strip := max(grid.Cell, 16)
+	n := (region.Dy() + strip - 1) / strip
+
+	strips := make(chan image.Rectangle, n)
+	for y := region.Min.Y; y < region.Max.Y; y += strip {
+		strips <- image.Rect(region.Min.X, y, region.Max.X, min(y+strip, region.Max.Y))
+	}
+	close(strips)
+
+	var wg sync.WaitGroup
+	for i := 0; i < min(workers, n); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for r := range strips {
+				drawShapedCells(dst, r, s.Plan, 1, 0, 0, func(i int) *image.RGBA {
+					return s.Tiles.Get(i, side)
+				})
+				s.Guide.Overlay(dst, r, s.Overlay)
+				drawEmptyCells(dst, r, s.Plan, 1, 0, 0, func(r image.Rectangle) *image.RGBA {
+					bg := image.NewRGBA(r)
+					s.Background.orWhite().draw(bg, r, s.Guide)
+					return bg
+				})
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// GuideLayer is the guide on its own, with the opacity it is blended over
+// the tiles with as its alpha. Drawn over a scene without overlay, it gives
+// the same image as the scene with that overlay.
+type GuideLayer struct {
+	Guide   *Guide
+	Opacity float64
+	Plan    *Plan // if set, the guide is left out of the cells without a tile
+}
+
+// Bounds returns the output rectangle of the layer
+func (l *GuideLayer) Bounds() image.Rectangle {
+	size := l.Guide.Size()
+	return image.Rect(0, 0, size.Width, size.Height)
+}
+
+// Opaque reports whether the guide is drawn at full opacity everywhere
+func (l *GuideLayer) Opaque() bool {
+	return l.Opacity >= 1 && l.Plan == nil
+}
+
+// Draw renders the part of the layer covered by dst's bounds into dst,
+// which must be transparent
+func (l *GuideLayer) Draw(dst *image.RGBA) {
+	r := dst.Bounds().Intersect(l.Bounds())
+	l.Guide.Overlay(dst, r, l.Opacity)
+	if l.Plan != nil {
+		drawEmptyCells(dst, r, l.Plan, 1, 0, 0, image.NewRGBA)
+	}
+}
@@ -0,0 +1,106 @@
+package render
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"math/rand"
+	"testing"
+)
+
+// syntheticImage returns a gradient image whose colours depend on seed
+func syntheticImage(w, h, seed int) image.Image {
+	img := image.NewYCbCr(image.Rect(0, 0, w, h), image.YCbCrSubsampleRatio420)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			yy, cb, cr := color.RGBToYCbCr(uint8(x*255/w), uint8(y*255/h), uint8(seed*37))
+			img.Y[img.YOffset(x, y)] = yy
+			img.Cb[img.COffset(x, y)] = cb
+			img.Cr[img.COffset(x, y)] = cr
+		}
+	}
+	return img
+}
+
+// testScene lays out a mosaic of a synthetic main image and tiles, placing
+// the tiles with seed
+func testScene(tb testing.TB, width, tileCount, tilePixels, tileSize int, shape ShapeSpec, seed int64) *Scene {
+	tb.Helper()
+	mainImg := syntheticImage(300, 200, 0)
+	size, err := OutputSpec{Width: width}.Resolve(300, 200)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	grid := NewLayoutGrid(size, 300, 200, tileSize, shape)
+
+	photos := make([]image.Image, tileCount)
+	for i := range photos {
+		photos[i] = syntheticImage(tilePixels, tilePixels, i+1)
+	}
+	tiles, err := LoadTiles(context.Background(), len(photos), []int{grid.TileSide()}, 0,
+		func(i int) (image.Image, error) { return photos[i], nil },
+		func(i int, err error) { tb.Fatalf("tile %d: %v", i, err) },
+	)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	plan := NewRandomPlan(grid, tiles.Len(), rand.New(rand.NewSource(seed)))
+	return &Scene{Guide: NewGuide(mainImg, size), Plan: plan, Tiles: tiles, Overlay: 0.3}
+}
+
+// renderPNG draws a scene and encodes it
+func renderPNG(t *testing.T, scene *Scene) []byte {
+	t.Helper()
+	dst := image.NewRGBA(scene.Bounds())
+	scene.Draw(dst)
+	var buf bytes.Buffer
+	if err := EncodePNG(&buf, dst, 72); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestSceneDrawIsDeterministic(t *testing.T) {
+	for _, shape := range []string{ShapeSquare, ShapeHex} {
+		t.Run(shape, func(t *testing.T) {
+			spec := ShapeSpec{Shape: shape}
+			first := renderPNG(t, testScene(t, 600, 8, 64, 20, spec, 42))
+			again := renderPNG(t, testScene(t, 600, 8, 64, 20, spec, 42))
+			if !bytes.Equal(first, again) {
+				t.Fatal("two renders with the same seed differ")
+			}
+
+			other := renderPNG(t, testScene(t, 600, 8, 64, 20, spec, 43))
+			if bytes.Equal(first, other) {
+				t.Fatal("renders with different seeds are identical")
+			}
+		})
+	}
+}
+
+// BenchmarkSceneDraw draws a 3000 pixel wide mosaic of 50 pixel cells with
+// one worker and with GOMAXPROCS workers
+func BenchmarkSceneDraw(b *testing.B) {
+	scene := testScene(b, 3000, 100, 400, 5, ShapeSpec{}, 1)
+	dst := image.NewRGBA(scene.Bounds())
+
+	for _, bc := range benchWorkers {
+		b.Run(bc.name, func(b *testing.B) {
+			scene.Workers = bc.workers
+			for i := 0; i < b.N; i++ {
+				scene.Draw(dst)
+			}
+		})
+	}
+}
+
+// benchWorkers compares one worker with the default of GOMAXPROCS workers
+var benchWorkers = []struct {
+	name    string
+	workers int
+}{
+	{"workers=1", 1},
+	{"workers=gomaxprocs", 0},
+}
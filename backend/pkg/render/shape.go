@@ -0,0 +1,360 @@
+package render
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Tile shapes. The tile size is the width of a cell: the side of a square
+// or triangle, the diameter of a circle, the distance between the flat
+// sides of a hexagon and the diagonal of a diamond.
+const (
+	ShapeSquare   = "square"
+	ShapeHex      = "hex"      // pointy-top hexagons in offset rows
+	ShapeCircle   = "circle"   // circles packed in offset rows, with grout between them
+	ShapeTriangle = "triangle" // triangles pointing up and down in turn
+	ShapeDiamond  = "diamond"  // squares turned by 45 degrees
+	ShapeBrick    = "brick"    // squares in rows offset by half a tile
+)
+
+// ShapeSpec selects the shape of the tiles and the grout between them
+type ShapeSpec struct {
+	Shape      string  `json:"shape,omitempty"`       // square, hex, circle, triangle, diamond or brick; square if empty
+	GroutWidth float64 `json:"grout_width,omitempty"` // in main image pixels, like the tile size
+	GroutColor string  `json:"grout_color,omitempty"` // #rrggbb or #rgb, white if empty
+}
+
+// Validate checks the shape and that the grout leaves some of each tile
+// showing
+func (s ShapeSpec) Validate(tileSize int) error {
+	switch s.Shape {
+	case "", ShapeSquare, ShapeHex, ShapeCircle, ShapeTriangle, ShapeDiamond, ShapeBrick:
+	default:
+		return fmt.Errorf("unknown tile shape %q", s.Shape)
+	}
+	if s.GroutWidth < 0 || s.GroutWidth > float64(tileSize)/4 {
+		return errors.New("grout width must be between 0 and a quarter of the tile size")
+	}
+	if _, err := s.groutColor(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// groutColor parses the grout colour
+func (s ShapeSpec) groutColor() (color.RGBA, error) {
+	return parseColor("grout color", s.GroutColor)
+}
+
+// parseColor parses an opaque colour given as #rrggbb or #rgb, white if
+// empty. name is the field the colour came from, for the error.
+func parseColor(name, value string) (color.RGBA, error) {
+	if value == "" {
+		return color.RGBA{R: 255, G: 255, B: 255, A: 255}, nil
+	}
+
+	hex := strings.TrimPrefix(value, "#")
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil || len(hex) != 6 || !strings.HasPrefix(value, "#") {
+		return color.RGBA{}, fmt.Errorf("%s must be like #rrggbb, not %q", name, value)
+	}
+	return color.RGBA{R: uint8(v >> 16), G: uint8(v >> 8), B: uint8(v), A: 255}, nil
+}
+
+// newShapedGrid covers an output with cells of a shape, cell pixels wide.
+// Every shape is laid out in rows of a fixed number of cells, some of which
+// may fall outside the output where rows are offset.
+func newShapedGrid(size Size, cell, grout float64, spec ShapeSpec) Grid {
+	if spec.Shape == "" || spec.Shape == ShapeSquare {
+		grid := NewGrid(size, cell)
+		grid.Grout = grout
+		grid.GroutColor, _ = spec.groutColor()
+		return grid
+	}
+
+	grid := Grid{Size: size, Cell: max(int(math.Round(cell)), 1), Shape: spec.Shape, Grout: grout}
+	grid.GroutColor, _ = spec.groutColor()
+	g := grid.geometry()
+	w, h := float64(size.Width), float64(size.Height)
+
+	// Enough cells for the last of each row to reach the right edge, and
+	// enough rows for the last to cover the bottom edge
+	grid.Cols = int(math.Ceil((w-g.cx0+g.w/2)/g.px)) + 1
+	switch grid.Shape {
+	case ShapeBrick, ShapeTriangle:
+		grid.Rows = int(math.Ceil(h / g.py))
+	case ShapeHex:
+		grid.Rows = int(math.Ceil((h-g.cy0-g.h/4)/g.py)) + 1
+	default:
+		grid.Rows = int(math.Ceil((h-g.cy0)/g.py)) + 1
+	}
+	grid.Rows = max(grid.Rows, 1)
+	return grid
+}
+
+// Plain reports whether the grid is square cells without grout, drawn
+// edge to edge
+func (g Grid) Plain() bool {
+	return (g.Shape == "" || g.Shape == ShapeSquare) && g.Grout <= 0
+}
+
+// gridGeometry places the cells of a grid: cell (col, row) is centred at
+// (cx0 + col*px + odd*(row%2), cy0 + row*py) and its bounds are w x h
+type gridGeometry struct {
+	px, py, odd float64
+	cx0, cy0    float64
+	w, h        float64
+}
+
+func (g Grid) geometry() gridGeometry {
+	s := float64(g.Cell)
+	switch g.Shape {
+	case ShapeBrick:
+		return gridGeometry{px: s, py: s, odd: -s / 2, cx0: s / 2, cy0: s / 2, w: s, h: s}
+	case ShapeHex:
+		h := 2 * s / math.Sqrt(3)
+		return gridGeometry{px: s, py: h * 3 / 4, odd: -s / 2, cx0: s / 2, cy0: h / 4, w: s, h: h}
+	case ShapeCircle:
+		return gridGeometry{px: s, py: s * math.Sqrt(3) / 2, odd: -s / 2, cx0: s / 2, cy0: s / 2, w: s, h: s}
+	case ShapeTriangle:
+		h := s * math.Sqrt(3) / 2
+		return gridGeometry{px: s / 2, py: h, cx0: 0, cy0: h / 2, w: s, h: h}
+	case ShapeDiamond:
+		return gridGeometry{px: s, py: s / 2, odd: -s / 2, cx0: s / 2, cy0: 0, w: s, h: s}
+	default:
+		return gridGeometry{px: s, py: s, cx0: s / 2, cy0: s / 2, w: s, h: s}
+	}
+}
+
+// Center returns the centre of a cell's bounds in output pixels
+func (g Grid) Center(col, row int) (float64, float64) {
+	geo := g.geometry()
+	return geo.cx0 + float64(col)*geo.px + geo.odd*float64(row%2), geo.cy0 + float64(row)*geo.py
+}
+
+// TileSide returns the side of the square tiles are scaled to, which covers
+// the bounds of a cell
+func (g Grid) TileSide() int {
+	if g.Plain() {
+		return g.Cell
+	}
+	geo := g.geometry()
+	return int(math.Ceil(math.Max(geo.w, geo.h)))
+}
+
+// distance returns how far output point (x, y) is outside a cell, in
+// output pixels; it is negative inside
+func (g Grid) distance(col, row int, x, y float64) float64 {
+	cx, cy := g.Center(col, row)
+	return g.shapeDistance(col, row)(x-cx, y-cy)
+}
+
+// shapeDistance returns the distance function of a cell, taking the
+// offset from its centre
+func (g Grid) shapeDistance(col, row int) func(dx, dy float64) float64 {
+	s := float64(g.Cell)
+	switch g.Shape {
+	case ShapeHex:
+		return func(dx, dy float64) float64 {
+			dx, dy = math.Abs(dx), math.Abs(dy)
+			return math.Max(dx, dx/2+dy*math.Sqrt(3)/2) - s/2
+		}
+	case ShapeCircle:
+		return func(dx, dy float64) float64 {
+			return math.Hypot(dx, dy) - s/2
+		}
+	case ShapeTriangle:
+		h := s * math.Sqrt(3) / 2
+		flip := 1.0
+		if (col+row)%2 == 1 {
+			flip = -1
+		}
+		return func(dx, dy float64) float64 {
+			dy *= flip
+			return math.Max(dy-h/2, math.Abs(dx)*math.Sqrt(3)/2-(dy+h/2)/2)
+		}
+	case ShapeDiamond:
+		return func(dx, dy float64) float64 {
+			return (math.Abs(dx) + math.Abs(dy) - s/2) / math.Sqrt2
+		}
+	default:
+		return func(dx, dy float64) float64 {
+			return math.Max(math.Abs(dx), math.Abs(dy)) - s/2
+		}
+	}
+}
+
+// CellAt returns the cell whose shape holds output point (x, y), or the
+// nearest cell to a point in the grout
+func (g Grid) CellAt(x, y float64) (int, int) {
+	bestCol, bestRow, best := 0, 0, math.Inf(1)
+	g.nearCells(x, y, func(col, row int) {
+		if d := g.distance(col, row, x, y); d < best {
+			bestCol, bestRow, best = col, row, d
+		}
+	})
+	return bestCol, bestRow
+}
+
+// nearCells calls f with every cell that can hold output point (x, y) or
+// be nearest to it
+func (g Grid) nearCells(x, y float64, f func(col, row int)) {
+	geo := g.geometry()
+	row := int(math.Floor((y - geo.cy0) / geo.py))
+	for r := max(row-1, 0); r <= min(row+2, g.Rows-1); r++ {
+		col := int(math.Floor((x - geo.cx0 - geo.odd*float64(r%2)) / geo.px))
+		for c := max(col-1, 0); c <= min(col+2, g.Cols-1); c++ {
+			f(c, r)
+		}
+	}
+}
+
+// cellBounds returns the bounds of a cell in output pixels
+func (g Grid) cellBounds(col, row int) (x0, y0, x1, y1 float64) {
+	geo := g.geometry()
+	cx, cy := g.Center(col, row)
+	return cx - geo.w/2, cy - geo.h/2, cx + geo.w/2, cy + geo.h/2
+}
+
+// drawShapedCells draws the cells of a plan that fall in region r of dst
+// over grout, with output pixel (x, y) landing on dst at (x*k+ox, y*k+oy).
+// tile returns a square image of tile i at any size; cells without a tile
+// are left as grout. Edges are anti-aliased against the grout; without
+// grout each cell is widened by half a pixel, so that it blends over the
+// cells drawn before it instead of letting the grout show through the
+// seams. Every pixel is composited from the cells over it in the same
+// order however r is split.
+func drawShapedCells(dst *image.RGBA, r image.Rectangle, plan *Plan, k, ox, oy float64, tile func(i int) *image.RGBA) {
+	grid := plan.Grid
+	r = r.Intersect(dst.Bounds())
+	if r.Empty() {
+		return
+	}
+	groutColor := grid.GroutColor
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		i := dst.PixOffset(r.Min.X, y)
+		for end := i + r.Dx()*4; i < end; i += 4 {
+			dst.Pix[i], dst.Pix[i+1], dst.Pix[i+2], dst.Pix[i+3] = groutColor.R, groutColor.G, groutColor.B, 255
+		}
+	}
+
+	// Coverage is 0.5 - d + expand for a pixel whose centre is d pixels
+	// outside the shape shrunk by half the grout
+	expand := -grid.Grout * k / 2
+	if grid.Grout <= 0 {
+		expand = 0.5
+	}
+
+	side := float64(grid.TileSide()) * k
+	cellsIn(grid, r, k, ox, oy, func(col, row int, cr image.Rectangle) {
+		i := plan.Tile(col, row)
+		if i == NoTile {
+			return
+		}
+		img := tile(i)
+		cx, cy := grid.Center(col, row)
+		distance := grid.shapeDistance(col, row)
+		drawShapedCell(dst, cr, img, cx*k+ox-side/2, cy*k+oy-side/2, side, func(x, y int) float64 {
+			d := distance((float64(x)+0.5-ox)/k-cx, (float64(y)+0.5-oy)/k-cy) * k
+			return math.Min(math.Max(0.5-d+expand, 0), 1)
+		})
+	})
+}
+
+// cellsIn calls f with every cell of grid that reaches into region r of
+// dst, where output pixel (x, y) lands on (x*k+ox, y*k+oy), and the part
+// of r its bounds cover, widened by a pixel for anti-aliasing
+func cellsIn(grid Grid, r image.Rectangle, k, ox, oy float64, f func(col, row int, cr image.Rectangle)) {
+	geo := grid.geometry()
+	oy0, oy1 := (float64(r.Min.Y)-oy)/k, (float64(r.Max.Y)-oy)/k
+	ox0, ox1 := (float64(r.Min.X)-ox)/k, (float64(r.Max.X)-ox)/k
+	row0 := max(int(math.Floor((oy0-geo.cy0-geo.h/2)/geo.py)), 0)
+	row1 := min(int(math.Ceil((oy1-geo.cy0+geo.h/2)/geo.py))+1, grid.Rows)
+	for row := row0; row < row1; row++ {
+		shift := geo.odd * float64(row%2)
+		col0 := max(int(math.Floor((ox0-geo.cx0-shift-geo.w/2)/geo.px)), 0)
+		col1 := min(int(math.Ceil((ox1-geo.cx0-shift+geo.w/2)/geo.px))+1, grid.Cols)
+		for col := col0; col < col1; col++ {
+			bx0, by0, bx1, by1 := grid.cellBounds(col, row)
+			cr := image.Rect(
+				int(math.Floor(bx0*k+ox-1)), int(math.Floor(by0*k+oy-1)),
+				int(math.Ceil(bx1*k+ox+1)), int(math.Ceil(by1*k+oy+1)),
+			).Intersect(r)
+			if !cr.Empty() {
+				f(col, row, cr)
+			}
+		}
+	}
+}
+
+// drawShapedCell blends img, scaled to a square of side pixels at (x0, y0)
+// on dst, over region r of dst with the given coverage of each pixel. The
+// tile is sampled bilinearly, with edge pixels repeated past its bounds.
+func drawShapedCell(dst *image.RGBA, r image.Rectangle, img *image.RGBA, x0, y0, side float64, coverage func(x, y int) float64) {
+	b := img.Bounds()
+	f := side / float64(b.Dx())
+
+	// Tiles at the size they are drawn at are placed on whole pixels and
+	// copied without filtering
+	exact := f == 1
+	if exact {
+		x0, y0 = math.Round(x0), math.Round(y0)
+	}
+
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			cov := coverage(x, y)
+			if cov <= 0 {
+				continue
+			}
+
+			var c [4]float64
+			if exact {
+				sx := min(max(x-int(x0), 0), b.Dx()-1)
+				sy := min(max(y-int(y0), 0), b.Dy()-1)
+				p := img.PixOffset(b.Min.X+sx, b.Min.Y+sy)
+				c = [4]float64{float64(img.Pix[p]), float64(img.Pix[p+1]), float64(img.Pix[p+2]), 255}
+			} else {
+				c = sampleBilinear(img, (float64(x)+0.5-x0)/f-0.5, (float64(y)+0.5-y0)/f-0.5)
+			}
+
+			i := dst.PixOffset(x, y)
+			for ch := 0; ch < 3; ch++ {
+				v := float64(dst.Pix[i+ch])
+				dst.Pix[i+ch] = uint8(math.Round(v + (c[ch]-v)*cov))
+			}
+		}
+	}
+}
+
+// sampleBilinear returns the colour of img at (u, v) in pixel units from
+// its top left pixel centre, clamped to its bounds
+func sampleBilinear(img *image.RGBA, u, v float64) [4]float64 {
+	b := img.Bounds()
+	u = math.Min(math.Max(u, 0), float64(b.Dx()-1))
+	v = math.Min(math.Max(v, 0), float64(b.Dy()-1))
+	x0, y0 := int(u), int(v)
+	x1, y1 := min(x0+1, b.Dx()-1), min(y0+1, b.Dy()-1)
+	fx, fy := u-float64(x0), v-float64(y0)
+
+	p00 := img.PixOffset(b.Min.X+x0, b.Min.Y+y0)
+	p10 := img.PixOffset(b.Min.X+x1, b.Min.Y+y0)
+	p01 := img.PixOffset(b.Min.X+x0, b.Min.Y+y1)
+	p11 := img.PixOffset(b.Min.X+x1, b.Min.Y+y1)
+	var c [4]float64
+	for ch := 0; ch < 3; ch++ {
+		top := float64(img.Pix[p00+ch])*(1-fx) + float64(img.Pix[p10+ch])*fx
+		bottom := float64(img.Pix[p01+ch])*(1-fx) + float64(img.Pix[p11+ch])*fx
+		c[ch] = top*(1-fy) + bottom*fy
+	}
+	c[3] = 255
+	return c
+}
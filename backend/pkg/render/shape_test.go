@@ -0,0 +1,162 @@
+package render
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"image/draw"
+	"math/rand"
+	"testing"
+)
+
+// tessellatingShapes cover the plane; circles leave gaps between them
+var tessellatingShapes = []string{ShapeSquare, ShapeHex, ShapeTriangle, ShapeDiamond, ShapeBrick}
+
+// solidScene lays out 200x150 of red tiles 20px wide in a shape, with
+// blue grout of the given width
+func solidScene(t *testing.T, shape string, grout float64) *Scene {
+	t.Helper()
+	size := Size{Width: 200, Height: 150}
+	grid := NewLayoutGrid(size, 200, 150, 20, ShapeSpec{Shape: shape, GroutWidth: grout, GroutColor: "#00f"})
+	red := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	draw.Draw(red, red.Rect, image.NewUniform(color.RGBA{R: 255, A: 255}), image.Point{}, draw.Src)
+	tiles, err := LoadTiles(context.Background(), 1, []int{grid.TileSide()}, 0,
+		func(int) (image.Image, error) { return red, nil },
+		func(i int, err error) { t.Fatalf("tile %d: %v", i, err) },
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Scene{
+		Guide: NewGuide(syntheticImage(200, 150, 0), size),
+		Plan:  NewRandomPlan(grid, 1, rand.New(rand.NewSource(1))),
+		Tiles: tiles,
+	}
+}
+
+func TestShapeSpecValidate(t *testing.T) {
+	for _, spec := range []ShapeSpec{{}, {Shape: ShapeHex, GroutWidth: 5, GroutColor: "#102030"}, {Shape: ShapeCircle, GroutColor: "#abc"}} {
+		if err := spec.Validate(20); err != nil {
+			t.Errorf("%+v rejected: %v", spec, err)
+		}
+	}
+	for _, spec := range []ShapeSpec{{Shape: "star"}, {GroutWidth: 5.1}, {GroutWidth: -1}, {GroutColor: "red"}, {GroutColor: "#12345"}, {GroutColor: "abcdef"}} {
+		if err := spec.Validate(20); err == nil {
+			t.Errorf("%+v accepted", spec)
+		}
+	}
+	if c, _ := (ShapeSpec{GroutColor: "#a1c"}).groutColor(); c != (color.RGBA{R: 0xaa, G: 0x11, B: 0xcc, A: 255}) {
+		t.Fatalf("#a1c is %v", c)
+	}
+}
+
+func TestShapesTessellate(t *testing.T) {
+	for _, shape := range tessellatingShapes {
+		t.Run(shape, func(t *testing.T) {
+			grid := NewLayoutGrid(Size{Width: 200, Height: 150}, 200, 150, 20, ShapeSpec{Shape: shape})
+			for y := 0.25; y < 150; y += 1.5 {
+				for x := 0.25; x < 200; x += 1.5 {
+					// Every point of the output is in the cell found for it,
+					// and in the inside of no other cell
+					col, row := grid.CellAt(x, y)
+					if d := grid.distance(col, row, x, y); d > 1e-9 {
+						t.Fatalf("(%v, %v) is %v outside cell %d, %d", x, y, d, col, row)
+					}
+					inside := 0
+					for r := 0; r < grid.Rows; r++ {
+						for c := 0; c < grid.Cols; c++ {
+							if grid.distance(c, r, x, y) < -1e-9 {
+								inside++
+							}
+						}
+					}
+					if inside > 1 {
+						t.Fatalf("(%v, %v) is inside %d cells", x, y, inside)
+					}
+
+					// and the bounds the tile is drawn over hold the point
+					if !(image.Point{X: int(x), Y: int(y)}).In(grid.CellRect(col, row)) {
+						t.Fatalf("(%v, %v) is outside the bounds %v of its cell", x, y, grid.CellRect(col, row))
+					}
+				}
+			}
+			if side := grid.TileSide(); side < grid.CellRect(1, 1).Dx() || side < grid.CellRect(1, 1).Dy() {
+				t.Fatalf("tiles of %dpx do not cover cells of %v", side, grid.CellRect(1, 1).Size())
+			}
+		})
+	}
+}
+
+func TestShapedCellsLeaveNoSeams(t *testing.T) {
+	for _, shape := range tessellatingShapes {
+		t.Run(shape, func(t *testing.T) {
+			// Without grout the blue shows nowhere, even on anti-aliased edges
+			scene := solidScene(t, shape, 0)
+			dst := image.NewRGBA(scene.Bounds())
+			scene.Draw(dst)
+			for i := 0; i < len(dst.Pix); i += 4 {
+				if dst.Pix[i] < 250 || dst.Pix[i+2] > 5 {
+					t.Fatalf("pixel %d, %d is %v", i/4%200, i/4/200, dst.Pix[i:i+4])
+				}
+			}
+		})
+	}
+}
+
+func TestShapedCellsWithGrout(t *testing.T) {
+	for _, shape := range append(tessellatingShapes, ShapeCircle) {
+		t.Run(shape, func(t *testing.T) {
+			scene := solidScene(t, shape, 4)
+			dst := image.NewRGBA(scene.Bounds())
+			scene.Draw(dst)
+			grid := scene.Plan.Grid
+
+			// The centre of a cell is tile, and the edge it shares with the
+			// next cell in its row is grout
+			cx, cy := grid.Center(3, 3)
+			nx, _ := grid.Center(4, 3)
+			if c := dst.RGBAAt(int(cx), int(cy)); c != (color.RGBA{R: 255, A: 255}) {
+				t.Fatalf("cell centre is %v", c)
+			}
+			if c := dst.RGBAAt(int((cx+nx)/2), int(cy)); c != (color.RGBA{B: 255, A: 255}) {
+				t.Fatalf("edge between cells is %v", c)
+			}
+
+			// Slanted and curved edges are anti-aliased
+			if shape == ShapeSquare || shape == ShapeBrick {
+				return
+			}
+			blended := 0
+			for i := 0; i < len(dst.Pix); i += 4 {
+				if dst.Pix[i] > 30 && dst.Pix[i] < 225 {
+					blended++
+				}
+			}
+			if blended == 0 {
+				t.Fatal("no pixel blends tile and grout")
+			}
+		})
+	}
+}
+
+// Shaped grids draw the same pixels however the output is split into bands
+func TestShapedCellsDrawTheSameInBands(t *testing.T) {
+	for _, shape := range []string{ShapeHex, ShapeTriangle, ShapeCircle} {
+		t.Run(shape, func(t *testing.T) {
+			scene := testScene(t, 300, 6, 32, 15, ShapeSpec{Shape: shape, GroutWidth: 2}, 7)
+			whole := image.NewRGBA(scene.Bounds())
+			scene.Draw(whole)
+
+			banded := image.NewRGBA(scene.Bounds())
+			for y := 0; y < banded.Rect.Dy(); y += 13 {
+				scene.Draw(banded.SubImage(image.Rect(0, y, banded.Rect.Dx(), y+13)).(*image.RGBA))
+			}
+			for i := range whole.Pix {
+				if whole.Pix[i] != banded.Pix[i] {
+					p := i / 4
+					t.Fatalf("pixel %d, %d differs", p%whole.Rect.Dx(), p/whole.Rect.Dx())
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package render
+
+import (
+	"context"
+	"image"
+	"image/color"
+)
+
+// bandBytes is the memory budget of one band of a streamed render
+const bandBytes = 32 << 20
+
+// Layer is anything that can draw any part of itself on request, such as
+// a Scene
+type Layer interface {
+	Bounds() image.Rectangle
+	Draw(dst *image.RGBA)
+	Opaque() bool
+}
+
+// Stream renders a layer one horizontal band at a time and hands encode an
+// image that renders bands as the encoder reads them. Encoders read rows
+// top to bottom, so only one band of the output is held however large it
+// is. What the layer draws from, such as the tiles of a scene, is held in
+// addition; see TileSetBytes.
+// Every rendered row is also fed to the previews, which end up holding
+// smaller copies of the same render. Rendering stops at the first band
+// after ctx is cancelled.
+func Stream(ctx context.Context, layer Layer, encode func(image.Image) error, previews ...*Downsampler) error {
+	img := &bandImage{
+		ctx:        ctx,
+		layer:      layer,
+		bounds:     layer.Bounds(),
+		bandHeight: BandHeight(layer.Bounds().Dx()),
+		previews:   previews,
+	}
+	if err := encode(img); err != nil {
+		return err
+	}
+	return img.err
+}
+
+// BandHeight returns the number of rows rendered at a time for an output of
+// the given width. It is a multiple of 16 so JPEG blocks never span bands.
+func BandHeight(width int) int {
+	rows := bandBytes / (4 * max(width, 1))
+	rows -= rows % 16
+	return min(max(rows, 16), 1024)
+}
+
+// bandImage is an image.Image backed by the most recently rendered band
+type bandImage struct {
+	ctx        context.Context
+	layer      Layer
+	bounds     image.Rectangle
+	bandHeight int
+	band       *image.RGBA
+	previews   []*Downsampler
+	err        error
+}
+
+func (b *bandImage) ColorModel() color.Model {
+	return color.RGBAModel
+}
+
+func (b *bandImage) Bounds() image.Rectangle {
+	return b.bounds
+}
+
+func (b *bandImage) At(x, y int) color.Color {
+	return b.RGBAAt(x, y)
+}
+
+// Opaque lets encoders skip scanning the image for transparency, which
+// would render every band twice
+func (b *bandImage) Opaque() bool {
+	return b.layer.Opaque()
+}
+
+// RGBARow returns the pixels of row y, rendering its band first if needed.
+// The slice is only valid until the next band is rendered.
+func (b *bandImage) RGBARow(y int) []uint8 {
+	if b.band == nil || y < b.band.Rect.Min.Y || y >= b.band.Rect.Max.Y {
+		b.renderBand(y)
+	}
+	offset := b.band.PixOffset(b.bounds.Min.X, y)
+	return b.band.Pix[offset : offset+b.bounds.Dx()*4]
+}
+
+// RGBAAt returns the pixel at (x, y), rendering its band first if needed
+func (b *bandImage) RGBAAt(x, y int) color.RGBA {
+	if !(image.Point{X: x, Y: y}).In(b.bounds) {
+		return color.RGBA{}
+	}
+	if b.band == nil || !(image.Point{X: x, Y: y}).In(b.band.Rect) {
+		b.renderBand(y)
+	}
+	return b.band.RGBAAt(x, y)
+}
+
+// renderBand renders the band containing row y, reusing the band buffer
+func (b *bandImage) renderBand(y int) {
+	top := y - y%b.bandHeight
+	rect := image.Rect(b.bounds.Min.X, top, b.bounds.Max.X, min(top+b.bandHeight, b.bounds.Max.Y))
+
+	if b.band == nil {
+		b.band = image.NewRGBA(image.Rect(0, 0, b.bounds.Dx(), b.bandHeight))
+	}
+	b.band.Rect = rect
+	clear(b.band.Pix)
+
+	// After cancellation the remaining bands are left blank so the encoder
+	// finishes quickly; the caller discards the output
+	if b.err == nil {
+		b.err = b.ctx.Err()
+	}
+	if b.err != nil {
+		return
+	}
+	b.layer.Draw(b.band)
+
+	// Previews take each row once, in order
+	for _, preview := range b.previews {
+		for y := max(preview.Next(), rect.Min.Y); y < rect.Max.Y && y == preview.Next(); y++ {
+			offset := b.band.PixOffset(rect.Min.X, y)
+			preview.AddRow(b.band.Pix[offset : offset+rect.Dx()*4])
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package render
+
+import (
+	"encoding/binary"
+	"errors"
+	"image"
+	"io"
+	"sort"
+)
+
+// TIFF tags written by EncodeTIFF16
+const (
+	tiffImageWidth      = 256
+	tiffImageLength     = 257
+	tiffBitsPerSample   = 258
+	tiffCompression     = 259
+	tiffPhotometric     = 262
+	tiffStripOffsets    = 273
+	tiffSamplesPerPixel = 277
+	tiffRowsPerStrip    = 278
+	tiffStripByteCounts = 279
+	tiffXResolution     = 282
+	tiffYResolution     = 283
+	tiffPlanarConfig    = 284
+	tiffResolutionUnit  = 296
+)
+
+// TIFF field types
+const (
+	tiffShort    = 3
+	tiffLong     = 4
+	tiffRational = 5
+)
+
+// tiffStripBytes is the target size of one strip of pixel data
+const tiffStripBytes = 1 << 20
+
+// tiffField is one entry of a TIFF image file directory
+type tiffField struct {
+	tag    uint16
+	typ    uint16
+	values []uint32 // rationals take two values each
+}
+
+// size returns the number of bytes the field's values take
+func (f tiffField) size() int {
+	switch f.typ {
+	case tiffShort:
+		return 2 * len(f.values)
+	default:
+		return 4 * len(f.values)
+	}
+}
+
+// EncodeTIFF16 writes img as an uncompressed RGB TIFF with 16 bits per
+// sample and the given resolution, the format print shops ask for. Rows
+// are read once from top to bottom, so streamed images are rendered once.
+// The renderer works in 8 bits, so samples are widened rather than
+// carrying extra precision. Alpha is dropped.
+func EncodeTIFF16(w io.Writer, img image.Image, dpi int) error {
+	if dpi <= 0 {
+		dpi = DefaultDPI
+	}
+	b := img.Bounds()
+	width, height := b.Dx(), b.Dy()
+	rowBytes := width * 6
+	if uint64(rowBytes)*uint64(height) > 0xFFFFFFFF-(1<<20) {
+		return errors.New("image is too large for a TIFF file")
+	}
+
+	rowsPerStrip := max(tiffStripBytes/max(rowBytes, 1), 1)
+	strips := (height + rowsPerStrip - 1) / rowsPerStrip
+	offsets := make([]uint32, strips)
+	counts := make([]uint32, strips)
+
+	fields := []tiffField{
+		{tiffImageWidth, tiffLong, []uint32{uint32(width)}},
+		{tiffImageLength, tiffLong, []uint32{uint32(height)}},
+		{tiffBitsPerSample, tiffShort, []uint32{16, 16, 16}},
+		{tiffCompression, tiffShort, []uint32{1}},
+		{tiffPhotometric, tiffShort, []uint32{2}}, // RGB
+		{tiffStripOffsets, tiffLong, offsets},
+		{tiffSamplesPerPixel, tiffShort, []uint32{3}},
+		{tiffRowsPerStrip, tiffLong, []uint32{uint32(rowsPerStrip)}},
+		{tiffStripByteCounts, tiffLong, counts},
+		{tiffXResolution, tiffRational, []uint32{uint32(dpi), 1}},
+		{tiffYResolution, tiffRational, []uint32{uint32(dpi), 1}},
+		{tiffPlanarConfig, tiffShort, []uint32{1}},   // interleaved
+		{tiffResolutionUnit, tiffShort, []uint32{2}}, // inches
+	}
+	sort.Slice(fields, func(i, j int) bool { return fields[i].tag < fields[j].tag })
+
+	// The directory comes right after the header and the values that do
+	// not fit in it follow, so the pixel data can be streamed last
+	ifdSize := 2 + 12*len(fields) + 4
+	dataStart := 8 + ifdSize
+	for _, f := range fields {
+		if f.size() > 4 {
+			dataStart += f.size()
+		}
+	}
+	for i := range offsets {
+		rows := min(rowsPerStrip, height-i*rowsPerStrip)
+		offsets[i] = uint32(dataStart + i*rowsPerStrip*rowBytes)
+		counts[i] = uint32(rows * rowBytes)
+	}
+
+	header := make([]byte, 0, dataStart)
+	header = append(header, 'I', 'I')
+	header = binary.LittleEndian.AppendUint16(header, 42)
+	header = binary.LittleEndian.AppendUint32(header, 8)
+	header = binary.LittleEndian.AppendUint16(header, uint16(len(fields)))
+
+	var extra []byte
+	extraOffset := 8 + ifdSize
+	for _, f := range fields {
+		header = binary.LittleEndian.AppendUint16(header, f.tag)
+		header = binary.LittleEndian.AppendUint16(header, f.typ)
+		count := len(f.values)
+		if f.typ == tiffRational {
+			count /= 2
+		}
+		header = binary.LittleEndian.AppendUint32(header, uint32(count))
+
+		value := appendTIFFValues(nil, f)
+		if len(value) <= 4 {
+			header = append(header, value...)
+			header = append(header, make([]byte, 4-len(value))...)
+			continue
+		}
+		header = binary.LittleEndian.AppendUint32(header, uint32(extraOffset+len(extra)))
+		extra = append(extra, value...)
+	}
+	header = binary.LittleEndian.AppendUint32(header, 0) // no further directories
+	header = append(header, extra...)
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+
+	rgba := make([]uint8, 0, width*4)
+	out := make([]byte, rowBytes)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		row := rgbaRow(img, y, rgba)
+		for x := 0; x < width; x++ {
+			for c := 0; c < 3; c++ {
+				v := uint16(row[x*4+c]) * 257
+				out[x*6+c*2] = byte(v)
+				out[x*6+c*2+1] = byte(v >> 8)
+			}
+		}
+		if _, err := w.Write(out); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// appendTIFFValues appends the little-endian encoding of a field's values
+func appendTIFFValues(buf []byte, f tiffField) []byte {
+	for _, v := range f.values {
+		if f.typ == tiffShort {
+			buf = binary.LittleEndian.AppendUint16(buf, uint16(v))
+		} else {
+			buf = binary.LittleEndian.AppendUint32(buf, v)
+		}
+	}
+	return buf
+}
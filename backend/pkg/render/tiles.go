@@ -0,0 +1,123 @@
+package render
+
+import (
+	"context"
+	"image"
+	"image/draw"
+	"runtime"
+	"sync"
+
+	xdraw "golang.org/x/image/draw"
+)
+
+// TileSet holds every tile photo pre-scaled to each cell size it is drawn
+// at. Each tile is rescaled once per size when it is loaded, and the full
+// size photo is dropped afterwards.
+type TileSet struct {
+	sizes  []int
+	scaled map[int][]*image.RGBA
+}
+
+// LoadTiles loads n tiles with load and scales each one to every size in
+// sizes, using up to workers goroutines (GOMAXPROCS if zero). Tiles that
+// fail to load are reported to skip, which may be called from several
+// goroutines at once, and are left out of the set.
+func LoadTiles(ctx context.Context, n int, sizes []int, workers int, load func(i int) (image.Image, error), skip func(i int, err error)) (*TileSet, error) {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	sizes = uniqueSizes(sizes)
+
+	results := make([][]*image.RGBA, n)
+	indexes := make(chan int, n)
+	for i := 0; i < n; i++ {
+		indexes <- i
+	}
+	close(indexes)
+
+	var wg sync.WaitGroup
+	for w := 0; w < min(workers, n); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				if ctx.Err() != nil {
+					return
+				}
+				img, err := load(i)
+				if err != nil {
+					skip(i, err)
+					continue
+				}
+				scaled := make([]*image.RGBA, len(sizes))
+				for j, size := range sizes {
+					scaled[j] = ScaleTile(img, size)
+				}
+				results[i] = scaled
+			}
+		}()
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	// Keep the loaded tiles in their original order so seeded choices
+	// do not depend on which worker finished first
+	set := &TileSet{sizes: sizes, scaled: make(map[int][]*image.RGBA, len(sizes))}
+	for _, scaled := range results {
+		if scaled == nil {
+			continue
+		}
+		for j, size := range sizes {
+			set.scaled[size] = append(set.scaled[size], scaled[j])
+		}
+	}
+	return set, nil
+}
+
+// TileSetBytes returns the memory a set of n tiles scaled to side pixels
+// holds. The cell side grows with the output size, so a large output with
+// many tiles can need more memory than the output itself is streamed in.
+func TileSetBytes(n, side int) int64 {
+	return int64(n) * int64(side) * int64(side) * 4
+}
+
+// Len returns the number of tiles in the set
+func (t *TileSet) Len() int {
+	if len(t.sizes) == 0 {
+		return 0
+	}
+	return len(t.scaled[t.sizes[0]])
+}
+
+// Get returns tile i scaled to size. The size must be one the set was
+// loaded with.
+func (t *TileSet) Get(i int, size int) *image.RGBA {
+	return t.scaled[size][i]
+}
+
+// ScaleTile scales a tile photo to a square of size pixels
+func ScaleTile(img image.Image, size int) *image.RGBA {
+	return ScaleImage(img, size, size)
+}
+
+// ScaleImage scales an image to width x height pixels
+func ScaleImage(img image.Image, width, height int) *image.RGBA {
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	xdraw.CatmullRom.Scale(dst, dst.Bounds(), img, img.Bounds(), draw.Src, nil)
+	return dst
+}
+
+// uniqueSizes returns sizes without duplicates, in their original order
+func uniqueSizes(sizes []int) []int {
+	unique := make([]int, 0, len(sizes))
+	seen := make(map[int]bool, len(sizes))
+	for _, size := range sizes {
+		if !seen[size] {
+			seen[size] = true
+			unique = append(unique, size)
+		}
+	}
+	return unique
+}
@@ -0,0 +1,28 @@
+package render
+
+import (
+	"context"
+	"image"
+	"testing"
+)
+
+// BenchmarkLoadTiles scales 100 tile photos of 400 pixels to the 50 pixel
+// cells of BenchmarkSceneDraw with one worker and with GOMAXPROCS workers
+func BenchmarkLoadTiles(b *testing.B) {
+	photos := make([]image.Image, 100)
+	for i := range photos {
+		photos[i] = syntheticImage(400, 400, i+1)
+	}
+	load := func(i int) (image.Image, error) { return photos[i], nil }
+	skip := func(i int, err error) { b.Fatalf("tile %d: %v", i, err) }
+
+	for _, bc := range benchWorkers {
+		b.Run(bc.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := LoadTiles(context.Background(), len(photos), []int{50}, bc.workers, load, skip); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
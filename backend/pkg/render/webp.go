@@ -0,0 +1,419 @@
+package render
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"image"
+	"io"
+	"sort"
+)
+
+// MaxWebPSide is the largest width or height a WebP image can have
+const MaxWebPSide = 16384
+
+// VP8L stream constants
+const (
+	vp8lSignature      = 0x2f
+	vp8lPredictor      = 0 // transform types
+	vp8lSubtractGreen  = 2
+	vp8lPredictorBits  = 9  // log2 of the predictor block size, the largest allowed
+	vp8lPredictorMode  = 12 // ClampAddSubtractFull of the left, top and top-left pixels
+	vp8lGreenAlphabet  = 256 + 24
+	vp8lMaxCodeLength  = 15
+	vp8lMaxLengthCode  = 7
+	vp8lCodeLengthSyms = 19
+)
+
+// vp8lCodeLengthOrder is the order code length code lengths are stored in
+var vp8lCodeLengthOrder = [vp8lCodeLengthSyms]int{17, 18, 0, 1, 2, 3, 4, 5, 16, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+// EncodeWebP writes img as a lossless WebP, with an alpha channel unless
+// img reports that it is opaque. The prefix codes are built from sample,
+// usually a small copy of img, so that img is read only once from top to
+// bottom and streamed images are rendered once. Every value is given a
+// code, so any sample works, but one that looks like img compresses best;
+// a nil sample reads img twice.
+func EncodeWebP(w io.WriteSeeker, img image.Image, sample image.Image) error {
+	b := img.Bounds()
+	if b.Dx() < 1 || b.Dy() < 1 || b.Dx() > MaxWebPSide || b.Dy() > MaxWebPSide {
+		return errors.New("WebP images are limited to 16384 pixels per side")
+	}
+	if sample == nil {
+		sample = img
+	}
+	alpha := false
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		alpha = !o.Opaque()
+	}
+
+	// Count the residuals of the sample to size the codes
+	var green, red, blue, alphas [256]int
+	forEachResidual(sample, func(r, g, bl, a uint8) {
+		green[g]++
+		red[r]++
+		blue[bl]++
+		alphas[a]++
+	})
+	greenCode := newPrefixCode(append(smooth(green[:]), make([]int, vp8lGreenAlphabet-256)...), vp8lMaxCodeLength)
+	redCode := newPrefixCode(smooth(red[:]), vp8lMaxCodeLength)
+	blueCode := newPrefixCode(smooth(blue[:]), vp8lMaxCodeLength)
+	var alphaCode *prefixCode
+	if alpha {
+		alphaCode = newPrefixCode(smooth(alphas[:]), vp8lMaxCodeLength)
+	}
+
+	start, err := w.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	buffered := bufio.NewWriterSize(w, 1<<16)
+	header := make([]byte, 20)
+	copy(header[0:], "RIFF")
+	copy(header[8:], "WEBPVP8L")
+	if _, err := buffered.Write(header); err != nil {
+		return err
+	}
+
+	bw := &bitWriter{w: buffered}
+	bw.writeBits(vp8lSignature, 8)
+	bw.writeBits(uint32(b.Dx()-1), 14)
+	bw.writeBits(uint32(b.Dy()-1), 14)
+	if alpha {
+		bw.writeBits(1, 1)
+	} else {
+		bw.writeBits(0, 1)
+	}
+	bw.writeBits(0, 3) // version
+
+	// Subtract green, then predict every pixel from its neighbours with a
+	// single mode, written as a sub-image of one block value
+	bw.writeBits(1, 1)
+	bw.writeBits(vp8lSubtractGreen, 2)
+	bw.writeBits(1, 1)
+	bw.writeBits(vp8lPredictor, 2)
+	bw.writeBits(vp8lPredictorBits-2, 3)
+	bw.writeBits(0, 1) // no color cache
+	writeSimpleCode(bw, vp8lPredictorMode)
+	for i := 0; i < 4; i++ {
+		writeSimpleCode(bw, 0)
+	}
+	bw.writeBits(0, 1) // no more transforms
+
+	// The pixels, with one set of codes for the whole image
+	bw.writeBits(0, 1) // no color cache
+	bw.writeBits(0, 1) // no meta prefix codes
+	greenCode.writeTo(bw)
+	redCode.writeTo(bw)
+	blueCode.writeTo(bw)
+	if alpha {
+		alphaCode.writeTo(bw)
+	} else {
+		writeSimpleCode(bw, 0) // alpha residuals are always zero
+	}
+	writeSimpleCode(bw, 0) // no backward references
+	forEachResidual(img, func(r, g, bl, a uint8) {
+		greenCode.write(bw, int(g))
+		redCode.write(bw, int(r))
+		blueCode.write(bw, int(bl))
+		if alpha {
+			alphaCode.write(bw, int(a))
+		}
+	})
+
+	dataSize, err := bw.close()
+	if err != nil {
+		return err
+	}
+	if dataSize%2 == 1 {
+		if err := buffered.WriteByte(0); err != nil {
+			return err
+		}
+	}
+	if err := buffered.Flush(); err != nil {
+		return err
+	}
+
+	// Fill in the chunk sizes now that they are known
+	end, err := w.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	sizes := []struct {
+		offset int64
+		value  uint32
+	}{
+		{4, uint32(end - start - 8)},
+		{16, uint32(dataSize)},
+	}
+	for _, size := range sizes {
+		if _, err := w.Seek(start+size.offset, io.SeekStart); err != nil {
+			return err
+		}
+		if err := binary.Write(w, binary.LittleEndian, size.value); err != nil {
+			return err
+		}
+	}
+	_, err = w.Seek(end, io.SeekStart)
+	return err
+}
+
+// forEachResidual calls f with the red, green, blue and alpha residuals of
+// every pixel of img after the subtract green and predictor transforms,
+// from top to bottom. WebP stores colours without premultiplied alpha.
+func forEachResidual(img image.Image, f func(r, g, b, a uint8)) {
+	bounds := img.Bounds()
+	width := bounds.Dx()
+	prev := make([]uint8, width*4)
+	cur := make([]uint8, width*4)
+	buf := make([]uint8, 0, width*4)
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		row := rgbaRow(img, y, buf)
+		for x := 0; x < width; x++ {
+			i := x * 4
+			r, g, b, a := row[i], row[i+1], row[i+2], row[i+3]
+			if a < 255 {
+				r, g, b = unpremultiply(r, a), unpremultiply(g, a), unpremultiply(b, a)
+			}
+			cur[i], cur[i+1], cur[i+2], cur[i+3] = r-g, g, b-g, a
+
+			var p [4]uint8
+			switch {
+			case y == bounds.Min.Y && x == 0:
+				// Predicted as opaque black
+				p[3] = 255
+			case y == bounds.Min.Y:
+				copy(p[:], cur[i-4:i])
+			case x == 0:
+				copy(p[:], prev[i:i+4])
+			default:
+				for c := 0; c < 4; c++ {
+					p[c] = clampAddSubtract(cur[i-4+c], prev[i+c], prev[i-4+c])
+				}
+			}
+			f(cur[i]-p[0], cur[i+1]-p[1], cur[i+2]-p[2], cur[i+3]-p[3])
+		}
+		prev, cur = cur, prev
+	}
+}
+
+// unpremultiply returns a colour channel premultiplied by alpha a to its
+// full value; fully transparent pixels are black
+func unpremultiply(v, a uint8) uint8 {
+	if a == 0 {
+		return 0
+	}
+	return uint8(min((int(v)*255+int(a)/2)/int(a), 255))
+}
+
+// clampAddSubtract returns l + t - tl clamped to a byte
+func clampAddSubtract(l, t, tl uint8) uint8 {
+	return uint8(min(max(int(l)+int(t)-int(tl), 0), 255))
+}
+
+// smooth gives every value at least one count, so that values missing
+// from a sample still get a code
+func smooth(counts []int) []int {
+	smoothed := make([]int, len(counts))
+	for i, c := range counts {
+		smoothed[i] = c + 1
+	}
+	return smoothed
+}
+
+// prefixCode is a canonical prefix code, stored with each code's bits
+// reversed as VP8L writes codes from their most significant bit
+type prefixCode struct {
+	lengths []uint8
+	codes   []uint16
+	single  bool // only one symbol, which readers decode without any bits
+}
+
+// newPrefixCode builds a prefix code for the given counts with no code
+// longer than maxLength bits
+func newPrefixCode(counts []int, maxLength int) *prefixCode {
+	lengths := codeLengths(counts, maxLength)
+	code := &prefixCode{lengths: lengths, codes: make([]uint16, len(lengths))}
+
+	// A code with a single symbol takes no bits
+	used := 0
+	for _, l := range lengths {
+		if l > 0 {
+			used++
+		}
+	}
+	if used < 2 {
+		code.single = true
+		return code
+	}
+
+	var lengthCount [vp8lMaxCodeLength + 1]int
+	for _, l := range lengths {
+		lengthCount[l]++
+	}
+	lengthCount[0] = 0
+	var next [vp8lMaxCodeLength + 1]int
+	value := 0
+	for l := 1; l <= vp8lMaxCodeLength; l++ {
+		value = (value + lengthCount[l-1]) << 1
+		next[l] = value
+	}
+	for symbol, l := range lengths {
+		if l == 0 {
+			continue
+		}
+		c := next[l]
+		next[l]++
+		reversed := 0
+		for i := 0; i < int(l); i++ {
+			reversed = reversed<<1 | (c>>i)&1
+		}
+		code.codes[symbol] = uint16(reversed)
+	}
+	return code
+}
+
+// write writes the code of symbol
+func (c *prefixCode) write(bw *bitWriter, symbol int) {
+	if c.single {
+		return
+	}
+	bw.writeBits(uint32(c.codes[symbol]), uint(c.lengths[symbol]))
+}
+
+// writeTo writes the code lengths, themselves prefix coded
+func (c *prefixCode) writeTo(bw *bitWriter) {
+	var counts [vp8lCodeLengthSyms]int
+	for _, l := range c.lengths {
+		counts[l]++
+	}
+	lengthCode := newPrefixCode(counts[:], vp8lMaxLengthCode)
+
+	stored := vp8lCodeLengthSyms
+	for stored > 4 && lengthCode.lengths[vp8lCodeLengthOrder[stored-1]] == 0 {
+		stored--
+	}
+
+	bw.writeBits(0, 1) // normal code
+	bw.writeBits(uint32(stored-4), 4)
+	for _, symbol := range vp8lCodeLengthOrder[:stored] {
+		bw.writeBits(uint32(lengthCode.lengths[symbol]), 3)
+	}
+	bw.writeBits(0, 1) // lengths for the whole alphabet
+	for _, l := range c.lengths {
+		lengthCode.write(bw, int(l))
+	}
+}
+
+// writeSimpleCode writes a code with a single symbol, which takes no bits
+func writeSimpleCode(bw *bitWriter, symbol uint32) {
+	bw.writeBits(1, 1) // simple code
+	bw.writeBits(0, 1) // one symbol
+	if symbol < 2 {
+		bw.writeBits(0, 1)
+		bw.writeBits(symbol, 1)
+		return
+	}
+	bw.writeBits(1, 1)
+	bw.writeBits(symbol, 8)
+}
+
+// codeLengths returns Huffman code lengths for counts, flattening the
+// counts until no code is longer than maxLength. Symbols with no count get
+// no code.
+func codeLengths(counts []int, maxLength int) []uint8 {
+	lengths := make([]uint8, len(counts))
+	var symbols []int
+	for symbol, c := range counts {
+		if c > 0 {
+			symbols = append(symbols, symbol)
+		}
+	}
+	switch len(symbols) {
+	case 0:
+		return lengths
+	case 1:
+		lengths[symbols[0]] = 1
+		return lengths
+	}
+
+	weights := make([]int, len(counts))
+	copy(weights, counts)
+	for {
+		sort.SliceStable(symbols, func(i, j int) bool { return weights[symbols[i]] < weights[symbols[j]] })
+
+		// Merge the two lightest nodes until one is left. Leaves and merged
+		// nodes are both taken in order of weight from two queues.
+		n := len(symbols)
+		nodeWeight := make([]int, 2*n-1)
+		parent := make([]int, 2*n-1)
+		for i, symbol := range symbols {
+			nodeWeight[i] = weights[symbol]
+		}
+		leaf, merged, next := 0, n, n
+		lightest := func() int {
+			if leaf < n && (merged >= next || nodeWeight[leaf] <= nodeWeight[merged]) {
+				leaf++
+				return leaf - 1
+			}
+			merged++
+			return merged - 1
+		}
+		for ; next < 2*n-1; next++ {
+			a, b := lightest(), lightest()
+			nodeWeight[next] = nodeWeight[a] + nodeWeight[b]
+			parent[a], parent[b] = next, next
+		}
+
+		depth := make([]int, 2*n-1)
+		longest := 0
+		for i := 2*n - 3; i >= 0; i-- {
+			depth[i] = depth[parent[i]] + 1
+			if i < n {
+				longest = max(longest, depth[i])
+			}
+		}
+		if longest <= maxLength {
+			for i, symbol := range symbols {
+				lengths[symbol] = uint8(depth[i])
+			}
+			return lengths
+		}
+
+		for _, symbol := range symbols {
+			weights[symbol] = max(weights[symbol]/2, 1)
+		}
+	}
+}
+
+// bitWriter writes values least significant bit first
+type bitWriter struct {
+	w       *bufio.Writer
+	acc     uint64
+	n       uint
+	written int
+	err     error
+}
+
+// writeBits writes the n low bits of v
+func (bw *bitWriter) writeBits(v uint32, n uint) {
+	bw.acc |= uint64(v) << bw.n
+	bw.n += n
+	for bw.n >= 8 {
+		if bw.err == nil {
+			bw.err = bw.w.WriteByte(byte(bw.acc))
+		}
+		bw.acc >>= 8
+		bw.n -= 8
+		bw.written++
+	}
+}
+
+// close writes the last partial byte and returns the number of bytes written
+func (bw *bitWriter) close() (int, error) {
+	if bw.n > 0 {
+		bw.writeBits(0, 8-bw.n)
+	}
+	return bw.written, bw.err
+}
@@ -4,15 +4,13 @@ import (
 	"github.com/amityadav9314/goinkgrid/controllers"
 	"github.com/amityadav9314/goinkgrid/internal/api/middleware"
 	"github.com/amityadav9314/goinkgrid/internal/app"
+	"github.com/amityadav9314/goinkgrid/internal/services"
 	"github.com/gin-gonic/gin"
 )
 
 func InitRoutes(mainRouter *gin.Engine, environment string, serviceProvider *app.ServiceProvider) {
-	// Serve static files from uploads directory
-	mainRouter.Static("/uploads", "./uploads")
-
 	// Initialize middleware
-	authMiddleware := middleware.NewAuthMiddleware(serviceProvider.JWTSecret())
+	authMiddleware := middleware.NewAuthMiddleware(serviceProvider.JWTSecret(), serviceProvider.UserService(), serviceProvider.APIKeyService())
 
 	// Base API group
 	api := mainRouter.Group("/goinkgrid")
@@ -24,12 +22,26 @@ func InitRoutes(mainRouter *gin.Engine, environment string, serviceProvider *app
 		controllers.HandleWebSocketV2(c, serviceProvider.Pool())
 	})
 
+	// Uploaded and generated files, authorized by signed, expiring URLs
+	api.GET("/files/*filepath", serviceProvider.FileHandler().ServeFile)
+
+	// Public share links for completed mosaics
+	api.GET("/s/:token", serviceProvider.ShareHandler().SharePage)
+	api.POST("/s/:token", serviceProvider.ShareHandler().SharePage)
+	api.GET("/share/:token", serviceProvider.ShareHandler().GetSharedMosaic)
+	api.GET("/share/:token/image/:size", serviceProvider.ShareHandler().GetSharedImage)
+	api.GET("/share/:token/deepzoom.dzi", serviceProvider.ShareHandler().GetSharedDeepZoom)
+	api.GET("/share/:token/deepzoom_files/*tile", serviceProvider.ShareHandler().GetSharedDeepZoom)
+	api.GET("/oembed", serviceProvider.ShareHandler().OEmbed)
+
 	// Auth routes
 	auth := api.Group("/auth")
 	{
 		auth.POST("/register", serviceProvider.AuthHandler().Register)
 		auth.POST("/login", serviceProvider.AuthHandler().Login)
 		auth.POST("/refresh", serviceProvider.AuthHandler().RefreshToken)
+		auth.GET("/oidc/login", serviceProvider.AuthHandler().OIDCLogin)
+		auth.GET("/oidc/callback", serviceProvider.AuthHandler().OIDCCallback)
 	}
 
 	// API routes that require authentication
@@ -40,35 +52,111 @@ func InitRoutes(mainRouter *gin.Engine, environment string, serviceProvider *app
 		{
 			// Protected routes
 			imagesAuth := images.Group("/")
-			imagesAuth.Use(authMiddleware.RequireAuth())
+			imagesAuth.Use(authMiddleware.RequireAuth(), authMiddleware.RequireScope(services.ScopeImagesRead, services.ScopeImagesWrite))
 			{
 				imagesAuth.POST("/main", serviceProvider.ImageHandler().UploadMainImage)
 				imagesAuth.POST("/tiles", serviceProvider.ImageHandler().UploadTileImages)
 				imagesAuth.GET("/tiles", serviceProvider.ImageHandler().GetTileCollections)
+				imagesAuth.DELETE("/:id", serviceProvider.ImageHandler().DeleteImage)
 			}
 		}
 
+		// Profile routes for the authenticated user
+		me := apiV1.Group("/me")
+		me.Use(authMiddleware.RequireAuth(), authMiddleware.RequireSession())
+		{
+			me.GET("", serviceProvider.ProfileHandler().GetProfile)
+			me.PUT("", serviceProvider.ProfileHandler().UpdateProfile)
+			me.POST("/avatar", serviceProvider.ProfileHandler().UploadAvatar)
+			me.PUT("/password", serviceProvider.ProfileHandler().ChangePassword)
+		}
+
+		// Personal API key management (interactive sessions only)
+		keys := apiV1.Group("/keys")
+		keys.Use(authMiddleware.RequireAuth(), authMiddleware.RequireSession())
+		{
+			keys.GET("", serviceProvider.APIKeyHandler().ListAPIKeys)
+			keys.POST("", serviceProvider.APIKeyHandler().CreateAPIKey)
+			keys.DELETE("/:id", serviceProvider.APIKeyHandler().RevokeAPIKey)
+		}
+
 		// Project routes (all require auth)
 		projects := apiV1.Group("/projects")
-		projects.Use(authMiddleware.RequireAuth())
+		projects.Use(authMiddleware.RequireAuth(), authMiddleware.RequireScope(services.ScopeProjectsRead, services.ScopeProjectsWrite))
 		{
 			projects.GET("/", serviceProvider.ProjectHandler().ListProjects)
 			projects.POST("/", serviceProvider.ProjectHandler().CreateProject)
+			projects.GET("/templates", serviceProvider.ProjectHandler().ListTemplates)
+			projects.POST("/import", serviceProvider.ArchiveHandler().ImportProject)
 			projects.GET("/:id", serviceProvider.ProjectHandler().GetProject)
 			projects.PUT("/:id", serviceProvider.ProjectHandler().UpdateProject)
 			projects.DELETE("/:id", serviceProvider.ProjectHandler().DeleteProject)
+			projects.POST("/:id/duplicate", serviceProvider.ProjectHandler().DuplicateProject)
+			projects.PUT("/:id/template", serviceProvider.ProjectHandler().SetProjectTemplate)
+			projects.GET("/:id/export", serviceProvider.ArchiveHandler().ExportProject)
 			projects.GET("/:id/images", serviceProvider.ImageHandler().GetProjectImages)
 			projects.GET("/:id/mosaics", serviceProvider.MosaicHandler().GetProjectMosaics)
+			projects.GET("/:id/members", serviceProvider.ProjectMemberHandler().ListMembers)
+			projects.POST("/:id/members", serviceProvider.ProjectMemberHandler().InviteMember)
+			projects.PUT("/:id/members/:memberId", serviceProvider.ProjectMemberHandler().UpdateMember)
+			projects.DELETE("/:id/members/:memberId", serviceProvider.ProjectMemberHandler().RemoveMember)
+		}
+
+		// Deleted projects and images, kept until the retention period ends.
+		// Each kind needs the scopes of its live counterpart, and the
+		// listing, which shows both, needs both.
+		projectScope := authMiddleware.RequireScope(services.ScopeProjectsRead, services.ScopeProjectsWrite)
+		imageScope := authMiddleware.RequireScope(services.ScopeImagesRead, services.ScopeImagesWrite)
+		trash := apiV1.Group("/trash")
+		trash.Use(authMiddleware.RequireAuth())
+		{
+			trash.GET("", projectScope, imageScope, serviceProvider.TrashHandler().ListTrash)
+			trash.POST("/projects/:id/restore", projectScope, serviceProvider.TrashHandler().RestoreProject)
+			trash.DELETE("/projects/:id", projectScope, serviceProvider.TrashHandler().PurgeProject)
+			trash.POST("/images/:id/restore", imageScope, serviceProvider.TrashHandler().RestoreImage)
+			trash.DELETE("/images/:id", imageScope, serviceProvider.TrashHandler().PurgeImage)
+		}
+
+		// Project invites for the authenticated user (interactive sessions only)
+		invites := apiV1.Group("/invites")
+		invites.Use(authMiddleware.RequireAuth(), authMiddleware.RequireSession())
+		{
+			invites.GET("", serviceProvider.ProjectMemberHandler().ListInvites)
+			invites.POST("/:token/accept", serviceProvider.ProjectMemberHandler().AcceptInvite)
 		}
 
 		// Mosaic generation routes (all require auth)
 		generate := apiV1.Group("/generate")
-		generate.Use(authMiddleware.RequireAuth())
+		generate.Use(authMiddleware.RequireAuth(), authMiddleware.RequireScope(services.ScopeGenerate, services.ScopeGenerate))
 		{
 			generate.POST("/", serviceProvider.MosaicHandler().GenerateMosaic)
+			generate.POST("/preview", serviceProvider.MosaicHandler().PreviewMosaic)
 			generate.GET("/:id/status", serviceProvider.MosaicHandler().GetGenerationStatus)
+			generate.GET("/:id/diff/:otherId", serviceProvider.MosaicHandler().DiffMosaics)
+			generate.POST("/:id/rerender", serviceProvider.MosaicHandler().RerenderMosaic)
+			generate.POST("/:id/exports", serviceProvider.MosaicHandler().ExportMosaic)
+			generate.GET("/:id/deepzoom.dzi", serviceProvider.MosaicHandler().GetDeepZoom)
+			generate.GET("/:id/deepzoom_files/*tile", serviceProvider.MosaicHandler().GetDeepZoom)
+			generate.GET("/:id/placement", serviceProvider.MosaicHandler().GetPlacementMap)
+			generate.GET("/:id/placement/at", serviceProvider.MosaicHandler().GetPlacementAt)
+			generate.GET("/:id/placement/tiles/:imageId", serviceProvider.MosaicHandler().GetTilePlacements)
+			generate.GET("/:id/placement/tiles/:imageId/highlight", serviceProvider.MosaicHandler().GetPlacementHighlight)
+			generate.GET("/:id/shares", serviceProvider.ShareHandler().ListShareLinks)
+			generate.POST("/:id/shares", serviceProvider.ShareHandler().CreateShareLink)
+			generate.DELETE("/:id/shares/:shareId", serviceProvider.ShareHandler().RevokeShareLink)
 			generate.POST("/settings", serviceProvider.MosaicHandler().SaveMosaicSettings)
 			generate.GET("/settings", serviceProvider.MosaicHandler().GetMosaicSettings)
 		}
+
+		// Admin routes (admin role, interactive sessions only)
+		admin := apiV1.Group("/admin")
+		admin.Use(authMiddleware.RequireAuth(), authMiddleware.RequireSession(), authMiddleware.RequireRole(services.RoleAdmin))
+		{
+			admin.GET("/users", serviceProvider.AdminHandler().ListUsers)
+			admin.PATCH("/users/:id", serviceProvider.AdminHandler().UpdateUser)
+			admin.GET("/jobs", serviceProvider.AdminHandler().GetJobs)
+			admin.POST("/jobs/:id/cancel", serviceProvider.AdminHandler().CancelJob)
+			admin.GET("/storage", serviceProvider.AdminHandler().GetStorageUsage)
+		}
 	}
 }